@@ -129,7 +129,7 @@ func CloseAllClients() (bool, []error) {
 // Private Methods
 
 func newClient() *clientImpl {
-	return &clientImpl{&nodeImpl{}, nil, nil, nil, nil}
+	return &clientImpl{newNodeImpl(), nil, nil, nil, nil}
 }
 
 func closeClientInternal(client Client, force bool) error {
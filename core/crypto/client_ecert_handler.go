@@ -41,7 +41,7 @@ func (handler *eCertHandlerImpl) init(client *clientImpl) error {
 
 // GetCertificate returns the TCert DER
 func (handler *eCertHandlerImpl) GetCertificate() []byte {
-	return utils.Clone(handler.client.enrollCert.Raw)
+	return utils.Clone(handler.client.getEnrollmentCertificate().Raw)
 }
 
 // Sign signs msg using the signing key corresponding to this TCert
@@ -84,7 +84,7 @@ func (handler *eCertTransactionHandlerImpl) init(client *clientImpl) error {
 
 	handler.client = client
 	handler.nonce = nonce
-	handler.binding = primitives.Hash(append(handler.client.enrollCert.Raw, handler.nonce...))
+	handler.binding = primitives.Hash(append(handler.client.getEnrollmentCertificate().Raw, handler.nonce...))
 
 	return nil
 }
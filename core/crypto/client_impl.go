@@ -83,6 +83,17 @@ func (client *clientImpl) GetNextTCerts(nCerts int, attributes ...string) (tCert
 	return tCerts, nil
 }
 
+// NextTCert returns the DER encoding of the next available (not yet used)
+// transaction certificate without attributes.
+func (client *clientImpl) NextTCert() ([]byte, error) {
+	tCerts, err := client.GetNextTCerts(1)
+	if err != nil {
+		return nil, err
+	}
+
+	return tCerts[0].GetCertificate().Raw, nil
+}
+
 // NewChaincodeInvokeTransaction is used to invoke chaincode's functions.
 func (client *clientImpl) NewChaincodeExecute(chaincodeInvocation *obc.ChaincodeInvocationSpec, uuid string, attributes ...string) (*obc.Transaction, error) {
 	// Verify that the client is initialized
@@ -209,7 +220,7 @@ func (client *clientImpl) register(id string, pwd []byte, enrollID, enrollPWD st
 		client.Info("Register crypto engine...")
 		err = client.registerCryptoEngine()
 		if err != nil {
-			client.Errorf("Failed registering crypto engine [%s]: [%s].", enrollID, err.Error())
+			client.Errorf("Failed registering crypto engine [%s]: [%s].", client.logID(enrollID), err.Error())
 			return nil
 		}
 		client.Info("Register crypto engine...done.")
@@ -217,7 +228,7 @@ func (client *clientImpl) register(id string, pwd []byte, enrollID, enrollPWD st
 	}
 
 	if err = client.nodeImpl.register(NodeClient, id, pwd, enrollID, enrollPWD, clentRegFunc); err != nil {
-		client.Errorf("Failed registering client [%s]: [%s]", enrollID, err)
+		client.Errorf("Failed registering client [%s]: [%s]", client.logID(enrollID), err)
 		return err
 	}
 
@@ -268,3 +279,11 @@ func (client *clientImpl) close() (err error) {
 	}
 	return
 }
+
+// Close stops the TCert pool and releases the resources held by the client.
+// It replaces the Close of the embedded node, which would leave the TCert pool
+// running. The clients obtained with InitClient are closed with CloseClient
+// instead, which also forgets them.
+func (client *clientImpl) Close() error {
+	return client.close()
+}
@@ -165,10 +165,11 @@ func (client *clientImpl) getTCertFromExternalDER(der []byte) (tCert, error) {
 		// Computable by TCA / Auditor: TCertPub_Key = EnrollPub_Key + ExpansionValue G
 		// using elliptic curve point addition per NIST FIPS PUB 186-4- specified P-384
 
-		// Compute temporary secret key
+		// Compute temporary secret key, from the current enrollment key
+		enrollPrivKey := client.getEnrollmentKey()
 		tempSK := &ecdsa.PrivateKey{
 			PublicKey: ecdsa.PublicKey{
-				Curve: client.enrollPrivKey.Curve,
+				Curve: enrollPrivKey.Curve,
 				X:     new(big.Int),
 				Y:     new(big.Int),
 			},
@@ -177,18 +178,18 @@ func (client *clientImpl) getTCertFromExternalDER(der []byte) (tCert, error) {
 
 		var k = new(big.Int).SetBytes(ExpansionValue)
 		var one = new(big.Int).SetInt64(1)
-		n := new(big.Int).Sub(client.enrollPrivKey.Params().N, one)
+		n := new(big.Int).Sub(enrollPrivKey.Params().N, one)
 		k.Mod(k, n)
 		k.Add(k, one)
 
-		tempSK.D.Add(client.enrollPrivKey.D, k)
-		tempSK.D.Mod(tempSK.D, client.enrollPrivKey.PublicKey.Params().N)
+		tempSK.D.Add(enrollPrivKey.D, k)
+		tempSK.D.Mod(tempSK.D, enrollPrivKey.PublicKey.Params().N)
 
 		// Compute temporary public key
-		tempX, tempY := client.enrollPrivKey.PublicKey.ScalarBaseMult(k.Bytes())
+		tempX, tempY := enrollPrivKey.PublicKey.ScalarBaseMult(k.Bytes())
 		tempSK.PublicKey.X, tempSK.PublicKey.Y =
 			tempSK.PublicKey.Add(
-				client.enrollPrivKey.PublicKey.X, client.enrollPrivKey.PublicKey.Y,
+				enrollPrivKey.PublicKey.X, enrollPrivKey.PublicKey.Y,
 				tempX, tempY,
 			)
 
@@ -300,10 +301,11 @@ func (client *clientImpl) getTCertFromDER(certBlk *TCertDBBlock) (certBlock *TCe
 	// Computable by TCA / Auditor: TCertPub_Key = EnrollPub_Key + ExpansionValue G
 	// using elliptic curve point addition per NIST FIPS PUB 186-4- specified P-384
 
-	// Compute temporary secret key
+	// Compute temporary secret key, from the current enrollment key
+	enrollPrivKey := client.getEnrollmentKey()
 	tempSK := &ecdsa.PrivateKey{
 		PublicKey: ecdsa.PublicKey{
-			Curve: client.enrollPrivKey.Curve,
+			Curve: enrollPrivKey.Curve,
 			X:     new(big.Int),
 			Y:     new(big.Int),
 		},
@@ -312,18 +314,18 @@ func (client *clientImpl) getTCertFromDER(certBlk *TCertDBBlock) (certBlock *TCe
 
 	var k = new(big.Int).SetBytes(ExpansionValue)
 	var one = new(big.Int).SetInt64(1)
-	n := new(big.Int).Sub(client.enrollPrivKey.Params().N, one)
+	n := new(big.Int).Sub(enrollPrivKey.Params().N, one)
 	k.Mod(k, n)
 	k.Add(k, one)
 
-	tempSK.D.Add(client.enrollPrivKey.D, k)
-	tempSK.D.Mod(tempSK.D, client.enrollPrivKey.PublicKey.Params().N)
+	tempSK.D.Add(enrollPrivKey.D, k)
+	tempSK.D.Mod(tempSK.D, enrollPrivKey.PublicKey.Params().N)
 
 	// Compute temporary public key
-	tempX, tempY := client.enrollPrivKey.PublicKey.ScalarBaseMult(k.Bytes())
+	tempX, tempY := enrollPrivKey.PublicKey.ScalarBaseMult(k.Bytes())
 	tempSK.PublicKey.X, tempSK.PublicKey.Y =
 		tempSK.PublicKey.Add(
-			client.enrollPrivKey.PublicKey.X, client.enrollPrivKey.PublicKey.Y,
+			enrollPrivKey.PublicKey.X, enrollPrivKey.PublicKey.Y,
 			tempX, tempY,
 		)
 
@@ -377,14 +379,38 @@ func (client *clientImpl) getTCertFromDER(certBlk *TCertDBBlock) (certBlock *TCe
 }
 
 func (client *clientImpl) getTCertsFromTCA(attrhash string, attributes []string, num int) error {
-	client.Debugf("Get [%d] certificates from the TCA...", num)
+	tCertBlocks, err := client.getTCertBatch(context.Background(), attrhash, attributes, num)
+	if err != nil {
+		return err
+	}
+
+	for _, tCertBlock := range tCertBlocks {
+		client.tCertPool.AddTCert(tCertBlock)
+	}
+
+	return nil
+}
+
+// getTCertBatch requests count transaction certificates from the TCA in one call and
+// returns the valid ones, with the keys derived for them. The TCA may return fewer
+// certificates than requested and the invalid ones are skipped, so fewer than count
+// can be returned. It fails only if no valid certificate is received.
+func (client *clientImpl) getTCertBatch(ctx context.Context, attrhash string, attributes []string, count int) ([]*TCertBlock, error) {
+	if count <= 0 {
+		return nil, fmt.Errorf("Invalid number of transaction certificates requested [%d].", count)
+	}
+
+	client.Debugf("Get [%d] certificates from the TCA...", count)
 
 	// Contact the TCA
-	TCertOwnerKDFKey, certDERs, err := client.callTCACreateCertificateSet(num, attributes)
+	TCertOwnerKDFKey, certDERs, err := client.callTCACreateCertificateSet(ctx, count, attributes)
 	if err != nil {
 		client.Errorf("Failed contacting TCA [%s].", err.Error())
 
-		return err
+		return nil, err
+	}
+	if len(certDERs) < count {
+		client.Warningf("TCA returned [%d] certificates out of the [%d] requested.", len(certDERs), count)
 	}
 
 	//	client.debug("TCertOwnerKDFKey [%s].", utils.EncodeBase64(TCertOwnerKDFKey))
@@ -394,7 +420,7 @@ func (client *clientImpl) getTCertsFromTCA(attrhash string, attributes []string,
 		// Check that the keys are the same
 		equal := bytes.Equal(client.tCertOwnerKDFKey, TCertOwnerKDFKey)
 		if !equal {
-			return errors.New("Failed reciving kdf key from TCA. The keys are different.")
+			return nil, errors.New("Failed reciving kdf key from TCA. The keys are different.")
 		}
 	} else {
 		client.tCertOwnerKDFKey = TCertOwnerKDFKey
@@ -403,7 +429,7 @@ func (client *clientImpl) getTCertsFromTCA(attrhash string, attributes []string,
 		if err := client.storeTCertOwnerKDFKey(); err != nil {
 			client.Errorf("Failed storing TCertOwnerKDFKey [%s].", err.Error())
 
-			return err
+			return nil, err
 		}
 	}
 
@@ -412,8 +438,14 @@ func (client *clientImpl) getTCertsFromTCA(attrhash string, attributes []string,
 	TCertOwnerEncryptKey := primitives.HMACAESTruncated(client.tCertOwnerKDFKey, []byte{1})
 	ExpansionKey := primitives.HMAC(client.tCertOwnerKDFKey, []byte{2})
 
-	j := 0
-	for i := 0; i < num; i++ {
+	var tCertBlocks []*TCertBlock
+	for i := 0; i < len(certDERs) && i < count; i++ {
+		if certDERs[i] == nil {
+			client.Errorf("Empty certificate [%d].", i)
+
+			continue
+		}
+
 		// DER to x509
 		x509Cert, err := primitives.DERToX509Certificate(certDERs[i].Cert)
 		prek0 := certDERs[i].Prek0
@@ -465,10 +497,11 @@ func (client *clientImpl) getTCertsFromTCA(attrhash string, attributes []string,
 		// Computable by TCA / Auditor: TCertPub_Key = EnrollPub_Key + ExpansionValue G
 		// using elliptic curve point addition per NIST FIPS PUB 186-4- specified P-384
 
-		// Compute temporary secret key
+		// Compute temporary secret key, from the current enrollment key
+		enrollPrivKey := client.getEnrollmentKey()
 		tempSK := &ecdsa.PrivateKey{
 			PublicKey: ecdsa.PublicKey{
-				Curve: client.enrollPrivKey.Curve,
+				Curve: enrollPrivKey.Curve,
 				X:     new(big.Int),
 				Y:     new(big.Int),
 			},
@@ -477,18 +510,18 @@ func (client *clientImpl) getTCertsFromTCA(attrhash string, attributes []string,
 
 		var k = new(big.Int).SetBytes(ExpansionValue)
 		var one = new(big.Int).SetInt64(1)
-		n := new(big.Int).Sub(client.enrollPrivKey.Params().N, one)
+		n := new(big.Int).Sub(enrollPrivKey.Params().N, one)
 		k.Mod(k, n)
 		k.Add(k, one)
 
-		tempSK.D.Add(client.enrollPrivKey.D, k)
-		tempSK.D.Mod(tempSK.D, client.enrollPrivKey.PublicKey.Params().N)
+		tempSK.D.Add(enrollPrivKey.D, k)
+		tempSK.D.Mod(tempSK.D, enrollPrivKey.PublicKey.Params().N)
 
 		// Compute temporary public key
-		tempX, tempY := client.enrollPrivKey.PublicKey.ScalarBaseMult(k.Bytes())
+		tempX, tempY := enrollPrivKey.PublicKey.ScalarBaseMult(k.Bytes())
 		tempSK.PublicKey.X, tempSK.PublicKey.Y =
 			tempSK.PublicKey.Add(
-				client.enrollPrivKey.PublicKey.X, client.enrollPrivKey.PublicKey.Y,
+				enrollPrivKey.PublicKey.X, enrollPrivKey.PublicKey.Y,
 				tempX, tempY,
 			)
 
@@ -536,8 +569,7 @@ func (client *clientImpl) getTCertsFromTCA(attrhash string, attributes []string,
 			continue
 		}
 
-		client.Debugf("Sub index [%d]", j)
-		j++
+		client.Debugf("Sub index [%d]", len(tCertBlocks))
 		client.Debugf("Certificate [%d] validated.", i)
 
 		prek0Cp := make([]byte, len(prek0))
@@ -548,21 +580,26 @@ func (client *clientImpl) getTCertsFromTCA(attrhash string, attributes []string,
 		tcertBlk.tCert = &tCertImpl{client, x509Cert, tempSK, prek0Cp}
 		tcertBlk.attributesHash = attrhash
 
-		client.tCertPool.AddTCert(tcertBlk)
+		tCertBlocks = append(tCertBlocks, tcertBlk)
 	}
 
-	if j == 0 {
+	if len(tCertBlocks) == 0 {
 		client.Error("No valid TCert was sent")
 
-		return errors.New("No valid TCert was sent.")
+		return nil, errors.New("No valid TCert was sent.")
 	}
 
-	return nil
+	return tCertBlocks, nil
 }
 
-func (client *clientImpl) callTCACreateCertificateSet(num int, attributes []string) ([]byte, []*membersrvc.TCert, error) {
+func (client *clientImpl) callTCACreateCertificateSet(ctx context.Context, num int, attributes []string) ([]byte, []*membersrvc.TCert, error) {
 	// Get a TCA Client
 	sock, tcaP, err := client.getTCAClient()
+	if err != nil {
+		client.Errorf("Failed getting TCA client [%s].", err.Error())
+
+		return nil, nil, err
+	}
 	defer sock.Close()
 
 	var attributesList []*membersrvc.TCertAttribute
@@ -597,19 +634,33 @@ func (client *clientImpl) callTCACreateCertificateSet(num int, attributes []stri
 		return nil, nil, err
 	}
 
-	R, _ := r.MarshalText()
-	S, _ := s.MarshalText()
+	R, err := r.MarshalText()
+	if err != nil {
+		client.Errorf("Failed marshaling signature [%s].", err.Error())
+		return nil, nil, err
+	}
+	S, err := s.MarshalText()
+	if err != nil {
+		client.Errorf("Failed marshaling signature [%s].", err.Error())
+		return nil, nil, err
+	}
 
 	// 3. Append the signature
 	req.Sig = &membersrvc.Signature{Type: membersrvc.CryptoType_ECDSA, R: R, S: S}
 
 	// 4. Send request
-	certSet, err := tcaP.CreateCertificateSet(context.Background(), req)
+	certSet, err := tcaP.CreateCertificateSet(ctx, req)
 	if err != nil {
 		client.Errorf("Failed requesting tca create certificate set [%s].", err.Error())
 
 		return nil, nil, err
 	}
 
+	if certSet.Certs == nil {
+		client.Error("TCA returned no certificate set.")
+
+		return nil, nil, errors.New("TCA returned no certificate set.")
+	}
+
 	return certSet.Certs.Key, certSet.Certs.Certs, nil
 }
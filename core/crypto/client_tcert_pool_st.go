@@ -23,6 +23,7 @@ import (
 	"sync"
 
 	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"golang.org/x/net/context"
 )
 
 //TCertBlock is an object that include the generated TCert and the attributes used to generate it.
@@ -48,6 +49,17 @@ type tCertPoolSingleThreadImpl struct {
 	tCerts map[string][]*TCertBlock
 
 	m sync.Mutex
+
+	// Background refills in progress, by attributes hash. refills is added to
+	// under m and only while not stopped, stopRefills cancels their TCA calls.
+	refilling   map[string]bool
+	refills     sync.WaitGroup
+	stopped     bool
+	refillCtx   context.Context
+	stopRefills context.CancelFunc
+
+	// Serializes the TCA calls
+	tcaMutex sync.Mutex
 }
 
 //Start starts the pool processing.
@@ -57,6 +69,10 @@ func (tCertPool *tCertPoolSingleThreadImpl) Start() (err error) {
 
 	tCertPool.client.Debug("Starting TCert Pool...")
 
+	if !tCertPool.client.conf.isTCertPersistenceEnabled() {
+		return
+	}
+
 	// Load unused TCerts if any
 	tCertDBBlocks, err := tCertPool.client.ks.loadUnusedTCerts()
 	if err != nil {
@@ -85,8 +101,28 @@ func (tCertPool *tCertPoolSingleThreadImpl) Start() (err error) {
 
 //Stop stops the pool.
 func (tCertPool *tCertPoolSingleThreadImpl) Stop() (err error) {
+	// Start no more refills and abort the TCA calls of those in progress
+	tCertPool.m.Lock()
+	if tCertPool.stopped {
+		tCertPool.m.Unlock()
+
+		return
+	}
+	tCertPool.stopped = true
+	tCertPool.stopRefills()
+	tCertPool.m.Unlock()
+
+	tCertPool.refills.Wait()
+
 	tCertPool.m.Lock()
 	defer tCertPool.m.Unlock()
+
+	if !tCertPool.client.conf.isTCertPersistenceEnabled() {
+		tCertPool.client.Debug("TCerts persistence disabled. Dropping unused TCerts.")
+
+		return
+	}
+
 	for k := range tCertPool.tCerts {
 		certList := tCertPool.tCerts[k]
 		certListLen := tCertPool.length[k]
@@ -146,7 +182,10 @@ func (tCertPool *tCertPoolSingleThreadImpl) getNextTCert(attributes ...string) (
 
 	if poolLen <= 0 {
 		// Reload
-		if err := tCertPool.client.getTCertsFromTCA(attributesHash, attributes, tCertPool.client.conf.getTCertBatchSize()); err != nil {
+		tCertPool.tcaMutex.Lock()
+		err := tCertPool.client.getTCertsFromTCA(attributesHash, attributes, tCertPool.client.conf.getTCertBatchSize())
+		tCertPool.tcaMutex.Unlock()
+		if err != nil {
 			return nil, fmt.Errorf("Failed loading TCerts from TCA")
 		}
 	}
@@ -155,9 +194,42 @@ func (tCertPool *tCertPoolSingleThreadImpl) getNextTCert(attributes ...string) (
 
 	tCertPool.length[attributesHash] = tCertPool.length[attributesHash] - 1
 
+	if tCertPool.length[attributesHash] < tCertPool.client.conf.getTCertLowWaterMark() && !tCertPool.refilling[attributesHash] && !tCertPool.stopped {
+		tCertPool.refilling[attributesHash] = true
+		tCertPool.refills.Add(1)
+		go tCertPool.refill(attributesHash, attributes)
+	}
+
 	return tCert, nil
 }
 
+// refill gets a batch of TCerts from the TCA in background, so that the
+// callers of getNextTCert do not wait for the TCA while TCerts are left
+func (tCertPool *tCertPoolSingleThreadImpl) refill(attributesHash string, attributes []string) {
+	defer tCertPool.refills.Done()
+
+	tCertPool.client.Debugf("Refilling TCert Pool for [%s]...", attributesHash)
+
+	tCertPool.tcaMutex.Lock()
+	tCertBlocks, err := tCertPool.client.getTCertBatch(tCertPool.refillCtx, attributesHash, attributes, tCertPool.client.conf.getTCertBatchSize())
+	tCertPool.tcaMutex.Unlock()
+
+	tCertPool.m.Lock()
+	defer tCertPool.m.Unlock()
+
+	tCertPool.refilling[attributesHash] = false
+	if err != nil {
+		tCertPool.client.Errorf("Failed refilling TCert Pool from the TCA [%s].", err.Error())
+
+		return
+	}
+	for _, tCertBlock := range tCertBlocks {
+		tCertPool.AddTCert(tCertBlock)
+	}
+
+	tCertPool.client.Debugf("Refilling TCert Pool for [%s]...done! Size [%d].", attributesHash, tCertPool.length[attributesHash])
+}
+
 //AddTCert adds a TCert into the pool is invoked by the client after TCA is called.
 func (tCertPool *tCertPoolSingleThreadImpl) AddTCert(tCertBlock *TCertBlock) (err error) {
 
@@ -167,15 +239,11 @@ func (tCertPool *tCertPoolSingleThreadImpl) AddTCert(tCertBlock *TCertBlock) (er
 		tCertPool.length[tCertBlock.attributesHash] = 0
 	}
 
-	tCertPool.length[tCertBlock.attributesHash] = tCertPool.length[tCertBlock.attributesHash] + 1
-
-	if tCertPool.tCerts[tCertBlock.attributesHash] == nil {
-
-		tCertPool.tCerts[tCertBlock.attributesHash] = make([]*TCertBlock, tCertPool.client.conf.getTCertBatchSize())
+	// A refill can add TCerts while some are left, so the list grows beyond the batch size
+	tCerts := tCertPool.tCerts[tCertBlock.attributesHash][:tCertPool.length[tCertBlock.attributesHash]]
+	tCertPool.tCerts[tCertBlock.attributesHash] = append(tCerts, tCertBlock)
 
-	}
-
-	tCertPool.tCerts[tCertBlock.attributesHash][tCertPool.length[tCertBlock.attributesHash]-1] = tCertBlock
+	tCertPool.length[tCertBlock.attributesHash] = tCertPool.length[tCertBlock.attributesHash] + 1
 
 	return nil
 }
@@ -188,5 +256,9 @@ func (tCertPool *tCertPoolSingleThreadImpl) init(client *clientImpl) (err error)
 
 	tCertPool.length = make(map[string]int)
 
+	tCertPool.refilling = make(map[string]bool)
+
+	tCertPool.refillCtx, tCertPool.stopRefills = context.WithCancel(context.Background())
+
 	return
 }
@@ -310,8 +310,9 @@ func (client *clientImpl) newChaincodeDeployUsingECert(chaincodeDeploymentSpec *
 	// Sign the transaction
 
 	// Append the certificate to the transaction
-	client.Debugf("Appending certificate [% x].", client.enrollCert.Raw)
-	tx.Cert = client.enrollCert.Raw
+	enrollCert := client.getEnrollmentCertificate()
+	client.Debugf("Appending certificate [% x].", enrollCert.Raw)
+	tx.Cert = enrollCert.Raw
 
 	// Sign the transaction and append the signature
 	// 1. Marshall tx to bytes
@@ -347,8 +348,9 @@ func (client *clientImpl) newChaincodeExecuteUsingECert(chaincodeInvocation *obc
 	// Sign the transaction
 
 	// Append the certificate to the transaction
-	client.Debugf("Appending certificate [% x].", client.enrollCert.Raw)
-	tx.Cert = client.enrollCert.Raw
+	enrollCert := client.getEnrollmentCertificate()
+	client.Debugf("Appending certificate [% x].", enrollCert.Raw)
+	tx.Cert = enrollCert.Raw
 
 	// Sign the transaction and append the signature
 	// 1. Marshall tx to bytes
@@ -384,8 +386,9 @@ func (client *clientImpl) newChaincodeQueryUsingECert(chaincodeInvocation *obc.C
 	// Sign the transaction
 
 	// Append the certificate to the transaction
-	client.Debugf("Appending certificate [% x].", client.enrollCert.Raw)
-	tx.Cert = client.enrollCert.Raw
+	enrollCert := client.getEnrollmentCertificate()
+	client.Debugf("Appending certificate [% x].", enrollCert.Raw)
+	tx.Cert = enrollCert.Raw
 
 	// Sign the transaction and append the signature
 	// 1. Marshall tx to bytes
@@ -437,7 +440,7 @@ func (client *clientImpl) checkTransaction(tx *obc.Transaction) error {
 		if _, err = primitives.CheckCertAgainRoot(cert, client.tcaCertPool); err != nil {
 			client.Warningf("Failed verifing certificate against TCA cert pool [%s].", err.Error())
 			// c. Check against ECA certPool, if this check also fails then return an error
-			if _, err = primitives.CheckCertAgainRoot(cert, client.ecaCertPool); err != nil {
+			if _, err = primitives.CheckCertAgainRoot(cert, client.getECACertPool()); err != nil {
 				client.Warningf("Failed verifing certificate against ECA cert pool [%s].", err.Error())
 
 				return fmt.Errorf("Certificate has not been signed by a trusted authority. [%s]", err)
@@ -21,6 +21,8 @@ import (
 	"github.com/op/go-logging"
 
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
@@ -32,6 +34,7 @@ import (
 	"crypto/rand"
 
 	"runtime"
+	"sync"
 	"time"
 
 	"github.com/hyperledger/fabric/core/crypto/attributes"
@@ -39,7 +42,9 @@ import (
 	"github.com/hyperledger/fabric/core/crypto/utils"
 	"github.com/hyperledger/fabric/core/util"
 	"github.com/hyperledger/fabric/membersrvc/ca"
+	membersrvc "github.com/hyperledger/fabric/membersrvc/protos"
 	"github.com/spf13/viper"
+	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 )
@@ -255,6 +260,33 @@ func TestRegistrationSameEnrollIDDifferentRole(t *testing.T) {
 	}
 }
 
+func TestRegistrationAlreadyEnrolled(t *testing.T) {
+	conf := utils.NodeConfiguration{Type: "client", Name: "TestRegistrationAlreadyEnrolled"}
+	if err := RegisterClient(conf.Name, nil, conf.GetEnrollmentID(), conf.GetEnrollmentPWD()); err != nil {
+		t.Fatalf("Failed client registration [%s]", err)
+	}
+
+	// The password is consumed by the first enrollment
+	err := RegisterClient(conf.Name+"Again", nil, conf.GetEnrollmentID(), conf.GetEnrollmentPWD())
+	if !errors.Is(err, utils.ErrAlreadyEnrolled) {
+		t.Fatalf("Enrolling twice must fail with [%s], got [%v]", utils.ErrAlreadyEnrolled, err)
+	}
+}
+
+func TestRegistrationWrongPassword(t *testing.T) {
+	conf := utils.NodeConfiguration{Type: "client", Name: "TestRegistrationWrongPassword"}
+
+	err := RegisterClient(conf.Name, nil, "user2", "not the right password")
+	if !errors.Is(err, utils.ErrEnrollmentAuthFailed) {
+		t.Fatalf("Registration with a wrong password must fail with [%s], got [%s]", utils.ErrEnrollmentAuthFailed, err)
+	}
+
+	err = RegisterClient(conf.Name, nil, "unknownUser", "9gvZQRwhUq9q")
+	if !errors.Is(err, utils.ErrEnrollmentAuthFailed) {
+		t.Fatalf("Registration of an unknown identity must fail with [%s], got [%s]", utils.ErrEnrollmentAuthFailed, err)
+	}
+}
+
 func TestTLSCertificateDeletion(t *testing.T) {
 	conf := utils.NodeConfiguration{Type: "peer", Name: "peer"}
 
@@ -742,6 +774,476 @@ func TestPeerID(t *testing.T) {
 	}
 }
 
+func TestPeerEnrollmentCertFingerprint(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	node := peer.(*peerImpl).nodeImpl
+
+	hash, err := node.GetEnrollmentCertHash()
+	if err != nil {
+		t.Fatalf("Failed getting enrollment certificate hash [%s]", err)
+	}
+	if !bytes.Equal(hash, primitives.Hash(node.enrollCert.Raw)) {
+		t.Fatalf("Invalid enrollment certificate hash [% x]", hash)
+	}
+
+	fingerprint, err := node.GetEnrollmentCertFingerprint()
+	if err != nil {
+		t.Fatalf("Failed getting enrollment certificate fingerprint [%s]", err)
+	}
+	if fingerprint != utils.EncodeFingerprint(hash) || len(fingerprint) != 3*len(hash)-1 {
+		t.Fatalf("Invalid enrollment certificate fingerprint [%s]", fingerprint)
+	}
+
+	if _, err := node.GetECACertHash(); err != nil {
+		t.Fatalf("Failed getting ECA certificate hash [%s]", err)
+	}
+}
+
+func TestPeerECertChain(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	node := peer.(*peerImpl).nodeImpl
+
+	chain, err := node.getECertChain()
+	if err != nil {
+		t.Fatalf("Failed getting enrollment certificate chain [%s]", err)
+	}
+	if len(chain) != 2 || !chain[0].Equal(node.enrollCert) || !chain[1].Equal(node.ecaCert) {
+		t.Fatalf("Invalid enrollment certificate chain of length [%d]", len(chain))
+	}
+	if err := chain[0].CheckSignatureFrom(chain[1]); err != nil {
+		t.Fatalf("Enrollment certificate not issued by the ECA [%s]", err)
+	}
+}
+
+func TestPeerEnrollmentCertInfo(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	node := peer.(*peerImpl).nodeImpl
+
+	info, err := node.GetEnrollmentCertInfo()
+	if err != nil {
+		t.Fatalf("Failed getting enrollment certificate info [%s]", err)
+	}
+	if info.SerialNumber.Cmp(node.enrollCert.SerialNumber) != 0 {
+		t.Fatal("Invalid serial number")
+	}
+	if info.Issuer.CommonName != node.ecaCert.Subject.CommonName {
+		t.Fatalf("Invalid issuer [%s]", info.Issuer.CommonName)
+	}
+	if info.Role != membersrvc.Role_PEER {
+		t.Fatalf("Expected role [%s], got [%s]", membersrvc.Role_PEER, info.Role)
+	}
+}
+
+func TestPeerListStoredCerts(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	node := peer.(*peerImpl).nodeImpl
+
+	infos, err := node.ListStoredCerts()
+	if err != nil {
+		t.Fatalf("Failed listing stored certificates [%s]", err)
+	}
+	found := make(map[StoredCertType]StoredCertInfo)
+	for _, info := range infos {
+		if info.Err != nil {
+			t.Fatalf("Failed parsing stored certificate [%s] [%s]", info.Name, info.Err)
+		}
+		if !info.Valid {
+			t.Fatalf("Stored certificate [%s] must be valid", info.Name)
+		}
+		found[info.Type] = info
+	}
+	for _, certType := range []StoredCertType{StoredCertEnrollment, StoredCertECAChain, StoredCertTCAChain, StoredCertTLSCAChain} {
+		if _, ok := found[certType]; !ok {
+			t.Fatalf("Missing [%s] certificate in [%v]", certType, infos)
+		}
+	}
+	if found[StoredCertEnrollment].SerialNumber.Cmp(node.enrollCert.SerialNumber) != 0 {
+		t.Fatal("Invalid serial number of the enrollment certificate")
+	}
+
+	// Expired
+	node.clock = func() time.Time { return node.enrollCert.NotAfter.Add(time.Hour) }
+	defer func() { node.clock = nil }()
+	infos, err = node.ListStoredCerts()
+	if err != nil {
+		t.Fatalf("Failed listing stored certificates [%s]", err)
+	}
+	for _, info := range infos {
+		if info.Type == StoredCertEnrollment && info.Valid {
+			t.Fatal("An expired enrollment certificate must not be valid")
+		}
+	}
+}
+
+func TestPeerCryptoStatus(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	node := peer.(*peerImpl).nodeImpl
+
+	if err := node.PingECA(context.Background()); err != nil {
+		t.Fatalf("Failed pinging the ECA [%s]", err)
+	}
+
+	status := node.CryptoStatus()
+	if !status.Enrolled || status.EnrollID != node.enrollID {
+		t.Fatalf("Invalid enrollment status [%v]", status)
+	}
+	fingerprint, err := node.GetEnrollmentCertFingerprint()
+	if err != nil {
+		t.Fatalf("Failed getting enrollment certificate fingerprint [%s]", err)
+	}
+	if status.EnrollmentCert == nil || status.EnrollmentCert.Fingerprint != fingerprint {
+		t.Fatalf("Invalid enrollment certificate status [%v]", status.EnrollmentCert)
+	}
+	if !status.EnrollmentCert.NotAfter.Equal(node.enrollCert.NotAfter) {
+		t.Fatal("Invalid enrollment certificate validity period")
+	}
+	if status.ECACert == nil {
+		t.Fatal("Missing ECA certificate status")
+	}
+	if status.ECAAddress == "" || status.LastECAContact.IsZero() {
+		t.Fatalf("Missing ECA contact [%s] [%s]", status.ECAAddress, status.LastECAContact)
+	}
+	if status.TrustedRoots != node.TrustedRootsCount() {
+		t.Fatalf("Expected [%d] trusted roots, got [%d]", node.TrustedRootsCount(), status.TrustedRoots)
+	}
+
+	raw, err := json.Marshal(status)
+	if err != nil {
+		t.Fatalf("Failed marshalling crypto status [%s]", err)
+	}
+	var decoded CryptoStatus
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		t.Fatalf("Failed unmarshalling crypto status [%s]", err)
+	}
+	if decoded.EnrollmentCert.Fingerprint != fingerprint {
+		t.Fatal("The crypto status does not survive a JSON round trip")
+	}
+
+	// The enrollment id is redacted as in the logs
+	node.conf.redactLogs = true
+	defer func() { node.conf.redactLogs = false }()
+	if status := node.CryptoStatus(); status.EnrollID != node.logID(node.enrollID) || status.EnrollID == node.enrollID {
+		t.Fatalf("The enrollment id must be redacted, got [%s]", status.EnrollID)
+	}
+}
+
+func TestClientGetTCertBatch(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	client := deployer.(*clientImpl)
+
+	tCertBlocks, err := client.getTCertBatch(context.Background(), calculateAttributesHash(nil), nil, 3)
+	if err != nil {
+		t.Fatalf("Failed getting transaction certificates batch [%s]", err)
+	}
+	if len(tCertBlocks) != 3 {
+		t.Fatalf("Expected [3] transaction certificates, got [%d]", len(tCertBlocks))
+	}
+	for _, tCertBlock := range tCertBlocks {
+		tCert := tCertBlock.tCert
+		if err := primitives.CheckCertPKAgainstSK(tCert.GetCertificate(), tCert.(*tCertImpl).sk); err != nil {
+			t.Fatalf("Derived key does not match the transaction certificate [%s]", err)
+		}
+	}
+
+	if _, err := client.getTCertBatch(context.Background(), calculateAttributesHash(nil), nil, 0); err == nil {
+		t.Fatal("Requesting no transaction certificate must fail")
+	}
+}
+
+func TestClientNextTCertRefill(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	client := deployer.(*clientImpl)
+	pool, ok := client.tCertPool.(*tCertPoolSingleThreadImpl)
+	if !ok {
+		t.Skip("Background refill is done by the single thread pool only")
+	}
+
+	attributesHash := calculateAttributesHash(nil)
+	seen := make(map[string]bool)
+	for i := 0; i <= client.conf.getTCertBatchSize()-client.conf.getTCertLowWaterMark(); i++ {
+		der, err := client.NextTCert()
+		if err != nil {
+			t.Fatalf("Failed getting next TCert [%s]", err)
+		}
+		if seen[string(der)] {
+			t.Fatal("TCert handed out twice")
+		}
+		seen[string(der)] = true
+	}
+
+	// Below the low water mark, a new batch is requested in background
+	pool.refills.Wait()
+	pool.m.Lock()
+	length := pool.length[attributesHash]
+	pool.m.Unlock()
+	if length < client.conf.getTCertBatchSize() {
+		t.Fatalf("TCert pool not refilled, [%d] TCerts left", length)
+	}
+}
+
+func TestPeerTLSCertificate(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	node := peer.(*peerImpl).nodeImpl
+
+	if _, err := node.certStore.Get(node.conf.getTLSCACertsChainFilename()); err != nil {
+		t.Fatalf("TLSCA certificates chain not stored [%s]", err)
+	}
+	pool, err := node.getTLSCACertPool()
+	if err != nil {
+		t.Fatalf("Failed loading TLSCA certificates chain [%s]", err)
+	}
+
+	cert, err := node.GetTLSCertificate()
+	if err != nil {
+		t.Fatalf("Failed getting TLS certificate [%s]", err)
+	}
+	if err := primitives.CheckCertAgainstSKAndRoot(cert.Leaf, cert.PrivateKey, pool); err != nil {
+		t.Fatalf("TLS certificate not issued by the TLSCA for its key [%s]", err)
+	}
+}
+
+func TestValidatorVerifyCertificate(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	node := validator.(*validatorImpl).nodeImpl
+	peerCert := peer.(*peerImpl).nodeImpl.enrollCert
+
+	if err := node.VerifyCertificate(peerCert.Raw); err != nil {
+		t.Fatalf("Failed verifying the enrollment certificate of the peer [%s]", err)
+	}
+
+	// Not issued by the ECA
+	certRaw, _, err := primitives.NewSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Failed generating certificate [%s]", err)
+	}
+	if err := node.VerifyCertificate(certRaw); !errors.Is(err, utils.ErrInvalidCert) {
+		t.Fatalf("Verifying a foreign certificate must fail with ErrInvalidCert, got [%v]", err)
+	}
+	if err := node.VerifyCertificate([]byte("not a certificate")); !errors.Is(err, utils.ErrInvalidCert) {
+		t.Fatalf("Verifying garbage must fail with ErrInvalidCert, got [%v]", err)
+	}
+
+	// Expired
+	node.clock = func() time.Time { return peerCert.NotAfter.Add(time.Hour) }
+	defer func() { node.clock = nil }()
+	if err := node.VerifyCertificate(peerCert.Raw); !errors.Is(err, utils.ErrInvalidCert) {
+		t.Fatalf("Verifying an expired certificate must fail with ErrInvalidCert, got [%v]", err)
+	}
+}
+
+func TestPeerVerifyWithECert(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	node := peer.(*peerImpl).nodeImpl
+	payload := []byte("payload")
+
+	r, s, err := node.ecdsaSignWithEnrollmentKey(payload)
+	if err != nil {
+		t.Fatalf("Failed signing with the enrollment key [%s]", err)
+	}
+	R, _ := r.MarshalText()
+	S, _ := s.MarshalText()
+
+	if err := node.VerifyWithECert(payload, R, S); err != nil {
+		t.Fatalf("Failed verifying the signature of the enrollment key [%s]", err)
+	}
+	if err := node.VerifyWithECert([]byte("other"), R, S); !errors.Is(err, utils.ErrInvalidSignature) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrInvalidSignature, err)
+	}
+	if err := node.VerifyWithECert(payload, S, R); !errors.Is(err, utils.ErrInvalidSignature) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrInvalidSignature, err)
+	}
+	if err := node.VerifyWithECert(payload, []byte("garbage"), S); !errors.Is(err, utils.ErrInvalidSignature) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrInvalidSignature, err)
+	}
+}
+
+func TestValidatorVerifyCertificates(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	node := validator.(*validatorImpl).nodeImpl
+	peerCert := peer.(*peerImpl).nodeImpl.enrollCert
+	certRaw, _, err := primitives.NewSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Failed generating certificate [%s]", err)
+	}
+
+	var ders [][]byte
+	for i := 0; i < 32; i++ {
+		ders = append(ders, peerCert.Raw, certRaw, []byte("not a certificate"))
+	}
+	errs := node.VerifyCertificates(ders)
+	if len(errs) != len(ders) {
+		t.Fatalf("Expected [%d] results, got [%d]", len(ders), len(errs))
+	}
+	for i, err := range errs {
+		switch i % 3 {
+		case 0:
+			if err != nil {
+				t.Fatalf("Failed verifying the enrollment certificate of the peer at [%d] [%s]", i, err)
+			}
+		default:
+			if !errors.Is(err, utils.ErrInvalidCert) {
+				t.Fatalf("Expected [%s] at [%d], got [%v]", utils.ErrInvalidCert, i, err)
+			}
+		}
+	}
+
+	if errs := node.VerifyCertificates(nil); len(errs) != 0 {
+		t.Fatalf("Expected no result, got %v", errs)
+	}
+}
+
+func TestPeerExportImportEnrollment(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	node := peer.(*peerImpl).nodeImpl
+	hash := node.enrollCertHash
+
+	data, err := node.ExportEnrollment()
+	if err != nil {
+		t.Fatalf("Failed exporting enrollment [%s]", err)
+	}
+
+	if err := node.ImportEnrollment(data[:len(data)/2], node.getEnrollmentKeyPassphrase()); err == nil {
+		t.Fatal("Importing a truncated enrollment must fail")
+	}
+
+	// A failed import leaves the current enrollment untouched
+	keyPath := node.conf.getPathForAlias(node.conf.getEnrollmentKeyFilename())
+	key, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		t.Fatalf("Failed reading enrollment key [%s]", err)
+	}
+	store := node.certStore
+	node.certStore = &failingCertStore{store}
+	err = node.ImportEnrollment(data, node.getEnrollmentKeyPassphrase())
+	node.certStore = store
+	if err == nil {
+		t.Fatal("Importing an enrollment must fail when the certificates cannot be stored")
+	}
+	if current, err := ioutil.ReadFile(keyPath); err != nil || !bytes.Equal(current, key) {
+		t.Fatalf("The enrollment key must not be replaced by a failed import [%v]", err)
+	}
+	if _, err := os.Stat(node.conf.getPathForAlias(stagedAlias(node.conf.getEnrollmentKeyFilename()))); !os.IsNotExist(err) {
+		t.Fatalf("The staged enrollment data must be removed after a failed import [%v]", err)
+	}
+
+	// The enrollment can be imported while the node is used
+	done := make(chan struct{})
+	var readers sync.WaitGroup
+	readers.Add(1)
+	go func() {
+		defer readers.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				if key, cert := node.getEnrollmentData(); key == nil || cert == nil {
+					t.Error("The enrollment data must be set while importing")
+					return
+				}
+			}
+		}
+	}()
+	err = node.ImportEnrollment(data, node.getEnrollmentKeyPassphrase())
+	close(done)
+	readers.Wait()
+	if err != nil {
+		t.Fatalf("Failed importing enrollment [%s]", err)
+	}
+	if !bytes.Equal(hash, node.enrollCertHash) {
+		t.Fatal("Imported enrollment certificate differs from the exported one")
+	}
+}
+
+func TestPeerReEnroll(t *testing.T) {
+	conf := utils.NodeConfiguration{Type: "peer", Name: "peerreenroll"}
+	if err := RegisterPeer(conf.Name, ksPwd, conf.GetEnrollmentID(), conf.GetEnrollmentPWD()); err != nil {
+		t.Fatalf("Failed peer registration [%s]", err)
+	}
+	p, err := InitPeer(conf.Name, ksPwd)
+	if err != nil {
+		t.Fatalf("Failed peer initialization [%s]", err)
+	}
+	node := p.(*peerImpl).nodeImpl
+	oldCert := node.getEnrollmentCertificate()
+
+	// The ECA enrolls an identity once, then only re-enrolls it
+	if _, _, _, _, err := node.getEnrollmentCertificateFromECA(context.Background(), conf.GetEnrollmentID(), conf.GetEnrollmentPWD()); err == nil {
+		t.Fatal("Enrolling an identity twice must fail")
+	}
+	for i := 0; i < 2; i++ {
+		if err := node.reEnroll(context.Background(), conf.GetEnrollmentID()); err != nil {
+			t.Fatalf("Failed re-enrolling [%d] [%s]", i, err)
+		}
+	}
+
+	newCert := node.getEnrollmentCertificate()
+	if newCert.Equal(oldCert) {
+		t.Fatal("The enrollment certificate must be replaced")
+	}
+	pair, err := node.callECAReadCertificate(context.Background(), &membersrvc.ECertReadReq{Id: &membersrvc.Identity{Id: conf.GetEnrollmentID()}})
+	if err != nil {
+		t.Fatalf("Failed reading the enrollment certificate from the ECA [%s]", err)
+	}
+	if !bytes.Equal(pair.Sign, newCert.Raw) {
+		t.Fatal("The ECA must serve the new enrollment certificate")
+	}
+
+	msg := []byte("Hello World!!!")
+	signature, err := node.signWithEnrollmentKey(msg)
+	if err != nil {
+		t.Fatalf("Failed signing with the new enrollment key [%s]", err)
+	}
+	if ok, err := node.verifyWithEnrollmentCert(msg, signature); err != nil || !ok {
+		t.Fatalf("Failed verifying with the new enrollment certificate [%v]", err)
+	}
+
+	// The new enrollment data is the one loaded at the next start
+	if err := ClosePeer(p); err != nil {
+		t.Fatalf("Failed closing peer [%s]", err)
+	}
+	if p, err = InitPeer(conf.Name, ksPwd); err != nil {
+		t.Fatalf("Failed peer initialization after re-enrolling [%s]", err)
+	}
+	defer ClosePeer(p)
+	node = p.(*peerImpl).nodeImpl
+	if !node.getEnrollmentCertificate().Equal(newCert) {
+		t.Fatal("The stored enrollment certificate must be the new one")
+	}
+
+	// The key rotation takes the same path
+	if err := node.RotateEnrollmentKey(context.Background()); err != nil {
+		t.Fatalf("Failed rotating the enrollment key [%s]", err)
+	}
+	if node.getEnrollmentCertificate().Equal(newCert) {
+		t.Fatal("The enrollment certificate must be replaced by the rotation")
+	}
+}
+
 func TestPeerDeployTransaction(t *testing.T) {
 	initNodes()
 	defer closeNodes()
@@ -0,0 +1,338 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	"golang.org/x/net/context"
+)
+
+// ExportEnrollment packages the enrollment ID, key and certificate, the enrollment
+// chain key and the ECA certificates chain into a single tar archive.
+// Private keys are encrypted with the keystore passphrase, if any.
+func (node *nodeImpl) ExportEnrollment() ([]byte, error) {
+	node.Debug("Exporting enrollment...")
+
+	node.certPoolsMutex.RLock()
+	ecaCert, ecaIntermediates, intermediates := node.ecaCert, node.ecaIntermediates, node.ecertIntermediates
+	node.certPoolsMutex.RUnlock()
+
+	enrollPrivKey, enrollCert := node.getEnrollmentData()
+	if enrollCert == nil || enrollPrivKey == nil || ecaCert == nil {
+		return nil, errors.New("Node not enrolled.")
+	}
+
+	passphrase := node.getEnrollmentKeyPassphrase()
+
+	key, err := primitives.PrivateKeyToPKCS8PEM(enrollPrivKey, passphrase)
+	if err != nil {
+		node.Errorf("Failed converting enrollment key to PEM [%s].", err.Error())
+
+		return nil, err
+	}
+
+	var chainKey []byte
+	if node.eType == NodeValidator {
+		chainKey, err = primitives.PrivateKeyToPEM(node.enrollChainKey, passphrase)
+	} else {
+		chainKey, err = primitives.PublicKeyToPEM(node.enrollChainKey, nil)
+	}
+	if err != nil {
+		node.Errorf("Failed converting enrollment chain key to PEM [%s].", err.Error())
+
+		return nil, err
+	}
+
+	ecaChain := primitives.DERCertToPEM(ecaCert.Raw)
+	for _, cert := range ecaIntermediates {
+		ecaChain = append(ecaChain, primitives.DERCertToPEM(cert.Raw)...)
+	}
+
+	names := []string{
+		node.conf.getEnrollmentIDFilename(),
+		node.conf.getEnrollmentKeyFilename(),
+		node.conf.getEnrollmentCertFilename(),
+		node.conf.getEnrollmentChainKeyFilename(),
+		node.conf.getECACertsChainFilename(),
+	}
+	files := map[string][]byte{
+		node.conf.getEnrollmentIDFilename():       []byte(node.enrollID),
+		node.conf.getEnrollmentKeyFilename():      key,
+		node.conf.getEnrollmentCertFilename():     primitives.DERCertToPEM(enrollCert.Raw),
+		node.conf.getEnrollmentChainKeyFilename(): chainKey,
+		node.conf.getECACertsChainFilename():      ecaChain,
+	}
+	if len(intermediates) != 0 {
+		var pem []byte
+		for _, cert := range intermediates {
+			pem = append(pem, primitives.DERCertToPEM(cert.Raw)...)
+		}
+		names = append(names, node.conf.getECertIntermediatesFilename())
+		files[node.conf.getECertIntermediatesFilename()] = pem
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range names {
+		hdr := &tar.Header{Name: name, Mode: 0600, Size: int64(len(files[name]))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+
+	node.Debug("Exporting enrollment...done!")
+
+	return buf.Bytes(), nil
+}
+
+// ImportEnrollment restores the enrollment exported by ExportEnrollment.
+// passphrase decrypts the private keys in data. They are stored again
+// encrypted with the keystore passphrase of this node. The enrollment data is
+// staged and moved in place once all of it is written: a failure leaves the
+// current enrollment untouched.
+func (node *nodeImpl) ImportEnrollment(data, passphrase []byte) error {
+	node.Debug("Importing enrollment...")
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			node.Errorf("Failed reading enrollment archive [%s].", err.Error())
+
+			return err
+		}
+		raw, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		files[hdr.Name] = raw
+	}
+
+	for _, name := range []string{
+		node.conf.getEnrollmentIDFilename(),
+		node.conf.getEnrollmentKeyFilename(),
+		node.conf.getEnrollmentCertFilename(),
+		node.conf.getEnrollmentChainKeyFilename(),
+		node.conf.getECACertsChainFilename(),
+	} {
+		if _, ok := files[name]; !ok {
+			return fmt.Errorf("Missing [%s] in enrollment archive.", name)
+		}
+	}
+
+	// Check the materials before overwriting anything
+	key, err := primitives.PEMtoPrivateKey(files[node.conf.getEnrollmentKeyFilename()], passphrase)
+	if err != nil {
+		node.Errorf("Failed decrypting enrollment key [%s].", err.Error())
+
+		return err
+	}
+	cert, der, err := primitives.PEMtoCertificateAndDER(files[node.conf.getEnrollmentCertFilename()])
+	if err != nil {
+		node.Errorf("Failed parsing enrollment certificate [%s].", err.Error())
+
+		return err
+	}
+	if err := primitives.CheckCertPKAgainstSK(cert, key); err != nil {
+		node.Errorf("Enrollment certificate does not match the enrollment key [%s].", err.Error())
+
+		return err
+	}
+	ecaCert, err := primitives.PEMtoCertificate(files[node.conf.getECACertsChainFilename()])
+	if err != nil {
+		node.Errorf("Failed parsing ECA certificate [%s].", err.Error())
+
+		return err
+	}
+	if err := cert.CheckSignatureFrom(ecaCert); err != nil {
+		node.Errorf("Enrollment certificate not issued by the ECA [%s].", err.Error())
+
+		return err
+	}
+
+	var chainKey interface{}
+	if node.eType == NodeValidator {
+		chainKey, err = primitives.PEMtoPrivateKey(files[node.conf.getEnrollmentChainKeyFilename()], passphrase)
+	} else {
+		chainKey, err = primitives.PEMtoPublicKey(files[node.conf.getEnrollmentChainKeyFilename()], nil)
+	}
+	if err != nil {
+		node.Errorf("Failed parsing enrollment chain key [%s].", err.Error())
+
+		return err
+	}
+
+	// Stage the materials, nothing is overwritten until they are all written
+	aliases := []string{
+		node.conf.getEnrollmentIDFilename(),
+		node.conf.getEnrollmentKeyFilename(),
+		node.conf.getEnrollmentChainKeyFilename(),
+		node.conf.getEnrollmentCertFilename(),
+	}
+	removeStaged := func() {
+		for _, alias := range aliases {
+			os.Remove(node.conf.getPathForAlias(stagedAlias(alias)))
+		}
+	}
+	if err := node.stageImportedEnrollment(files, key, der, chainKey); err != nil {
+		removeStaged()
+
+		return err
+	}
+
+	// Store the ECA certificates, restoring the previous ones on failure
+	restore, err := node.putImportedCerts(files)
+	if err != nil {
+		restore()
+		removeStaged()
+
+		return err
+	}
+
+	// Move the staged materials in place, the enrollment certificate last
+	for _, alias := range aliases {
+		path := node.conf.getPathForAlias(alias)
+		if err := os.Rename(node.conf.getPathForAlias(stagedAlias(alias)), path); err != nil {
+			node.Errorf("Failed moving imported enrollment data in place [%s]. The remaining files are left at [%s].", err.Error(), node.conf.getRawsPath())
+
+			return err
+		}
+	}
+
+	// Load the materials
+	if err := node.loadECACertsChain(context.Background()); err != nil {
+		return err
+	}
+	enrollPrivKey, err := node.readEnrollmentKey()
+	if err != nil {
+		return err
+	}
+	enrollCert, enrollCertRaw, err := node.readEnrollmentCertificate(enrollPrivKey)
+	if err != nil {
+		return err
+	}
+	enrollID, err := node.readEnrollmentID()
+	if err != nil {
+		return err
+	}
+
+	// The node may be running, publish the imported enrollment at once
+	node.enrollDataMutex.Lock()
+	node.enrollID = enrollID
+	node.enrollPrivKey = enrollPrivKey
+	node.setEnrollmentCertificate(enrollCert, enrollCertRaw)
+	node.enrollDataMutex.Unlock()
+	if err := node.loadEnrollmentChainKey(); err != nil {
+		return err
+	}
+
+	node.Debug("Importing enrollment...done!")
+
+	return nil
+}
+
+// stageImportedEnrollment writes the enrollment id, key, certificate and chain key
+// of an imported enrollment under their staged aliases
+func (node *nodeImpl) stageImportedEnrollment(files map[string][]byte, key interface{}, der []byte, chainKey interface{}) error {
+	path := node.conf.getPathForAlias(stagedAlias(node.conf.getEnrollmentIDFilename()))
+	if err := utils.WriteFileAtomic(path, files[node.conf.getEnrollmentIDFilename()], 0700); err != nil {
+		node.Errorf("Failed storing enrollment id [%s].", err.Error())
+
+		return err
+	}
+	if err := node.storeEnrollmentKeyAs(stagedAlias(node.conf.getEnrollmentKeyFilename()), key, node.getEnrollmentKeyPassphrase()); err != nil {
+		return err
+	}
+	if err := node.ks.storeCert(stagedAlias(node.conf.getEnrollmentCertFilename()), der); err != nil {
+		return err
+	}
+
+	var err error
+	if node.eType == NodeValidator {
+		err = node.ks.storePrivateKey(stagedAlias(node.conf.getEnrollmentChainKeyFilename()), chainKey)
+	} else {
+		err = node.ks.storePublicKey(stagedAlias(node.conf.getEnrollmentChainKeyFilename()), chainKey)
+	}
+	if err != nil {
+		node.Errorf("Failed storing enrollment chain key [%s].", err.Error())
+
+		return err
+	}
+
+	return nil
+}
+
+// putImportedCerts stores the ECA certificates chain and the intermediate certificates
+// of an imported enrollment in the cert store. The returned function puts back the
+// certificates stored before, also when it fails.
+func (node *nodeImpl) putImportedCerts(files map[string][]byte) (func(), error) {
+	names := []string{node.conf.getECACertsChainFilename()}
+	if _, ok := files[node.conf.getECertIntermediatesFilename()]; ok {
+		names = append(names, node.conf.getECertIntermediatesFilename())
+	}
+
+	var restores []func()
+	restore := func() {
+		for _, r := range restores {
+			r()
+		}
+	}
+	for _, name := range names {
+		name := name
+		prev, err := node.certStore.Get(name)
+		if err != nil && !errors.Is(err, utils.ErrCertNotFound) {
+			node.Errorf("Failed reading certificate [%s] [%s].", name, err.Error())
+
+			return restore, err
+		}
+		found := err == nil
+
+		if err := node.certStore.Put(name, files[name]); err != nil {
+			node.Errorf("Failed storing certificate [%s] [%s].", name, err.Error())
+
+			return restore, err
+		}
+		restores = append(restores, func() {
+			if found {
+				node.certStore.Put(name, prev)
+			} else {
+				node.certStore.Delete(name)
+			}
+		})
+	}
+
+	return restore, nil
+}
@@ -0,0 +1,107 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"container/list"
+	"sync"
+
+	membersrvc "github.com/hyperledger/fabric/membersrvc/protos"
+)
+
+// certCache keeps the last certificate pairs read from the ECA, by identity.
+// The least recently used pair is evicted when the cache is full.
+type certCache struct {
+	size int
+
+	entries map[string]*list.Element
+	order   *list.List
+	mutex   sync.Mutex
+}
+
+type certCacheEntry struct {
+	id   string
+	pair *membersrvc.CertPair
+}
+
+func newCertCache(size int) *certCache {
+	return &certCache{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func (cache *certCache) get(id string) (*membersrvc.CertPair, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	elem, ok := cache.entries[id]
+	if !ok {
+		return nil, false
+	}
+	cache.order.MoveToFront(elem)
+
+	return elem.Value.(*certCacheEntry).pair, true
+}
+
+func (cache *certCache) put(id string, pair *membersrvc.CertPair) {
+	if cache.size <= 0 {
+		return
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if elem, ok := cache.entries[id]; ok {
+		elem.Value.(*certCacheEntry).pair = pair
+		cache.order.MoveToFront(elem)
+
+		return
+	}
+
+	cache.entries[id] = cache.order.PushFront(&certCacheEntry{id, pair})
+	if cache.order.Len() > cache.size {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*certCacheEntry).id)
+	}
+}
+
+func (cache *certCache) remove(id string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if elem, ok := cache.entries[id]; ok {
+		cache.order.Remove(elem)
+		delete(cache.entries, id)
+	}
+}
+
+// getCertCache returns the cache of the certificates read from the ECA, created on first use
+func (node *nodeImpl) getCertCache() *certCache {
+	node.certCacheOnce.Do(func() {
+		node.certCache = newCertCache(node.conf.getCertReadCacheSize())
+	})
+
+	return node.certCache
+}
+
+// invalidateCertificate drops the cached certificate pair of id, if any
+func (node *nodeImpl) invalidateCertificate(id string) {
+	node.getCertCache().remove(id)
+}
@@ -0,0 +1,206 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	membersrvc "github.com/hyperledger/fabric/membersrvc/protos"
+)
+
+// CertInfo describes an enrollment certificate
+type CertInfo struct {
+	CommonName   string
+	Subject      pkix.Name
+	Issuer       pkix.Name
+	SerialNumber *big.Int
+	NotBefore    time.Time
+	NotAfter     time.Time
+
+	// Role is the role of the subject, as set by the ECA
+	Role membersrvc.Role
+
+	// Attributes holds the values of the attribute extensions, by OID
+	Attributes map[string][]byte
+}
+
+// GetEnrollmentCertInfo parses the stored enrollment certificate
+func (node *nodeImpl) GetEnrollmentCertInfo() (*CertInfo, error) {
+	cert, _, err := node.ks.loadCertX509AndDer(node.conf.getEnrollmentCertFilename())
+	if err != nil {
+		node.Errorf("Failed loading enrollment certificate [%s].", err.Error())
+
+		return nil, err
+	}
+
+	return newCertInfo(cert)
+}
+
+func newCertInfo(cert *x509.Certificate) (*CertInfo, error) {
+	info := &CertInfo{
+		CommonName:   cert.Subject.CommonName,
+		Subject:      cert.Subject,
+		Issuer:       cert.Issuer,
+		SerialNumber: cert.SerialNumber,
+		NotBefore:    cert.NotBefore,
+		NotAfter:     cert.NotAfter,
+		Attributes:   make(map[string][]byte),
+	}
+
+	for _, ext := range cert.Extensions {
+		switch {
+		case ext.Id.Equal(ECertSubjectRole):
+			role, err := strconv.Atoi(string(ext.Value))
+			if err != nil {
+				return nil, fmt.Errorf("Invalid subject role [%s]", ext.Value)
+			}
+			info.Role = membersrvc.Role(role)
+		case isAttributeOID(ext.Id):
+			info.Attributes[ext.Id.String()] = ext.Value
+		}
+	}
+
+	return info, nil
+}
+
+// isAttributeOID tells whether oid is the OID of an attribute extension.
+// Attributes are numbered after the attributes header.
+func isAttributeOID(oid []int) bool {
+	base := primitives.TCertEncAttributesBase
+	if len(oid) != len(base)+1 || oid[len(base)] <= primitives.TCertAttributesHeaders[len(base)] {
+		return false
+	}
+	for i := range base {
+		if oid[i] != base[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// StoredCertType tells what a stored certificate is used for
+type StoredCertType string
+
+const (
+	// StoredCertEnrollment The enrollment certificate
+	StoredCertEnrollment StoredCertType = "enrollment"
+
+	// StoredCertEnrollmentIntermediate A certificate between the ECA and the root
+	StoredCertEnrollmentIntermediate StoredCertType = "enrollment intermediate"
+
+	// StoredCertECAChain The ECA certificates chain
+	StoredCertECAChain StoredCertType = "ECA chain"
+
+	// StoredCertTCAChain The TCA certificates chain
+	StoredCertTCAChain StoredCertType = "TCA chain"
+
+	// StoredCertTLS The TLS certificate
+	StoredCertTLS StoredCertType = "TLS"
+
+	// StoredCertTLSCAChain The TLSCA certificates chain
+	StoredCertTLSCAChain StoredCertType = "TLSCA chain"
+)
+
+// StoredCertInfo describes a certificate stored by the node
+type StoredCertInfo struct {
+	Type StoredCertType
+
+	// Name is the name the certificate is stored under
+	Name string
+
+	Subject      pkix.Name
+	SerialNumber *big.Int
+	NotBefore    time.Time
+	NotAfter     time.Time
+
+	// Valid tells whether the current time is within the validity period
+	Valid bool
+
+	// Err is set if the stored certificate cannot be parsed, the other fields are then empty
+	Err error
+}
+
+// ListStoredCerts returns the certificates stored by the node, in the keystore and
+// in the cert store, for a quick look at what is on disk. Missing certificates are
+// skipped, certificates that cannot be parsed are listed with their error.
+func (node *nodeImpl) ListStoredCerts() ([]StoredCertInfo, error) {
+	stored := []struct {
+		certType StoredCertType
+		name     string
+		inKS     bool
+	}{
+		{StoredCertEnrollment, node.conf.getEnrollmentCertFilename(), true},
+		{StoredCertEnrollmentIntermediate, node.conf.getECertIntermediatesFilename(), false},
+		{StoredCertECAChain, node.conf.getECACertsChainFilename(), false},
+		{StoredCertTCAChain, node.conf.getTCACertsChainFilename(), true},
+		{StoredCertTLS, node.conf.getTLSCertFilename(), true},
+		{StoredCertTLSCAChain, node.conf.getTLSCACertsChainFilename(), false},
+	}
+
+	now := node.now()
+	var infos []StoredCertInfo
+	for _, entry := range stored {
+		var raw []byte
+		if entry.inKS {
+			if node.ks.certMissing(entry.name) {
+				continue
+			}
+
+			var err error
+			if raw, err = node.ks.loadCert(entry.name); err != nil {
+				return nil, fmt.Errorf("ListStoredCerts: %w", err)
+			}
+		} else {
+			var err error
+			raw, err = node.certStore.Get(entry.name)
+			if errors.Is(err, utils.ErrCertNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("ListStoredCerts: %w", err)
+			}
+		}
+
+		certs, err := decodeCerts(raw)
+		if err != nil {
+			infos = append(infos, StoredCertInfo{Type: entry.certType, Name: entry.name, Err: err})
+			continue
+		}
+		for _, cert := range certs {
+			infos = append(infos, StoredCertInfo{
+				Type:         entry.certType,
+				Name:         entry.name,
+				Subject:      cert.Subject,
+				SerialNumber: cert.SerialNumber,
+				NotBefore:    cert.NotBefore,
+				NotAfter:     cert.NotAfter,
+				Valid:        !now.Before(cert.NotBefore) && !now.After(cert.NotAfter),
+			})
+		}
+	}
+
+	return infos, nil
+}
@@ -0,0 +1,245 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	"golang.org/x/net/context"
+)
+
+// CertStore is a storage backend for encoded certificates
+type CertStore interface {
+
+	// Put stores the encoded certificate under the passed name
+	Put(name string, pem []byte) error
+
+	// Get returns the encoded certificate stored under the passed name.
+	// If there is none, the error wraps utils.ErrCertNotFound
+	Get(name string) ([]byte, error)
+
+	// Delete removes the certificate stored under the passed name.
+	// If there is none, the error wraps utils.ErrCertNotFound
+	Delete(name string) error
+}
+
+// certStoreFlusher is implemented by the cert stores buffering writes.
+// Flush is called when the node is closed.
+type certStoreFlusher interface {
+	Flush() error
+}
+
+var (
+	certStoreFactory      func() CertStore
+	certStoreFactoryMutex sync.RWMutex
+)
+
+// SetCertStoreFactory sets the function creating the certificate store of
+// the nodes initialized afterwards, for instance NewMemCertStore for peers
+// not keeping their certificates on disk. If nil, the keystore folder is used.
+func SetCertStoreFactory(factory func() CertStore) {
+	certStoreFactoryMutex.Lock()
+	defer certStoreFactoryMutex.Unlock()
+
+	certStoreFactory = factory
+}
+
+func getCertStoreFactory() func() CertStore {
+	certStoreFactoryMutex.RLock()
+	defer certStoreFactoryMutex.RUnlock()
+
+	return certStoreFactory
+}
+
+// encodeCerts encodes the passed DER certificates for storage,
+// in the format set by security.certstorageformat
+func (node *nodeImpl) encodeCerts(ders ...[]byte) []byte {
+	var raw []byte
+	for _, der := range ders {
+		if node.conf.getCertStorageFormat() == "der" {
+			raw = append(raw, der...)
+		} else {
+			raw = append(raw, primitives.DERCertToPEM(der)...)
+		}
+	}
+
+	return raw
+}
+
+// decodeCerts parses stored certificates. The encoding is detected,
+// so that certificates stored before a change of format still load.
+func decodeCerts(raw []byte) ([]*x509.Certificate, error) {
+	if bytes.Contains(raw, []byte("-----BEGIN")) {
+		return primitives.PEMtoCertificates(raw)
+	}
+
+	// DER is binary, trimming it could cut a signature ending with a whitespace byte
+	certs, err := x509.ParseCertificates(raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("No certificate available")
+	}
+
+	return certs, nil
+}
+
+// pendingCertReads bounds the cert store reads in flight in the process. A read
+// abandoned by readCert keeps its slot until the cert store returns, so that a
+// stuck mount blocks at most cap(pendingCertReads) goroutines.
+var pendingCertReads = make(chan struct{}, 16)
+
+// readCert gets the certificate stored under name, giving up when ctx is
+// done or after conf.getCertIOTimeout(), so that a stuck mount does not hang
+// the caller. The abandoned read goes on in the background until the cert store
+// returns; once pendingCertReads are all abandoned, the reads wait for a slot.
+func (node *nodeImpl) readCert(ctx context.Context, name string) ([]byte, error) {
+	if timeout := node.conf.getCertIOTimeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	ctxErr := func() error {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("readCert: %w: [%s]", utils.ErrCertIOTimeout, name)
+		}
+		return fmt.Errorf("readCert: %w", ctx.Err())
+	}
+
+	select {
+	case pendingCertReads <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctxErr()
+	}
+
+	type result struct {
+		raw []byte
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer func() { <-pendingCertReads }()
+
+		raw, err := node.certStore.Get(name)
+		done <- result{raw, err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.raw, res.err
+	case <-ctx.Done():
+		return nil, ctxErr()
+	}
+}
+
+// fileCertStore stores certificates in the raw folder of the keystore
+type fileCertStore struct {
+	node *nodeImpl
+}
+
+func (store *fileCertStore) Put(name string, pem []byte) error {
+	path := store.node.conf.getPathForAlias(name)
+	store.node.Debugf("Storing certificate [%s] at [%s]...", name, path)
+
+	// On a fresh host the raw folder may not exist yet
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		store.node.Errorf("Failed creating the cert store directory of [%s]: [%s]", name, err)
+
+		return fmt.Errorf("Put: %w: %v", utils.ErrCertStoreDir, err)
+	}
+
+	return utils.WriteFileAtomic(path, pem, store.node.conf.getCertFilePerm())
+}
+
+func (store *fileCertStore) Get(name string) ([]byte, error) {
+	path := store.node.conf.getPathForAlias(name)
+	store.node.Debugf("Loading certificate [%s] at [%s]...", name, path)
+
+	pem, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %v", utils.ErrCertNotFound, err)
+	}
+
+	return pem, err
+}
+
+func (store *fileCertStore) Delete(name string) error {
+	path := store.node.conf.getPathForAlias(name)
+	store.node.Debugf("Deleting certificate [%s] at [%s]...", name, path)
+
+	err := os.Remove(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: %v", utils.ErrCertNotFound, err)
+	}
+
+	return err
+}
+
+// memCertStore keeps certificates in memory
+type memCertStore struct {
+	sync.RWMutex
+	pems map[string][]byte
+}
+
+// NewMemCertStore returns a CertStore keeping the certificates in memory.
+// They are lost when the process exits.
+func NewMemCertStore() CertStore {
+	return &memCertStore{pems: make(map[string][]byte)}
+}
+
+func (store *memCertStore) Put(name string, pem []byte) error {
+	store.Lock()
+	defer store.Unlock()
+
+	store.pems[name] = append([]byte(nil), pem...)
+
+	return nil
+}
+
+func (store *memCertStore) Get(name string) ([]byte, error) {
+	store.RLock()
+	defer store.RUnlock()
+
+	pem, ok := store.pems[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: [%s]", utils.ErrCertNotFound, name)
+	}
+
+	return append([]byte(nil), pem...), nil
+}
+
+func (store *memCertStore) Delete(name string) error {
+	store.Lock()
+	defer store.Unlock()
+
+	if _, ok := store.pems[name]; !ok {
+		return fmt.Errorf("%w: [%s]", utils.ErrCertNotFound, name)
+	}
+	delete(store.pems, name)
+
+	return nil
+}
@@ -0,0 +1,341 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	"golang.org/x/net/context"
+)
+
+func newTestCertStoreNode(t *testing.T, perm os.FileMode) (*nodeImpl, func()) {
+	dir, err := ioutil.TempDir("", "obc-crypto-certstore")
+	if err != nil {
+		t.Fatalf("Failed creating temporary folder [%s]", err)
+	}
+
+	node := &nodeImpl{conf: &configuration{rawsPath: dir, certFilePerm: perm}}
+
+	return node, func() { os.RemoveAll(dir) }
+}
+
+func TestFileCertStorePermissions(t *testing.T) {
+	for _, perm := range []os.FileMode{0644, 0600} {
+		node, cleanup := newTestCertStoreNode(t, perm)
+		defer cleanup()
+
+		store := &fileCertStore{node}
+		pem := []byte("-----BEGIN CERTIFICATE-----\n-----END CERTIFICATE-----\n")
+		if err := store.Put(node.conf.getECACertsChainFilename(), pem); err != nil {
+			t.Fatalf("Failed storing certificate [%s]", err)
+		}
+
+		info, err := os.Stat(filepath.Join(node.conf.getRawsPath(), node.conf.getECACertsChainFilename()))
+		if err != nil {
+			t.Fatalf("Failed stating certificate file [%s]", err)
+		}
+		if info.Mode().Perm() != perm {
+			t.Fatalf("Certificate file mode must be [%s], got [%s]", perm, info.Mode().Perm())
+		}
+
+		loaded, err := store.Get(node.conf.getECACertsChainFilename())
+		if err != nil {
+			t.Fatalf("Failed loading certificate [%s]", err)
+		}
+		if !bytes.Equal(pem, loaded) {
+			t.Fatal("Loaded certificate differs from the stored one")
+		}
+	}
+}
+
+func TestFileCertStoreMissingDir(t *testing.T) {
+	node, cleanup := newTestCertStoreNode(t, 0644)
+	defer cleanup()
+	dir := node.conf.rawsPath
+
+	// The raw folder is created on first use
+	node.conf.rawsPath = filepath.Join(dir, "fresh", "raw")
+	store := &fileCertStore{node}
+	pem := []byte("-----BEGIN CERTIFICATE-----\n-----END CERTIFICATE-----\n")
+	if err := store.Put(node.conf.getECACertsChainFilename(), pem); err != nil {
+		t.Fatalf("Failed storing certificate in a missing folder [%s]", err)
+	}
+	info, err := os.Stat(node.conf.rawsPath)
+	if err != nil {
+		t.Fatalf("Failed stating the raw folder [%s]", err)
+	}
+	if info.Mode().Perm() != 0700 {
+		t.Fatalf("Raw folder mode must be [%s], got [%s]", os.FileMode(0700), info.Mode().Perm())
+	}
+
+	// A file in the way
+	if err := ioutil.WriteFile(filepath.Join(dir, "file"), nil, 0600); err != nil {
+		t.Fatalf("Failed creating file [%s]", err)
+	}
+	node.conf.rawsPath = filepath.Join(dir, "file", "raw")
+	if err := store.Put(node.conf.getECACertsChainFilename(), pem); !errors.Is(err, utils.ErrCertStoreDir) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrCertStoreDir, err)
+	}
+}
+
+func TestCertStoreNotFound(t *testing.T) {
+	node, cleanup := newTestCertStoreNode(t, 0644)
+	defer cleanup()
+
+	for _, store := range []CertStore{&fileCertStore{node}, NewMemCertStore()} {
+		name := node.conf.getECACertsChainFilename()
+		if _, err := store.Get(name); !errors.Is(err, utils.ErrCertNotFound) {
+			t.Fatalf("%T: Get of a missing certificate must fail with ErrCertNotFound, got [%v]", store, err)
+		}
+		if err := store.Delete(name); !errors.Is(err, utils.ErrCertNotFound) {
+			t.Fatalf("%T: Delete of a missing certificate must fail with ErrCertNotFound, got [%v]", store, err)
+		}
+
+		pem := []byte("-----BEGIN CERTIFICATE-----\n-----END CERTIFICATE-----\n")
+		if err := store.Put(name, pem); err != nil {
+			t.Fatalf("%T: Failed storing certificate [%s]", store, err)
+		}
+		if loaded, err := store.Get(name); err != nil || !bytes.Equal(pem, loaded) {
+			t.Fatalf("%T: Loaded certificate differs from the stored one [%v]", store, err)
+		}
+		if err := store.Delete(name); err != nil {
+			t.Fatalf("%T: Failed deleting certificate [%s]", store, err)
+		}
+		if _, err := store.Get(name); !errors.Is(err, utils.ErrCertNotFound) {
+			t.Fatalf("%T: Get of a deleted certificate must fail with ErrCertNotFound, got [%v]", store, err)
+		}
+	}
+}
+
+func TestMemCertStoreECACertsChain(t *testing.T) {
+	// The path locks are still taken in the raws folder
+	node, cleanup := newTestCertStoreNode(t, 0644)
+	defer cleanup()
+	node.certStore = NewMemCertStore()
+
+	certRaw, _, err := primitives.NewSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Failed generating certificate [%s]", err)
+	}
+	if err := node.loadECACertsChain(context.Background()); !errors.Is(err, utils.ErrCertNotFound) {
+		t.Fatalf("Loading a missing ECA certificates chain must fail with ErrCertNotFound, got [%v]", err)
+	}
+
+	if err := node.certStore.Put(node.conf.getECACertsChainFilename(), primitives.DERCertToPEM(certRaw)); err != nil {
+		t.Fatalf("Failed storing ECA certificates chain [%s]", err)
+	}
+	// The chain is already stored, so the ECA is not contacted
+	if err := node.retrieveECACertsChain("user"); err != nil {
+		t.Fatalf("Failed retrieving ECA certificates chain [%s]", err)
+	}
+	if err := node.loadECACertsChain(context.Background()); err != nil {
+		t.Fatalf("Failed loading ECA certificates chain [%s]", err)
+	}
+	if !bytes.Equal(node.ecaCert.Raw, certRaw) {
+		t.Fatal("Loaded ECA certificate differs from the stored one")
+	}
+}
+
+func TestCertStorageFormat(t *testing.T) {
+	certRaw, _, err := primitives.NewSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Failed generating certificate [%s]", err)
+	}
+
+	for _, format := range []string{"pem", "der"} {
+		node, cleanup := newTestCertStoreNode(t, 0644)
+		defer cleanup()
+		node.conf.certStorageFormat = format
+		node.certStore = NewMemCertStore()
+
+		raw := node.encodeCerts(certRaw, certRaw)
+		if isPEM := bytes.Contains(raw, []byte("-----BEGIN")); isPEM != (format == "pem") {
+			t.Fatalf("%s: Unexpected encoding of the stored certificates [% x]", format, raw)
+		}
+		if err := node.certStore.Put(node.conf.getECACertsChainFilename(), node.encodeCerts(certRaw)); err != nil {
+			t.Fatalf("%s: Failed storing ECA certificates chain [%s]", format, err)
+		}
+		if err := node.certStore.Put(node.conf.getECertIntermediatesFilename(), raw); err != nil {
+			t.Fatalf("%s: Failed storing intermediate certificates [%s]", format, err)
+		}
+
+		// Certificates stored in the other format must still load
+		for _, loadFormat := range []string{"pem", "der"} {
+			node.conf.certStorageFormat = loadFormat
+			if err := node.loadECACertsChain(context.Background()); err != nil {
+				t.Fatalf("%s: Failed loading ECA certificates chain with format [%s] [%s]", format, loadFormat, err)
+			}
+			if !bytes.Equal(node.ecaCert.Raw, certRaw) {
+				t.Fatalf("%s: Loaded ECA certificate differs from the stored one", format)
+			}
+			if len(node.ecertIntermediates) != 2 {
+				t.Fatalf("%s: Expected 2 intermediate certificates, got [%d]", format, len(node.ecertIntermediates))
+			}
+		}
+	}
+
+	if _, err := decodeCerts([]byte("not a certificate")); err == nil {
+		t.Fatal("Decoding garbage must fail")
+	}
+}
+
+func TestLoadEmptyECACertsChain(t *testing.T) {
+	node, cleanup := newTestCertStoreNode(t, 0644)
+	defer cleanup()
+	node.certStore = &fileCertStore{node}
+
+	for _, raw := range [][]byte{{}, []byte(" \n\t\n")} {
+		if err := node.certStore.Put(node.conf.getECACertsChainFilename(), raw); err != nil {
+			t.Fatalf("Failed storing ECA certificates chain [%s]", err)
+		}
+		if err := node.loadECACertsChain(context.Background()); !errors.Is(err, utils.ErrEmptyCertChain) {
+			t.Fatalf("Loading an empty ECA certificates chain must fail with ErrEmptyCertChain, got [%v]", err)
+		}
+	}
+}
+
+// stuckCertStore never returns from Get until released, like a dead NFS mount
+type stuckCertStore struct {
+	CertStore
+	release chan struct{}
+}
+
+func (store *stuckCertStore) Get(name string) ([]byte, error) {
+	<-store.release
+
+	return store.CertStore.Get(name)
+}
+
+func TestLoadECACertsChainTimeout(t *testing.T) {
+	store := &stuckCertStore{NewMemCertStore(), make(chan struct{})}
+	node, cleanup := newTestCertStoreNode(t, 0644)
+	defer cleanup()
+	node.conf.certIOTimeout = 50 * time.Millisecond
+	node.certStore = store
+
+	loaded := make(chan error, 1)
+	go func() {
+		loaded <- node.loadECACertsChain(context.Background())
+	}()
+
+	select {
+	case err := <-loaded:
+		if !errors.Is(err, utils.ErrCertIOTimeout) {
+			t.Fatalf("Expected [%s], got [%v]", utils.ErrCertIOTimeout, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Loading the ECA certificates chain from a stuck store must time out")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := node.readCert(ctx, node.conf.getECACertsChainFilename()); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected [%s], got [%v]", context.Canceled, err)
+	}
+
+	// The abandoned reads are capped, then the reads time out waiting for a slot
+	var wg sync.WaitGroup
+	for i := 0; i < cap(pendingCertReads); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			node.readCert(context.Background(), node.conf.getECACertsChainFilename())
+		}()
+	}
+	wg.Wait()
+	if len(pendingCertReads) != cap(pendingCertReads) {
+		t.Fatalf("Expected [%d] abandoned reads, got [%d]", cap(pendingCertReads), len(pendingCertReads))
+	}
+	if _, err := node.readCert(context.Background(), node.conf.getECACertsChainFilename()); !errors.Is(err, utils.ErrCertIOTimeout) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrCertIOTimeout, err)
+	}
+
+	// The slots are released once the store returns
+	close(store.release)
+	for deadline := time.Now().Add(5 * time.Second); len(pendingCertReads) != 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("The abandoned reads must release their slot, [%d] left", len(pendingCertReads))
+		}
+	}
+}
+
+func TestFileCertStoreAtomicPut(t *testing.T) {
+	node, cleanup := newTestCertStoreNode(t, 0644)
+	defer cleanup()
+
+	store := &fileCertStore{node}
+	name := node.conf.getECACertsChainFilename()
+	for _, pem := range [][]byte{[]byte("first"), []byte("second")} {
+		if err := store.Put(name, pem); err != nil {
+			t.Fatalf("Failed storing certificate [%s]", err)
+		}
+		if loaded, err := store.Get(name); err != nil || !bytes.Equal(pem, loaded) {
+			t.Fatalf("Loaded certificate differs from the stored one [%v]", err)
+		}
+	}
+
+	// The temporary files are renamed into place
+	files, err := ioutil.ReadDir(node.conf.getRawsPath())
+	if err != nil {
+		t.Fatalf("Failed listing the raws folder [%s]", err)
+	}
+	if len(files) != 1 || files[0].Name() != name {
+		t.Fatalf("Only [%s] must be left in the raws folder, got [%d] files", name, len(files))
+	}
+}
+
+func TestLockPath(t *testing.T) {
+	node, cleanup := newTestCertStoreNode(t, 0644)
+	defer cleanup()
+
+	path := node.conf.getPathForAlias(node.conf.getECACertsChainFilename())
+	unlock := lockPath(path)
+
+	// Other processes are kept out by the lock file
+	file, err := os.OpenFile(path+".lock", os.O_RDWR, 0600)
+	if err != nil {
+		t.Fatalf("Failed opening lock file [%s]", err)
+	}
+	defer file.Close()
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err == nil {
+		t.Fatal("The lock file must be locked while the path is")
+	}
+
+	unlock()
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		t.Fatalf("The lock file must be unlocked with the path [%s]", err)
+	}
+	syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+
+	// The lock is dropped once released
+	pathLocksMutex.Lock()
+	_, ok := pathLocks[path]
+	pathLocksMutex.Unlock()
+	if ok {
+		t.Fatal("Released path locks must be dropped")
+	}
+}
@@ -18,9 +18,16 @@ package crypto
 
 import (
 	"errors"
+	"fmt"
+	"net"
+	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/spf13/viper"
+	"google.golang.org/grpc"
 )
 
 func (node *nodeImpl) initConfiguration(name string) (err error) {
@@ -33,11 +40,27 @@ func (node *nodeImpl) initConfiguration(name string) (err error) {
 		return
 	}
 
+	if err = node.conf.validateECAPAddrs(); err != nil {
+		node.Errorf("Invalid configuration: [%s]", err)
+		return
+	}
+
 	node.Debugf("Data will be stored at [%s]", node.conf.configurationPath)
 
 	return
 }
 
+// ecaKeepalive holds the keepalive parameters of the ECA connection
+type ecaKeepalive struct {
+	// Interval of the TCP keepalives and of the ECA probes
+	time time.Duration
+	// Maximum time to wait for an ECA probe to be answered
+	timeout time.Duration
+	// Probe the ECA even when no call is in progress, polling it with
+	// ReadCACertificate calls
+	permitWithoutStream bool
+}
+
 type configuration struct {
 	prefix string
 	name   string
@@ -56,13 +79,49 @@ type configuration struct {
 	tlscaPAddressProperty     string
 
 	securityLevel                  int
+	minSecurityLevel               int
 	hashAlgorithm                  string
 	confidentialityProtocolVersion string
-
-	tlsServerName string
-
-	multiThreading bool
-	tCertBatchSize int
+	ctSubmitRequired               bool
+
+	tlsServerName    string
+	ecaTLSServerName string
+
+	ecaDialTimeout       time.Duration
+	ecaUserAgent         string
+	ecaDialOptions       []grpc.DialOption
+	ecaInterceptors      []ECAUnaryInterceptor
+	keySealer            KeySealer
+	ecaKeepalive         ecaKeepalive
+	ecaEnrollmentTimeout time.Duration
+	ecaReadTimeout       time.Duration
+	ecaCloseTimeout      time.Duration
+	ecaMaxRecvSize       int
+	ecaRetryAttempts     int
+	ecaRetryBaseDelay    time.Duration
+	ecaReadConcurrency   int
+	certReadCacheSize    int
+	ecaClockSkew         time.Duration
+	ecaAttributes        bool
+	ecaCertCacheTTL      time.Duration
+
+	enrollmentCertExpiryWarningThreshold time.Duration
+	maxCertLifetime                      time.Duration
+	revocationCheckInterval              time.Duration
+	ocspTimeout                          time.Duration
+	renewalThreshold                     time.Duration
+	renewalCheckInterval                 time.Duration
+
+	multiThreading     bool
+	tCertBatchSize     int
+	tCertLowWaterMark  int
+	tCertPersistUnused bool
+
+	certFilePerm      os.FileMode
+	certIOTimeout     time.Duration
+	certStorageFormat string
+	certVerifyMode    string
+	redactLogs        bool
 }
 
 func (conf *configuration) init() error {
@@ -76,8 +135,10 @@ func (conf *configuration) init() error {
 	if err := conf.checkProperty(conf.configurationPathProperty); err != nil {
 		return err
 	}
-	if err := conf.checkProperty(conf.ecaPAddressProperty); err != nil {
-		return err
+	if os.Getenv(ecaPAddrEnv) == "" {
+		if err := conf.checkProperty(conf.ecaPAddressProperty); err != nil {
+			return err
+		}
 	}
 	if err := conf.checkProperty(conf.tcaPAddressProperty); err != nil {
 		return err
@@ -112,6 +173,15 @@ func (conf *configuration) init() error {
 		}
 	}
 
+	// Set the minimum strength, in bits, of the curves keys are generated on. Zero accepts any curve.
+	conf.minSecurityLevel = 0
+	if viper.IsSet("security.minlevel") {
+		conf.minSecurityLevel = viper.GetInt("security.minlevel")
+	}
+
+	// Set whether a failed submission to the CT log fails the enrollment
+	conf.ctSubmitRequired = viper.GetBool("security.ct.required")
+
 	conf.hashAlgorithm = "SHA3"
 	if viper.IsSet("security.hashAlgorithm") {
 		ovveride := viper.GetString("security.hashAlgorithm")
@@ -137,6 +207,195 @@ func (conf *configuration) init() error {
 		}
 	}
 
+	// Set ECA TLS host override. Unless overridden, the ECA TLS certificate must match
+	// the host of the ECA replica dialed, see getECATLSServerName
+	conf.ecaTLSServerName = ""
+	if viper.GetString("peer.pki.tls.serverhostoverride") != "" {
+		conf.ecaTLSServerName = conf.tlsServerName
+	}
+	if viper.IsSet("peer.pki.eca.tls.serverhostoverride") {
+		ovveride := viper.GetString("peer.pki.eca.tls.serverhostoverride")
+		if ovveride != "" {
+			conf.ecaTLSServerName = ovveride
+		}
+	}
+
+	// Set ECA dial timeout
+	conf.ecaDialTimeout = 5 * time.Second
+	if viper.IsSet("peer.pki.eca.dialtimeout") {
+		ovveride := viper.GetDuration("peer.pki.eca.dialtimeout")
+		if ovveride != 0 {
+			conf.ecaDialTimeout = ovveride
+		}
+	}
+
+	// Set ECA dial options
+	conf.ecaDialOptions = getECADialOptions()
+	conf.ecaInterceptors = getECAUnaryInterceptors()
+
+	// Set the sealer of the enrollment key
+	conf.keySealer = getKeySealer()
+
+	// Set ECA keepalive parameters
+	conf.ecaKeepalive = ecaKeepalive{time: 2 * time.Minute, timeout: 20 * time.Second}
+	if viper.IsSet("peer.pki.eca.keepalive.time") {
+		ovveride := viper.GetDuration("peer.pki.eca.keepalive.time")
+		if ovveride != 0 {
+			conf.ecaKeepalive.time = ovveride
+		}
+	}
+	if viper.IsSet("peer.pki.eca.keepalive.timeout") {
+		ovveride := viper.GetDuration("peer.pki.eca.keepalive.timeout")
+		if ovveride != 0 {
+			conf.ecaKeepalive.timeout = ovveride
+		}
+	}
+	conf.ecaKeepalive.permitWithoutStream = viper.GetBool("peer.pki.eca.keepalive.permitwithoutstream")
+
+	// Set the user agent of the ECA calls, for the ECA audit logs
+	conf.ecaUserAgent = "fabric-crypto"
+	if version := viper.GetString("peer.version"); version != "" {
+		conf.ecaUserAgent += "/" + version
+	}
+	if viper.IsSet("peer.pki.eca.useragent") {
+		ovveride := viper.GetString("peer.pki.eca.useragent")
+		if ovveride != "" {
+			conf.ecaUserAgent = ovveride
+		}
+	}
+
+	// Set ECA enrollment timeout
+	conf.ecaEnrollmentTimeout = 30 * time.Second
+	if viper.IsSet("peer.pki.eca.timeout") {
+		ovveride := viper.GetDuration("peer.pki.eca.timeout")
+		if ovveride != 0 {
+			conf.ecaEnrollmentTimeout = ovveride
+		}
+	}
+
+	// Set ECA read timeout
+	conf.ecaReadTimeout = 5 * time.Second
+	if viper.IsSet("peer.pki.eca.readtimeout") {
+		ovveride := viper.GetDuration("peer.pki.eca.readtimeout")
+		if ovveride != 0 {
+			conf.ecaReadTimeout = ovveride
+		}
+	}
+
+	// Set how long close waits for the in-flight enrollments to abort
+	conf.ecaCloseTimeout = 5 * time.Second
+	if viper.IsSet("peer.pki.eca.closetimeout") {
+		ovveride := viper.GetDuration("peer.pki.eca.closetimeout")
+		if ovveride != 0 {
+			conf.ecaCloseTimeout = ovveride
+		}
+	}
+
+	// Set the maximum size of the ECA responses. Certificates are small
+	conf.ecaMaxRecvSize = 4 << 20
+	if viper.IsSet("peer.pki.eca.maxrecvsize") {
+		ovveride := viper.GetInt("peer.pki.eca.maxrecvsize")
+		if ovveride < 0 {
+			return fmt.Errorf("Invalid ECA maximum response size [%d]. It must be positive.", ovveride)
+		}
+		if ovveride != 0 {
+			conf.ecaMaxRecvSize = ovveride
+		}
+	}
+
+	// Set ECA retry policy
+	conf.ecaRetryAttempts = 3
+	if viper.IsSet("peer.pki.eca.retry.attempts") {
+		ovveride := viper.GetInt("peer.pki.eca.retry.attempts")
+		if ovveride != 0 {
+			conf.ecaRetryAttempts = ovveride
+		}
+	}
+
+	conf.ecaRetryBaseDelay = 500 * time.Millisecond
+	if viper.IsSet("peer.pki.eca.retry.basedelay") {
+		ovveride := viper.GetDuration("peer.pki.eca.retry.basedelay")
+		if ovveride != 0 {
+			conf.ecaRetryBaseDelay = ovveride
+		}
+	}
+
+	// Set the number of concurrent certificate reads
+	conf.ecaReadConcurrency = 8
+	if viper.IsSet("peer.pki.eca.readconcurrency") {
+		ovveride := viper.GetInt("peer.pki.eca.readconcurrency")
+		if ovveride > 0 {
+			conf.ecaReadConcurrency = ovveride
+		}
+	}
+
+	// Set the number of certificates read from the ECA kept in memory. Zero disables the cache.
+	conf.certReadCacheSize = 128
+	if viper.IsSet("peer.pki.eca.readcachesize") {
+		ovveride := viper.GetInt("peer.pki.eca.readcachesize")
+		if ovveride >= 0 {
+			conf.certReadCacheSize = ovveride
+		}
+	}
+
+	// Set tolerated clock skew between this node and the ECA
+	conf.ecaClockSkew = time.Minute
+	if viper.IsSet("peer.pki.eca.clockskew") {
+		conf.ecaClockSkew = viper.GetDuration("peer.pki.eca.clockskew")
+	}
+
+	// Set whether the ECA embeds the requested attributes in the enrollment certificates
+	conf.ecaAttributes = viper.GetBool("peer.pki.eca.attributes")
+
+	// Set how long the ECA certificate is cached. Zero caches it forever, negative never.
+	conf.ecaCertCacheTTL = 0
+	if viper.IsSet("peer.pki.eca.cert.cachettl") {
+		conf.ecaCertCacheTTL = viper.GetDuration("peer.pki.eca.cert.cachettl")
+	}
+
+	// Set enrollment certificate expiry warning threshold
+	conf.enrollmentCertExpiryWarningThreshold = 7 * 24 * time.Hour
+	if viper.IsSet("security.enrollment.expirywarning") {
+		conf.enrollmentCertExpiryWarningThreshold = viper.GetDuration("security.enrollment.expirywarning")
+	}
+
+	// Set the longest validity period accepted for enrollment certificates. Zero accepts any.
+	conf.maxCertLifetime = 365 * 24 * time.Hour
+	if viper.IsSet("security.enrollment.maxlifetime") {
+		conf.maxCertLifetime = viper.GetDuration("security.enrollment.maxlifetime")
+	}
+
+	// Set enrollment certificate revocation check interval. Zero disables the check.
+	conf.revocationCheckInterval = 0
+	if viper.IsSet("security.enrollment.revocation.interval") {
+		conf.revocationCheckInterval = viper.GetDuration("security.enrollment.revocation.interval")
+	}
+
+	// Set OCSP request timeout
+	conf.ocspTimeout = 10 * time.Second
+	if viper.IsSet("security.enrollment.revocation.timeout") {
+		ovveride := viper.GetDuration("security.enrollment.revocation.timeout")
+		if ovveride != 0 {
+			conf.ocspTimeout = ovveride
+		}
+	}
+
+	// Set enrollment certificate renewal parameters
+	conf.renewalThreshold = 72 * time.Hour
+	if viper.IsSet("security.enrollment.renewal.threshold") {
+		ovveride := viper.GetDuration("security.enrollment.renewal.threshold")
+		if ovveride != 0 {
+			conf.renewalThreshold = ovveride
+		}
+	}
+	conf.renewalCheckInterval = time.Hour
+	if viper.IsSet("security.enrollment.renewal.interval") {
+		ovveride := viper.GetDuration("security.enrollment.renewal.interval")
+		if ovveride != 0 {
+			conf.renewalCheckInterval = ovveride
+		}
+	}
+
 	// Set tCertBatchSize
 	conf.tCertBatchSize = 200
 	if viper.IsSet("security.tcert.batch.size") {
@@ -146,6 +405,86 @@ func (conf *configuration) init() error {
 		}
 	}
 
+	// Set tCertLowWaterMark, the pool is refilled in background below it
+	conf.tCertLowWaterMark = conf.tCertBatchSize / 4
+	if viper.IsSet("security.tcert.lowwatermark") {
+		ovveride := viper.GetInt("security.tcert.lowwatermark")
+		if ovveride != 0 {
+			conf.tCertLowWaterMark = ovveride
+		}
+	}
+	if conf.tCertLowWaterMark >= conf.tCertBatchSize {
+		return fmt.Errorf("Invalid TCert low water mark [%d], must be below the batch size [%d]", conf.tCertLowWaterMark, conf.tCertBatchSize)
+	}
+
+	// Set tCertPersistUnused
+	conf.tCertPersistUnused = true
+	if viper.IsSet("security.tcert.persist") {
+		conf.tCertPersistUnused = viper.GetBool("security.tcert.persist")
+	}
+
+	// Set certificate files permissions.
+	// Certificates are public, there is no need to restrict read access.
+	conf.certFilePerm = 0644
+	if viper.IsSet("security.certfileperm") {
+		switch ovveride := viper.Get("security.certfileperm").(type) {
+		case string:
+			if ovveride != "" {
+				perm, err := strconv.ParseUint(ovveride, 8, 32)
+				if err != nil {
+					return fmt.Errorf("Invalid certificate file permissions [%s]: [%s]", ovveride, err)
+				}
+				conf.certFilePerm = os.FileMode(perm)
+			}
+		default:
+			// Unquoted, YAML decodes 0644 as the integer 420
+			conf.certFilePerm = os.FileMode(viper.GetInt("security.certfileperm"))
+		}
+		if conf.certFilePerm&^os.ModePerm != 0 {
+			return fmt.Errorf("Invalid certificate file permissions [%o]", conf.certFilePerm)
+		}
+	}
+
+	// Set the time allowed to read a stored certificate,
+	// network filesystems can hang when the server is gone
+	conf.certIOTimeout = 30 * time.Second
+	if viper.IsSet("security.certiotimeout") {
+		ovveride := viper.GetDuration("security.certiotimeout")
+		if ovveride != 0 {
+			conf.certIOTimeout = ovveride
+		}
+	}
+
+	// Set the encoding of the stored certificates
+	conf.certStorageFormat = "pem"
+	if viper.IsSet("security.certstorageformat") {
+		ovveride := strings.ToLower(viper.GetString("security.certstorageformat"))
+		if ovveride != "" {
+			if ovveride != "pem" && ovveride != "der" {
+				return fmt.Errorf("Invalid certificate storage format [%s]. Expected pem or der.", ovveride)
+			}
+			conf.certStorageFormat = ovveride
+		}
+	}
+
+	// Set what to do when a certificate does not chain to a trusted root
+	conf.certVerifyMode = "enforce"
+	if viper.IsSet("security.certverificationmode") {
+		ovveride := strings.ToLower(viper.GetString("security.certverificationmode"))
+		if ovveride != "" {
+			if ovveride != "enforce" && ovveride != "warn" {
+				return fmt.Errorf("Invalid certificate verification mode [%s]. Expected enforce or warn.", ovveride)
+			}
+			conf.certVerifyMode = ovveride
+		}
+	}
+
+	// Set whether identity ids and raw certificates are kept out of the logs
+	conf.redactLogs = false
+	if viper.IsSet("security.redactsensitivelogs") {
+		conf.redactLogs = viper.GetBool("security.redactsensitivelogs")
+	}
+
 	// Set multithread
 	conf.multiThreading = false
 	if viper.IsSet("security.multithreading.enabled") {
@@ -167,10 +506,165 @@ func (conf *configuration) getTCAPAddr() string {
 	return viper.GetString(conf.tcaPAddressProperty)
 }
 
+// ecaPAddrEnv is the environment variable overriding peer.pki.eca.paddr
+const ecaPAddrEnv = "CORE_PEER_PKI_ECA_PADDR"
+
+// getECAPAddr returns the configured ECA addresses, as a comma separated list.
+// The environment variable CORE_PEER_PKI_ECA_PADDR takes precedence over the configuration.
 func (conf *configuration) getECAPAddr() string {
+	if addr := os.Getenv(ecaPAddrEnv); addr != "" {
+		return addr
+	}
+
 	return viper.GetString(conf.ecaPAddressProperty)
 }
 
+// getECAPAddrs returns the addresses of the ECA replicas, in the order they are tried.
+// They are configured as a comma separated list.
+func (conf *configuration) getECAPAddrs() []string {
+	var addrs []string
+	for _, addr := range strings.Split(conf.getECAPAddr(), ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	return addrs
+}
+
+// validateECAPAddrs checks that at least one ECA address is configured and that all are valid
+func (conf *configuration) validateECAPAddrs() error {
+	addrs := conf.getECAPAddrs()
+	if len(addrs) == 0 {
+		return fmt.Errorf("Invalid ECA address [%s] at [%s], no address specified", conf.getECAPAddr(), conf.ecaPAddressProperty)
+	}
+
+	for _, addr := range addrs {
+		if err := conf.validateECAPAddr(addr); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateECAPAddr checks that the ECA address is in the host:port form,
+// or that it names a unix domain socket as unix:///path/to/socket
+func (conf *configuration) validateECAPAddr(addr string) error {
+	if strings.HasPrefix(addr, unixScheme) {
+		if strings.TrimPrefix(addr, unixScheme) == "" {
+			return fmt.Errorf("Invalid ECA address [%s] at [%s], missing socket path", addr, conf.ecaPAddressProperty)
+		}
+
+		return nil
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("Invalid ECA address [%s] at [%s], expected host:port: [%s]", addr, conf.ecaPAddressProperty, err)
+	}
+	if host == "" {
+		return fmt.Errorf("Invalid ECA address [%s] at [%s], missing host", addr, conf.ecaPAddressProperty)
+	}
+	if p, err := strconv.ParseUint(port, 10, 16); err != nil || p == 0 {
+		return fmt.Errorf("Invalid ECA address [%s] at [%s], invalid port [%s]", addr, conf.ecaPAddressProperty, port)
+	}
+
+	return nil
+}
+
+func (conf *configuration) getECADialTimeout() time.Duration {
+	return conf.ecaDialTimeout
+}
+
+func (conf *configuration) getECADialOptions() []grpc.DialOption {
+	return conf.ecaDialOptions
+}
+
+func (conf *configuration) getECAUnaryInterceptors() []ECAUnaryInterceptor {
+	return conf.ecaInterceptors
+}
+
+func (conf *configuration) getKeySealer() KeySealer {
+	return conf.keySealer
+}
+
+func (conf *configuration) getECAKeepalive() ecaKeepalive {
+	return conf.ecaKeepalive
+}
+
+func (conf *configuration) getClientUserAgent() string {
+	return conf.ecaUserAgent
+}
+
+func (conf *configuration) getECAEnrollmentTimeout() time.Duration {
+	return conf.ecaEnrollmentTimeout
+}
+
+func (conf *configuration) getECAReadTimeout() time.Duration {
+	return conf.ecaReadTimeout
+}
+
+func (conf *configuration) getECACloseTimeout() time.Duration {
+	return conf.ecaCloseTimeout
+}
+
+func (conf *configuration) getECAMaxRecvSize() int {
+	return conf.ecaMaxRecvSize
+}
+
+func (conf *configuration) getECARetryAttempts() int {
+	return conf.ecaRetryAttempts
+}
+
+func (conf *configuration) getECARetryBaseDelay() time.Duration {
+	return conf.ecaRetryBaseDelay
+}
+
+func (conf *configuration) getECAReadConcurrency() int {
+	return conf.ecaReadConcurrency
+}
+
+func (conf *configuration) getCertReadCacheSize() int {
+	return conf.certReadCacheSize
+}
+
+func (conf *configuration) getECAClockSkew() time.Duration {
+	return conf.ecaClockSkew
+}
+
+func (conf *configuration) getECAAttributes() bool {
+	return conf.ecaAttributes
+}
+
+func (conf *configuration) getECACertCacheTTL() time.Duration {
+	return conf.ecaCertCacheTTL
+}
+
+func (conf *configuration) getEnrollmentCertExpiryWarningThreshold() time.Duration {
+	return conf.enrollmentCertExpiryWarningThreshold
+}
+
+func (conf *configuration) getMaxCertLifetime() time.Duration {
+	return conf.maxCertLifetime
+}
+
+func (conf *configuration) getRevocationCheckInterval() time.Duration {
+	return conf.revocationCheckInterval
+}
+
+func (conf *configuration) getOCSPTimeout() time.Duration {
+	return conf.ocspTimeout
+}
+
+func (conf *configuration) getRenewalThreshold() time.Duration {
+	return conf.renewalThreshold
+}
+
+func (conf *configuration) getRenewalCheckInterval() time.Duration {
+	return conf.renewalCheckInterval
+}
+
 func (conf *configuration) getTLSCAPAddr() string {
 	return viper.GetString(conf.tlscaPAddressProperty)
 }
@@ -235,10 +729,22 @@ func (conf *configuration) getECACertsChainFilename() string {
 	return "eca.cert.chain"
 }
 
+func (conf *configuration) getCTPendingFilename() string {
+	return "enrollment.ct.pending"
+}
+
+func (conf *configuration) getECertIntermediatesFilename() string {
+	return "enrollment.cert.intermediates"
+}
+
 func (conf *configuration) getTLSCACertsChainFilename() string {
 	return "tlsca.cert.chain"
 }
 
+func (conf *configuration) getECARootCertsExternalPath() string {
+	return viper.GetString("peer.pki.eca.rootcert.file")
+}
+
 func (conf *configuration) getTLSCACertsExternalPath() string {
 	return viper.GetString("peer.pki.tls.rootcert.file")
 }
@@ -259,10 +765,61 @@ func (conf *configuration) getTCAServerName() string {
 	return conf.tlsServerName
 }
 
-func (conf *configuration) getECAServerName() string {
+// getECATLSServerName returns the server name expected in the TLS certificate of the ECA at addr.
+// Unless overridden, this is the host of addr.
+func (conf *configuration) getECATLSServerName(addr string) string {
+	if conf.ecaTLSServerName != "" {
+		return conf.ecaTLSServerName
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil && host != "" {
+		return host
+	}
+
 	return conf.tlsServerName
 }
 
+func (conf *configuration) getPKCS11Enabled() bool {
+	return viper.GetBool("security.pkcs11.enabled")
+}
+
+// getKeystorePassphrase returns the passphrase protecting the enrollment key at rest.
+// The environment variable CORE_SECURITY_KEYSTORE_PASSPHRASE takes precedence over the configuration.
+func (conf *configuration) getKeystorePassphrase() []byte {
+	if passphrase := os.Getenv("CORE_SECURITY_KEYSTORE_PASSPHRASE"); passphrase != "" {
+		return []byte(passphrase)
+	}
+
+	return []byte(viper.GetString("security.keystore.passphrase"))
+}
+
+func (conf *configuration) getECACertFile() string {
+	return viper.GetString("peer.pki.eca.cert.file")
+}
+
+func (conf *configuration) getECACertPin() string {
+	return viper.GetString("peer.pki.eca.cert.pin")
+}
+
+// getECACertFingerprintAllowlist returns the SHA-256 fingerprints of the accepted ECA certificates
+func (conf *configuration) getECACertFingerprintAllowlist() []string {
+	var allowlist []string
+	for _, fingerprint := range viper.GetStringSlice("peer.pki.eca.cert.allowlist") {
+		if fingerprint = strings.TrimSpace(fingerprint); fingerprint != "" {
+			allowlist = append(allowlist, fingerprint)
+		}
+	}
+
+	return allowlist
+}
+
+func (conf *configuration) getECACRLPath() string {
+	return viper.GetString("peer.pki.eca.crl.file")
+}
+
+func (conf *configuration) getECATLSRootCert() string {
+	return viper.GetString("peer.pki.eca.tls.rootcert.file")
+}
+
 func (conf *configuration) getTLSCAServerName() string {
 	return conf.tlsServerName
 }
@@ -287,10 +844,56 @@ func (conf *configuration) getTCertOwnerKDFKeyFilename() string {
 	return "tca.kdf.key"
 }
 
+func (conf *configuration) getCertFilePerm() os.FileMode {
+	return conf.certFilePerm
+}
+
+func (conf *configuration) getCertIOTimeout() time.Duration {
+	return conf.certIOTimeout
+}
+
+func (conf *configuration) getCertStorageFormat() string {
+	return conf.certStorageFormat
+}
+
+// getCertVerificationMode returns enforce, failing on untrusted certificates,
+// or warn, only logging them
+func (conf *configuration) getCertVerificationMode() string {
+	return conf.certVerifyMode
+}
+
+func (conf *configuration) getRedactSensitiveLogs() bool {
+	return conf.redactLogs
+}
+
+func (conf *configuration) getSecurityLevel() int {
+	return conf.securityLevel
+}
+
+func (conf *configuration) getMinSecurityLevel() int {
+	return conf.minSecurityLevel
+}
+
+func (conf *configuration) getCTSubmitRequired() bool {
+	return conf.ctSubmitRequired
+}
+
+func (conf *configuration) getHashAlgorithm() string {
+	return conf.hashAlgorithm
+}
+
 func (conf *configuration) getTCertBatchSize() int {
 	return conf.tCertBatchSize
 }
 
+func (conf *configuration) getTCertLowWaterMark() int {
+	return conf.tCertLowWaterMark
+}
+
+func (conf *configuration) isTCertPersistenceEnabled() bool {
+	return conf.tCertPersistUnused
+}
+
 func (conf *configuration) GetConfidentialityProtocolVersion() string {
 	return conf.confidentialityProtocolVersion
 }
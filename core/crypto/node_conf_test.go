@@ -0,0 +1,156 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"os"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestValidateECAPAddr(t *testing.T) {
+	conf := &configuration{ecaPAddressProperty: "peer.pki.eca.paddr"}
+
+	original := viper.GetString(conf.ecaPAddressProperty)
+	defer viper.Set(conf.ecaPAddressProperty, original)
+
+	for _, addr := range []string{"localhost:50051", "10.0.0.1:7054", "[::1]:50051", "unix:///var/run/eca.sock", "eca1:50051, eca2:50051"} {
+		viper.Set(conf.ecaPAddressProperty, addr)
+		if err := conf.validateECAPAddrs(); err != nil {
+			t.Fatalf("Address [%s] must be valid [%s]", addr, err)
+		}
+	}
+
+	for _, addr := range []string{"", "localhost", ":50051", "localhost:", "localhost:port", "localhost:70000", "localhost:0", "http://localhost:50051", "unix://", " , ", "eca1:50051,eca2"} {
+		viper.Set(conf.ecaPAddressProperty, addr)
+		if err := conf.validateECAPAddrs(); err == nil {
+			t.Fatalf("Address [%s] must be invalid", addr)
+		}
+	}
+}
+
+func TestECAPAddrEnv(t *testing.T) {
+	conf := &configuration{ecaPAddressProperty: "peer.pki.eca.paddr"}
+
+	original := viper.GetString(conf.ecaPAddressProperty)
+	viper.Set(conf.ecaPAddressProperty, "file-eca:50051")
+	defer viper.Set(conf.ecaPAddressProperty, original)
+
+	t.Setenv(ecaPAddrEnv, "")
+	if addrs := conf.getECAPAddrs(); len(addrs) != 1 || addrs[0] != "file-eca:50051" {
+		t.Fatalf("Expected the configured ECA address, got %v", addrs)
+	}
+
+	t.Setenv(ecaPAddrEnv, "env-eca1:50051,env-eca2:50051")
+	if addrs := conf.getECAPAddrs(); len(addrs) != 2 || addrs[0] != "env-eca1:50051" || addrs[1] != "env-eca2:50051" {
+		t.Fatalf("The environment must take precedence over the configuration, got %v", addrs)
+	}
+	if err := conf.validateECAPAddrs(); err != nil {
+		t.Fatalf("Failed validating the ECA addresses from the environment [%s]", err)
+	}
+}
+
+func TestECATLSServerName(t *testing.T) {
+	conf := &configuration{prefix: "peer", name: "test"}
+	if err := conf.init(); err != nil {
+		t.Fatalf("Failed initializing configuration [%s]", err)
+	}
+	if name := conf.getECATLSServerName("localhost:50051"); name != "localhost" {
+		t.Fatalf("The ECA TLS server name must default to the ECA host, got [%s]", name)
+	}
+	if name := conf.getECATLSServerName("eca2.example.com:50051"); name != "eca2.example.com" {
+		t.Fatalf("The ECA TLS server name must default to the host of the replica dialed, got [%s]", name)
+	}
+
+	viper.Set("peer.pki.eca.tls.serverhostoverride", "eca.example.com")
+	defer viper.Set("peer.pki.eca.tls.serverhostoverride", "")
+	if err := conf.init(); err != nil {
+		t.Fatalf("Failed initializing configuration [%s]", err)
+	}
+	if name := conf.getECATLSServerName("localhost:50051"); name != "eca.example.com" {
+		t.Fatalf("Expected the ECA TLS server name override, got [%s]", name)
+	}
+}
+
+func TestClientUserAgent(t *testing.T) {
+	version := viper.GetString("peer.version")
+	viper.Set("peer.version", "0.1.0")
+	defer viper.Set("peer.version", version)
+
+	conf := &configuration{prefix: "peer", name: "test"}
+	if err := conf.init(); err != nil {
+		t.Fatalf("Failed initializing configuration [%s]", err)
+	}
+	if ua := conf.getClientUserAgent(); ua != "fabric-crypto/0.1.0" {
+		t.Fatalf("The user agent must default to the peer version, got [%s]", ua)
+	}
+
+	viper.Set("peer.pki.eca.useragent", "custom/1.0")
+	defer viper.Set("peer.pki.eca.useragent", "")
+	if err := conf.init(); err != nil {
+		t.Fatalf("Failed initializing configuration [%s]", err)
+	}
+	if ua := conf.getClientUserAgent(); ua != "custom/1.0" {
+		t.Fatalf("Expected the configured user agent, got [%s]", ua)
+	}
+}
+
+func TestCertVerificationModeConf(t *testing.T) {
+	original := viper.GetString("security.certverificationmode")
+	defer viper.Set("security.certverificationmode", original)
+
+	conf := &configuration{prefix: "peer", name: "test"}
+	for mode, expected := range map[string]string{"": "enforce", "Warn": "warn", "enforce": "enforce"} {
+		viper.Set("security.certverificationmode", mode)
+		if err := conf.init(); err != nil {
+			t.Fatalf("Failed initializing configuration with mode [%s] [%s]", mode, err)
+		}
+		if got := conf.getCertVerificationMode(); got != expected {
+			t.Fatalf("Expected mode [%s] for [%s], got [%s]", expected, mode, got)
+		}
+	}
+
+	viper.Set("security.certverificationmode", "lenient")
+	if err := conf.init(); err == nil {
+		t.Fatal("An unknown verification mode must be rejected")
+	}
+}
+
+func TestCertFilePermConf(t *testing.T) {
+	original := viper.Get("security.certfileperm")
+	defer viper.Set("security.certfileperm", original)
+
+	conf := &configuration{prefix: "peer", name: "test"}
+	// 420 is how YAML decodes the unquoted 0644
+	for perm, expected := range map[interface{}]os.FileMode{"": 0644, "0600": 0600, "644": 0644, 420: 0644, 0600: 0600} {
+		viper.Set("security.certfileperm", perm)
+		if err := conf.init(); err != nil {
+			t.Fatalf("Failed initializing configuration with permissions [%v] [%s]", perm, err)
+		}
+		if got := conf.getCertFilePerm(); got != expected {
+			t.Fatalf("Expected permissions [%o] for [%v], got [%o]", expected, perm, got)
+		}
+	}
+
+	for _, perm := range []interface{}{"0944", "rw-r--r--", "01000", 01000, -1} {
+		viper.Set("security.certfileperm", perm)
+		if err := conf.init(); err == nil {
+			t.Fatalf("Invalid permissions [%v] must be rejected", perm)
+		}
+	}
+}
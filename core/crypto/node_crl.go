@@ -0,0 +1,89 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io/ioutil"
+
+	"github.com/hyperledger/fabric/core/crypto/utils"
+)
+
+// loadCRL loads the CRL at path and validates its signature against the
+// trusted root certificates and the ECA certificate ecaCert.
+func (node *nodeImpl) loadCRL(path string, ecaCert *x509.Certificate) (*pkix.CertificateList, error) {
+	node.Debugf("Loading CRL at [%s]...", path)
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		node.Errorf("Failed loading CRL [%s].", err.Error())
+
+		return nil, err
+	}
+
+	crl, err := x509.ParseCRL(raw)
+	if err != nil {
+		node.Errorf("Failed parsing CRL [%s].", err.Error())
+
+		return nil, err
+	}
+
+	if crl.HasExpired(node.now()) {
+		node.Errorf("CRL at [%s] expired at [%s].", path, crl.TBSCertList.NextUpdate)
+
+		return nil, errors.New("CRL has expired.")
+	}
+
+	issuers := append([]*x509.Certificate{}, node.getRootCerts()...)
+	if ecaCert != nil {
+		issuers = append(issuers, ecaCert)
+	}
+	for _, issuer := range issuers {
+		if err := issuer.CheckCRLSignature(crl); err == nil {
+			return crl, nil
+		}
+	}
+
+	node.Errorf("Failed validating CRL at [%s]. No trusted issuer.", path)
+
+	return nil, errors.New("Failed validating CRL signature.")
+}
+
+// checkECACertRevocation fails if the ECA certificate ecaCert is listed in the configured CRL
+func (node *nodeImpl) checkECACertRevocation(ecaCert *x509.Certificate) error {
+	path := node.conf.getECACRLPath()
+	if path == "" {
+		return nil
+	}
+
+	crl, err := node.loadCRL(path, ecaCert)
+	if err != nil {
+		return err
+	}
+
+	for _, revoked := range crl.TBSCertList.RevokedCertificates {
+		if revoked.SerialNumber.Cmp(ecaCert.SerialNumber) == 0 {
+			node.Errorf("ECA certificate [%s] has been REVOKED at [%s]!", ecaCert.SerialNumber, revoked.RevocationTime)
+
+			return utils.ErrECACertRevoked
+		}
+	}
+
+	return nil
+}
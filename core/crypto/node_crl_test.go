@@ -0,0 +1,85 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	"github.com/spf13/viper"
+)
+
+func TestECACertRevocationCRL(t *testing.T) {
+	node, cleanup := newTestCertStoreNode(t, 0644)
+	defer cleanup()
+
+	der, key, err := primitives.NewSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Failed generating self signed cert [%s]", err)
+	}
+	node.ecaCert, err = primitives.DERToX509Certificate(der)
+	if err != nil {
+		t.Fatalf("Failed parsing self signed cert [%s]", err)
+	}
+
+	path := filepath.Join(node.conf.getRawsPath(), "eca.crl")
+	viper.Set("peer.pki.eca.crl.file", path)
+	defer viper.Set("peer.pki.eca.crl.file", "")
+
+	writeCRL := func(revoked []pkix.RevokedCertificate) {
+		crl, err := node.ecaCert.CreateCRL(rand.Reader, key, revoked, time.Now(), time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatalf("Failed creating CRL [%s]", err)
+		}
+		if err := ioutil.WriteFile(path, crl, 0644); err != nil {
+			t.Fatalf("Failed writing CRL [%s]", err)
+		}
+	}
+
+	writeCRL(nil)
+	if err := node.checkECACertRevocation(node.ecaCert); err != nil {
+		t.Fatalf("ECA certificate must not be revoked [%s]", err)
+	}
+
+	writeCRL([]pkix.RevokedCertificate{{SerialNumber: node.ecaCert.SerialNumber, RevocationTime: time.Now()}})
+	if err := node.checkECACertRevocation(node.ecaCert); err != utils.ErrECACertRevoked {
+		t.Fatalf("ECA certificate must be revoked, got [%v]", err)
+	}
+
+	// A CRL signed by an unknown issuer must be rejected
+	otherDER, otherKey, err := primitives.NewSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Failed generating self signed cert [%s]", err)
+	}
+	other, _ := primitives.DERToX509Certificate(otherDER)
+	crl, err := other.CreateCRL(rand.Reader, otherKey, nil, time.Now(), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Failed creating CRL [%s]", err)
+	}
+	if err := ioutil.WriteFile(path, crl, 0644); err != nil {
+		t.Fatalf("Failed writing CRL [%s]", err)
+	}
+	if err := node.checkECACertRevocation(node.ecaCert); err == nil {
+		t.Fatal("A CRL signed by an untrusted issuer must be rejected")
+	}
+}
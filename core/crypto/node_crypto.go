@@ -18,8 +18,13 @@ package crypto
 
 import (
 	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
 
 	ecies "github.com/hyperledger/fabric/core/crypto/primitives/ecies"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	"golang.org/x/net/context"
 )
 
 func (node *nodeImpl) registerCryptoEngine(enrollID, enrollPWD string) error {
@@ -34,6 +39,12 @@ func (node *nodeImpl) registerCryptoEngine(enrollID, enrollPWD string) error {
 		return err
 	}
 
+	if err := node.initRootsCertPool(); err != nil {
+		node.Errorf("Failed initliazing trusted roots [%s].", err.Error())
+
+		return err
+	}
+
 	if err := node.retrieveECACertsChain(enrollID); err != nil {
 		node.Errorf("Failed retrieving ECA certs chain [%s].", err.Error())
 
@@ -46,8 +57,11 @@ func (node *nodeImpl) registerCryptoEngine(enrollID, enrollPWD string) error {
 		return err
 	}
 
-	if err := node.retrieveEnrollmentData(enrollID, enrollPWD); err != nil {
+	if persisted, err := node.retrieveEnrollmentData(context.Background(), enrollID, enrollPWD); err != nil {
 		node.Errorf("Failed retrieving enrollment data [%s].", err.Error())
+		if persisted {
+			node.Errorf("Enrollment data left at [%s], the staged files end with .new. The ECA does not issue it again: move it in place instead of removing it.", node.conf.getRawsPath())
+		}
 
 		return err
 	}
@@ -70,13 +84,15 @@ func (node *nodeImpl) initCryptoEngine() error {
 	node.eciesSPI = ecies.NewSPI()
 
 	// Init certPools
-	node.rootsCertPool = x509.NewCertPool()
+	if err := node.initRootsCertPool(); err != nil {
+		return err
+	}
 	node.tlsCertPool = x509.NewCertPool()
-	node.ecaCertPool = x509.NewCertPool()
+	node.setECACertPool(x509.NewCertPool())
 	node.tcaCertPool = x509.NewCertPool()
 
 	// Load ECA certs chain
-	if err := node.loadECACertsChain(); err != nil {
+	if err := node.loadECACertsChain(context.Background()); err != nil {
 		return err
 	}
 
@@ -105,6 +121,11 @@ func (node *nodeImpl) initCryptoEngine() error {
 		return err
 	}
 
+	// Retry the CT submission that failed at enrollment
+	if err := node.retryPendingCTSubmission(); err != nil {
+		return err
+	}
+
 	// Load TLS certs chain certificate
 	if err := node.loadTLSCACertsChain(); err != nil {
 		return err
@@ -119,3 +140,150 @@ func (node *nodeImpl) initCryptoEngine() error {
 
 	return nil
 }
+
+func (node *nodeImpl) initRootsCertPool() error {
+	path := node.conf.getECARootCertsExternalPath()
+	if path == "" {
+		node.Debug("No trusted root certificates configured.")
+		node.setRootCerts(x509.NewCertPool(), nil)
+
+		return nil
+	}
+
+	node.Debugf("Loading trusted root certificates at [%s]...", path)
+
+	raw, err := node.ks.loadExternalCert(path)
+	if err != nil {
+		node.Errorf("Failed loading trusted root certificates [%s].", err.Error())
+
+		return err
+	}
+
+	pool := x509.NewCertPool()
+	ok := pool.AppendCertsFromPEM(raw)
+	if !ok {
+		node.Error("Failed appending trusted root certificates.")
+
+		return errors.New("Failed appending trusted root certificates.")
+	}
+
+	var certs []*x509.Certificate
+	for block, rest := pem.Decode(raw); block != nil; block, rest = pem.Decode(rest) {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			node.Errorf("Failed parsing trusted root certificate [%s].", err.Error())
+
+			return err
+		}
+		certs = append(certs, cert)
+	}
+
+	node.setRootCerts(pool, certs)
+
+	return nil
+}
+
+func (node *nodeImpl) setRootCerts(pool *x509.CertPool, certs []*x509.Certificate) {
+	node.certPoolsMutex.Lock()
+	defer node.certPoolsMutex.Unlock()
+
+	node.rootsCertPool = pool
+	node.rootCerts = certs
+}
+
+func (node *nodeImpl) getRootsCertPool() *x509.CertPool {
+	node.certPoolsMutex.RLock()
+	defer node.certPoolsMutex.RUnlock()
+
+	return node.rootsCertPool
+}
+
+func (node *nodeImpl) getRootCerts() []*x509.Certificate {
+	node.certPoolsMutex.RLock()
+	defer node.certPoolsMutex.RUnlock()
+
+	return node.rootCerts
+}
+
+// verificationRoots returns the trusted roots the certificates are verified against.
+// It returns nil if no root is configured: the certificates are then trusted on first use.
+// A configured but empty pool is an error, not an invitation to trust anything.
+func (node *nodeImpl) verificationRoots() (*x509.CertPool, error) {
+	roots := node.getRootsCertPool()
+	if roots == nil {
+		return nil, fmt.Errorf("verificationRoots: %w", utils.ErrNotInitialized)
+	}
+	if len(roots.Subjects()) != 0 {
+		return roots, nil
+	}
+	if node.conf != nil && node.conf.getECARootCertsExternalPath() != "" {
+		return nil, fmt.Errorf("verificationRoots: %w", utils.ErrNoTrustedRoots)
+	}
+
+	return nil, nil
+}
+
+// TrustedRootsCount returns the number of root certificates trusted by this node
+func (node *nodeImpl) TrustedRootsCount() int {
+	roots := node.getRootsCertPool()
+	if roots == nil {
+		return 0
+	}
+
+	return len(roots.Subjects())
+}
+
+func (node *nodeImpl) setECACertPool(pool *x509.CertPool) {
+	node.certPoolsMutex.Lock()
+	defer node.certPoolsMutex.Unlock()
+
+	node.ecaCertPool = pool
+}
+
+func (node *nodeImpl) getECACertPool() *x509.CertPool {
+	node.certPoolsMutex.RLock()
+	defer node.certPoolsMutex.RUnlock()
+
+	return node.ecaCertPool
+}
+
+// setECAChain replaces at once the ECA certificates pool, the ECA certificate, the
+// certificates between it and the root and the enrollment intermediates, so that
+// readers never see a mix of two chains
+func (node *nodeImpl) setECAChain(pool *x509.CertPool, ecaCert *x509.Certificate, ecaIntermediates, intermediates []*x509.Certificate) {
+	node.certPoolsMutex.Lock()
+	defer node.certPoolsMutex.Unlock()
+
+	node.ecaCertPool = pool
+	node.ecaCert = ecaCert
+	node.ecaIntermediates = ecaIntermediates
+	node.ecertIntermediates = intermediates
+}
+
+func (node *nodeImpl) getECACert() *x509.Certificate {
+	node.certPoolsMutex.RLock()
+	defer node.certPoolsMutex.RUnlock()
+
+	return node.ecaCert
+}
+
+func (node *nodeImpl) getECAIntermediates() []*x509.Certificate {
+	node.certPoolsMutex.RLock()
+	defer node.certPoolsMutex.RUnlock()
+
+	return node.ecaIntermediates
+}
+
+func (node *nodeImpl) setECertIntermediates(intermediates []*x509.Certificate) {
+	node.certPoolsMutex.Lock()
+	defer node.certPoolsMutex.Unlock()
+
+	node.ecertIntermediates = intermediates
+}
+
+func (node *nodeImpl) getECertIntermediates() []*x509.Certificate {
+	node.certPoolsMutex.RLock()
+	defer node.certPoolsMutex.RUnlock()
+
+	return node.ecertIntermediates
+}
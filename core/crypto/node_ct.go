@@ -0,0 +1,116 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/hyperledger/fabric/core/crypto/utils"
+)
+
+// SetCTSubmitter sets the function submitting the new enrollment certificates,
+// in DER format, to a certificate transparency log. It is invoked once the
+// enrollment data has been stored. If security.ct.required is set, a failed
+// submission fails the enrollment, otherwise it is logged and ignored.
+// The enrollment data is kept whatever the outcome: the ECA does not issue it
+// again. A required submission that failed is pending, and retried when the
+// next submitter is set, its error returned.
+// Passing nil disables the submission.
+func (node *nodeImpl) SetCTSubmitter(submitter func(cert []byte) error) error {
+	node.ctSubmitterMutex.Lock()
+	node.ctSubmitter = submitter
+	node.ctSubmitterMutex.Unlock()
+
+	if submitter == nil {
+		return nil
+	}
+
+	return node.retryPendingCTSubmission()
+}
+
+func (node *nodeImpl) submitToCT(enrollID string, certRaw []byte) error {
+	node.ctSubmitterMutex.Lock()
+	submitter := node.ctSubmitter
+	node.ctSubmitterMutex.Unlock()
+
+	if submitter == nil {
+		return nil
+	}
+
+	node.Debugf("Submitting enrollment certificate to the CT log [id=%s]...", node.logID(enrollID))
+
+	if err := submitter(certRaw); err != nil {
+		if !node.conf.getCTSubmitRequired() {
+			node.Warningf("Failed submitting enrollment certificate to the CT log [id=%s]. Ignoring it [%s].", node.logID(enrollID), err)
+
+			return nil
+		}
+
+		node.Errorf("Failed submitting enrollment certificate to the CT log [id=%s]: [%s].", node.logID(enrollID), err)
+
+		return fmt.Errorf("submitToCT: %w: %v", utils.ErrCTSubmissionFailed, err)
+	}
+
+	node.Debugf("Submitting enrollment certificate to the CT log [id=%s]...done!", node.logID(enrollID))
+
+	return nil
+}
+
+// markCTSubmissionPending records that the submission of the stored enrollment
+// certificate failed, to retry it later.
+func (node *nodeImpl) markCTSubmissionPending(enrollID string) error {
+	node.Warningf("Enrollment data kept, the CT submission is pending [id=%s].", node.logID(enrollID))
+
+	return utils.WriteFileAtomic(node.conf.getPathForAlias(node.conf.getCTPendingFilename()), []byte(enrollID), 0600)
+}
+
+// retryPendingCTSubmission submits again the stored enrollment certificate, if its
+// submission is pending and a submitter is set. The submission is no longer pending
+// once it succeeds.
+func (node *nodeImpl) retryPendingCTSubmission() error {
+	path := node.conf.getPathForAlias(node.conf.getCTPendingFilename())
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return fmt.Errorf("retryPendingCTSubmission: %w", err)
+	}
+
+	node.ctSubmitterMutex.Lock()
+	submitter := node.ctSubmitter
+	node.ctSubmitterMutex.Unlock()
+
+	enrollCert := node.getEnrollmentCertificate()
+	if submitter == nil || enrollCert == nil {
+		return nil
+	}
+
+	node.Infof("Retrying the pending CT submission [id=%s]...", node.logID(node.enrollID))
+
+	if err := node.submitToCT(node.enrollID, enrollCert.Raw); err != nil {
+		return fmt.Errorf("retryPendingCTSubmission: %w", err)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("retryPendingCTSubmission: %w", err)
+	}
+
+	node.Infof("Retrying the pending CT submission [id=%s]...done!", node.logID(node.enrollID))
+
+	return nil
+}
@@ -17,8 +17,10 @@ limitations under the License.
 package crypto
 
 import (
+	"bytes"
+	"crypto"
 	"crypto/ecdsa"
-	"crypto/rand"
+	"crypto/sha256"
 	"crypto/x509"
 	"google/protobuf"
 	"time"
@@ -27,13 +29,20 @@ import (
 
 	"encoding/asn1"
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/core/crypto/primitives"
 	"github.com/hyperledger/fabric/core/crypto/primitives/ecies"
+	"github.com/hyperledger/fabric/core/crypto/utils"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 )
 
 var (
@@ -42,444 +51,2051 @@ var (
 )
 
 func (node *nodeImpl) retrieveECACertsChain(userID string) error {
-	if !node.ks.certMissing(node.conf.getECACertsChainFilename()) {
+	// The chain is checked and stored at once, also by the other nodes sharing the keystore
+	defer lockPath(node.conf.getPathForAlias(node.conf.getECACertsChainFilename()))()
+
+	if _, err := node.certStore.Get(node.conf.getECACertsChainFilename()); err == nil {
 		return nil
 	}
 
+	node.ecaLog().WithField("user_id", node.logID(userID)).Debug("Retrieving ECA certificate...")
+
+	if err := node.refreshECACertificate(false); err != nil {
+		return fmt.Errorf("retrieveECACertsChain: %w", err)
+	}
+
+	return nil
+}
+
+// refreshECACertificate verifies the ECA certificate, and the intermediates served with it
+// up to the root if any, and stores them as the ECA certificates chain. If force is true,
+// the cached ECA certificate is dropped and fetched again from the ECA. This is needed
+// after a CA rotation. Invoked with the chain path locked.
+func (node *nodeImpl) refreshECACertificate(force bool) error {
+	if force {
+		node.invalidateECACertificate()
+	}
+
 	// Retrieve ECA certificate and verify it
 	ecaCertRaw, err := node.getECACertificate()
 	if err != nil {
-		node.Errorf("Failed getting ECA certificate [%s].", err.Error())
+		node.ecaLog().WithError(err).Error("Failed getting ECA certificate.")
 
-		return err
+		return fmt.Errorf("refreshECACertificate: %w", err)
 	}
-	node.Debugf("ECA certificate [% x].", ecaCertRaw)
+	node.ecaLog().Debugf("ECA certificate [%s].", node.logCert(ecaCertRaw))
 
-	// TODO: Test ECA cert againt root CA
-	// TODO: check response.Cert against rootCA
-	x509ECACert, err := primitives.DERToX509Certificate(ecaCertRaw)
+	certs, err := decodeCerts(ecaCertRaw)
 	if err != nil {
-		node.Errorf("Failed parsing ECA certificate [%s].", err.Error())
+		node.ecaLog().WithError(err).Error("Failed parsing ECA certificate.")
+		node.invalidateECACertificate()
 
-		return err
+		return fmt.Errorf("refreshECACertificate: %w: %v", utils.ErrInvalidECACert, err)
 	}
+	x509ECACert, ecaIntermediates := certs[0], certs[1:]
 
-	// Prepare ecaCertPool
-	node.ecaCertPool = x509.NewCertPool()
-	node.ecaCertPool.AddCert(x509ECACert)
+	if err := node.verifyECACertificate(x509ECACert, ecaIntermediates...); err != nil {
+		node.ecaLog().WithError(err).Error("Failed verifying ECA certificate.")
+		node.invalidateECACertificate()
 
-	// Store ECA cert
-	node.Debugf("Storing ECA certificate for [%s]...", userID)
+		return fmt.Errorf("refreshECACertificate: %w", err)
+	}
 
-	if err := node.ks.storeCert(node.conf.getECACertsChainFilename(), ecaCertRaw); err != nil {
-		node.Errorf("Failed storing eca certificate [%s].", err.Error())
-		return err
+	if err := node.checkECACertRevocation(x509ECACert); err != nil {
+		node.ecaLog().WithError(err).Error("Failed checking ECA certificate revocation.")
+		node.invalidateECACertificate()
+
+		return fmt.Errorf("refreshECACertificate: %w", err)
+	}
+
+	// Prepare ecaCertPool. Only the ECA issues enrollment certificates
+	pool := x509.NewCertPool()
+	pool.AddCert(x509ECACert)
+	node.setECAChain(pool, x509ECACert, ecaIntermediates, node.getECertIntermediates())
+
+	// Store ECA cert, followed by its intermediates
+	node.ecaLog().Debug("Storing ECA certificate...")
+
+	ders := make([][]byte, 0, len(certs))
+	for _, cert := range certs {
+		ders = append(ders, cert.Raw)
+	}
+	if err := node.certStore.Put(node.conf.getECACertsChainFilename(), node.encodeCerts(ders...)); err != nil {
+		node.ecaLog().WithError(err).Error("Failed storing eca certificate.")
+		return fmt.Errorf("refreshECACertificate: %w", err)
 	}
 
 	return nil
 }
 
-func (node *nodeImpl) retrieveEnrollmentData(enrollID, enrollPWD string) error {
-	if !node.ks.certMissing(node.conf.getEnrollmentCertFilename()) {
+// verifyECACertificate checks that the ECA certificate chains to a trusted root,
+// through the passed intermediates if the root is kept offline
+func (node *nodeImpl) verifyECACertificate(x509ECACert *x509.Certificate, intermediates ...*x509.Certificate) error {
+	roots, err := node.verificationRoots()
+	if err != nil {
+		return fmt.Errorf("verifyECACertificate: %w", err)
+	}
+	if roots == nil {
+		node.ecaLog().Warning("No trusted root certificates configured. Accepting ECA certificate without verification.")
+
 		return nil
 	}
 
-	key, enrollCertRaw, enrollChainKey, err := node.getEnrollmentCertificateFromECA(enrollID, enrollPWD)
-	if err != nil {
-		node.Errorf("Failed getting enrollment certificate [id=%s]: [%s]", enrollID, err)
+	opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+	for _, cert := range intermediates {
+		opts.Intermediates.AddCert(cert)
+	}
+	if _, err := x509ECACert.Verify(opts); err != nil {
+		return node.checkCertVerification(fmt.Errorf("verifyECACertificate: ECA certificate [%s] does not chain to a trusted root: [%w]", x509ECACert.Subject.CommonName, err))
+	}
+
+	return nil
+}
 
+// checkCertVerification returns err, the failure of a trust check, as is unless
+// security.certverificationmode is warn. Then the failure is only logged and
+// the certificate is used anyway.
+func (node *nodeImpl) checkCertVerification(err error) error {
+	if err == nil || node.conf.getCertVerificationMode() != "warn" {
 		return err
 	}
-	node.Debugf("Enrollment certificate [% x].", enrollCertRaw)
 
-	node.Debugf("Storing enrollment data for user [%s]...", enrollID)
+	node.Warningf("Certificate verification failed, proceeding in warn mode [%s].", err.Error())
+
+	return nil
+}
+
+// retrieveEnrollmentData enrolls enrollID and stores the enrollment data.
+// The data is verified before storing any of it. As the ECA does not issue it again,
+// it is staged next to its final place, then moved in place, the enrollment certificate
+// last: on failure the staged files are left on disk. It returns whether some enrollment
+// data is left in the keystore, in that case.
+// A failed CT submission keeps all of it, the submission pending.
+func (node *nodeImpl) retrieveEnrollmentData(ctx context.Context, enrollID, enrollPWD string) (bool, error) {
+	if !node.ks.certMissing(node.conf.getEnrollmentCertFilename()) {
+		return false, nil
+	}
+
+	res, err := node.enroll(ctx, enrollID, enrollPWD)
+	if err != nil {
+		return false, fmt.Errorf("retrieveEnrollmentData: %w", err)
+	}
+
+	node.Debugf("Storing enrollment data for user [%s]...", node.logID(enrollID))
+	node.sendEnrollmentEvent(enrollID, EnrollmentStepStoring)
 
-	// Store enrollment id
-	err = ioutil.WriteFile(node.conf.getEnrollmentIDPath(), []byte(enrollID), 0700)
+	fail := func(err error) (bool, error) {
+		node.Errorf("Enrollment data issued by the ECA left at [%s] [id=%s].", node.conf.getRawsPath(), node.logID(enrollID))
+
+		return true, fmt.Errorf("retrieveEnrollmentData: %w", err)
+	}
+	// Stage enrollment id
+	err = utils.WriteFileAtomic(node.conf.getPathForAlias(stagedAlias(node.conf.getEnrollmentIDFilename())), []byte(enrollID), 0700)
 	if err != nil {
-		node.Errorf("Failed storing enrollment certificate [id=%s]: [%s]", enrollID, err)
-		return err
+		node.Errorf("Failed storing enrollment id [id=%s]: [%s]", node.logID(enrollID), err)
+		return fail(err)
 	}
 
-	// Store enrollment key
-	if err := node.ks.storePrivateKey(node.conf.getEnrollmentKeyFilename(), key); err != nil {
-		node.Errorf("Failed storing enrollment key [id=%s]: [%s]", enrollID, err)
-		return err
+	// Stage enrollment key
+	if err := node.storeEnrollmentKeyAs(stagedAlias(node.conf.getEnrollmentKeyFilename()), res.Key, node.getEnrollmentKeyPassphrase()); err != nil {
+		node.Errorf("Failed storing enrollment key [id=%s]: [%s]", node.logID(enrollID), err)
+		return fail(err)
 	}
 
-	// Store enrollment cert
-	if err := node.ks.storeCert(node.conf.getEnrollmentCertFilename(), enrollCertRaw); err != nil {
-		node.Errorf("Failed storing enrollment certificate [id=%s]: [%s]", enrollID, err)
-		return err
+	// Stage enrollment cert
+	if err := node.ks.storeCert(stagedAlias(node.conf.getEnrollmentCertFilename()), res.Cert); err != nil {
+		node.Errorf("Failed storing enrollment certificate [id=%s]: [%s]", node.logID(enrollID), err)
+		return fail(err)
 	}
 
 	// Code for confidentiality 1.2
-	// Store enrollment chain key
+	// Stage enrollment chain key
 	if node.eType == NodeValidator {
-		node.Debugf("Enrollment chain key for validator [%s]...", enrollID)
-		// enrollChainKey is a secret key
-
-		node.Debugf("key [%s]...", string(enrollChainKey))
+		err = node.ks.storePrivateKey(stagedAlias(node.conf.getEnrollmentChainKeyFilename()), res.ChainKey)
+	} else {
+		err = node.ks.storePublicKey(stagedAlias(node.conf.getEnrollmentChainKeyFilename()), res.ChainKey)
+	}
+	if err != nil {
+		node.Errorf("Failed storing enrollment chain key [id=%s]: [%s]", node.logID(enrollID), err)
+		return fail(err)
+	}
 
-		key, err := primitives.PEMtoPrivateKey(enrollChainKey, nil)
+	// Store intermediate certificates, unused until the enrollment certificate is in place
+	if len(res.Chain) != 0 {
+		unlock := lockPath(node.conf.getPathForAlias(node.conf.getECertIntermediatesFilename()))
+		err := node.certStore.Put(node.conf.getECertIntermediatesFilename(), node.encodeCerts(res.Chain...))
+		unlock()
 		if err != nil {
-			node.Errorf("Failed unmarshalling enrollment chain key [id=%s]: [%s]", enrollID, err)
-			return err
+			node.Errorf("Failed storing intermediate certificates [id=%s]: [%s]", node.logID(enrollID), err)
+			return fail(err)
 		}
+	}
 
-		if err := node.ks.storePrivateKey(node.conf.getEnrollmentChainKeyFilename(), key); err != nil {
-			node.Errorf("Failed storing enrollment chain key [id=%s]: [%s]", enrollID, err)
-			return err
+	// Move the staged files in place, the enrollment certificate marks the enrollment done
+	for _, alias := range []string{
+		node.conf.getEnrollmentIDFilename(),
+		node.conf.getEnrollmentKeyFilename(),
+		node.conf.getEnrollmentChainKeyFilename(),
+		node.conf.getEnrollmentCertFilename(),
+	} {
+		path := node.conf.getPathForAlias(alias)
+		if err := os.Rename(stagedAlias(path), path); err != nil {
+			node.Errorf("Failed moving enrollment data in place [id=%s]: [%s]", node.logID(enrollID), err)
+			return fail(err)
 		}
-	} else {
-		node.Debugf("Enrollment chain key for non-validator [%s]...", enrollID)
-		// enrollChainKey is a public key
+	}
 
-		key, err := primitives.PEMtoPublicKey(enrollChainKey, nil)
-		if err != nil {
-			node.Errorf("Failed unmarshalling enrollment chain key [id=%s]: [%s]", enrollID, err)
-			return err
+	// The ECA does not issue the enrollment data again: keep it if the certificate is not
+	// logged, the submission is pending
+	if err := node.submitToCT(enrollID, res.Cert); err != nil {
+		if err := node.markCTSubmissionPending(enrollID); err != nil {
+			node.Errorf("Failed recording the pending CT submission [id=%s]: [%s]", node.logID(enrollID), err)
 		}
-		node.Debugf("Key decoded from PEM [%s]...", enrollID)
 
-		if err := node.ks.storePublicKey(node.conf.getEnrollmentChainKeyFilename(), key); err != nil {
-			node.Errorf("Failed storing enrollment chain key [id=%s]: [%s]", enrollID, err)
-			return err
-		}
+		return false, fmt.Errorf("retrieveEnrollmentData: %w", err)
 	}
 
-	return nil
-}
-
-func (node *nodeImpl) loadEnrollmentKey() error {
-	node.Debug("Loading enrollment key...")
+	node.notifyEnrolled(res.Cert)
 
-	enrollPrivKey, err := node.ks.loadPrivateKey(node.conf.getEnrollmentKeyFilename())
-	if err != nil {
-		node.Errorf("Failed loading enrollment private key [%s].", err.Error())
+	return false, nil
+}
 
-		return err
-	}
+// stagedAlias returns the alias under which the enrollment data stored as alias is
+// written before being moved in place
+func stagedAlias(alias string) string {
+	return alias + ".new"
+}
 
-	node.enrollPrivKey = enrollPrivKey.(*ecdsa.PrivateKey)
+// SetOnEnrolled sets the function invoked once the enrollment data has been
+// verified and stored, with the new enrollment certificate. It runs before the
+// enrollment returns, also after a re-enrollment. A panic in it is logged and ignored.
+func (node *nodeImpl) SetOnEnrolled(handler func(cert *x509.Certificate)) {
+	node.onEnrolledMutex.Lock()
+	defer node.onEnrolledMutex.Unlock()
 
-	return nil
+	node.onEnrolled = handler
 }
 
-func (node *nodeImpl) loadEnrollmentCertificate() error {
-	node.Debug("Loading enrollment certificate...")
-
-	cert, der, err := node.ks.loadCertX509AndDer(node.conf.getEnrollmentCertFilename())
-	if err != nil {
-		node.Errorf("Failed parsing enrollment certificate [%s].", err.Error())
+func (node *nodeImpl) notifyEnrolled(certRaw []byte) {
+	node.onEnrolledMutex.Lock()
+	handler := node.onEnrolled
+	node.onEnrolledMutex.Unlock()
 
-		return err
+	if handler == nil {
+		return
 	}
-	node.enrollCert = cert
 
-	// TODO: move this to retrieve
-	pk := node.enrollCert.PublicKey.(*ecdsa.PublicKey)
-	err = primitives.VerifySignCapability(node.enrollPrivKey, pk)
+	cert, err := primitives.DERToX509Certificate(certRaw)
 	if err != nil {
-		node.Errorf("Failed checking enrollment certificate against enrollment key [%s].", err.Error())
+		node.Errorf("Failed parsing enrollment certificate for the enrollment handler [%s].", err.Error())
 
-		return err
+		return
 	}
 
-	// Set node ID
-	node.id = primitives.Hash(der)
-	node.Debugf("Setting id to [% x].", node.id)
-
-	// Set eCertHash
-	node.enrollCertHash = primitives.Hash(der)
-	node.Debugf("Setting enrollCertHash to [% x].", node.enrollCertHash)
-
-	return nil
+	defer func() {
+		if r := recover(); r != nil {
+			node.Errorf("Enrollment handler panicked [%v].", r)
+		}
+	}()
+	handler(cert)
 }
 
-func (node *nodeImpl) loadEnrollmentID() error {
-	node.Debugf("Loading enrollment id at [%s]...", node.conf.getEnrollmentIDPath())
+// ValidateEnrollment runs the enrollment protocol for id and pw and verifies the
+// issued certificate without storing anything. The generated key is discarded.
+// It returns the description of the certificate that has been issued.
+// The ECA is not aware of the dry run: afterwards it considers id enrolled.
+func (node *nodeImpl) ValidateEnrollment(ctx context.Context, id, pw string) (*CertInfo, error) {
+	// The enrollment protocol sets the intermediates used to verify the certificate
+	oldIntermediates := node.getECertIntermediates()
+	defer func() { node.setECertIntermediates(oldIntermediates) }()
 
-	enrollID, err := ioutil.ReadFile(node.conf.getEnrollmentIDPath())
+	key, enrollCertRaw, _, enrollChainKey, err := node.getEnrollmentCertificateFromECA(ctx, id, pw)
 	if err != nil {
-		node.Errorf("Failed loading enrollment id [%s].", err.Error())
+		node.Errorf("Enrollment validation failed [id=%s]: [%s]", node.logID(id), err)
 
-		return err
+		return nil, fmt.Errorf("ValidateEnrollment: %w", err)
 	}
 
-	// Set enrollment ID
-	node.enrollID = string(enrollID)
-	node.Debugf("Setting enrollment id to [%s].", node.enrollID)
+	if _, err := node.validateEnrollmentData(key, enrollCertRaw, enrollChainKey); err != nil {
+		node.Errorf("Enrollment validation failed [id=%s]: [%s]", node.logID(id), err)
 
-	return nil
+		return nil, fmt.Errorf("ValidateEnrollment: %w", err)
+	}
+
+	cert, err := primitives.DERToX509Certificate(enrollCertRaw)
+	if err != nil {
+		return nil, fmt.Errorf("ValidateEnrollment: %w", err)
+	}
+
+	return newCertInfo(cert)
 }
 
-func (node *nodeImpl) loadEnrollmentChainKey() error {
-	node.Debug("Loading enrollment chain key...")
+// validateEnrollmentData checks that the enrollment certificate binds the public key
+// of key and that the enrollment chain key can be decoded. It returns the chain key,
+// a secret key for validators and a public key for the other nodes.
+func (node *nodeImpl) validateEnrollmentData(key interface{}, enrollCertRaw, enrollChainKey []byte) (interface{}, error) {
+	cert, err := primitives.DERToX509Certificate(enrollCertRaw)
+	if err != nil {
+		return nil, fmt.Errorf("validateEnrollmentData: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("validateEnrollmentData: %w", utils.ErrInvalidKey)
+	}
+	if err := checkPublicKeyMatchesSigner(cert.PublicKey, signer); err != nil {
+		return nil, fmt.Errorf("validateEnrollmentData: Enrollment certificate does not match the enrollment key: [%w]", err)
+	}
 
 	// Code for confidentiality 1.2
 	if node.eType == NodeValidator {
 		// enrollChainKey is a secret key
-		enrollChainKey, err := node.ks.loadPrivateKey(node.conf.getEnrollmentChainKeyFilename())
-		if err != nil {
-			node.Errorf("Failed loading enrollment chain key: [%s]", err)
-			return err
-		}
-		node.enrollChainKey = enrollChainKey
-	} else {
-		// enrollChainKey is a public key
-		enrollChainKey, err := node.ks.loadPublicKey(node.conf.getEnrollmentChainKeyFilename())
+		chainKey, err := primitives.PEMtoPrivateKey(enrollChainKey, nil)
 		if err != nil {
-			node.Errorf("Failed load enrollment chain key: [%s]", err)
-			return err
+			return nil, fmt.Errorf("validateEnrollmentData: Failed unmarshalling enrollment chain key: [%w]", err)
 		}
-		node.enrollChainKey = enrollChainKey
+
+		return chainKey, nil
 	}
 
-	return nil
+	// enrollChainKey is a public key
+	chainKey, err := primitives.PEMtoPublicKey(enrollChainKey, nil)
+	if err != nil {
+		return nil, fmt.Errorf("validateEnrollmentData: Failed unmarshalling enrollment chain key: [%w]", err)
+	}
+
+	return chainKey, nil
 }
 
-func (node *nodeImpl) loadECACertsChain() error {
-	node.Debug("Loading ECA certificates chain...")
+// getEnrollmentKeyPassphrase returns the configured keystore passphrase
+// or, if none, the password the node has been initialized with.
+func (node *nodeImpl) getEnrollmentKeyPassphrase() []byte {
+	if passphrase := node.conf.getKeystorePassphrase(); len(passphrase) != 0 {
+		return passphrase
+	}
 
-	pem, err := node.ks.loadCert(node.conf.getECACertsChainFilename())
-	if err != nil {
-		node.Errorf("Failed loading ECA certificates chain [%s].", err.Error())
+	return node.ks.pwd
+}
 
-		return err
+// storeEnrollmentKey stores the enrollment key sealed by the KeySealer set, by default
+// as PKCS#8 encrypted with passphrase if not empty
+func (node *nodeImpl) storeEnrollmentKey(priv interface{}, passphrase []byte) error {
+	return node.storeEnrollmentKeyAs(node.conf.getEnrollmentKeyFilename(), priv, passphrase)
+}
+
+// storeEnrollmentKeyAs stores the enrollment key as alias, to be moved in place later
+func (node *nodeImpl) storeEnrollmentKeyAs(alias string, priv interface{}, passphrase []byte) error {
+	if !isSoftwareKey(priv) {
+		node.Error("The enrollment key is held by a PKCS#11 token and cannot be stored.")
+
+		return fmt.Errorf("storeEnrollmentKeyAs: %w", utils.ErrPKCS11NotAvailable)
+	}
+
+	sealer := node.getEnrollmentKeySealer(passphrase)
+	if _, ok := sealer.(*passphraseKeySealer); ok && len(passphrase) == 0 {
+		node.Warning("No keystore passphrase configured. The enrollment key will be stored in clear!")
 	}
 
-	ok := node.ecaCertPool.AppendCertsFromPEM(pem)
+	signer, ok := priv.(crypto.Signer)
 	if !ok {
-		node.Error("Failed appending ECA certificates chain.")
+		node.Error("The enrollment key is not a signer and cannot be stored.")
+
+		return fmt.Errorf("storeEnrollmentKeyAs: %w", utils.ErrInvalidKey)
+	}
+
+	raw, err := sealer.Seal(signer)
+	if err != nil {
+		node.Errorf("Failed sealing enrollment key [%s].", err.Error())
 
-		return errors.New("Failed appending ECA certificates chain.")
+		return fmt.Errorf("storeEnrollmentKeyAs: %w", err)
+	}
+
+	if err := utils.WriteFileAtomic(node.conf.getPathForAlias(alias), raw, 0600); err != nil {
+		node.Errorf("Failed storing enrollment key [%s].", err.Error())
+
+		return fmt.Errorf("storeEnrollmentKeyAs: %w", err)
 	}
 
 	return nil
 }
 
-func (node *nodeImpl) getECAClient() (*grpc.ClientConn, membersrvc.ECAPClient, error) {
-	node.Debug("Getting ECA client...")
-
-	conn, err := node.getClientConn(node.conf.getECAPAddr(), node.conf.getECAServerName())
+// loadEnrollmentKeyWithPassphrase loads the enrollment key stored by storeEnrollmentKey.
+// passphrase is ignored if a KeySealer is set.
+func (node *nodeImpl) loadEnrollmentKeyWithPassphrase(passphrase []byte) (*ecdsa.PrivateKey, error) {
+	raw, err := ioutil.ReadFile(node.conf.getPathForAlias(node.conf.getEnrollmentKeyFilename()))
 	if err != nil {
-		node.Errorf("Failed getting client connection: [%s]", err)
+		return nil, fmt.Errorf("loadEnrollmentKeyWithPassphrase: %w", err)
 	}
 
-	client := membersrvc.NewECAPClient(conn)
+	key, err := node.getEnrollmentKeySealer(passphrase).Unseal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("loadEnrollmentKeyWithPassphrase: %w", err)
+	}
 
-	node.Debug("Getting ECA client...done")
+	enrollPrivKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("loadEnrollmentKeyWithPassphrase: %w", utils.ErrInvalidKey)
+	}
 
-	return conn, client, nil
+	return enrollPrivKey, nil
 }
 
-func (node *nodeImpl) callECAReadCACertificate(ctx context.Context, opts ...grpc.CallOption) (*membersrvc.Cert, error) {
-	// Get an ECA Client
-	sock, ecaP, err := node.getECAClient()
-	defer sock.Close()
+// RewrapEnrollmentKey encrypts again the stored enrollment key with newPass, without re-enrolling.
+// The key is decrypted with oldPass first, a wrong oldPass fails with utils.ErrWrongPassphrase and
+// leaves the stored key untouched. The keystore passphrase in the configuration must be updated
+// accordingly for the key to be loaded at the next start. Keys sealed by a KeySealer do not use
+// a passphrase and fail with utils.ErrNotSupported.
+func (node *nodeImpl) RewrapEnrollmentKey(oldPass, newPass string) error {
+	node.Debug("Rewrapping enrollment key...")
 
-	// Issue the request
-	cert, err := ecaP.ReadCACertificate(ctx, &membersrvc.Empty{}, opts...)
+	if node.conf.getKeySealer() != nil {
+		node.Error("The enrollment key is sealed by a KeySealer and cannot be rewrapped with a passphrase.")
+
+		return fmt.Errorf("RewrapEnrollmentKey: %w", utils.ErrNotSupported)
+	}
+
+	priv, err := node.loadEnrollmentKeyWithPassphrase([]byte(oldPass))
 	if err != nil {
-		node.Errorf("Failed requesting read certificate [%s].", err.Error())
+		node.Errorf("Failed decrypting enrollment key [%s].", err.Error())
 
-		return nil, err
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("RewrapEnrollmentKey: %w", err)
+		}
+		return fmt.Errorf("RewrapEnrollmentKey: %w: %v", utils.ErrWrongPassphrase, err)
 	}
 
-	return cert, nil
-}
+	if err := node.storeEnrollmentKey(priv, []byte(newPass)); err != nil {
+		return fmt.Errorf("RewrapEnrollmentKey: %w", err)
+	}
 
-func (node *nodeImpl) callECAReadCertificate(ctx context.Context, in *membersrvc.ECertReadReq, opts ...grpc.CallOption) (*membersrvc.CertPair, error) {
-	// Get an ECA Client
-	sock, ecaP, err := node.getECAClient()
-	defer sock.Close()
+	node.Info("Enrollment key rewrapped.")
 
-	// Issue the request
-	resp, err := ecaP.ReadCertificatePair(ctx, in, opts...)
-	if err != nil {
-		node.Errorf("Failed requesting read certificate [%s].", err.Error())
+	return nil
+}
 
-		return nil, err
+func (node *nodeImpl) loadEnrollmentKey() error {
+	enrollPrivKey, err := node.readEnrollmentKey()
+	if err != nil {
+		return fmt.Errorf("loadEnrollmentKey: %w", err)
 	}
 
-	return resp, nil
+	node.enrollDataMutex.Lock()
+	node.enrollPrivKey = enrollPrivKey
+	node.enrollDataMutex.Unlock()
+
+	return nil
 }
 
-func (node *nodeImpl) callECAReadCertificateByHash(ctx context.Context, in *membersrvc.Hash, opts ...grpc.CallOption) (*membersrvc.CertPair, error) {
-	// Get an ECA Client
-	sock, ecaP, err := node.getECAClient()
-	defer sock.Close()
+// readEnrollmentKey loads the stored enrollment key, without setting it
+func (node *nodeImpl) readEnrollmentKey() (*ecdsa.PrivateKey, error) {
+	node.Debug("Loading enrollment key...")
 
-	// Issue the request
-	resp, err := ecaP.ReadCertificateByHash(ctx, in, opts...)
+	passphrase := node.getEnrollmentKeyPassphrase()
+	enrollPrivKey, err := node.loadEnrollmentKeyWithPassphrase(passphrase)
+	if err != nil && !errors.Is(err, os.ErrNotExist) && node.conf.getKeySealer() == nil && !bytes.Equal(passphrase, node.ks.pwd) {
+		if migrated := node.migrateEnrollmentKey(passphrase); migrated != nil {
+			enrollPrivKey, err = migrated, nil
+		}
+	}
 	if err != nil {
-		node.Errorf("Failed requesting read certificate [%s].", err.Error())
+		node.Errorf("Failed loading enrollment private key [%s].", err.Error())
 
-		return nil, err
+		return nil, fmt.Errorf("readEnrollmentKey: %w", err)
 	}
 
-	return &membersrvc.CertPair{Sign: resp.Cert, Enc: nil}, nil
+	return enrollPrivKey, nil
 }
 
-func (node *nodeImpl) getEnrollmentCertificateFromECA(id, pw string) (interface{}, []byte, []byte, error) {
-	// Get a new ECA Client
-	sock, ecaP, err := node.getECAClient()
-	defer sock.Close()
+// migrateEnrollmentKey loads the enrollment key stored before a keystore passphrase
+// was configured, encrypted with the keystore password, and stores it again encrypted
+// with passphrase. This happens once, at the first start with the passphrase set.
+// It returns nil if the key is not encrypted with the keystore password. If storing
+// it again fails, the key is still returned and the migration retried at the next start.
+func (node *nodeImpl) migrateEnrollmentKey(passphrase []byte) *ecdsa.PrivateKey {
+	enrollPrivKey, err := node.loadEnrollmentKeyWithPassphrase(node.ks.pwd)
+	if err != nil {
+		return nil
+	}
 
-	// Run the protocol
+	node.Info("Enrollment key encrypted with the keystore password. Encrypting it with the keystore passphrase...")
 
-	signPriv, err := primitives.NewECDSAKey()
-	if err != nil {
-		node.Errorf("Failed generating ECDSA key [%s].", err.Error())
+	if err := node.storeEnrollmentKey(enrollPrivKey, passphrase); err != nil {
+		node.Errorf("Failed encrypting enrollment key with the keystore passphrase [%s]. Still encrypted with the keystore password.", err.Error())
 
-		return nil, nil, nil, err
+		return enrollPrivKey
 	}
-	signPub, err := x509.MarshalPKIXPublicKey(&signPriv.PublicKey)
-	if err != nil {
-		node.Errorf("Failed mashalling ECDSA key [%s].", err.Error())
 
-		return nil, nil, nil, err
-	}
+	node.Info("Enrollment key encrypted with the keystore passphrase.")
 
-	encPriv, err := primitives.NewECDSAKey()
-	if err != nil {
-		node.Errorf("Failed generating Encryption key [%s].", err.Error())
+	return enrollPrivKey
+}
 
-		return nil, nil, nil, err
-	}
-	encPub, err := x509.MarshalPKIXPublicKey(&encPriv.PublicKey)
+func (node *nodeImpl) loadEnrollmentCertificate() error {
+	cert, der, err := node.readEnrollmentCertificate(node.getEnrollmentKey())
 	if err != nil {
-		node.Errorf("Failed marshalling Encryption key [%s].", err.Error())
-
-		return nil, nil, nil, err
+		return fmt.Errorf("loadEnrollmentCertificate: %w", err)
 	}
 
-	req := &membersrvc.ECertCreateReq{
-		Ts:   &google_protobuf.Timestamp{Seconds: time.Now().Unix(), Nanos: 0},
-		Id:   &membersrvc.Identity{Id: id},
-		Tok:  &membersrvc.Token{Tok: []byte(pw)},
-		Sign: &membersrvc.PublicKey{Type: membersrvc.CryptoType_ECDSA, Key: signPub},
-		Enc:  &membersrvc.PublicKey{Type: membersrvc.CryptoType_ECDSA, Key: encPub},
-		Sig:  nil}
+	node.enrollDataMutex.Lock()
+	node.setEnrollmentCertificate(cert, der)
+	node.enrollDataMutex.Unlock()
 
-	resp, err := ecaP.CreateCertificatePair(context.Background(), req)
-	if err != nil {
-		node.Errorf("Failed invoking CreateCertficatePair [%s].", err.Error())
+	return nil
+}
 
-		return nil, nil, nil, err
-	}
+// readEnrollmentCertificate loads the stored enrollment certificate and checks that it binds
+// enrollPrivKey, without setting it. It returns the certificate and its DER encoding.
+func (node *nodeImpl) readEnrollmentCertificate(enrollPrivKey *ecdsa.PrivateKey) (*x509.Certificate, []byte, error) {
+	node.Debug("Loading enrollment certificate...")
 
-	if resp.FetchResult != nil && resp.FetchResult.Status != membersrvc.FetchAttrsResult_SUCCESS {
-		node.Warning(resp.FetchResult.Msg)
-	}
-	//out, err := rsa.DecryptPKCS1v15(rand.Reader, encPriv, resp.Tok.Tok)
-	spi := ecies.NewSPI()
-	eciesKey, err := spi.NewPrivateKey(nil, encPriv)
+	cert, der, err := node.ks.loadCertX509AndDer(node.conf.getEnrollmentCertFilename())
 	if err != nil {
-		node.Errorf("Failed parsing decrypting key [%s].", err.Error())
+		node.Errorf("Failed parsing enrollment certificate [%s].", err.Error())
 
-		return nil, nil, nil, err
+		return nil, nil, fmt.Errorf("readEnrollmentCertificate: %w", err)
 	}
 
-	ecies, err := spi.NewAsymmetricCipherFromPublicKey(eciesKey)
-	if err != nil {
-		node.Errorf("Failed creating asymmetrinc cipher [%s].", err.Error())
+	// TODO: move this to retrieve
+	pk, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		node.Errorf("Enrollment certificate does not bind an ECDSA key, got [%T].", cert.PublicKey)
 
-		return nil, nil, nil, err
+		return nil, nil, fmt.Errorf("readEnrollmentCertificate: %w", utils.ErrInvalidKey)
 	}
-
-	out, err := ecies.Process(resp.Tok.Tok)
+	err = primitives.VerifySignCapability(enrollPrivKey, pk)
 	if err != nil {
-		node.Errorf("Failed decrypting toke [%s].", err.Error())
+		node.Errorf("Failed checking enrollment certificate against enrollment key [%s].", err.Error())
 
-		return nil, nil, nil, err
+		return nil, nil, fmt.Errorf("readEnrollmentCertificate: %w", err)
 	}
 
-	req.Tok.Tok = out
-	req.Sig = nil
+	return cert, der, nil
+}
 
-	hash := primitives.NewHash()
-	raw, _ := proto.Marshal(req)
-	hash.Write(raw)
+// setEnrollmentCertificate sets the enrollment certificate, with the node ID and the eCertHash
+// derived from its DER encoding der. Invoked with enrollDataMutex locked.
+func (node *nodeImpl) setEnrollmentCertificate(cert *x509.Certificate, der []byte) {
+	node.enrollCert = cert
 
-	r, s, err := ecdsa.Sign(rand.Reader, signPriv, hash.Sum(nil))
-	if err != nil {
-		node.Errorf("Failed signing [%s].", err.Error())
+	// Set node ID
+	node.id = primitives.Hash(der)
+	node.Debugf("Setting id to [% x].", node.id)
 
-		return nil, nil, nil, err
-	}
-	R, _ := r.MarshalText()
-	S, _ := s.MarshalText()
-	req.Sig = &membersrvc.Signature{Type: membersrvc.CryptoType_ECDSA, R: R, S: S}
+	// Set eCertHash
+	node.enrollCertHash = primitives.Hash(der)
+	node.Debugf("Setting enrollCertHash to [% x].", node.enrollCertHash)
+}
 
-	resp, err = ecaP.CreateCertificatePair(context.Background(), req)
-	if err != nil {
-		node.Errorf("Failed invoking CreateCertificatePair [%s].", err.Error())
+// getEnrollmentData returns the enrollment key and certificate, from the same enrollment
+func (node *nodeImpl) getEnrollmentData() (*ecdsa.PrivateKey, *x509.Certificate) {
+	node.enrollDataMutex.RLock()
+	defer node.enrollDataMutex.RUnlock()
 
-		return nil, nil, nil, err
-	}
+	return node.enrollPrivKey, node.enrollCert
+}
 
-	// Verify response
+// getEnrollmentCertificate returns the enrollment certificate, nil if not loaded yet
+func (node *nodeImpl) getEnrollmentCertificate() *x509.Certificate {
+	node.enrollDataMutex.RLock()
+	defer node.enrollDataMutex.RUnlock()
 
-	// Verify cert for signing
-	node.Debugf("Enrollment certificate for signing [% x]", primitives.Hash(resp.Certs.Sign))
+	return node.enrollCert
+}
 
-	x509SignCert, err := primitives.DERToX509Certificate(resp.Certs.Sign)
-	if err != nil {
-		node.Errorf("Failed parsing signing enrollment certificate for signing: [%s]", err)
+// getEnrollmentID returns the enrollment id, empty if not loaded yet
+func (node *nodeImpl) getEnrollmentID() string {
+	node.enrollDataMutex.RLock()
+	defer node.enrollDataMutex.RUnlock()
 
-		return nil, nil, nil, err
-	}
+	return node.enrollID
+}
 
-	_, err = primitives.GetCriticalExtension(x509SignCert, ECertSubjectRole)
-	if err != nil {
-		node.Errorf("Failed parsing ECertSubjectRole in enrollment certificate for signing: [%s]", err)
+// getEnrollmentKey returns the enrollment key, nil if not loaded yet
+func (node *nodeImpl) getEnrollmentKey() *ecdsa.PrivateKey {
+	node.enrollDataMutex.RLock()
+	defer node.enrollDataMutex.RUnlock()
 
-		return nil, nil, nil, err
-	}
+	return node.enrollPrivKey
+}
 
-	err = primitives.CheckCertAgainstSKAndRoot(x509SignCert, signPriv, node.ecaCertPool)
-	if err != nil {
-		node.Errorf("Failed checking signing enrollment certificate for signing: [%s]", err)
+// getNodeID returns the identifier of the node, the hash of its enrollment certificate
+func (node *nodeImpl) getNodeID() []byte {
+	node.enrollDataMutex.RLock()
+	defer node.enrollDataMutex.RUnlock()
+
+	return node.id
+}
 
-		return nil, nil, nil, err
+// GetEnrollmentCertHash returns the fingerprint of the enrollment certificate
+func (node *nodeImpl) GetEnrollmentCertHash() ([]byte, error) {
+	cert := node.getEnrollmentCertificate()
+	if cert == nil {
+		return nil, fmt.Errorf("GetEnrollmentCertHash: %w", utils.ErrNotInitialized)
 	}
 
-	// Verify cert for encrypting
-	node.Debugf("Enrollment certificate for encrypting [% x]", primitives.Hash(resp.Certs.Enc))
+	return primitives.Hash(cert.Raw), nil
+}
 
-	x509EncCert, err := primitives.DERToX509Certificate(resp.Certs.Enc)
+// GetEnrollmentCertFingerprint returns the fingerprint of the enrollment certificate as colon-separated hex
+func (node *nodeImpl) GetEnrollmentCertFingerprint() (string, error) {
+	hash, err := node.GetEnrollmentCertHash()
 	if err != nil {
-		node.Errorf("Failed parsing signing enrollment certificate for encrypting: [%s]", err)
-
-		return nil, nil, nil, err
+		return "", fmt.Errorf("GetEnrollmentCertFingerprint: %w", err)
 	}
 
-	_, err = primitives.GetCriticalExtension(x509EncCert, ECertSubjectRole)
-	if err != nil {
-		node.Errorf("Failed parsing ECertSubjectRole in enrollment certificate for encrypting: [%s]", err)
+	return utils.EncodeFingerprint(hash), nil
+}
 
-		return nil, nil, nil, err
+// GetECACertHash returns the fingerprint of the ECA certificate
+func (node *nodeImpl) GetECACertHash() ([]byte, error) {
+	ecaCert := node.getECACert()
+	if ecaCert == nil {
+		return nil, fmt.Errorf("GetECACertHash: %w", utils.ErrNotInitialized)
 	}
 
-	err = primitives.CheckCertAgainstSKAndRoot(x509EncCert, encPriv, node.ecaCertPool)
-	if err != nil {
-		node.Errorf("Failed checking signing enrollment certificate for encrypting: [%s]", err)
+	return primitives.Hash(ecaCert.Raw), nil
+}
 
-		return nil, nil, nil, err
+// GetECACertFingerprint returns the fingerprint of the ECA certificate as colon-separated hex
+func (node *nodeImpl) GetECACertFingerprint() (string, error) {
+	hash, err := node.GetECACertHash()
+	if err != nil {
+		return "", fmt.Errorf("GetECACertFingerprint: %w", err)
 	}
 
-	return signPriv, resp.Certs.Sign, resp.Pkchain, nil
+	return utils.EncodeFingerprint(hash), nil
 }
 
-func (node *nodeImpl) getECACertificate() ([]byte, error) {
-	responce, err := node.callECAReadCACertificate(context.Background())
+func (node *nodeImpl) loadEnrollmentID() error {
+	enrollID, err := node.readEnrollmentID()
 	if err != nil {
-		node.Errorf("Failed requesting ECA certificate [%s].", err.Error())
+		return fmt.Errorf("loadEnrollmentID: %w", err)
+	}
+
+	node.enrollDataMutex.Lock()
+	node.enrollID = enrollID
+	node.enrollDataMutex.Unlock()
+	node.Debugf("Setting enrollment id to [%s].", node.logID(enrollID))
+
+	return nil
+}
+
+// readEnrollmentID loads the stored enrollment id, without setting it
+func (node *nodeImpl) readEnrollmentID() (string, error) {
+	node.Debugf("Loading enrollment id at [%s]...", node.conf.getEnrollmentIDPath())
+
+	enrollID, err := ioutil.ReadFile(node.conf.getEnrollmentIDPath())
+	if err != nil {
+		node.Errorf("Failed loading enrollment id [%s].", err.Error())
+
+		return "", fmt.Errorf("readEnrollmentID: %w", err)
+	}
+
+	return string(enrollID), nil
+}
+
+func (node *nodeImpl) loadEnrollmentChainKey() error {
+	node.Debug("Loading enrollment chain key...")
+
+	// Code for confidentiality 1.2
+	if node.eType == NodeValidator {
+		// enrollChainKey is a secret key
+		enrollChainKey, err := node.ks.loadPrivateKey(node.conf.getEnrollmentChainKeyFilename())
+		if err != nil {
+			node.Errorf("Failed loading enrollment chain key: [%s]", err)
+			return fmt.Errorf("loadEnrollmentChainKey: %w", err)
+		}
+		node.enrollChainKey = enrollChainKey
+	} else {
+		// enrollChainKey is a public key
+		enrollChainKey, err := node.ks.loadPublicKey(node.conf.getEnrollmentChainKeyFilename())
+		if err != nil {
+			node.Errorf("Failed load enrollment chain key: [%s]", err)
+			return fmt.Errorf("loadEnrollmentChainKey: %w", err)
+		}
+		node.enrollChainKey = enrollChainKey
+	}
+
+	return nil
+}
+
+func (node *nodeImpl) loadECACertsChain(ctx context.Context) error {
+	node.Debug("Loading ECA certificates chain...")
+
+	defer lockPath(node.conf.getPathForAlias(node.conf.getECACertsChainFilename()))()
+
+	raw, err := node.readCert(ctx, node.conf.getECACertsChainFilename())
+	if err != nil {
+		node.Errorf("Failed loading ECA certificates chain [%s].", err.Error())
+
+		return fmt.Errorf("loadECACertsChain: %w", err)
+	}
+	if len(bytes.TrimSpace(raw)) == 0 {
+		// Likely left by an interrupted write
+		node.Errorf("ECA certificates chain [%s] is empty. Remove it and enroll again.", node.conf.getECACertsChainFilename())
+
+		return fmt.Errorf("loadECACertsChain: %w: [%s]", utils.ErrEmptyCertChain, node.conf.getECACertsChainFilename())
+	}
+
+	certs, err := decodeCerts(raw)
+	if err != nil {
+		node.Errorf("Failed parsing ECA certificate [%s].", err.Error())
+
+		return fmt.Errorf("loadECACertsChain: %w: %v", utils.ErrInvalidECACert, err)
+	}
+
+	if err := node.checkECACertRevocation(certs[0]); err != nil {
+		node.Errorf("Failed checking ECA certificate revocation [%s].", err.Error())
+
+		return fmt.Errorf("loadECACertsChain: %w", err)
+	}
+
+	// Load intermediate certificates, if any
+	var intermediates []*x509.Certificate
+	unlock := lockPath(node.conf.getPathForAlias(node.conf.getECertIntermediatesFilename()))
+	raw, err = node.readCert(ctx, node.conf.getECertIntermediatesFilename())
+	unlock()
+	if errors.Is(err, utils.ErrCertIOTimeout) {
+		node.Errorf("Failed loading intermediate certificates [%s].", err.Error())
+
+		return fmt.Errorf("loadECACertsChain: %w", err)
+	}
+	if err == nil {
+		intermediates, err = decodeCerts(raw)
+		if err != nil {
+			node.Errorf("Failed parsing intermediate certificates [%s].", err.Error())
+
+			return fmt.Errorf("loadECACertsChain: %w", err)
+		}
+	}
+
+	// Nothing is replaced unless the whole chain loaded.
+	// The certificates after the ECA one lead to the root, they issue no enrollment certificate
+	pool := x509.NewCertPool()
+	pool.AddCert(certs[0])
+	node.setECAChain(pool, certs[0], certs[1:], intermediates)
+
+	return nil
+}
+
+// ReloadECAChain loads again the ECA certificates chain and the enrollment intermediates
+// from the cert store, for instance once they have been replaced after a CA rotation.
+// The chain in use is replaced at once, and kept if the new one fails to load.
+// ctx bounds the reads from the cert store.
+func (node *nodeImpl) ReloadECAChain(ctx context.Context) error {
+	node.Info("Reloading ECA certificates chain...")
+
+	if err := node.loadECACertsChain(ctx); err != nil {
+		node.Errorf("Failed reloading ECA certificates chain. Keeping the previous one [%s].", err.Error())
+
+		return fmt.Errorf("ReloadECAChain: %w", err)
+	}
+
+	node.Info("Reloading ECA certificates chain...done!")
+
+	return nil
+}
+
+// ECAChainMatchesServer tells whether the ECA certificate of the stored chain is the one the
+// ECA serves now, comparing their fingerprints. The ECA is asked directly, bypassing the cache.
+// A mismatch means the ECA certificate was rotated: the stored chain must be replaced, then
+// loaded with ReloadECAChain.
+func (node *nodeImpl) ECAChainMatchesServer(ctx context.Context) (bool, error) {
+	unlock := lockPath(node.conf.getPathForAlias(node.conf.getECACertsChainFilename()))
+	raw, err := node.readCert(ctx, node.conf.getECACertsChainFilename())
+	unlock()
+	if err != nil {
+		node.Errorf("Failed loading ECA certificates chain [%s].", err.Error())
+
+		return false, fmt.Errorf("ECAChainMatchesServer: %w", err)
+	}
+	stored, err := decodeCerts(raw)
+	if err != nil {
+		node.Errorf("Failed parsing ECA certificate [%s].", err.Error())
+
+		return false, fmt.Errorf("ECAChainMatchesServer: %w: %v", utils.ErrInvalidECACert, err)
+	}
+
+	resp, err := node.callECAReadCACertificate(ctx)
+	if err != nil {
+		return false, fmt.Errorf("ECAChainMatchesServer: %w", err)
+	}
+	live, err := decodeCerts(resp.Cert)
+	if err != nil {
+		node.ecaLog().WithError(err).Error("Failed parsing ECA certificate.")
+
+		return false, fmt.Errorf("ECAChainMatchesServer: %w: %v", utils.ErrInvalidECACert, err)
+	}
+
+	storedHash, liveHash := primitives.Hash(stored[0].Raw), primitives.Hash(live[0].Raw)
+	if !bytes.Equal(storedHash, liveHash) {
+		node.ecaLog().Warningf("Stored ECA certificate [%s] differs from the one served by the ECA [%s].",
+			utils.EncodeFingerprint(storedHash), utils.EncodeFingerprint(liveHash))
+
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// getECertChain returns the enrollment certificate followed by the
+// certificates up to the root: the ECA certificate and the intermediates, if any.
+func (node *nodeImpl) getECertChain() ([]*x509.Certificate, error) {
+	node.certPoolsMutex.RLock()
+	ecaCert, ecaIntermediates, intermediates := node.ecaCert, node.ecaIntermediates, node.ecertIntermediates
+	node.certPoolsMutex.RUnlock()
+
+	enrollCert := node.getEnrollmentCertificate()
+	if enrollCert == nil || ecaCert == nil {
+		return nil, fmt.Errorf("getECertChain: %w", utils.ErrNotInitialized)
+	}
+
+	chain := []*x509.Certificate{enrollCert, ecaCert}
+	for _, certs := range [][]*x509.Certificate{intermediates, ecaIntermediates} {
+		for _, cert := range certs {
+			if !containsCert(chain, cert) {
+				chain = append(chain, cert)
+			}
+		}
+	}
+
+	return chain, nil
+}
+
+func containsCert(certs []*x509.Certificate, cert *x509.Certificate) bool {
+	for _, c := range certs {
+		if c.Equal(cert) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseCertificates(ders [][]byte) ([]*x509.Certificate, error) {
+	certs := make([]*x509.Certificate, 0, len(ders))
+	for _, der := range ders {
+		cert, err := primitives.DERToX509Certificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("parseCertificates: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+
+	return certs, nil
+}
+
+type closeFunc func() error
+
+// ecaClientFactory returns an ECAP client for the ECA at addr and the function releasing it.
+// Tests can replace the default gRPC one to run without a live ECA.
+type ecaClientFactory func(addr string) (membersrvc.ECAPClient, closeFunc, error)
+
+func (node *nodeImpl) newGRPCECAClient(addr string) (membersrvc.ECAPClient, closeFunc, error) {
+	opts, err := node.getECADialOpts(addr)
+	if err != nil {
+		node.ecaLog().WithError(err).Error("Failed getting client connection.")
+
+		return nil, nil, fmt.Errorf("newGRPCECAClient: %w", err)
+	}
+
+	key, err := node.getECAConnKey(addr)
+	if err != nil {
+		node.ecaLog().WithError(err).Error("Failed getting client connection.")
+
+		return nil, nil, fmt.Errorf("newGRPCECAClient: %w", err)
+	}
+
+	// Share the connection with the other nodes of this process talking to the same ECA the same way
+	conn, err := AcquireECAConn(key, addr, opts...)
+	if err != nil {
+		node.ecaLog().WithError(err).Error("Failed getting client connection.")
+
+		return nil, nil, fmt.Errorf("newGRPCECAClient: %w", err)
+	}
+
+	// The connection is shared, the node is identified on each call
+	custom := node.conf.getECAUnaryInterceptors()
+	interceptors := append(make([]ECAUnaryInterceptor, 0, len(custom)+1), custom...)
+	interceptors = append(interceptors, node.nodeIDInterceptor)
+
+	return newECAPClient(conn, interceptors), func() error { return ReleaseECAConn(key) }, nil
+}
+
+// rotateECAAddrs returns a copy of addrs rotated to start at first, if found,
+// keeping the order of the replicas that follow it
+func rotateECAAddrs(addrs []string, first string) []string {
+	rotated := make([]string, 0, len(addrs))
+	for i, addr := range addrs {
+		if addr == first {
+			rotated = append(rotated, addrs[i:]...)
+
+			return append(rotated, addrs[:i]...)
+		}
+	}
+
+	return append(rotated, addrs...)
+}
+
+func (node *nodeImpl) getECAClient() (membersrvc.ECAPClient, error) {
+	node.ecaConnMutex.Lock()
+	defer node.ecaConnMutex.Unlock()
+
+	if node.ecaClient != nil {
+		return node.ecaClient, nil
+	}
+
+	node.ecaLog().Debug("Getting ECA client...")
+
+	factory := node.ecaClientFactory
+	if factory == nil {
+		factory = node.newGRPCECAClient
+	}
+
+	// Try the replicas in order, starting from the one that worked last
+	addrs := rotateECAAddrs(node.conf.getECAPAddrs(), node.ecaAddr)
+
+	var (
+		client membersrvc.ECAPClient
+		closer closeFunc
+		err    = errors.New("No ECA address configured.")
+	)
+	for _, addr := range addrs {
+		client, closer, err = factory(addr)
+		if err == nil {
+			node.ecaAddr = addr
+			break
+		}
+		getMetrics().ObserveECADialFailure(err)
+
+		node.ecaLog().WithField("endpoint", addr).WithError(err).Warning("ECA replica not reachable.")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getECAClient: %w", err)
+	}
+
+	node.ecaClient = client
+	node.ecaClose = closer
+
+	if keepalive := node.conf.getECAKeepalive(); keepalive.permitWithoutStream {
+		node.ecaKeepaliveStop = make(chan struct{})
+		node.background.Add(1)
+		go node.probeECA(client, keepalive, node.ecaKeepaliveStop)
+	}
+
+	node.ecaLog().Debug("Getting ECA client...done")
+
+	return node.ecaClient, nil
+}
+
+// probeECA periodically calls the ECA and drops the connection if it doesn't answer,
+// so that the next call re-establishes it instead of hanging on a stale one.
+// This is polling, not a transport keepalive, which the vendored gRPC lacks: each
+// probe is a ReadCACertificate call, handled and answered in full by the ECA.
+func (node *nodeImpl) probeECA(client membersrvc.ECAPClient, keepalive ecaKeepalive, stop chan struct{}) {
+	defer node.background.Done()
+
+	ticker := time.NewTicker(keepalive.time)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), keepalive.timeout)
+		_, err := client.ReadCACertificate(ctx, &membersrvc.Empty{})
+		cancel()
+		if err == nil {
+			continue
+		}
+
+		node.ecaLog().WithError(err).Warning("ECA not answering keepalive probe. Dropping connection.")
+
+		node.ecaConnMutex.Lock()
+		if node.ecaClient == client {
+			node.closeECAConnLocked()
+		}
+		node.ecaConnMutex.Unlock()
+
+		return
+	}
+}
+
+func (node *nodeImpl) closeECAConn() {
+	node.ecaConnMutex.Lock()
+	defer node.ecaConnMutex.Unlock()
+
+	node.closeECAConnLocked()
+}
+
+func (node *nodeImpl) closeECAConnLocked() {
+	if node.ecaClient == nil {
+		return
+	}
+
+	node.ecaLog().Debug("Closing ECA connection...")
+	if node.ecaKeepaliveStop != nil {
+		close(node.ecaKeepaliveStop)
+		node.ecaKeepaliveStop = nil
+	}
+	if node.ecaClose != nil {
+		if err := node.ecaClose(); err != nil {
+			node.ecaLog().WithError(err).Warning("Failed closing ECA connection.")
+		}
+	}
+	node.ecaClose = nil
+	node.ecaClient = nil
+}
+
+// PingECA checks that the ECA is reachable by reading its certificate.
+// It is meant for readiness probes and doesn't use the cached ECA certificate.
+func (node *nodeImpl) PingECA(ctx context.Context) error {
+	if _, err := node.callECAReadCACertificate(ctx); err != nil {
+		node.ecaLog().WithError(err).Warning("ECA not reachable.")
+
+		return fmt.Errorf("PingECA: %w", err)
+	}
+
+	return nil
+}
+
+func (node *nodeImpl) callECAReadCACertificate(ctx context.Context, opts ...grpc.CallOption) (*membersrvc.Cert, error) {
+	// Get the ECA Client
+	ecaP, err := node.getECAClient()
+	if err != nil {
+		node.ecaLog().WithError(err).Error("Failed dialing in.")
+
+		return nil, fmt.Errorf("callECAReadCACertificate: %w", err)
+	}
+
+	// Issue the request. Reads are light, so they get a shorter deadline than enrollment
+	ctx, cancel := context.WithTimeout(ctx, node.conf.getECAReadTimeout())
+	defer cancel()
+
+	ctx, span := node.startSpan(ctx, "ECA.ReadCACertificate")
+	start := time.Now()
+	cert, err := ecaP.ReadCACertificate(ctx, &membersrvc.Empty{}, opts...)
+	getMetrics().ObserveECACall("ReadCACertificate", time.Since(start), err)
+	if err != nil {
+		endSpan(span, err, nil)
+		node.ecaLog().WithError(err).Error("Failed requesting read certificate.")
+
+		return nil, fmt.Errorf("callECAReadCACertificate: %w", node.ecaProtocolError(err))
+	}
+	endSpan(span, nil, cert.Cert)
+	node.markECAContact()
+
+	return cert, nil
+}
+
+// ecaProtocolError returns an error wrapping utils.ErrNotECAServer if err reports
+// that the endpoint doesn't implement the ECA service, err otherwise.
+// This is what a gRPC server other than the ECA answers.
+func (node *nodeImpl) ecaProtocolError(err error) error {
+	if grpc.Code(err) != codes.Unimplemented {
+		return err
+	}
+
+	node.ecaConnMutex.Lock()
+	addr := node.ecaAddr
+	node.ecaConnMutex.Unlock()
+
+	return fmt.Errorf("%w: endpoint [%s] is not an ECA server (got Unimplemented: %s)", utils.ErrNotECAServer, addr, grpc.ErrorDesc(err))
+}
+
+func (node *nodeImpl) callECAReadCertificate(ctx context.Context, in *membersrvc.ECertReadReq, opts ...grpc.CallOption) (*membersrvc.CertPair, error) {
+	// Get the ECA Client
+	ecaP, err := node.getECAClient()
+	if err != nil {
+		node.ecaLog().WithError(err).Error("Failed dialing in.")
+
+		return nil, fmt.Errorf("callECAReadCertificate: %w", err)
+	}
+
+	// Issue the request. Reads are light, so they get a shorter deadline than enrollment
+	ctx, cancel := context.WithTimeout(ctx, node.conf.getECAReadTimeout())
+	defer cancel()
+
+	ctx, span := node.startSpan(ctx, "ECA.ReadCertificatePair")
+	start := time.Now()
+	resp, err := ecaP.ReadCertificatePair(ctx, in, opts...)
+	getMetrics().ObserveECACall("ReadCertificatePair", time.Since(start), err)
+	if err != nil {
+		endSpan(span, err, nil)
+		node.ecaLog().WithError(err).Error("Failed requesting read certificate.")
+
+		return nil, fmt.Errorf("callECAReadCertificate: %w", err)
+	}
+	endSpan(span, nil, resp.Sign)
+	node.markECAContact()
+
+	return resp, nil
+}
+
+// readCertificate reads the certificate pair of id, from the cache unless force is set
+func (node *nodeImpl) readCertificate(ctx context.Context, id string, force bool) (*membersrvc.CertPair, error) {
+	cache := node.getCertCache()
+	if !force {
+		if pair, ok := cache.get(id); ok {
+			return pair, nil
+		}
+	}
+
+	pair, err := node.callECAReadCertificate(ctx, &membersrvc.ECertReadReq{Id: &membersrvc.Identity{Id: id}})
+	if err != nil {
+		return nil, fmt.Errorf("readCertificate: %w", err)
+	}
+	cache.put(id, pair)
+
+	return pair, nil
+}
+
+// readCertificates reads the certificate pairs of ids, returning them by id.
+// The ECA API has no batch read, so the reads are issued concurrently over the
+// same connection, at most conf.getECAReadConcurrency() at a time.
+// Cached pairs are returned without a read unless force is set.
+// It fails with the first error encountered.
+func (node *nodeImpl) readCertificates(ctx context.Context, ids []string, force bool) (map[string]*membersrvc.CertPair, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		id   string
+		pair *membersrvc.CertPair
+		err  error
+	}
+
+	jobs := make(chan string)
+	results := make(chan result)
+
+	workers := node.conf.getECAReadConcurrency()
+	if workers > len(ids) {
+		workers = len(ids)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range jobs {
+				pair, err := node.readCertificate(ctx, id, force)
+				results <- result{id, pair, err}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		for _, id := range ids {
+			select {
+			case jobs <- id:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	pairs := make(map[string]*membersrvc.CertPair, len(ids))
+	var firstErr error
+	for r := range results {
+		if r.err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("Failed reading certificate of [%s]: [%w]", r.id, r.err)
+				cancel()
+			}
+			continue
+		}
+		pairs[r.id] = r.pair
+	}
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	if firstErr != nil {
+		return nil, fmt.Errorf("readCertificates: %w", firstErr)
+	}
+
+	return pairs, nil
+}
+
+func (node *nodeImpl) callECAReadCertificateByHash(ctx context.Context, in *membersrvc.Hash, opts ...grpc.CallOption) (*membersrvc.CertPair, error) {
+	// Get the ECA Client
+	ecaP, err := node.getECAClient()
+	if err != nil {
+		node.ecaLog().WithError(err).Error("Failed dialing in.")
+
+		return nil, fmt.Errorf("callECAReadCertificateByHash: %w", err)
+	}
+
+	// Issue the request. Reads are light, so they get a shorter deadline than enrollment
+	ctx, cancel := context.WithTimeout(ctx, node.conf.getECAReadTimeout())
+	defer cancel()
+
+	ctx, span := node.startSpan(ctx, "ECA.ReadCertificateByHash")
+	start := time.Now()
+	resp, err := ecaP.ReadCertificateByHash(ctx, in, opts...)
+	getMetrics().ObserveECACall("ReadCertificateByHash", time.Since(start), err)
+	if err != nil {
+		endSpan(span, err, nil)
+		node.ecaLog().WithError(err).Error("Failed requesting read certificate.")
+
+		return nil, fmt.Errorf("callECAReadCertificateByHash: %w", err)
+	}
+	endSpan(span, nil, resp.Cert)
+	node.markECAContact()
+
+	return &membersrvc.CertPair{Sign: resp.Cert, Enc: nil}, nil
+}
+
+// callECACreateCertificatePair sends in to the ECA. A request signed with the
+// current enrollment key, in EcertSig, is a re-enrollment and goes to ReEnrollCertificatePair.
+func (node *nodeImpl) callECACreateCertificatePair(ctx context.Context, in *membersrvc.ECertCreateReq, opts ...grpc.CallOption) (*membersrvc.ECertCreateResp, error) {
+	// Get the ECA Client
+	ecaP, err := node.getECAClient()
+	if err != nil {
+		node.ecaLog().WithError(err).Error("Failed dialing in.")
+
+		return nil, fmt.Errorf("callECACreateCertificatePair: %w", err)
+	}
+
+	method, call := "CreateCertificatePair", ecaP.CreateCertificatePair
+	if in.EcertSig != nil {
+		method, call = "ReEnrollCertificatePair", ecaP.ReEnrollCertificatePair
+	}
+
+	// Issue the request
+	ctx, span := node.startSpan(ctx, "ECA."+method)
+	start := time.Now()
+	resp, err := call(ctx, in, opts...)
+	getMetrics().ObserveECACall(method, time.Since(start), err)
+	if err != nil {
+		endSpan(span, err, nil)
+		entry := node.ecaLog().WithField("method", method).WithField("code", grpc.Code(err)).WithError(err)
+		switch {
+		case isECATransientError(err):
+			entry.Warning("Enrollment request failed. The ECA is not available.")
+		case isECAAlreadyEnrolledError(err):
+			entry.Error("Enrollment request failed. The identity is already enrolled.")
+		case grpc.Code(err) == codes.InvalidArgument:
+			entry.Error("Enrollment request failed. Malformed request.")
+		case grpc.Code(err) == codes.Unimplemented && in.EcertSig != nil:
+			entry.Error("Re-enrollment request failed. The ECA does not support re-enrollment.")
+		case grpc.Code(err) == codes.Unimplemented:
+			entry.Error("Enrollment request failed. The endpoint is not an ECA server, check the ECA address.")
+		case isECAClockSkewError(err):
+			entry.WithField("request_time", timestampToTime(in.Ts)).
+				WithField("local_time", node.now()).
+				WithField("tolerated_skew", node.conf.getECAClockSkew()).
+				Error("Enrollment request failed. The ECA rejected the request timestamp, check the clocks of this node and the ECA.")
+		default:
+			entry.Errorf("Failed invoking %s.", method)
+		}
+
+		// An ECA older than the re-enrollment is still an ECA
+		if grpc.Code(err) == codes.Unimplemented && in.EcertSig != nil {
+			return nil, fmt.Errorf("callECACreateCertificatePair: %w: %s", utils.ErrReEnrollmentNotSupported, grpc.ErrorDesc(err))
+		}
+
+		return nil, fmt.Errorf("callECACreateCertificatePair: %w", node.ecaProtocolError(err))
+	}
+
+	// The first phase answers with the challenge only
+	var cert []byte
+	if resp.Certs != nil {
+		cert = resp.Certs.Sign
+	}
+	endSpan(span, nil, cert)
+	node.markECAContact()
+
+	return resp, nil
+}
+
+func (node *nodeImpl) callECACreateCertificatePairWithRetry(ctx context.Context, in *membersrvc.ECertCreateReq, opts ...grpc.CallOption) (*membersrvc.ECertCreateResp, error) {
+	attempts := node.conf.getECARetryAttempts()
+	delay := node.conf.getECARetryBaseDelay()
+
+	for attempt := 1; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("callECACreateCertificatePairWithRetry: %w", err)
+		}
+
+		resp, err := node.callECACreateCertificatePair(ctx, in, opts...)
+		if err == nil {
+			return resp, nil
+		}
+		if attempt >= attempts || !isECATransientError(err) {
+			return nil, fmt.Errorf("callECACreateCertificatePairWithRetry: %w", err)
+		}
+
+		node.ecaLog().Debugf("ECA not reachable, retrying the enrollment request in [%s] (attempt %d of %d).", delay, attempt+1, attempts)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			node.ecaLog().Debug("Retry of the enrollment request cancelled.")
+
+			return nil, fmt.Errorf("callECACreateCertificatePairWithRetry: %w", ctx.Err())
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+// ecaRootError returns the innermost of the errors wrapped by err.
+// The status of a failed ECA call can only be read from the gRPC error itself.
+func ecaRootError(err error) error {
+	for {
+		wrapped := errors.Unwrap(err)
+		if wrapped == nil {
+			return err
+		}
+		err = wrapped
+	}
+}
+
+// isECATransientError returns true if err is a transport level error
+// worth retrying, as opposed to a rejection of the request by the ECA.
+func isECATransientError(err error) bool {
+	switch grpc.Code(ecaRootError(err)) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+
+	return false
+}
+
+// isECAAuthError returns true if err signals that the ECA
+// rejected the enrollment id or password.
+func isECAAuthError(err error) bool {
+	code := grpc.Code(ecaRootError(err))
+
+	return code == codes.Unauthenticated || code == codes.PermissionDenied
+}
+
+// isECAClockSkewError returns true if err signals that the ECA
+// rejected the timestamp of the request.
+func isECAClockSkewError(err error) bool {
+	err = ecaRootError(err)
+	if grpc.Code(err) == codes.OutOfRange {
+		return true
+	}
+
+	desc := strings.ToLower(grpc.ErrorDesc(err))
+	return strings.Contains(desc, "timestamp out of range") || strings.Contains(desc, "clock skew")
+}
+
+// isECAAlreadyEnrolledError returns true if err signals that the ECA
+// has already issued the enrollment certificates of the identity.
+func isECAAlreadyEnrolledError(err error) bool {
+	return grpc.Code(ecaRootError(err)) == codes.AlreadyExists
+}
+
+// sendECertCreateReq signs req with sign, if not nil, and sends it to the ECA.
+// A rejected request timestamp is not retried: the ECA doesn't report its time, so a
+// fresh timestamp would come from the same skewed clock. callECACreateCertificatePair
+// logs the skew diagnosis instead.
+func (node *nodeImpl) sendECertCreateReq(ctx context.Context, req *membersrvc.ECertCreateReq, sign func() error) (*membersrvc.ECertCreateResp, error) {
+	if sign != nil {
+		if err := sign(); err != nil {
+			return nil, fmt.Errorf("sendECertCreateReq: %w", err)
+		}
+	}
+
+	resp, err := node.callECACreateCertificatePairWithRetry(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("sendECertCreateReq: %w", err)
+	}
+
+	return resp, nil
+}
+
+// timestampToTime converts the timestamp of a request
+func timestampToTime(ts *google_protobuf.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+
+	return time.Unix(ts.Seconds, int64(ts.Nanos))
+}
+
+// ecaEnrollmentError converts the errors returned by CreateCertificatePair
+// into the errors returned to the callers of the enrollment
+func ecaEnrollmentError(err error) error {
+	switch {
+	case isECAAuthError(err):
+		return utils.ErrEnrollmentAuthFailed
+	case isECAAlreadyEnrolledError(err):
+		return utils.ErrAlreadyEnrolled
+	case isECAClockSkewError(err):
+		return fmt.Errorf("%w: %s", utils.ErrClockSkew, grpc.ErrorDesc(ecaRootError(err)))
+	case grpc.Code(ecaRootError(err)) == codes.InvalidArgument:
+		return fmt.Errorf("%w: %s", utils.ErrInvalidEnrollmentRequest, grpc.ErrorDesc(ecaRootError(err)))
+	case isECATransientError(err):
+		return fmt.Errorf("%w: %s", utils.ErrECAUnavailable, grpc.ErrorDesc(ecaRootError(err)))
+	}
+
+	return err
+}
+
+// getEnrollmentCertificateFromECA runs the enrollment protocol with the ECA for id.
+// Passing the password as an argument tends to leak it, prefer enrollFromSecretsFile.
+func (node *nodeImpl) getEnrollmentCertificateFromECA(ctx context.Context, id, pw string) (interface{}, []byte, [][]byte, []byte, error) {
+	return node.runEnrollmentProtocol(ctx, "ECA.Enrollment", id, pw, nil)
+}
+
+// getReEnrollmentCertificateFromECA runs the re-enrollment protocol with the ECA for id,
+// enrolled with ecertKey: the requests are authenticated by signing them with ecertKey.
+func (node *nodeImpl) getReEnrollmentCertificateFromECA(ctx context.Context, id string, ecertKey *ecdsa.PrivateKey) (interface{}, []byte, [][]byte, []byte, error) {
+	return node.runEnrollmentProtocol(ctx, "ECA.ReEnrollment", id, "", ecertKey)
+}
+
+func (node *nodeImpl) runEnrollmentProtocol(ctx context.Context, name, id, pw string, ecertKey *ecdsa.PrivateKey) (interface{}, []byte, [][]byte, []byte, error) {
+	ctx, done, err := node.beginEnrollment(ctx)
+	if err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("runEnrollmentProtocol: %w", err)
+	}
+	defer done()
+
+	ctx, span := node.startSpan(ctx, name)
+	start := time.Now()
+	key, cert, intermediates, chainKey, err := node.requestEnrollmentCertificate(ctx, id, pw, ecertKey)
+	if err != nil && node.isClosing() {
+		err = fmt.Errorf("runEnrollmentProtocol: %w: %v", utils.ErrNodeClosed, err)
+	}
+	getMetrics().ObserveEnrollment(time.Since(start), err)
+	endSpan(span, err, cert)
+
+	return key, cert, intermediates, chainKey, err
+}
+
+// requestEnrollmentCertificate runs the enrollment protocol for id, authenticated with the
+// password pw, or, for a re-enrollment, with the current enrollment key ecertKey if not nil.
+func (node *nodeImpl) requestEnrollmentCertificate(ctx context.Context, id, pw string, ecertKey *ecdsa.PrivateKey) (interface{}, []byte, [][]byte, []byte, error) {
+	ecaLog := node.ecaLog().WithField("user_id", node.logID(id))
+
+	// Bound the whole protocol so that a stalled ECA does not block enrollment forever
+	ctx, cancel := context.WithTimeout(ctx, node.conf.getECAEnrollmentTimeout())
+	defer cancel()
+
+	// The certificates of an ECA ignoring the attributes would fail the check below,
+	// once the identity is used up
+	attrs := node.requestedEnrollmentAttributes(ctx)
+	if len(attrs) != 0 && !node.conf.getECAAttributes() {
+		ecaLog.Error("Enrollment attributes requested, but the ECA is not known to support them.")
+
+		return nil, nil, nil, nil, fmt.Errorf("requestEnrollmentCertificate: %w", utils.ErrEnrollmentAttributesNotSupported)
+	}
+
+	// Run the protocol
+
+	curve, err := primitives.GetCurveForSecurityLevel(node.conf.getSecurityLevel())
+	if err != nil {
+		ecaLog.WithError(err).Error("Failed selecting curve for enrollment keys.")
+
+		return nil, nil, nil, nil, fmt.Errorf("requestEnrollmentCertificate: %w", err)
+	}
+	if err := node.checkCurveStrength(curve); err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("requestEnrollmentCertificate: %w", err)
+	}
+
+	// The request hash must match the curve of the signing key
+	newHash, err := primitives.GetHashForSecurityLevel(node.conf.getHashAlgorithm(), node.conf.getSecurityLevel())
+	if err != nil {
+		ecaLog.WithError(err).Error("Failed selecting hash for enrollment requests.")
+
+		return nil, nil, nil, nil, fmt.Errorf("requestEnrollmentCertificate: %w", err)
+	}
+
+	node.sendEnrollmentEvent(id, EnrollmentStepGeneratingKey)
+	signPriv, err := node.newEnrollmentSigner(curve)
+	if err != nil {
+		ecaLog.WithError(err).Error("Failed generating signing key.")
+
+		return nil, nil, nil, nil, fmt.Errorf("requestEnrollmentCertificate: %w", err)
+	}
+	signPub, err := x509.MarshalPKIXPublicKey(signPriv.Public())
+	if err != nil {
+		ecaLog.WithError(err).Error("Failed mashalling signing key.")
+
+		return nil, nil, nil, nil, fmt.Errorf("requestEnrollmentCertificate: %w", err)
+	}
+
+	encPriv, err := primitives.NewECDSAKeyForCurve(curve)
+	if err != nil {
+		ecaLog.WithError(err).Error("Failed generating Encryption key.")
 
-		return nil, err
+		return nil, nil, nil, nil, fmt.Errorf("requestEnrollmentCertificate: %w", err)
 	}
+	encPub, err := x509.MarshalPKIXPublicKey(&encPriv.PublicKey)
+	if err != nil {
+		ecaLog.WithError(err).Error("Failed marshalling Encryption key.")
+
+		return nil, nil, nil, nil, fmt.Errorf("requestEnrollmentCertificate: %w", err)
+	}
+
+	// A re-enrollment carries no password
+	var tok []byte
+	if ecertKey == nil {
+		tok = []byte(pw)
+	}
+
+	now := node.now()
+	req := &membersrvc.ECertCreateReq{
+		Ts:    &google_protobuf.Timestamp{Seconds: now.Unix(), Nanos: int32(now.Nanosecond())},
+		Id:    &membersrvc.Identity{Id: id},
+		Tok:   &membersrvc.Token{Tok: tok},
+		Sign:  &membersrvc.PublicKey{Type: membersrvc.CryptoType_ECDSA, Key: signPub},
+		Enc:   &membersrvc.PublicKey{Type: membersrvc.CryptoType_ECDSA, Key: encPub},
+		Sig:   nil,
+		Attrs: attrs}
+
+	// A re-enrollment request is signed with the current enrollment key, over the request
+	// without signatures, then with the new signing key, if any, over the request with EcertSig
+	var signECert func() error
+	if ecertKey != nil {
+		newECertHash, err := primitives.GetHashForSecurityLevel(node.conf.getHashAlgorithm(), ecertKey.Curve.Params().BitSize)
+		if err != nil {
+			ecaLog.WithError(err).Error("Failed selecting hash for re-enrollment requests.")
+
+			return nil, nil, nil, nil, fmt.Errorf("requestEnrollmentCertificate: %w", err)
+		}
+
+		signECert = func() error {
+			req.Sig, req.EcertSig = nil, nil
+			raw, err := proto.Marshal(req)
+			if err != nil {
+				ecaLog.WithError(err).Error("Failed marshalling request.")
+
+				return err
+			}
+			hash := newECertHash()
+			hash.Write(raw)
+
+			req.EcertSig, err = node.signEnrollmentRequest(ecertKey, hash.Sum(nil))
+			if err != nil {
+				ecaLog.WithError(err).Error("Failed signing with the enrollment key.")
+
+				return err
+			}
+
+			return nil
+		}
+	}
+
+	node.sendEnrollmentEvent(id, EnrollmentStepRequestingCert)
+	resp, err := node.sendECertCreateReq(ctx, req, signECert)
+	if err != nil {
+		ecaLog.WithError(err).Error("Failed requesting enrollment challenge.")
+
+		return nil, nil, nil, nil, fmt.Errorf("requestEnrollmentCertificate: %w", ecaEnrollmentError(err))
+	}
+
+	if resp.FetchResult != nil && resp.FetchResult.Status != membersrvc.FetchAttrsResult_SUCCESS {
+		ecaLog.Warning(resp.FetchResult.Msg)
+	}
+	if resp.Tok == nil {
+		ecaLog.Error("ECA response carries no enrollment challenge.")
+
+		return nil, nil, nil, nil, errors.New("requestEnrollmentCertificate: ECA response carries no enrollment challenge.")
+	}
+	//out, err := rsa.DecryptPKCS1v15(rand.Reader, encPriv, resp.Tok.Tok)
+	spi := ecies.NewSPI()
+	eciesKey, err := spi.NewPrivateKey(nil, encPriv)
+	if err != nil {
+		ecaLog.WithError(err).Error("Failed parsing decrypting key.")
+
+		return nil, nil, nil, nil, fmt.Errorf("requestEnrollmentCertificate: %w", err)
+	}
+
+	ecies, err := spi.NewAsymmetricCipherFromPublicKey(eciesKey)
+	if err != nil {
+		ecaLog.WithError(err).Error("Failed creating asymmetrinc cipher.")
+
+		return nil, nil, nil, nil, fmt.Errorf("requestEnrollmentCertificate: %w", err)
+	}
+
+	out, err := ecies.Process(resp.Tok.Tok)
+	if err != nil {
+		ecaLog.WithError(err).Error("Failed decrypting toke.")
+
+		return nil, nil, nil, nil, fmt.Errorf("requestEnrollmentCertificate: %w", err)
+	}
+
+	// Add a fresh nonce to the signed request. The ECA must echo it back, so that a
+	// response not issued for this request is rejected. The ECA keeps no record of
+	// the nonces: replayed requests are refused by the one-time challenge instead.
+	nonce, err := primitives.GetRandomNonce()
+	if err != nil {
+		ecaLog.WithError(err).Error("Failed generating nonce.")
+
+		return nil, nil, nil, nil, fmt.Errorf("requestEnrollmentCertificate: %w", err)
+	}
+
+	req.Tok.Tok = out
+	req.Nonce = nonce
+
+	sign := func() error {
+		if signECert != nil {
+			if err := signECert(); err != nil {
+				return err
+			}
+		}
+
+		req.Sig = nil
+		raw, err := proto.Marshal(req)
+		if err != nil {
+			ecaLog.WithError(err).Error("Failed marshalling request.")
+
+			return err
+		}
+		hash := newHash()
+		hash.Write(raw)
+
+		req.Sig, err = node.signEnrollmentRequest(signPriv, hash.Sum(nil))
+		if err != nil {
+			ecaLog.WithError(err).Error("Failed signing.")
+
+			return err
+		}
+
+		return nil
+	}
+
+	resp, err = node.sendECertCreateReq(ctx, req, sign)
+	if err != nil {
+		ecaLog.WithError(err).Error("Failed requesting enrollment certificate.")
+
+		return nil, nil, nil, nil, fmt.Errorf("requestEnrollmentCertificate: %w", ecaEnrollmentError(err))
+	}
+
+	// Verify response
+	node.sendEnrollmentEvent(id, EnrollmentStepVerifying)
+	if !bytes.Equal(resp.Nonce, nonce) {
+		ecaLog.Error("ECA response nonce does not match the request nonce.")
+
+		return nil, nil, nil, nil, errors.New("requestEnrollmentCertificate: ECA response nonce does not match the request nonce.")
+	}
+
+	// Intermediate certificates between the ECA and the root, if any
+	intermediates, err := parseCertificates(resp.Intermediates)
+	if err != nil {
+		ecaLog.WithError(err).Error("Failed parsing intermediate certificates.")
+
+		return nil, nil, nil, nil, fmt.Errorf("requestEnrollmentCertificate: %w", err)
+	}
+	node.setECertIntermediates(intermediates)
+
+	// Verify cert for signing
+	ecaLog.Debugf("Enrollment certificate for signing [% x]", primitives.Hash(resp.Certs.Sign))
+
+	x509SignCert, err := primitives.DERToX509Certificate(resp.Certs.Sign)
+	if err != nil {
+		ecaLog.WithError(err).Error("Failed parsing signing enrollment certificate for signing.")
+
+		return nil, nil, nil, nil, fmt.Errorf("requestEnrollmentCertificate: %w", err)
+	}
+
+	if err := node.checkEnrollmentCertificateValidity(x509SignCert); err != nil {
+		ecaLog.WithError(err).Error("Failed checking validity period of enrollment certificate for signing.")
+
+		return nil, nil, nil, nil, fmt.Errorf("requestEnrollmentCertificate: %w", err)
+	}
+
+	_, err = primitives.GetCriticalExtension(x509SignCert, ECertSubjectRole)
+	if err != nil {
+		ecaLog.WithError(err).Error("Failed parsing ECertSubjectRole in enrollment certificate for signing.")
+
+		return nil, nil, nil, nil, fmt.Errorf("requestEnrollmentCertificate: %w", err)
+	}
+
+	if err := checkEnrollmentAttributes(x509SignCert, req.Attrs); err != nil {
+		ecaLog.WithError(err).Error("Failed checking requested attributes in enrollment certificate for signing.")
+
+		return nil, nil, nil, nil, fmt.Errorf("requestEnrollmentCertificate: %w", err)
+	}
+
+	err = node.verifyEnrollmentCertificate(x509SignCert, signPriv)
+	if err != nil {
+		ecaLog.WithError(err).Error("Failed checking signing enrollment certificate for signing.")
+
+		return nil, nil, nil, nil, fmt.Errorf("requestEnrollmentCertificate: %w", err)
+	}
+
+	// Verify cert for encrypting
+	ecaLog.Debugf("Enrollment certificate for encrypting [% x]", primitives.Hash(resp.Certs.Enc))
+
+	x509EncCert, err := primitives.DERToX509Certificate(resp.Certs.Enc)
+	if err != nil {
+		ecaLog.WithError(err).Error("Failed parsing signing enrollment certificate for encrypting.")
+
+		return nil, nil, nil, nil, fmt.Errorf("requestEnrollmentCertificate: %w", err)
+	}
+
+	_, err = primitives.GetCriticalExtension(x509EncCert, ECertSubjectRole)
+	if err != nil {
+		ecaLog.WithError(err).Error("Failed parsing ECertSubjectRole in enrollment certificate for encrypting.")
+
+		return nil, nil, nil, nil, fmt.Errorf("requestEnrollmentCertificate: %w", err)
+	}
+
+	err = node.verifyEnrollmentCertificate(x509EncCert, encPriv)
+	if err != nil {
+		ecaLog.WithError(err).Error("Failed checking signing enrollment certificate for encrypting.")
+
+		return nil, nil, nil, nil, fmt.Errorf("requestEnrollmentCertificate: %w", err)
+	}
+
+	return signPriv, resp.Certs.Sign, resp.Intermediates, resp.Pkchain, nil
+}
+
+// verifyEnrollmentCertificate checks that cert binds the public key of priv
+// and that it has been issued by the ECA, chaining up to the trusted roots if any.
+// Self-signed certificates are rejected with ErrSelfSignedCert. In warn mode,
+// see checkCertVerification, only the key and the ECA signature are enforced.
+func (node *nodeImpl) verifyEnrollmentCertificate(cert *x509.Certificate, priv interface{}) error {
+	if isSelfSigned(cert) {
+		if err := node.checkCertVerification(fmt.Errorf("verifyEnrollmentCertificate: %w", utils.ErrSelfSignedCert)); err != nil {
+			return err
+		}
+	}
+
+	ecaCertPool := node.getECACertPool()
+	if signer, ok := priv.(crypto.Signer); ok && !isSoftwareKey(priv) {
+		// The private key is held by a token, compare the public keys
+		if err := checkPublicKeyMatchesSigner(cert.PublicKey, signer); err != nil {
+			return fmt.Errorf("verifyEnrollmentCertificate: %w", err)
+		}
+		if _, err := primitives.CheckCertAgainRoot(cert, ecaCertPool); err != nil {
+			return fmt.Errorf("verifyEnrollmentCertificate: %w", err)
+		}
+	} else if err := primitives.CheckCertAgainstSKAndRoot(cert, priv, ecaCertPool); err != nil {
+		return fmt.Errorf("verifyEnrollmentCertificate: %w", err)
+	}
+
+	roots, err := node.verificationRoots()
+	if err != nil {
+		return fmt.Errorf("verifyEnrollmentCertificate: %w", err)
+	}
+	if roots == nil {
+		return nil
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: node.getECertIntermediatesPool(),
+		CurrentTime:   node.now(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	if _, err := cert.Verify(opts); err != nil {
+		return node.checkCertVerification(fmt.Errorf("verifyEnrollmentCertificate: Certificate does not chain to a trusted root [%w]", err))
+	}
+
+	return nil
+}
+
+// isSelfSigned tells whether cert names itself as issuer or carries
+// a signature made with its own key
+func isSelfSigned(cert *x509.Certificate) bool {
+	if bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+		return true
+	}
+
+	return cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+}
+
+// getECertIntermediatesPool returns the pool of the certificates between
+// the enrollment certificates and the trusted roots
+func (node *nodeImpl) getECertIntermediatesPool() *x509.CertPool {
+	node.certPoolsMutex.RLock()
+	defer node.certPoolsMutex.RUnlock()
+
+	if len(node.ecertIntermediates) == 0 && len(node.ecaIntermediates) == 0 {
+		return node.ecaCertPool
+	}
+
+	intermediates := x509.NewCertPool()
+	if node.ecaCert != nil {
+		intermediates.AddCert(node.ecaCert)
+	}
+	for _, cert := range node.ecertIntermediates {
+		intermediates.AddCert(cert)
+	}
+	for _, cert := range node.ecaIntermediates {
+		intermediates.AddCert(cert)
+	}
+
+	return intermediates
+}
+
+// VerifyCertificate checks that the DER encoded certificate der is currently valid
+// and chains up to the roots trusted by this node. If no root is configured,
+// the ECA certificates chain is trusted instead.
+func (node *nodeImpl) VerifyCertificate(der []byte) error {
+	opts, err := node.getCertVerifyOptions()
+	if err != nil {
+		return fmt.Errorf("VerifyCertificate: %w", err)
+	}
+	if err := verifyCertificate(der, opts); err != nil {
+		return fmt.Errorf("VerifyCertificate: %w", err)
+	}
+
+	return nil
+}
+
+// VerifyCertificates checks the DER encoded certificates ders as VerifyCertificate does.
+// The pools are read once and the certificates are verified concurrently, by at most
+// runtime.NumCPU() workers. The returned slice holds the error of each input, nil if valid.
+func (node *nodeImpl) VerifyCertificates(ders [][]byte) []error {
+	errs := make([]error, len(ders))
+
+	opts, err := node.getCertVerifyOptions()
+	if err != nil {
+		for i := range errs {
+			errs[i] = fmt.Errorf("VerifyCertificates: %w", err)
+		}
+		return errs
+	}
+
+	jobs := make(chan int)
+	workers := runtime.NumCPU()
+	if workers > len(ders) {
+		workers = len(ders)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if err := verifyCertificate(ders[i], opts); err != nil {
+					errs[i] = fmt.Errorf("VerifyCertificates: %w", err)
+				}
+			}
+		}()
+	}
+	for i := range ders {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return errs
+}
+
+// getCertVerifyOptions returns the options verifying certificates against
+// the roots trusted by this node, or the ECA certificates chain if none
+func (node *nodeImpl) getCertVerifyOptions() (x509.VerifyOptions, error) {
+	ecaCertPool := node.getECACertPool()
+	if ecaCertPool == nil {
+		return x509.VerifyOptions{}, utils.ErrNotInitialized
+	}
+
+	roots, err := node.verificationRoots()
+	if err != nil {
+		return x509.VerifyOptions{}, err
+	}
+	intermediates := node.getECertIntermediatesPool()
+	if roots == nil {
+		roots, intermediates = ecaCertPool, nil
+	}
+
+	return x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   node.now(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}, nil
+}
+
+func verifyCertificate(der []byte, opts x509.VerifyOptions) error {
+	cert, err := primitives.DERToX509Certificate(der)
+	if err != nil {
+		return fmt.Errorf("%w: %v", utils.ErrInvalidCert, err)
+	}
+
+	// The role is handled by the consumers of enrollment certificates
+	primitives.GetCriticalExtension(cert, ECertSubjectRole)
+
+	if _, err := cert.Verify(opts); err != nil {
+		return fmt.Errorf("%w: %v", utils.ErrInvalidCert, err)
+	}
+
+	return nil
+}
+
+func (node *nodeImpl) checkEnrollmentCertificateValidity(cert *x509.Certificate) error {
+	now := node.now()
+	skew := node.conf.getECAClockSkew()
+	if now.Add(skew).Before(cert.NotBefore) {
+		return fmt.Errorf("checkEnrollmentCertificateValidity: Certificate not valid before [%s], local time is [%s]. Check the clocks of this node and the ECA.", cert.NotBefore, now)
+	}
+	if now.Add(-skew).After(cert.NotAfter) {
+		return fmt.Errorf("checkEnrollmentCertificateValidity: Certificate expired at [%s], local time is [%s].", cert.NotAfter, now)
+	}
+	if max := node.conf.getMaxCertLifetime(); max > 0 && cert.NotAfter.Sub(cert.NotBefore) > max {
+		return fmt.Errorf("checkEnrollmentCertificateValidity: %w: valid from [%s] to [%s], at most [%s] accepted. Check the ECA configuration.", utils.ErrCertLifetimeTooLong, cert.NotBefore, cert.NotAfter, max)
+	}
+
+	if remaining := cert.NotAfter.Sub(now); remaining < node.conf.getEnrollmentCertExpiryWarningThreshold() {
+		node.Warningf("Enrollment certificate expires in [%s], at [%s]. Plan re-enrollment.", remaining, cert.NotAfter)
+	}
+
+	return nil
+}
+
+// getECACertificate returns the DER encoded ECA certificate, followed by the
+// intermediates up to the root if the ECA serves them
+func (node *nodeImpl) getECACertificate() ([]byte, error) {
+	node.ecaCACertMutex.Lock()
+	defer node.ecaCACertMutex.Unlock()
+
+	if node.ecaCACert != nil && !node.isECACertCacheExpired() {
+		return node.ecaCACert, nil
+	}
+
+	if path := node.conf.getECACertFile(); path != "" {
+		der, err := node.loadECACertificateFromFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("getECACertificate: %w", err)
+		}
+		if err := node.checkECACertTrust(der); err != nil {
+			return nil, fmt.Errorf("getECACertificate: %w", err)
+		}
+		node.ecaCACert = der
+		node.ecaCACertFetched = node.now()
+
+		return node.ecaCACert, nil
+	}
+
+	responce, err := node.callECAReadCACertificate(context.Background())
+	if err != nil {
+		node.ecaLog().WithError(err).Error("Failed requesting ECA certificate.")
+
+		return nil, fmt.Errorf("getECACertificate: %w", err)
+	}
+
+	// Don't cache what can't be parsed
+	if _, err := decodeCerts(responce.Cert); err != nil {
+		node.ecaLog().WithError(err).Error("Failed parsing ECA certificate.")
+
+		return nil, fmt.Errorf("getECACertificate: %w: %v", utils.ErrInvalidECACert, err)
+	}
+	if err := node.checkECACertTrust(responce.Cert); err != nil {
+		return nil, fmt.Errorf("getECACertificate: %w", err)
+	}
+	node.ecaCACert = responce.Cert
+	node.ecaCACertFetched = node.now()
+
+	return node.ecaCACert, nil
+}
+
+// isECACertCacheExpired tells whether the cached ECA certificate must be fetched again.
+// Invoked with ecaCACertMutex held.
+func (node *nodeImpl) isECACertCacheExpired() bool {
+	ttl := node.conf.getECACertCacheTTL()
+	switch {
+	case ttl < 0:
+		return true
+	case ttl == 0:
+		return false
+	}
+
+	return node.now().Sub(node.ecaCACertFetched) >= ttl
+}
+
+// loadECACertificateFromFile loads the ECA certificate, PEM or DER encoded, provided out of band.
+// The file may hold the intermediates up to the root after the ECA certificate.
+func (node *nodeImpl) loadECACertificateFromFile(path string) ([]byte, error) {
+	node.ecaLog().Debugf("Loading ECA certificate at [%s]...", path)
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		node.ecaLog().WithError(err).Error("Failed loading ECA certificate.")
+
+		return nil, fmt.Errorf("loadECACertificateFromFile: %w", err)
+	}
+
+	certs, err := decodeCerts(raw)
+	if err != nil {
+		node.ecaLog().WithError(err).Errorf("Failed parsing ECA certificate at [%s].", path)
+
+		return nil, fmt.Errorf("loadECACertificateFromFile: %w: %v", utils.ErrInvalidECACert, err)
+	}
+
+	var der []byte
+	for _, cert := range certs {
+		der = append(der, cert.Raw...)
+	}
+
+	return der, nil
+}
+
+// checkECACertTrust checks the ECA certificate, the first of chain, against the
+// configured pin and allowlist
+func (node *nodeImpl) checkECACertTrust(chain []byte) error {
+	certs, err := decodeCerts(chain)
+	if err != nil {
+		return fmt.Errorf("checkECACertTrust: %w: %v", utils.ErrInvalidECACert, err)
+	}
+	if err := node.checkECACertPin(certs[0].Raw); err != nil {
+		return fmt.Errorf("checkECACertTrust: %w", err)
+	}
+	if err := node.checkECACertAllowlist(certs[0].Raw); err != nil {
+		return fmt.Errorf("checkECACertTrust: %w", err)
+	}
+
+	return nil
+}
+
+// checkECACertPin fails if a pin is configured and the fingerprint of der,
+// as returned by GetECACertFingerprint, doesn't match it.
+func (node *nodeImpl) checkECACertPin(der []byte) error {
+	pin := node.conf.getECACertPin()
+	if pin == "" {
+		return nil
+	}
+
+	fingerprint := utils.EncodeFingerprint(primitives.Hash(der))
+	if !strings.EqualFold(strings.Replace(pin, ":", "", -1), strings.Replace(fingerprint, ":", "", -1)) {
+		node.ecaLog().WithField("fingerprint", fingerprint).Errorf("ECA certificate does not match the pin [%s].", pin)
+
+		return fmt.Errorf("checkECACertPin: %w", utils.ErrECACertPinMismatch)
+	}
+
+	return nil
+}
+
+// checkECACertAllowlist fails if an allowlist is configured and the SHA-256
+// fingerprint of der is not listed in it. Listing the certificates of both the
+// current and the next ECA keys allows a rotation without any rejection.
+func (node *nodeImpl) checkECACertAllowlist(der []byte) error {
+	allowlist := node.conf.getECACertFingerprintAllowlist()
+	if len(allowlist) == 0 {
+		return nil
+	}
+
+	sum := sha256.Sum256(der)
+	fingerprint := utils.EncodeFingerprint(sum[:])
+	for _, allowed := range allowlist {
+		if strings.EqualFold(strings.Replace(allowed, ":", "", -1), strings.Replace(fingerprint, ":", "", -1)) {
+			return nil
+		}
+	}
+	node.ecaLog().WithField("fingerprint", fingerprint).Error("ECA certificate not in the allowlist.")
+
+	return fmt.Errorf("checkECACertAllowlist: %w", utils.ErrECACertNotAllowed)
+}
+
+func (node *nodeImpl) invalidateECACertificate() {
+	node.ecaCACertMutex.Lock()
+	defer node.ecaCACertMutex.Unlock()
 
-	return responce.Cert, nil
+	node.ecaCACert = nil
 }
@@ -0,0 +1,2191 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/testutil"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	membersrvc "github.com/hyperledger/fabric/membersrvc/protos"
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+// fakeECAPClient returns canned responses instead of talking to a live ECA
+type fakeECAPClient struct {
+	caCert      []byte
+	readCAErr   error
+	readCACalls int
+	readCAHang  bool
+
+	createErrs  []error
+	createCalls int
+
+	// Guards the calls counters and createErrs, for the concurrent enrollments
+	callsMutex sync.Mutex
+
+	// Certificate pairs by id. Read concurrently, hence the mutex
+	pairs       map[string]*membersrvc.CertPair
+	pairsMutex  sync.Mutex
+	inFlight    int
+	maxInFlight int
+	readCalls   int
+}
+
+func (c *fakeECAPClient) ReadCACertificate(ctx context.Context, in *membersrvc.Empty, opts ...grpc.CallOption) (*membersrvc.Cert, error) {
+	c.callsMutex.Lock()
+	c.readCACalls++
+	c.callsMutex.Unlock()
+	if c.readCAHang {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if c.readCAErr != nil {
+		return nil, c.readCAErr
+	}
+	return &membersrvc.Cert{Cert: c.caCert}, nil
+}
+
+func (c *fakeECAPClient) CreateCertificatePair(ctx context.Context, in *membersrvc.ECertCreateReq, opts ...grpc.CallOption) (*membersrvc.ECertCreateResp, error) {
+	c.callsMutex.Lock()
+	defer c.callsMutex.Unlock()
+
+	c.createCalls++
+	if len(c.createErrs) > 0 {
+		err := c.createErrs[0]
+		c.createErrs = c.createErrs[1:]
+		return nil, err
+	}
+	return &membersrvc.ECertCreateResp{}, nil
+}
+
+func (c *fakeECAPClient) ReEnrollCertificatePair(ctx context.Context, in *membersrvc.ECertCreateReq, opts ...grpc.CallOption) (*membersrvc.ECertCreateResp, error) {
+	return c.CreateCertificatePair(ctx, in, opts...)
+}
+
+func (c *fakeECAPClient) ReadCertificatePair(ctx context.Context, in *membersrvc.ECertReadReq, opts ...grpc.CallOption) (*membersrvc.CertPair, error) {
+	c.pairsMutex.Lock()
+	c.readCalls++
+	c.inFlight++
+	if c.inFlight > c.maxInFlight {
+		c.maxInFlight = c.inFlight
+	}
+	pair, ok := c.pairs[in.Id.Id]
+	c.pairsMutex.Unlock()
+
+	time.Sleep(time.Millisecond)
+
+	c.pairsMutex.Lock()
+	c.inFlight--
+	c.pairsMutex.Unlock()
+
+	if !ok {
+		return nil, errors.New("Identity not found")
+	}
+	return pair, nil
+}
+
+func (c *fakeECAPClient) ReadCertificateByHash(ctx context.Context, in *membersrvc.Hash, opts ...grpc.CallOption) (*membersrvc.Cert, error) {
+	return nil, errors.New("Not implemented")
+}
+
+func (c *fakeECAPClient) RevokeCertificatePair(ctx context.Context, in *membersrvc.ECertRevokeReq, opts ...grpc.CallOption) (*membersrvc.CAStatus, error) {
+	return nil, errors.New("Not implemented")
+}
+
+func newTestECANode(t *testing.T, client *fakeECAPClient) (*nodeImpl, func()) {
+	node, cleanup := newTestCertStoreNode(t, 0644)
+	node.conf.ecaPAddressProperty = "peer.pki.eca.paddr"
+	node.conf.ecaRetryAttempts = 3
+	node.conf.ecaRetryBaseDelay = time.Millisecond
+	node.conf.ecaReadTimeout = time.Second
+	node.ecaClientFactory = func(addr string) (membersrvc.ECAPClient, closeFunc, error) {
+		return client, func() error { return nil }, nil
+	}
+
+	return node, cleanup
+}
+
+func TestECACreateCertificatePairRetry(t *testing.T) {
+	unavailable := grpc.Errorf(codes.Unavailable, "ECA down")
+
+	client := &fakeECAPClient{createErrs: []error{unavailable, unavailable}}
+	node, cleanup := newTestECANode(t, client)
+	defer cleanup()
+
+	if _, err := node.callECACreateCertificatePairWithRetry(context.Background(), &membersrvc.ECertCreateReq{}); err != nil {
+		t.Fatalf("CreateCertificatePair must succeed after retrying [%s]", err)
+	}
+	if client.createCalls != 3 {
+		t.Fatalf("Expected 3 calls, got [%d]", client.createCalls)
+	}
+
+	// Exhaust the attempts
+	client.createCalls = 0
+	client.createErrs = []error{unavailable, unavailable, unavailable}
+	if _, err := node.callECACreateCertificatePairWithRetry(context.Background(), &membersrvc.ECertCreateReq{}); grpc.Code(ecaRootError(err)) != codes.Unavailable {
+		t.Fatalf("Expected Unavailable, got [%v]", err)
+	}
+	if client.createCalls != 3 {
+		t.Fatalf("Expected 3 calls, got [%d]", client.createCalls)
+	}
+
+	// Rejections are not retried
+	client.createCalls = 0
+	client.createErrs = []error{grpc.Errorf(codes.Unauthenticated, "Identity or token does not match.")}
+	if _, err := node.callECACreateCertificatePairWithRetry(context.Background(), &membersrvc.ECertCreateReq{}); err == nil {
+		t.Fatal("CreateCertificatePair must fail")
+	}
+	if client.createCalls != 1 {
+		t.Fatalf("Expected 1 call, got [%d]", client.createCalls)
+	}
+}
+
+func TestECACreateCertificatePairRetryCancelled(t *testing.T) {
+	unavailable := grpc.Errorf(codes.Unavailable, "ECA down")
+
+	client := &fakeECAPClient{createErrs: []error{unavailable, unavailable, unavailable}}
+	node, cleanup := newTestECANode(t, client)
+	defer cleanup()
+	node.conf.ecaRetryBaseDelay = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	if _, err := node.callECACreateCertificatePairWithRetry(ctx, &membersrvc.ECertCreateReq{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected [%s], got [%v]", context.Canceled, err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Cancelling must abort the backoff, took [%s]", elapsed)
+	}
+	if client.createCalls != 1 {
+		t.Fatalf("Expected 1 call, got [%d]", client.createCalls)
+	}
+
+	// An already cancelled context doesn't reach the ECA
+	client.createCalls = 0
+	if _, err := node.callECACreateCertificatePairWithRetry(ctx, &membersrvc.ECertCreateReq{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected [%s], got [%v]", context.Canceled, err)
+	}
+	if client.createCalls != 0 {
+		t.Fatalf("Expected no call, got [%d]", client.createCalls)
+	}
+}
+
+func TestECACertificateCache(t *testing.T) {
+	der, _, err := primitives.NewSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Failed generating self signed cert [%s]", err)
+	}
+	client := &fakeECAPClient{caCert: der}
+	node, cleanup := newTestECANode(t, client)
+	defer cleanup()
+
+	for i := 0; i < 2; i++ {
+		if _, err := node.getECACertificate(); err != nil {
+			t.Fatalf("Failed getting ECA certificate [%s]", err)
+		}
+	}
+	if client.readCACalls != 1 {
+		t.Fatalf("ECA certificate must be fetched once, got [%d] calls", client.readCACalls)
+	}
+
+	node.invalidateECACertificate()
+	if _, err := node.getECACertificate(); err != nil {
+		t.Fatalf("Failed getting ECA certificate [%s]", err)
+	}
+	if client.readCACalls != 2 {
+		t.Fatalf("ECA certificate must be fetched again after invalidation, got [%d] calls", client.readCACalls)
+	}
+}
+
+func TestECACertificateCacheTTL(t *testing.T) {
+	der, _, err := primitives.NewSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Failed generating self signed cert [%s]", err)
+	}
+	client := &fakeECAPClient{caCert: der}
+	node, cleanup := newTestECANode(t, client)
+	defer cleanup()
+
+	now := time.Now()
+	node.clock = func() time.Time { return now }
+
+	fetch := func(expected int) {
+		if _, err := node.getECACertificate(); err != nil {
+			t.Fatalf("Failed getting ECA certificate [%s]", err)
+		}
+		if client.readCACalls != expected {
+			t.Fatalf("Expected [%d] ECA certificate fetches, got [%d]", expected, client.readCACalls)
+		}
+	}
+
+	// Cached until the TTL elapses
+	node.conf.ecaCertCacheTTL = time.Hour
+	fetch(1)
+	now = now.Add(time.Hour - time.Second)
+	fetch(1)
+	now = now.Add(time.Second)
+	fetch(2)
+	fetch(2)
+
+	// Cached forever
+	node.conf.ecaCertCacheTTL = 0
+	now = now.Add(24 * time.Hour)
+	fetch(2)
+
+	// Never cached
+	node.conf.ecaCertCacheTTL = -1
+	fetch(3)
+	fetch(4)
+}
+
+func TestEnrollmentCertificateValidityClockSkew(t *testing.T) {
+	node, cleanup := newTestECANode(t, &fakeECAPClient{})
+	defer cleanup()
+
+	now := time.Now()
+	node.clock = func() time.Time { return now }
+	cert := &x509.Certificate{NotBefore: now.Add(30 * time.Second), NotAfter: now.Add(90 * 24 * time.Hour)}
+
+	node.conf.ecaClockSkew = time.Minute
+	if err := node.checkEnrollmentCertificateValidity(cert); err != nil {
+		t.Fatalf("Certificate must be accepted within the clock skew [%s]", err)
+	}
+
+	node.conf.ecaClockSkew = 0
+	if err := node.checkEnrollmentCertificateValidity(cert); err == nil {
+		t.Fatal("Certificate not yet valid must be rejected")
+	}
+
+	node.clock = func() time.Time { return now.Add(91 * 24 * time.Hour) }
+	if err := node.checkEnrollmentCertificateValidity(cert); err == nil {
+		t.Fatal("Expired certificate must be rejected")
+	}
+}
+
+func TestEnrollmentCertificateMaxLifetime(t *testing.T) {
+	node, cleanup := newTestECANode(t, &fakeECAPClient{})
+	defer cleanup()
+
+	now := time.Now()
+	node.clock = func() time.Time { return now }
+	cert := &x509.Certificate{NotBefore: now.Add(-time.Minute), NotAfter: now.Add(100 * 365 * 24 * time.Hour)}
+
+	// Disabled
+	if err := node.checkEnrollmentCertificateValidity(cert); err != nil {
+		t.Fatalf("Any lifetime must be accepted without maximum [%s]", err)
+	}
+
+	node.conf.maxCertLifetime = 365 * 24 * time.Hour
+	if err := node.checkEnrollmentCertificateValidity(cert); !errors.Is(err, utils.ErrCertLifetimeTooLong) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrCertLifetimeTooLong, err)
+	}
+
+	cert.NotAfter = now.Add(90 * 24 * time.Hour)
+	if err := node.checkEnrollmentCertificateValidity(cert); err != nil {
+		t.Fatalf("Certificate within the maximum lifetime must be accepted [%s]", err)
+	}
+}
+
+func TestECAClientDialTimeout(t *testing.T) {
+	node, cleanup := newTestCertStoreNode(t, 0644)
+	defer cleanup()
+	node.conf.ecaDialTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	if _, _, err := node.newGRPCECAClient("localhost:1"); err == nil {
+		t.Fatal("Dialing an unreachable ECA must fail")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Dialing an unreachable ECA must fail within the dial timeout, took [%s]", elapsed)
+	}
+}
+
+func TestECAClientFailover(t *testing.T) {
+	client := &fakeECAPClient{}
+	node, cleanup := newTestECANode(t, client)
+	defer cleanup()
+
+	node.conf.ecaPAddressProperty = "test.eca.failover.paddr"
+	viper.Set(node.conf.ecaPAddressProperty, "eca1:50051, eca2:50051, eca3:50051")
+	defer viper.Set(node.conf.ecaPAddressProperty, "")
+
+	var dialed []string
+	down := map[string]bool{"eca1:50051": true}
+	node.ecaClientFactory = func(addr string) (membersrvc.ECAPClient, closeFunc, error) {
+		dialed = append(dialed, addr)
+		if down[addr] {
+			return nil, nil, fmt.Errorf("ECA at [%s] not reachable", addr)
+		}
+		return client, func() error { return nil }, nil
+	}
+
+	if _, err := node.getECAClient(); err != nil {
+		t.Fatalf("Failed getting ECA client [%s]", err)
+	}
+	if strings.Join(dialed, ",") != "eca1:50051,eca2:50051" {
+		t.Fatalf("The replicas must be tried in order until one succeeds, dialed [%v]", dialed)
+	}
+
+	// The working replica is tried first on reconnection
+	node.closeECAConn()
+	dialed = nil
+	down = map[string]bool{}
+	if _, err := node.getECAClient(); err != nil {
+		t.Fatalf("Failed getting ECA client [%s]", err)
+	}
+	if strings.Join(dialed, ",") != "eca2:50051" {
+		t.Fatalf("The last working replica must be tried first, dialed [%v]", dialed)
+	}
+
+	// All the replicas down
+	node.closeECAConn()
+	dialed = nil
+	down = map[string]bool{"eca1:50051": true, "eca2:50051": true, "eca3:50051": true}
+	if _, err := node.getECAClient(); err == nil {
+		t.Fatal("Getting an ECA client must fail when no replica is reachable")
+	}
+	if strings.Join(dialed, ",") != "eca2:50051,eca3:50051,eca1:50051" {
+		t.Fatalf("All the replicas must be tried in order from the last working one, dialed [%v]", dialed)
+	}
+}
+
+func TestECAKeepaliveDropsStaleConnection(t *testing.T) {
+	client := &fakeECAPClient{readCAErr: grpc.Errorf(codes.Unavailable, "ECA gone")}
+	node, cleanup := newTestECANode(t, client)
+	defer cleanup()
+	node.conf.ecaKeepalive = ecaKeepalive{time: 10 * time.Millisecond, timeout: 10 * time.Millisecond, permitWithoutStream: true}
+
+	if _, err := node.getECAClient(); err != nil {
+		t.Fatalf("Failed getting ECA client [%s]", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		node.ecaConnMutex.Lock()
+		dropped := node.ecaClient == nil
+		node.ecaConnMutex.Unlock()
+		if dropped {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("A connection to an ECA not answering the probes must be dropped")
+}
+
+func TestEnrollmentKeyPassphrase(t *testing.T) {
+	node, cleanup := newTestCertStoreNode(t, 0644)
+	defer cleanup()
+
+	priv, err := primitives.NewECDSAKey()
+	if err != nil {
+		t.Fatalf("Failed generating ECDSA key [%s]", err)
+	}
+
+	if err := node.storeEnrollmentKey(priv, []byte("passphrase")); err != nil {
+		t.Fatalf("Failed storing enrollment key [%s]", err)
+	}
+
+	loaded, err := node.loadEnrollmentKeyWithPassphrase([]byte("passphrase"))
+	if err != nil {
+		t.Fatalf("Failed loading enrollment key [%s]", err)
+	}
+	if loaded.D.Cmp(priv.D) != 0 {
+		t.Fatal("Loaded enrollment key differs from the stored one")
+	}
+
+	if _, err := node.loadEnrollmentKeyWithPassphrase([]byte("wrong")); err == nil {
+		t.Fatal("Loading the enrollment key with a wrong passphrase must fail")
+	}
+	if _, err := node.loadEnrollmentKeyWithPassphrase(nil); err == nil {
+		t.Fatal("Loading an encrypted enrollment key without passphrase must fail")
+	}
+}
+
+func TestEnrollmentKeyPassphraseMigration(t *testing.T) {
+	node, cleanup := newTestCertStoreNode(t, 0644)
+	defer cleanup()
+	node.ks = &keyStore{node: node, pwd: []byte("password")}
+
+	priv, err := primitives.NewECDSAKey()
+	if err != nil {
+		t.Fatalf("Failed generating ECDSA key [%s]", err)
+	}
+	// Stored before the keystore passphrase was configured
+	if err := node.storeEnrollmentKey(priv, node.ks.pwd); err != nil {
+		t.Fatalf("Failed storing enrollment key [%s]", err)
+	}
+
+	viper.Set("security.keystore.passphrase", "passphrase")
+	defer viper.Set("security.keystore.passphrase", "")
+	if err := node.loadEnrollmentKey(); err != nil {
+		t.Fatalf("Failed loading enrollment key stored with the keystore password [%s]", err)
+	}
+	if node.enrollPrivKey.D.Cmp(priv.D) != 0 {
+		t.Fatal("Loaded enrollment key differs from the stored one")
+	}
+
+	// Encrypted again with the passphrase
+	if _, err := node.loadEnrollmentKeyWithPassphrase([]byte("passphrase")); err != nil {
+		t.Fatalf("The enrollment key must be encrypted with the keystore passphrase [%s]", err)
+	}
+	if _, err := node.loadEnrollmentKeyWithPassphrase(node.ks.pwd); err == nil {
+		t.Fatal("The enrollment key must no longer be encrypted with the keystore password")
+	}
+
+	// Keys encrypted with neither are still refused
+	if err := node.storeEnrollmentKey(priv, []byte("other")); err != nil {
+		t.Fatalf("Failed storing enrollment key [%s]", err)
+	}
+	if err := node.loadEnrollmentKey(); err == nil {
+		t.Fatal("Loading an enrollment key encrypted with another passphrase must fail")
+	}
+}
+
+// xorKeySealer stands for a KMS in tests
+type xorKeySealer struct {
+	sealed int
+}
+
+func (sealer *xorKeySealer) Seal(priv crypto.Signer) ([]byte, error) {
+	sealer.sealed++
+	raw, err := primitives.PrivateKeyToPKCS8PEM(priv, nil)
+	if err != nil {
+		return nil, err
+	}
+	for i := range raw {
+		raw[i] ^= 0x5a
+	}
+
+	return raw, nil
+}
+
+func (sealer *xorKeySealer) Unseal(sealed []byte) (crypto.Signer, error) {
+	raw := make([]byte, len(sealed))
+	for i := range sealed {
+		raw[i] = sealed[i] ^ 0x5a
+	}
+	key, err := primitives.PEMtoPrivateKey(raw, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return key.(crypto.Signer), nil
+}
+
+func TestEnrollmentKeySealer(t *testing.T) {
+	node, cleanup := newTestCertStoreNode(t, 0644)
+	defer cleanup()
+	sealer := &xorKeySealer{}
+	node.conf.keySealer = sealer
+
+	priv, err := primitives.NewECDSAKey()
+	if err != nil {
+		t.Fatalf("Failed generating ECDSA key [%s]", err)
+	}
+	if err := node.storeEnrollmentKey(priv, nil); err != nil {
+		t.Fatalf("Failed storing enrollment key [%s]", err)
+	}
+	if sealer.sealed != 1 {
+		t.Fatalf("The enrollment key must be sealed once, got [%d]", sealer.sealed)
+	}
+
+	stored, err := ioutil.ReadFile(node.conf.getPathForAlias(node.conf.getEnrollmentKeyFilename()))
+	if err != nil {
+		t.Fatalf("Failed reading enrollment key [%s]", err)
+	}
+	if _, err := primitives.PEMtoPrivateKey(stored, nil); err == nil {
+		t.Fatal("The enrollment key must not be stored in clear")
+	}
+
+	loaded, err := node.loadEnrollmentKeyWithPassphrase(nil)
+	if err != nil {
+		t.Fatalf("Failed loading enrollment key [%s]", err)
+	}
+	if loaded.D.Cmp(priv.D) != 0 {
+		t.Fatal("Unsealed enrollment key differs from the stored one")
+	}
+}
+
+func TestRewrapEnrollmentKey(t *testing.T) {
+	node, cleanup := newTestCertStoreNode(t, 0644)
+	defer cleanup()
+
+	if err := node.RewrapEnrollmentKey("old", "new"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Rewrapping a missing enrollment key must fail with [%s], got [%v]", os.ErrNotExist, err)
+	}
+
+	priv, err := primitives.NewECDSAKey()
+	if err != nil {
+		t.Fatalf("Failed generating ECDSA key [%s]", err)
+	}
+	if err := node.storeEnrollmentKey(priv, []byte("old")); err != nil {
+		t.Fatalf("Failed storing enrollment key [%s]", err)
+	}
+	name := node.conf.getPathForAlias(node.conf.getEnrollmentKeyFilename())
+	stored, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatalf("Failed reading enrollment key [%s]", err)
+	}
+
+	// A wrong passphrase leaves the stored key untouched
+	if err := node.RewrapEnrollmentKey("wrong", "new"); !errors.Is(err, utils.ErrWrongPassphrase) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrWrongPassphrase, err)
+	}
+	if raw, _ := ioutil.ReadFile(name); !bytes.Equal(raw, stored) {
+		t.Fatal("A failed rewrap must not modify the stored enrollment key")
+	}
+
+	if err := node.RewrapEnrollmentKey("old", "new"); err != nil {
+		t.Fatalf("Failed rewrapping enrollment key [%s]", err)
+	}
+	if _, err := node.loadEnrollmentKeyWithPassphrase([]byte("old")); err == nil {
+		t.Fatal("The old passphrase must not decrypt the rewrapped enrollment key")
+	}
+	loaded, err := node.loadEnrollmentKeyWithPassphrase([]byte("new"))
+	if err != nil {
+		t.Fatalf("Failed loading rewrapped enrollment key [%s]", err)
+	}
+	if loaded.D.Cmp(priv.D) != 0 {
+		t.Fatal("The rewrapped enrollment key differs from the stored one")
+	}
+
+	// Sealed keys have no passphrase to change
+	if stored, err = ioutil.ReadFile(name); err != nil {
+		t.Fatalf("Failed reading enrollment key [%s]", err)
+	}
+	node.conf.keySealer = &xorKeySealer{}
+	if err := node.RewrapEnrollmentKey("new", "newer"); !errors.Is(err, utils.ErrNotSupported) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrNotSupported, err)
+	}
+	if raw, _ := ioutil.ReadFile(name); !bytes.Equal(raw, stored) {
+		t.Fatal("A refused rewrap must not modify the stored enrollment key")
+	}
+}
+
+func TestInvalidECACertificate(t *testing.T) {
+	client := &fakeECAPClient{caCert: []byte("garbage")}
+	node, cleanup := newTestECANode(t, client)
+	defer cleanup()
+
+	if _, err := node.getECACertificate(); !errors.Is(err, utils.ErrInvalidECACert) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrInvalidECACert, err)
+	}
+	if node.ecaCACert != nil {
+		t.Fatal("An invalid ECA certificate must not be cached")
+	}
+
+	if err := node.refreshECACertificate(true); !errors.Is(err, utils.ErrInvalidECACert) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrInvalidECACert, err)
+	}
+
+	// Network errors are not reported as invalid certificates
+	client.readCAErr = grpc.Errorf(codes.Unavailable, "ECA down")
+	if _, err := node.getECACertificate(); err == nil || errors.Is(err, utils.ErrInvalidECACert) {
+		t.Fatalf("Expected a network error, got [%v]", err)
+	}
+}
+
+func TestECACertificateFromFile(t *testing.T) {
+	client := &fakeECAPClient{readCAErr: grpc.Errorf(codes.Unavailable, "ECA not reachable")}
+	node, cleanup := newTestECANode(t, client)
+	defer cleanup()
+
+	der, _, err := primitives.NewSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Failed generating self signed cert [%s]", err)
+	}
+	path := filepath.Join(node.conf.getRawsPath(), "eca.pem")
+	if err := ioutil.WriteFile(path, primitives.DERCertToPEM(der), 0644); err != nil {
+		t.Fatalf("Failed writing ECA certificate [%s]", err)
+	}
+
+	viper.Set("peer.pki.eca.cert.file", path)
+	defer viper.Set("peer.pki.eca.cert.file", "")
+
+	raw, err := node.getECACertificate()
+	if err != nil {
+		t.Fatalf("Failed getting ECA certificate from file [%s]", err)
+	}
+	if !bytes.Equal(raw, der) {
+		t.Fatal("Invalid ECA certificate loaded from file")
+	}
+	if client.readCACalls != 0 {
+		t.Fatal("The ECA must not be called when a local certificate is configured")
+	}
+
+	node.invalidateECACertificate()
+	if err := ioutil.WriteFile(path, []byte("garbage"), 0644); err != nil {
+		t.Fatalf("Failed writing ECA certificate [%s]", err)
+	}
+	if _, err := node.getECACertificate(); !errors.Is(err, utils.ErrInvalidECACert) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrInvalidECACert, err)
+	}
+}
+
+func TestPingECA(t *testing.T) {
+	client := &fakeECAPClient{caCert: []byte("eca")}
+	node, cleanup := newTestECANode(t, client)
+	defer cleanup()
+
+	if err := node.PingECA(context.Background()); err != nil {
+		t.Fatalf("Ping must succeed [%s]", err)
+	}
+
+	client.readCAErr = grpc.Errorf(codes.Unavailable, "ECA down")
+	if err := node.PingECA(context.Background()); grpc.Code(ecaRootError(err)) != codes.Unavailable {
+		t.Fatalf("Expected Unavailable, got [%v]", err)
+	}
+}
+
+func TestECAChainMatchesServer(t *testing.T) {
+	eca, _ := newTestCert(t, "eca", true, nil, nil)
+	rotated, _ := newTestCert(t, "eca", true, nil, nil)
+
+	client := &fakeECAPClient{caCert: eca.Raw}
+	node, cleanup := newTestECANode(t, client)
+	defer cleanup()
+	node.certStore = NewMemCertStore()
+
+	if _, err := node.ECAChainMatchesServer(context.Background()); !errors.Is(err, utils.ErrCertNotFound) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrCertNotFound, err)
+	}
+
+	if err := node.certStore.Put(node.conf.getECACertsChainFilename(), node.encodeCerts(eca.Raw)); err != nil {
+		t.Fatalf("Failed storing ECA certificates chain [%s]", err)
+	}
+	if match, err := node.ECAChainMatchesServer(context.Background()); err != nil || !match {
+		t.Fatalf("The stored chain must match the ECA certificate, got [%t] [%v]", match, err)
+	}
+
+	client.caCert = rotated.Raw
+	if match, err := node.ECAChainMatchesServer(context.Background()); err != nil || match {
+		t.Fatalf("A rotated ECA certificate must not match, got [%t] [%v]", match, err)
+	}
+
+	client.readCAErr = grpc.Errorf(codes.Unavailable, "ECA down")
+	if _, err := node.ECAChainMatchesServer(context.Background()); grpc.Code(ecaRootError(err)) != codes.Unavailable {
+		t.Fatalf("Expected Unavailable, got [%v]", err)
+	}
+}
+
+// recordingMetrics keeps the observations it receives
+type recordingMetrics struct {
+	enrollments  []error
+	calls        []string
+	callErrs     []error
+	dialFailures []error
+}
+
+func (m *recordingMetrics) ObserveEnrollment(duration time.Duration, err error) {
+	m.enrollments = append(m.enrollments, err)
+}
+
+func (m *recordingMetrics) ObserveECACall(method string, duration time.Duration, err error) {
+	m.calls = append(m.calls, method)
+	m.callErrs = append(m.callErrs, err)
+}
+
+func (m *recordingMetrics) ObserveECADialFailure(err error) {
+	m.dialFailures = append(m.dialFailures, err)
+}
+
+func TestECAMetrics(t *testing.T) {
+	m := &recordingMetrics{}
+	SetMetrics(m)
+	defer SetMetrics(nil)
+
+	client := &fakeECAPClient{readCAErr: errors.New("ECA failure")}
+	node, cleanup := newTestECANode(t, client)
+	defer cleanup()
+
+	if _, err := node.callECAReadCACertificate(context.Background()); err == nil {
+		t.Fatal("ReadCACertificate must fail")
+	}
+	if len(m.calls) != 1 || m.calls[0] != "ReadCACertificate" || m.callErrs[0] == nil {
+		t.Fatalf("Expected one failed ReadCACertificate call, got [%v] [%v]", m.calls, m.callErrs)
+	}
+	if len(m.dialFailures) != 0 {
+		t.Fatalf("Call failures must not be reported as dial failures [%v]", m.dialFailures)
+	}
+
+	// Dial failures are reported on their own
+	node.closeECAConn()
+	node.ecaClientFactory = func(addr string) (membersrvc.ECAPClient, closeFunc, error) {
+		return nil, nil, errors.New("ECA not reachable")
+	}
+	if _, err := node.callECAReadCACertificate(context.Background()); err == nil {
+		t.Fatal("ReadCACertificate must fail")
+	}
+	if len(m.dialFailures) != 1 {
+		t.Fatalf("Expected one dial failure, got [%v]", m.dialFailures)
+	}
+	if len(m.calls) != 1 {
+		t.Fatalf("Dial failures must not be reported as calls [%v]", m.calls)
+	}
+
+	// Enrollments are observed with their outcome
+	if _, _, _, _, err := node.getEnrollmentCertificateFromECA(context.Background(), "user", "pw"); err == nil {
+		t.Fatal("Enrollment must fail")
+	}
+	if len(m.enrollments) != 1 || m.enrollments[0] == nil {
+		t.Fatalf("Expected one failed enrollment, got [%v]", m.enrollments)
+	}
+}
+
+type recordedSpanKey struct{}
+
+// recordedSpan is a span kept by recordingTracer
+type recordedSpan struct {
+	name   string
+	parent string
+	attrs  map[string]string
+	ended  bool
+	err    error
+}
+
+func (s *recordedSpan) SetAttribute(key, value string) {
+	s.attrs[key] = value
+}
+
+func (s *recordedSpan) End(err error) {
+	s.ended = true
+	s.err = err
+}
+
+// recordingTracer keeps the spans it starts
+type recordingTracer struct {
+	spans []*recordedSpan
+}
+
+func (tr *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	span := &recordedSpan{name: name, attrs: map[string]string{}}
+	if parent, ok := ctx.Value(recordedSpanKey{}).(*recordedSpan); ok {
+		span.parent = parent.name
+	}
+	tr.spans = append(tr.spans, span)
+
+	return context.WithValue(ctx, recordedSpanKey{}, span), span
+}
+
+func TestECATracing(t *testing.T) {
+	caCert, _, err := primitives.NewSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Failed generating certificate [%s]", err)
+	}
+	client := &fakeECAPClient{caCert: caCert, createErrs: []error{grpc.Errorf(codes.PermissionDenied, "Bad password")}}
+	node, cleanup := newTestECANode(t, client)
+	defer cleanup()
+
+	// No span is recorded without a tracer
+	if _, err := node.callECAReadCACertificate(context.Background()); err != nil {
+		t.Fatalf("Failed reading ECA certificate [%s]", err)
+	}
+
+	tracer := &recordingTracer{}
+	node.SetTracer(tracer)
+
+	if _, err := node.callECAReadCACertificate(context.Background()); err != nil {
+		t.Fatalf("Failed reading ECA certificate [%s]", err)
+	}
+	if len(tracer.spans) != 1 {
+		t.Fatalf("Expected one span, got [%d]", len(tracer.spans))
+	}
+	span := tracer.spans[0]
+	if span.name != "ECA.ReadCACertificate" || !span.ended || span.err != nil {
+		t.Fatalf("Unexpected span [%+v]", span)
+	}
+	if span.attrs[spanAttrGRPCStatus] != codes.OK.String() {
+		t.Fatalf("Unexpected gRPC status [%s]", span.attrs[spanAttrGRPCStatus])
+	}
+	if span.attrs[spanAttrCertHash] != hex.EncodeToString(primitives.Hash(caCert)) {
+		t.Fatalf("Unexpected certificate hash [%s]", span.attrs[spanAttrCertHash])
+	}
+
+	// The RPCs of the enrollment are children of its span
+	node.conf.securityLevel = 256
+	node.conf.hashAlgorithm = "SHA3"
+	node.conf.ecaEnrollmentTimeout = time.Second
+	tracer.spans = nil
+	if _, _, _, _, err := node.getEnrollmentCertificateFromECA(context.Background(), "user", "pw"); err == nil {
+		t.Fatal("Enrollment must fail")
+	}
+	if len(tracer.spans) < 2 {
+		t.Fatalf("Expected the enrollment span and its children, got [%d]", len(tracer.spans))
+	}
+	root := tracer.spans[0]
+	if root.name != "ECA.Enrollment" || root.parent != "" || !root.ended || root.err == nil {
+		t.Fatalf("Unexpected enrollment span [%+v]", root)
+	}
+	for _, span := range tracer.spans[1:] {
+		if span.parent != root.name || !span.ended {
+			t.Fatalf("Unexpected RPC span [%+v]", span)
+		}
+	}
+	last := tracer.spans[len(tracer.spans)-1]
+	if last.name != "ECA.CreateCertificatePair" || last.attrs[spanAttrGRPCStatus] != codes.PermissionDenied.String() {
+		t.Fatalf("Unexpected CreateCertificatePair span [%+v]", last)
+	}
+}
+
+func TestRootsCertPoolConcurrentReload(t *testing.T) {
+	node, cleanup := newTestCertStoreNode(t, 0644)
+	defer cleanup()
+
+	caCert, caKey := newTestCert(t, "eca", true, nil, nil)
+	cert, key := newTestCert(t, "peer", false, caCert, caKey)
+
+	reload := func() {
+		roots := x509.NewCertPool()
+		roots.AddCert(caCert)
+		node.setRootCerts(roots, []*x509.Certificate{caCert})
+
+		pool := x509.NewCertPool()
+		pool.AddCert(caCert)
+		node.setECACertPool(pool)
+	}
+	reload()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			reload()
+		}
+	}()
+
+	for i := 0; i < 50; i++ {
+		if err := node.verifyEnrollmentCertificate(cert, key); err != nil {
+			t.Fatalf("Failed verifying certificate during reload [%s]", err)
+		}
+	}
+	<-done
+}
+
+func TestVerificationRoots(t *testing.T) {
+	der, _, err := primitives.NewSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Failed generating self signed cert [%s]", err)
+	}
+	cert, err := primitives.DERToX509Certificate(der)
+	if err != nil {
+		t.Fatalf("Failed parsing self signed cert [%s]", err)
+	}
+
+	// Not constructed with newNodeImpl
+	node := &nodeImpl{conf: &configuration{}}
+	if err := node.verifyECACertificate(cert); !errors.Is(err, utils.ErrNotInitialized) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrNotInitialized, err)
+	}
+
+	// No root configured, trusted on first use
+	node = newNodeImpl()
+	node.conf = &configuration{}
+	if node.TrustedRootsCount() != 0 {
+		t.Fatalf("Expected no trusted root, got [%d]", node.TrustedRootsCount())
+	}
+	if err := node.verifyECACertificate(cert); err != nil {
+		t.Fatalf("The ECA certificate must be trusted on first use [%s]", err)
+	}
+
+	// Roots configured but none loaded
+	viper.Set("peer.pki.eca.rootcert.file", "roots.pem")
+	defer viper.Set("peer.pki.eca.rootcert.file", "")
+	if err := node.verifyECACertificate(cert); !errors.Is(err, utils.ErrNoTrustedRoots) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrNoTrustedRoots, err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	node.setRootCerts(roots, []*x509.Certificate{cert})
+	if node.TrustedRootsCount() != 1 {
+		t.Fatalf("Expected 1 trusted root, got [%d]", node.TrustedRootsCount())
+	}
+	if err := node.verifyECACertificate(cert); err != nil {
+		t.Fatalf("Failed verifying ECA certificate [%s]", err)
+	}
+}
+
+// newTestCert issues a certificate for cn, self-signed if parent is nil
+func newTestCert(t *testing.T, cn string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := primitives.NewECDSAKey()
+	if err != nil {
+		t.Fatalf("Failed generating key [%s]", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("Failed creating certificate [%s]", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed parsing certificate [%s]", err)
+	}
+
+	return cert, key
+}
+
+func TestSelfSignedEnrollmentCertificate(t *testing.T) {
+	node, cleanup := newTestCertStoreNode(t, 0644)
+	defer cleanup()
+
+	eca, ecaKey := newTestCert(t, "eca", true, nil, nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(eca)
+	node.setRootCerts(pool, []*x509.Certificate{eca})
+	node.setECACertPool(pool)
+
+	leaf, leafKey := newTestCert(t, "peer", false, eca, ecaKey)
+	if err := node.verifyEnrollmentCertificate(leaf, leafKey); err != nil {
+		t.Fatalf("Certificate issued by the ECA rejected [%s]", err)
+	}
+	// Validity is checked against the node clock
+	node.clock = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if err := node.verifyEnrollmentCertificate(leaf, leafKey); err == nil {
+		t.Fatal("A certificate expired by the node clock must be rejected")
+	}
+	node.clock = nil
+
+	// Self-signed, even if trusted as is
+	self, selfKey := newTestCert(t, "peer", false, nil, nil)
+	pool.AddCert(self)
+	node.setECACertPool(pool)
+	if err := node.verifyEnrollmentCertificate(self, selfKey); !errors.Is(err, utils.ErrSelfSignedCert) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrSelfSignedCert, err)
+	}
+
+	// Self-signed, naming the ECA as issuer
+	fakeIssuer := *eca
+	fakeIssuer.PublicKey = &selfKey.PublicKey
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "peer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, &fakeIssuer, &selfKey.PublicKey, selfKey)
+	if err != nil {
+		t.Fatalf("Failed creating certificate [%s]", err)
+	}
+	masked, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed parsing certificate [%s]", err)
+	}
+	if err := node.verifyEnrollmentCertificate(masked, selfKey); !errors.Is(err, utils.ErrSelfSignedCert) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrSelfSignedCert, err)
+	}
+}
+
+func TestCertVerificationMode(t *testing.T) {
+	node, cleanup := newTestCertStoreNode(t, 0644)
+	defer cleanup()
+
+	// The ECA does not chain to the trusted root
+	root, _ := newTestCert(t, "root", true, nil, nil)
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	node.setRootCerts(roots, []*x509.Certificate{root})
+
+	eca, ecaKey := newTestCert(t, "eca", true, nil, nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(eca)
+	node.setECACertPool(pool)
+	leaf, leafKey := newTestCert(t, "peer", false, eca, ecaKey)
+	other, _ := newTestCert(t, "peer", false, nil, nil)
+
+	for _, mode := range []string{"", "enforce"} {
+		node.conf.certVerifyMode = mode
+		if err := node.verifyECACertificate(eca); err == nil {
+			t.Fatalf("[%s]: An untrusted ECA certificate must be rejected", mode)
+		}
+		if err := node.verifyEnrollmentCertificate(leaf, leafKey); err == nil {
+			t.Fatalf("[%s]: An untrusted enrollment certificate must be rejected", mode)
+		}
+	}
+
+	node.conf.certVerifyMode = "warn"
+	if err := node.verifyECACertificate(eca); err != nil {
+		t.Fatalf("An untrusted ECA certificate must only be logged in warn mode [%s]", err)
+	}
+	if err := node.verifyEnrollmentCertificate(leaf, leafKey); err != nil {
+		t.Fatalf("An untrusted enrollment certificate must only be logged in warn mode [%s]", err)
+	}
+	// The key binding is enforced anyway
+	if err := node.verifyEnrollmentCertificate(other, leafKey); err == nil {
+		t.Fatal("A certificate not matching the key must be rejected in warn mode")
+	}
+}
+
+func TestECAIntermediateChain(t *testing.T) {
+	// The ECA is issued by an offline root through an intermediate
+	root, rootKey := newTestCert(t, "root", true, nil, nil)
+	inter, interKey := newTestCert(t, "intermediate", true, root, rootKey)
+	eca, ecaKey := newTestCert(t, "eca", true, inter, interKey)
+	leaf, _ := newTestCert(t, "leaf", false, eca, ecaKey)
+
+	client := &fakeECAPClient{caCert: append(append([]byte{}, eca.Raw...), inter.Raw...)}
+	node, cleanup := newTestECANode(t, client)
+	defer cleanup()
+	node.certStore = NewMemCertStore()
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	node.setRootCerts(roots, []*x509.Certificate{root})
+
+	if err := node.retrieveECACertsChain("user"); err != nil {
+		t.Fatalf("Failed retrieving ECA certificates chain [%s]", err)
+	}
+	if !node.getECACert().Equal(eca) {
+		t.Fatal("The first certificate of the chain must be the ECA one")
+	}
+	if err := node.VerifyCertificate(leaf.Raw); err != nil {
+		t.Fatalf("A certificate issued by the ECA must chain to the root [%s]", err)
+	}
+	if _, err := primitives.CheckCertAgainRoot(leaf, node.getECACertPool()); err != nil {
+		t.Fatalf("A certificate issued by the ECA must verify against the ECA pool [%s]", err)
+	}
+	if _, err := primitives.CheckCertAgainRoot(inter, node.getECACertPool()); err == nil {
+		t.Fatal("The intermediates must not issue enrollment certificates")
+	}
+
+	// The whole chain is stored and loaded
+	node.setECAChain(nil, nil, nil, nil)
+	if err := node.loadECACertsChain(context.Background()); err != nil {
+		t.Fatalf("Failed loading ECA certificates chain [%s]", err)
+	}
+	if !node.getECACert().Equal(eca) || len(node.getECAIntermediates()) != 1 || !node.getECAIntermediates()[0].Equal(inter) {
+		t.Fatal("The loaded chain differs from the stored one")
+	}
+	if err := node.VerifyCertificate(leaf.Raw); err != nil {
+		t.Fatalf("A certificate issued by the ECA must chain to the root after loading [%s]", err)
+	}
+
+	// Without the intermediate the ECA certificate does not chain to the root
+	client.caCert = eca.Raw
+	if err := node.refreshECACertificate(true); err == nil {
+		t.Fatal("An ECA certificate served without its intermediates must be rejected")
+	}
+
+	// Multi-certificate PEM provided out of band
+	path := filepath.Join(node.conf.getRawsPath(), "eca.pem")
+	if err := ioutil.WriteFile(path, append(primitives.DERCertToPEM(eca.Raw), primitives.DERCertToPEM(inter.Raw)...), 0644); err != nil {
+		t.Fatalf("Failed writing ECA certificates chain [%s]", err)
+	}
+	viper.Set("peer.pki.eca.cert.file", path)
+	defer viper.Set("peer.pki.eca.cert.file", "")
+
+	if err := node.refreshECACertificate(true); err != nil {
+		t.Fatalf("Failed loading ECA certificates chain from file [%s]", err)
+	}
+	if len(node.getECAIntermediates()) != 1 {
+		t.Fatalf("Expected 1 intermediate certificate, got [%d]", len(node.getECAIntermediates()))
+	}
+}
+
+func TestECANotECAServer(t *testing.T) {
+	unimplemented := grpc.Errorf(codes.Unimplemented, "unknown service protos.ECAP")
+
+	client := &fakeECAPClient{createErrs: []error{unimplemented}, readCAErr: unimplemented}
+	node, cleanup := newTestECANode(t, client)
+	defer cleanup()
+
+	if _, err := node.callECACreateCertificatePairWithRetry(context.Background(), &membersrvc.ECertCreateReq{}); !errors.Is(err, utils.ErrNotECAServer) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrNotECAServer, err)
+	}
+	if client.createCalls != 1 {
+		t.Fatalf("A server other than the ECA must not be retried, got [%d] calls", client.createCalls)
+	}
+
+	if _, err := node.callECAReadCACertificate(context.Background()); !errors.Is(err, utils.ErrNotECAServer) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrNotECAServer, err)
+	}
+}
+
+func TestECACertificatePin(t *testing.T) {
+	der, _, err := primitives.NewSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Failed generating self signed cert [%s]", err)
+	}
+	client := &fakeECAPClient{caCert: der}
+	node, cleanup := newTestECANode(t, client)
+	defer cleanup()
+
+	fingerprint := utils.EncodeFingerprint(primitives.Hash(der))
+
+	viper.Set("peer.pki.eca.cert.pin", strings.ToLower(fingerprint))
+	defer viper.Set("peer.pki.eca.cert.pin", "")
+	if _, err := node.getECACertificate(); err != nil {
+		t.Fatalf("ECA certificate must match the pin [%s]", err)
+	}
+
+	other, _, err := primitives.NewSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Failed generating self signed cert [%s]", err)
+	}
+	node.invalidateECACertificate()
+	viper.Set("peer.pki.eca.cert.pin", utils.EncodeFingerprint(primitives.Hash(other)))
+	if _, err := node.getECACertificate(); !errors.Is(err, utils.ErrECACertPinMismatch) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrECACertPinMismatch, err)
+	}
+}
+
+func TestECACertificateAllowlist(t *testing.T) {
+	der, _, err := primitives.NewSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Failed generating self signed cert [%s]", err)
+	}
+	next, _, err := primitives.NewSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Failed generating self signed cert [%s]", err)
+	}
+	client := &fakeECAPClient{caCert: der}
+	node, cleanup := newTestECANode(t, client)
+	defer cleanup()
+
+	fingerprint := func(der []byte) string {
+		sum := sha256.Sum256(der)
+		return utils.EncodeFingerprint(sum[:])
+	}
+
+	// Both the current and the next ECA certificates are accepted during a rotation
+	viper.Set("peer.pki.eca.cert.allowlist", []string{fingerprint(next), strings.ToLower(fingerprint(der))})
+	defer viper.Set("peer.pki.eca.cert.allowlist", []string{})
+	if _, err := node.getECACertificate(); err != nil {
+		t.Fatalf("ECA certificate must be in the allowlist [%s]", err)
+	}
+
+	node.invalidateECACertificate()
+	viper.Set("peer.pki.eca.cert.allowlist", []string{fingerprint(next)})
+	if _, err := node.getECACertificate(); !errors.Is(err, utils.ErrECACertNotAllowed) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrECACertNotAllowed, err)
+	}
+
+	node.invalidateECACertificate()
+	viper.Set("peer.pki.eca.cert.allowlist", []string{})
+	if _, err := node.getECACertificate(); err != nil {
+		t.Fatalf("Any ECA certificate must be accepted without allowlist [%s]", err)
+	}
+}
+
+func TestECAClientUnixSocket(t *testing.T) {
+	node, cleanup := newTestCertStoreNode(t, 0644)
+	defer cleanup()
+	node.conf.ecaDialTimeout = time.Second
+
+	path := filepath.Join(node.conf.getRawsPath(), "eca.sock")
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Failed listening on unix socket [%s]", err)
+	}
+	server := grpc.NewServer()
+	go server.Serve(lis)
+	defer server.Stop()
+
+	_, release, err := node.newGRPCECAClient("unix://" + path)
+	if err != nil {
+		t.Fatalf("Failed dialing the ECA over a unix socket [%s]", err)
+	}
+	release()
+
+	// Custom dial options replace the default dialer
+	dialed := false
+	node.conf.ecaDialOptions = []grpc.DialOption{grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+		dialed = true
+		return net.DialTimeout("unix", path, timeout)
+	})}
+	_, release, err = node.newGRPCECAClient("eca.example.com:7054")
+	if err != nil {
+		t.Fatalf("Failed dialing the ECA with a custom dialer [%s]", err)
+	}
+	release()
+	if !dialed {
+		t.Fatal("The custom dialer must be used")
+	}
+}
+
+// sizedECAPServer answers ReadCACertificate with a certificate of the given size
+type sizedECAPServer struct {
+	membersrvc.ECAPServer
+	size int
+}
+
+func (s *sizedECAPServer) ReadCACertificate(ctx context.Context, in *membersrvc.Empty) (*membersrvc.Cert, error) {
+	return &membersrvc.Cert{Cert: make([]byte, s.size)}, nil
+}
+
+func TestECAMaxRecvSize(t *testing.T) {
+	node, cleanup := newTestCertStoreNode(t, 0644)
+	defer cleanup()
+	node.conf.ecaDialTimeout = time.Second
+
+	path := filepath.Join(node.conf.getRawsPath(), "eca.sock")
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Failed listening on unix socket [%s]", err)
+	}
+	server := grpc.NewServer()
+	membersrvc.RegisterECAPServer(server, &sizedECAPServer{size: 2048})
+	go server.Serve(lis)
+	defer server.Stop()
+
+	for _, limit := range []int{4096, 1024} {
+		node.conf.ecaMaxRecvSize = limit
+
+		client, release, err := node.newGRPCECAClient("unix://" + path)
+		if err != nil {
+			t.Fatalf("Failed dialing the ECA over a unix socket [%s]", err)
+		}
+		_, err = client.ReadCACertificate(context.Background(), &membersrvc.Empty{})
+		release()
+
+		if limit > 2048 && err != nil {
+			t.Fatalf("A response below the limit must be accepted [%s]", err)
+		}
+		if limit < 2048 && err == nil {
+			t.Fatal("A response above the limit must be refused")
+		}
+	}
+}
+
+// metadataECAPServer records the metadata of the ReadCACertificate calls
+type metadataECAPServer struct {
+	membersrvc.ECAPServer
+	md chan metadata.MD
+}
+
+func (s *metadataECAPServer) ReadCACertificate(ctx context.Context, in *membersrvc.Empty) (*membersrvc.Cert, error) {
+	md, _ := metadata.FromContext(ctx)
+	s.md <- md
+
+	return &membersrvc.Cert{}, nil
+}
+
+func TestECAUnaryInterceptors(t *testing.T) {
+	node, cleanup := newTestCertStoreNode(t, 0644)
+	defer cleanup()
+	node.conf.ecaDialTimeout = time.Second
+	node.conf.ecaMaxRecvSize = 1 << 20
+	node.conf.ecaPAddressProperty = "peer.pki.eca.paddr"
+	node.conf.name = "vp0"
+	node.conf.ecaUserAgent = "fabric-crypto/0.1.0"
+
+	path := filepath.Join(node.conf.getRawsPath(), "eca.sock")
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Failed listening on unix socket [%s]", err)
+	}
+	server := grpc.NewServer()
+	srv := &metadataECAPServer{md: make(chan metadata.MD, 1)}
+	membersrvc.RegisterECAPServer(server, srv)
+	go server.Serve(lis)
+	defer server.Stop()
+
+	addr := viper.GetString(node.conf.ecaPAddressProperty)
+	viper.Set(node.conf.ecaPAddressProperty, "unix://"+path)
+	defer viper.Set(node.conf.ecaPAddressProperty, addr)
+
+	var calls []string
+	node.conf.ecaInterceptors = []ECAUnaryInterceptor{
+		func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker ECAUnaryInvoker, opts ...grpc.CallOption) error {
+			calls = append(calls, "auth "+method)
+			return invoker(metadata.NewContext(ctx, metadata.Pairs("authorization", "Bearer token")), method, req, reply, cc, opts...)
+		},
+		func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker ECAUnaryInvoker, opts ...grpc.CallOption) error {
+			calls = append(calls, "log "+method)
+			return invoker(ctx, method, req, reply, cc, opts...)
+		},
+	}
+
+	client, err := node.getECAClient()
+	if err != nil {
+		t.Fatalf("Failed getting ECA client [%s]", err)
+	}
+	defer node.closeECAConn()
+	if _, err := client.ReadCACertificate(context.Background(), &membersrvc.Empty{}); err != nil {
+		t.Fatalf("Failed reading ECA certificate [%s]", err)
+	}
+
+	expected := []string{"auth /protos.ECAP/ReadCACertificate", "log /protos.ECAP/ReadCACertificate"}
+	if fmt.Sprint(calls) != fmt.Sprint(expected) {
+		t.Fatalf("Expected the interceptors called as [%v], got [%v]", expected, calls)
+	}
+	md := <-srv.md
+	if fmt.Sprint(md["authorization"]) != "[Bearer token]" {
+		t.Fatalf("Expected the auth token in the metadata, got [%v]", md)
+	}
+
+	// The node and its version are identified to the ECA
+	if fmt.Sprint(md[ecaNodeIDHeader]) != "[vp0]" {
+		t.Fatalf("Expected the node name in the metadata, got [%v]", md)
+	}
+	if ua := fmt.Sprint(md["user-agent"]); !strings.Contains(ua, "fabric-crypto/0.1.0") {
+		t.Fatalf("Expected the user agent in the metadata, got [%s]", ua)
+	}
+}
+
+func TestECAReadCertificates(t *testing.T) {
+	client := &fakeECAPClient{pairs: make(map[string]*membersrvc.CertPair)}
+	var ids []string
+	for i := 0; i < 20; i++ {
+		id := fmt.Sprintf("user%d", i)
+		ids = append(ids, id)
+		client.pairs[id] = &membersrvc.CertPair{Sign: []byte(id)}
+	}
+	node, cleanup := newTestECANode(t, client)
+	defer cleanup()
+	node.conf.ecaReadConcurrency = 4
+
+	pairs, err := node.readCertificates(context.Background(), ids, false)
+	if err != nil {
+		t.Fatalf("Failed reading certificates [%s]", err)
+	}
+	if len(pairs) != len(ids) {
+		t.Fatalf("Expected [%d] certificates, got [%d]", len(ids), len(pairs))
+	}
+	for _, id := range ids {
+		if string(pairs[id].Sign) != id {
+			t.Fatalf("Invalid certificate for [%s]", id)
+		}
+	}
+	if client.maxInFlight > 4 {
+		t.Fatalf("Expected at most 4 concurrent reads, got [%d]", client.maxInFlight)
+	}
+
+	if _, err := node.readCertificates(context.Background(), append(ids, "unknown"), false); err == nil {
+		t.Fatal("Reading the certificate of an unknown identity must fail")
+	}
+}
+
+func TestECAReadCertificateCache(t *testing.T) {
+	client := &fakeECAPClient{pairs: make(map[string]*membersrvc.CertPair)}
+	var ids []string
+	for i := 0; i < 3; i++ {
+		id := fmt.Sprintf("user%d", i)
+		ids = append(ids, id)
+		client.pairs[id] = &membersrvc.CertPair{Sign: []byte(id)}
+	}
+	node, cleanup := newTestECANode(t, client)
+	defer cleanup()
+	node.conf.ecaReadConcurrency = 4
+	node.conf.certReadCacheSize = 2
+
+	read := func(id string, force bool, expectedCalls int) {
+		pair, err := node.readCertificate(context.Background(), id, force)
+		if err != nil {
+			t.Fatalf("Failed reading certificate of [%s] [%s]", id, err)
+		}
+		if string(pair.Sign) != id {
+			t.Fatalf("Invalid certificate for [%s]", id)
+		}
+		if client.readCalls != expectedCalls {
+			t.Fatalf("Expected [%d] reads, got [%d]", expectedCalls, client.readCalls)
+		}
+	}
+
+	read("user0", false, 1)
+	read("user0", false, 1)
+	read("user0", true, 2)
+
+	// The least recently used certificate is evicted
+	read("user1", false, 3)
+	read("user0", false, 3)
+	read("user2", false, 4)
+	read("user0", false, 4)
+	read("user1", false, 5)
+
+	// Invalidated certificates are read again
+	node.invalidateCertificate("user1")
+	read("user1", false, 6)
+
+	// Concurrent lookups
+	if _, err := node.readCertificates(context.Background(), append(ids, ids...), false); err != nil {
+		t.Fatalf("Failed reading certificates [%s]", err)
+	}
+}
+
+func TestECAReadTimeout(t *testing.T) {
+	client := &fakeECAPClient{readCAHang: true}
+	node, cleanup := newTestECANode(t, client)
+	defer cleanup()
+	node.conf.ecaReadTimeout = 50 * time.Millisecond
+
+	start := time.Now()
+	if _, err := node.callECAReadCACertificate(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected [%s], got [%v]", context.DeadlineExceeded, err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("The read must fail within the read timeout, took [%s]", elapsed)
+	}
+}
+
+func TestECAEnrollmentError(t *testing.T) {
+	for _, c := range []struct {
+		err      error
+		expected error
+	}{
+		{grpc.Errorf(codes.Unauthenticated, "Bad token"), utils.ErrEnrollmentAuthFailed},
+		{grpc.Errorf(codes.AlreadyExists, "Enrolled"), utils.ErrAlreadyEnrolled},
+		{grpc.Errorf(codes.InvalidArgument, "Bad key"), utils.ErrInvalidEnrollmentRequest},
+		{grpc.Errorf(codes.Unavailable, "ECA down"), utils.ErrECAUnavailable},
+		{grpc.Errorf(codes.DeadlineExceeded, "ECA slow"), utils.ErrECAUnavailable},
+	} {
+		if err := ecaEnrollmentError(c.err); !errors.Is(err, c.expected) {
+			t.Fatalf("Expected [%s] for [%s], got [%v]", c.expected, c.err, err)
+		}
+	}
+
+	other := grpc.Errorf(codes.Internal, "Failure")
+	if err := ecaEnrollmentError(other); err != other {
+		t.Fatalf("Unexpected conversion of [%s] into [%v]", other, err)
+	}
+}
+
+func TestECAConnPool(t *testing.T) {
+	node, cleanup := newTestCertStoreNode(t, 0644)
+	defer cleanup()
+
+	path := filepath.Join(node.conf.getRawsPath(), "eca.sock")
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Failed listening on unix socket [%s]", err)
+	}
+	server := grpc.NewServer()
+	go server.Serve(lis)
+	defer server.Stop()
+
+	addr := "unix://" + path
+	dialer := grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+		return net.DialTimeout("unix", path, timeout)
+	})
+
+	key, err := node.getECAConnKey(addr)
+	if err != nil {
+		t.Fatalf("Failed getting ECA connection key [%s]", err)
+	}
+	first, err := AcquireECAConn(key, addr, grpc.WithInsecure(), dialer)
+	if err != nil {
+		t.Fatalf("Failed acquiring ECA connection [%s]", err)
+	}
+	second, err := AcquireECAConn(key, addr, grpc.WithInsecure(), dialer)
+	if err != nil {
+		t.Fatalf("Failed acquiring ECA connection [%s]", err)
+	}
+	if first != second {
+		t.Fatal("Connections to the same ECA must be shared")
+	}
+
+	// A node dialing the same ECA with other settings gets its own connection
+	node.conf.ecaDialOptions = []grpc.DialOption{dialer}
+	otherKey, err := node.getECAConnKey(addr)
+	if err != nil {
+		t.Fatalf("Failed getting ECA connection key [%s]", err)
+	}
+	if otherKey == key {
+		t.Fatal("Nodes with different dial settings must not share the connection key")
+	}
+	other, err := AcquireECAConn(otherKey, addr, grpc.WithInsecure(), dialer)
+	if err != nil {
+		t.Fatalf("Failed acquiring ECA connection [%s]", err)
+	}
+	if other == first {
+		t.Fatal("Connections with different settings must not be shared")
+	}
+	if err := ReleaseECAConn(otherKey); err != nil {
+		t.Fatalf("Failed releasing ECA connection [%s]", err)
+	}
+
+	if err := ReleaseECAConn(key); err != nil {
+		t.Fatalf("Failed releasing ECA connection [%s]", err)
+	}
+	if first.State() == grpc.Shutdown {
+		t.Fatal("The connection must stay open while still acquired")
+	}
+	if err := ReleaseECAConn(key); err != nil {
+		t.Fatalf("Failed releasing ECA connection [%s]", err)
+	}
+	if first.State() != grpc.Shutdown {
+		t.Fatal("The connection must be closed once released by all the callers")
+	}
+	if err := ReleaseECAConn(key); err == nil {
+		t.Fatal("Releasing a connection not acquired must fail")
+	}
+}
+
+func TestValidateEnrollmentData(t *testing.T) {
+	node, cleanup := newTestCertStoreNode(t, 0644)
+	defer cleanup()
+	node.eType = NodePeer
+
+	der, key, err := primitives.NewSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Failed generating self signed cert [%s]", err)
+	}
+	chainKey, err := primitives.PublicKeyToPEM(&key.(*ecdsa.PrivateKey).PublicKey, nil)
+	if err != nil {
+		t.Fatalf("Failed converting chain key to PEM [%s]", err)
+	}
+
+	if _, err := node.validateEnrollmentData(key, der, chainKey); err != nil {
+		t.Fatalf("Valid enrollment data rejected [%s]", err)
+	}
+
+	other, err := primitives.NewECDSAKey()
+	if err != nil {
+		t.Fatalf("Failed generating ECDSA key [%s]", err)
+	}
+	if _, err := node.validateEnrollmentData(other, der, chainKey); err == nil {
+		t.Fatal("A certificate not matching the key must be rejected")
+	}
+	if _, err := node.validateEnrollmentData(key, der, []byte("garbage")); err == nil {
+		t.Fatal("An undecodable chain key must be rejected")
+	}
+}
+
+func TestValidateEnrollmentDoesNotStore(t *testing.T) {
+	client := &fakeECAPClient{createErrs: []error{grpc.Errorf(codes.Unauthenticated, "Identity or token does not match.")}}
+	node, cleanup := newTestECANode(t, client)
+	defer cleanup()
+	node.conf.securityLevel = 256
+	node.conf.hashAlgorithm = "SHA3"
+	node.conf.ecaEnrollmentTimeout = time.Second
+
+	if _, err := node.ValidateEnrollment(context.Background(), "user", "wrong"); !errors.Is(err, utils.ErrEnrollmentAuthFailed) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrEnrollmentAuthFailed, err)
+	}
+
+	files, err := ioutil.ReadDir(node.conf.getRawsPath())
+	if err != nil {
+		t.Fatalf("Failed listing the raws folder [%s]", err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("Enrollment validation must not store anything, found [%s]", files[0].Name())
+	}
+}
+
+func TestNotifyEnrolled(t *testing.T) {
+	node, cleanup := newTestCertStoreNode(t, 0644)
+	defer cleanup()
+
+	certRaw, _, err := primitives.NewSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Failed generating certificate [%s]", err)
+	}
+
+	// No handler set
+	node.notifyEnrolled(certRaw)
+
+	var notified *x509.Certificate
+	node.SetOnEnrolled(func(cert *x509.Certificate) { notified = cert })
+	node.notifyEnrolled(certRaw)
+	if notified == nil || !bytes.Equal(notified.Raw, certRaw) {
+		t.Fatal("The enrollment handler must be invoked with the enrollment certificate")
+	}
+
+	// A panicking handler does not fail the enrollment
+	node.SetOnEnrolled(func(cert *x509.Certificate) { panic("handler failure") })
+	node.notifyEnrolled(certRaw)
+}
+
+func TestSubmitToCT(t *testing.T) {
+	node, cleanup := newTestCertStoreNode(t, 0644)
+	defer cleanup()
+
+	certRaw, _, err := primitives.NewSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Failed generating certificate [%s]", err)
+	}
+
+	// No submitter set
+	if err := node.submitToCT("user", certRaw); err != nil {
+		t.Fatalf("Enrollment must not fail without a CT submitter [%s]", err)
+	}
+
+	var submitted []byte
+	node.SetCTSubmitter(func(cert []byte) error {
+		submitted = cert
+		return errors.New("CT log down")
+	})
+	if err := node.submitToCT("user", certRaw); err != nil {
+		t.Fatalf("A failed submission must be ignored unless required [%s]", err)
+	}
+	if !bytes.Equal(submitted, certRaw) {
+		t.Fatal("The CT submitter must be invoked with the enrollment certificate")
+	}
+
+	node.conf.ctSubmitRequired = true
+	if err := node.submitToCT("user", certRaw); !errors.Is(err, utils.ErrCTSubmissionFailed) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrCTSubmissionFailed, err)
+	}
+}
+
+func TestEnrollmentDataLeftOnFailure(t *testing.T) {
+	eca, err := testutil.NewFakeECA(testutil.FakeECAOptions{})
+	if err != nil {
+		t.Fatalf("Failed starting fake ECA [%s]", err)
+	}
+	defer eca.Close()
+	node, cleanup := newFakeECANode(t, eca)
+	defer cleanup()
+	node.ks = &keyStore{node: node}
+
+	if err := node.retrieveECACertsChain("user"); err != nil {
+		t.Fatalf("Failed retrieving ECA certificates chain [%s]", err)
+	}
+	if err := node.loadECACertsChain(context.Background()); err != nil {
+		t.Fatalf("Failed loading ECA certificates chain [%s]", err)
+	}
+
+	// The enrollment chain key cannot be moved in place
+	chainKeyPath := node.conf.getPathForAlias(node.conf.getEnrollmentChainKeyFilename())
+	if err := os.MkdirAll(filepath.Join(chainKeyPath, "busy"), 0700); err != nil {
+		t.Fatalf("Failed creating directory [%s]", err)
+	}
+
+	persisted, err := node.retrieveEnrollmentData(context.Background(), "user", "pw")
+	if err == nil {
+		t.Fatal("Storing the enrollment data must fail")
+	}
+	if !persisted {
+		t.Fatal("The enrollment data left must be reported")
+	}
+	if !node.ks.certMissing(node.conf.getEnrollmentCertFilename()) {
+		t.Fatal("The enrollment certificate must not be in place")
+	}
+
+	// Nothing issued by the ECA is removed, moving it in place recovers the enrollment
+	if err := os.RemoveAll(chainKeyPath); err != nil {
+		t.Fatalf("Failed removing directory [%s]", err)
+	}
+	for _, alias := range []string{node.conf.getEnrollmentChainKeyFilename(), node.conf.getEnrollmentCertFilename()} {
+		path := node.conf.getPathForAlias(alias)
+		if err := os.Rename(path+".new", path); err != nil {
+			t.Fatalf("The staged [%s] must be kept [%s]", alias, err)
+		}
+	}
+	if err := node.loadEnrollmentID(); err != nil {
+		t.Fatalf("Failed loading enrollment id [%s]", err)
+	}
+	if err := node.loadEnrollmentKey(); err != nil {
+		t.Fatalf("Failed loading enrollment key [%s]", err)
+	}
+	if err := node.loadEnrollmentCertificate(); err != nil {
+		t.Fatalf("Failed loading enrollment certificate [%s]", err)
+	}
+	if err := node.loadEnrollmentChainKey(); err != nil {
+		t.Fatalf("Failed loading enrollment chain key [%s]", err)
+	}
+	if node.enrollCert.Subject.CommonName != "user" {
+		t.Fatalf("Expected an enrollment certificate for [user], got [%s]", node.enrollCert.Subject.CommonName)
+	}
+
+	// Once in place, the enrollment data is not requested again
+	if persisted, err := node.retrieveEnrollmentData(context.Background(), "user", "pw"); err != nil || persisted {
+		t.Fatalf("The enrollment data in place must be kept [%v]", err)
+	}
+}
+
+func TestEnrollmentCTSubmissionPending(t *testing.T) {
+	eca, err := testutil.NewFakeECA(testutil.FakeECAOptions{})
+	if err != nil {
+		t.Fatalf("Failed starting fake ECA [%s]", err)
+	}
+	defer eca.Close()
+	node, cleanup := newFakeECANode(t, eca)
+	defer cleanup()
+	node.ks = &keyStore{node: node}
+
+	if err := node.retrieveECACertsChain("user"); err != nil {
+		t.Fatalf("Failed retrieving ECA certificates chain [%s]", err)
+	}
+	if err := node.loadECACertsChain(context.Background()); err != nil {
+		t.Fatalf("Failed loading ECA certificates chain [%s]", err)
+	}
+
+	// The last step fails, the issued enrollment data is kept
+	node.conf.ctSubmitRequired = true
+	node.SetCTSubmitter(func(cert []byte) error { return errors.New("CT log down") })
+
+	persisted, err := node.retrieveEnrollmentData(context.Background(), "user", "pw")
+	if !errors.Is(err, utils.ErrCTSubmissionFailed) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrCTSubmissionFailed, err)
+	}
+	if persisted {
+		t.Fatal("The enrollment data must not be reported as partial")
+	}
+	for _, path := range []string{
+		node.conf.getEnrollmentIDPath(),
+		node.conf.getPathForAlias(node.conf.getEnrollmentKeyFilename()),
+		node.conf.getPathForAlias(node.conf.getEnrollmentCertFilename()),
+		node.conf.getPathForAlias(node.conf.getEnrollmentChainKeyFilename()),
+		node.conf.getPathForAlias(node.conf.getCTPendingFilename()),
+	} {
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("[%s] must be kept [%v]", path, err)
+		}
+	}
+	if err := node.loadEnrollmentCertificate(); err != nil {
+		t.Fatalf("Failed loading enrollment certificate [%s]", err)
+	}
+	if err := node.loadEnrollmentID(); err != nil {
+		t.Fatalf("Failed loading enrollment id [%s]", err)
+	}
+
+	// The submission is retried with the next submitter, until it succeeds
+	if err := node.SetCTSubmitter(func(cert []byte) error { return errors.New("CT log still down") }); !errors.Is(err, utils.ErrCTSubmissionFailed) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrCTSubmissionFailed, err)
+	}
+	var submitted []byte
+	if err := node.SetCTSubmitter(func(cert []byte) error { submitted = cert; return nil }); err != nil {
+		t.Fatalf("Failed retrying the CT submission [%s]", err)
+	}
+	if !bytes.Equal(submitted, node.enrollCert.Raw) {
+		t.Fatal("The pending CT submission must be the enrollment certificate")
+	}
+	if _, err := os.Stat(node.conf.getPathForAlias(node.conf.getCTPendingFilename())); !os.IsNotExist(err) {
+		t.Fatalf("The CT submission must no longer be pending [%v]", err)
+	}
+	submitted = nil
+	if err := node.SetCTSubmitter(func(cert []byte) error { submitted = cert; return nil }); err != nil || submitted != nil {
+		t.Fatalf("Nothing must be submitted again [%v]", err)
+	}
+}
+
+func TestEnrollmentAttributes(t *testing.T) {
+	node := &nodeImpl{}
+
+	for _, oid := range []string{"", "1", "1.a.3", "1.-2", "2.1.3.4.5.6.7"} {
+		if err := node.SetEnrollmentAttributes(map[string][]byte{oid: nil}); err == nil {
+			t.Fatalf("Requesting attribute [%s] must fail", oid)
+		}
+	}
+
+	if err := node.SetEnrollmentAttributes(map[string][]byte{"1.2.3.4": []byte("extra extension"), "1.2.3.10": []byte("other")}); err != nil {
+		t.Fatalf("Failed setting enrollment attributes [%s]", err)
+	}
+	attrs := node.enrollmentAttributes()
+	if len(attrs) != 2 || attrs[0].Oid != "1.2.3.10" || attrs[1].Oid != "1.2.3.4" {
+		t.Fatalf("Expected the attributes sorted by object identifier, got [%v]", attrs)
+	}
+
+	certRaw, _, err := primitives.NewSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Failed generating certificate [%s]", err)
+	}
+	cert, err := primitives.DERToX509Certificate(certRaw)
+	if err != nil {
+		t.Fatalf("Failed parsing certificate [%s]", err)
+	}
+
+	if err := checkEnrollmentAttributes(cert, attrs[1:]); err != nil {
+		t.Fatalf("The certificate carries the requested attribute [%s]", err)
+	}
+	if err := checkEnrollmentAttributes(cert, attrs); !errors.Is(err, utils.ErrMissingEnrollmentAttribute) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrMissingEnrollmentAttribute, err)
+	}
+	attrs[1].Value = []byte("tampered")
+	if err := checkEnrollmentAttributes(cert, attrs[1:]); !errors.Is(err, utils.ErrMissingEnrollmentAttribute) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrMissingEnrollmentAttribute, err)
+	}
+
+	if err := node.SetEnrollmentAttributes(nil); err != nil || node.enrollmentAttributes() != nil {
+		t.Fatal("No attribute must be requested after a reset")
+	}
+}
+
+func TestEnrollmentEvents(t *testing.T) {
+	client := &fakeECAPClient{createErrs: []error{grpc.Errorf(codes.Unauthenticated, "Identity or token does not match.")}}
+	node, cleanup := newTestECANode(t, client)
+	defer cleanup()
+	node.conf.securityLevel = 256
+	node.conf.hashAlgorithm = "SHA3"
+	node.conf.ecaEnrollmentTimeout = time.Second
+
+	// No channel set
+	if _, _, _, _, err := node.getEnrollmentCertificateFromECA(context.Background(), "user", "pw"); err == nil {
+		t.Fatal("Enrollment must fail")
+	}
+
+	events := make(chan EnrollmentEvent, 10)
+	node.SetEnrollmentEvents(events)
+	client.createErrs = []error{grpc.Errorf(codes.Unauthenticated, "Identity or token does not match.")}
+	if _, _, _, _, err := node.getEnrollmentCertificateFromECA(context.Background(), "user", "pw"); err == nil {
+		t.Fatal("Enrollment must fail")
+	}
+	close(events)
+
+	var steps []EnrollmentStep
+	for event := range events {
+		if event.EnrollID != "user" {
+			t.Fatalf("Unexpected enrollment id [%s]", event.EnrollID)
+		}
+		steps = append(steps, event.Step)
+	}
+	expected := []EnrollmentStep{EnrollmentStepGeneratingKey, EnrollmentStepRequestingCert}
+	if fmt.Sprint(steps) != fmt.Sprint(expected) {
+		t.Fatalf("Expected steps [%v], got [%v]", expected, steps)
+	}
+
+	// A full channel doesn't block the enrollment
+	node.SetEnrollmentEvents(make(chan EnrollmentEvent))
+	node.sendEnrollmentEvent("user", EnrollmentStepStoring)
+}
+
+func TestECAClockSkew(t *testing.T) {
+	skewed := grpc.Errorf(codes.OutOfRange, "Timestamp out of range")
+	client := &fakeECAPClient{}
+	node, cleanup := newTestECANode(t, client)
+	defer cleanup()
+	node.conf.securityLevel = 256
+	node.conf.hashAlgorithm = "SHA3"
+	node.conf.ecaEnrollmentTimeout = time.Second
+	node.conf.ecaClockSkew = time.Minute
+
+	enroll := func(errs []error, expectedCalls int) error {
+		client.createErrs = errs
+		client.createCalls = 0
+		_, _, _, _, err := node.getEnrollmentCertificateFromECA(context.Background(), "user", "pw")
+		if err == nil {
+			t.Fatal("Enrollment must fail")
+		}
+		if client.createCalls != expectedCalls {
+			t.Fatalf("Expected [%d] requests, got [%d]", expectedCalls, client.createCalls)
+		}
+
+		return err
+	}
+
+	// Reported and not retried, the local clock would give the same timestamp
+	if err := enroll([]error{skewed, skewed}, 1); !errors.Is(err, utils.ErrClockSkew) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrClockSkew, err)
+	}
+}
+
+func TestConcurrentEnrollment(t *testing.T) {
+	const n = 8
+
+	certRaw, _, err := primitives.NewSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Failed generating certificate [%s]", err)
+	}
+	var createErrs []error
+	for i := 0; i < 2*n; i++ {
+		createErrs = append(createErrs, grpc.Errorf(codes.Unauthenticated, "Identity or token does not match."))
+	}
+	client := &fakeECAPClient{caCert: certRaw, createErrs: createErrs}
+	cert, err := primitives.DERToX509Certificate(certRaw)
+	if err != nil {
+		t.Fatalf("Failed parsing certificate [%s]", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	// The nodes share the keystore
+	first, cleanup := newTestECANode(t, client)
+	defer cleanup()
+	first.conf.securityLevel = 256
+	first.conf.hashAlgorithm = "SHA3"
+	first.conf.ecaEnrollmentTimeout = time.Second
+	nodes := []*nodeImpl{first}
+	for i := 1; i < n; i++ {
+		nodes = append(nodes, &nodeImpl{conf: first.conf, ecaClientFactory: first.ecaClientFactory})
+	}
+	for _, node := range nodes {
+		node.certStore = &fileCertStore{node}
+		node.setRootCerts(roots, []*x509.Certificate{cert})
+	}
+
+	enroll := func(node *nodeImpl, id string) error {
+		if err := node.retrieveECACertsChain(id); err != nil {
+			return err
+		}
+		if err := node.loadECACertsChain(context.Background()); err != nil {
+			return err
+		}
+		if _, _, _, _, err := node.getEnrollmentCertificateFromECA(context.Background(), id, "pw"); !errors.Is(err, utils.ErrEnrollmentAuthFailed) {
+			return fmt.Errorf("expected [%s], got [%v]", utils.ErrEnrollmentAuthFailed, err)
+		}
+
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*n)
+	for i, node := range nodes {
+		wg.Add(2)
+		go func(node *nodeImpl, id string) {
+			defer wg.Done()
+			errs <- enroll(node, id)
+		}(node, fmt.Sprintf("user%d", i))
+		// Distinct ids on the same node
+		go func(id string) {
+			defer wg.Done()
+			errs <- enroll(first, id)
+		}(fmt.Sprintf("other%d", i))
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Failed enrolling concurrently [%s]", err)
+		}
+	}
+	if client.createCalls != 2*n {
+		t.Fatalf("Expected [%d] requests, got [%d]", 2*n, client.createCalls)
+	}
+
+	// The chain is retrieved once and stored whole
+	if client.readCACalls != 1 {
+		t.Fatalf("Expected the ECA certificate to be read once, got [%d] reads", client.readCACalls)
+	}
+	for _, node := range nodes {
+		if !node.getECACert().Equal(cert) {
+			t.Fatal("The loaded ECA certificate differs from the ECA one")
+		}
+	}
+}
+
+// newFakeECANode returns a node dialing eca like a deployed one would
+func newFakeECANode(t *testing.T, eca *testutil.FakeECA) (*nodeImpl, func()) {
+	node, cleanup := newTestECANode(t, nil)
+	node.ecaClientFactory = nil
+	node.certStore = &fileCertStore{node}
+	node.setRootCerts(x509.NewCertPool(), nil)
+	node.conf.ecaDialTimeout = time.Second
+	node.conf.ecaMaxRecvSize = 1 << 20
+	node.conf.securityLevel = 256
+	node.conf.hashAlgorithm = primitives.GetHashAlgorithm()
+	node.conf.ecaEnrollmentTimeout = time.Second
+	node.conf.ecaAttributes = true
+	addr := viper.GetString(node.conf.ecaPAddressProperty)
+	viper.Set(node.conf.ecaPAddressProperty, eca.Addr())
+
+	return node, func() {
+		viper.Set(node.conf.ecaPAddressProperty, addr)
+		node.closeECAConn()
+		cleanup()
+	}
+}
+
+func TestFakeECA(t *testing.T) {
+	eca, err := testutil.NewFakeECA(testutil.FakeECAOptions{})
+	if err != nil {
+		t.Fatalf("Failed starting fake ECA [%s]", err)
+	}
+	defer eca.Close()
+	node, cleanup := newFakeECANode(t, eca)
+	defer cleanup()
+
+	if err := node.retrieveECACertsChain("user"); err != nil {
+		t.Fatalf("Failed retrieving ECA certificates chain [%s]", err)
+	}
+	if err := node.loadECACertsChain(context.Background()); err != nil {
+		t.Fatalf("Failed loading ECA certificates chain [%s]", err)
+	}
+	if !bytes.Equal(node.getECACert().Raw, eca.Cert()) {
+		t.Fatal("The loaded ECA certificate differs from the fake ECA one")
+	}
+
+	if err := node.SetEnrollmentAttributes(map[string][]byte{"1.2.3.4": []byte("role")}); err != nil {
+		t.Fatalf("Failed setting enrollment attributes [%s]", err)
+	}
+
+	// Attributes are not requested from an ECA not known to support them, the identity is not used up
+	node.conf.ecaAttributes = false
+	if _, _, _, _, err := node.getEnrollmentCertificateFromECA(context.Background(), "user", "pw"); !errors.Is(err, utils.ErrEnrollmentAttributesNotSupported) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrEnrollmentAttributesNotSupported, err)
+	}
+	node.conf.ecaAttributes = true
+
+	_, certRaw, _, _, err := node.getEnrollmentCertificateFromECA(context.Background(), "user", "pw")
+	if err != nil {
+		t.Fatalf("Failed enrolling with the fake ECA [%s]", err)
+	}
+	cert, err := primitives.DERToX509Certificate(certRaw)
+	if err != nil {
+		t.Fatalf("Failed parsing enrollment certificate [%s]", err)
+	}
+	if cert.Subject.CommonName != "user" {
+		t.Fatalf("Expected an enrollment certificate for [user], got [%s]", cert.Subject.CommonName)
+	}
+}
+
+func TestFakeECAFailures(t *testing.T) {
+	// Wrong password
+	eca, err := testutil.NewFakeECA(testutil.FakeECAOptions{AuthFailure: true})
+	if err != nil {
+		t.Fatalf("Failed starting fake ECA [%s]", err)
+	}
+	defer eca.Close()
+	node, cleanup := newFakeECANode(t, eca)
+	defer cleanup()
+
+	if _, _, _, _, err := node.getEnrollmentCertificateFromECA(context.Background(), "user", "pw"); !errors.Is(err, utils.ErrEnrollmentAuthFailed) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrEnrollmentAuthFailed, err)
+	}
+
+	// Stalled ECA
+	stalled, err := testutil.NewFakeECA(testutil.FakeECAOptions{Delay: time.Minute})
+	if err != nil {
+		t.Fatalf("Failed starting fake ECA [%s]", err)
+	}
+	defer stalled.Close()
+	node, cleanup = newFakeECANode(t, stalled)
+	defer cleanup()
+	node.conf.ecaEnrollmentTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	if _, _, _, _, err := node.getEnrollmentCertificateFromECA(context.Background(), "user", "pw"); err == nil {
+		t.Fatal("Enrolling with a stalled ECA must fail")
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("The enrollment timeout must bound the enrollment, took [%s]", elapsed)
+	}
+
+	// Revoked ECA certificate
+	revoked, err := testutil.NewFakeECA(testutil.FakeECAOptions{Revoked: true})
+	if err != nil {
+		t.Fatalf("Failed starting fake ECA [%s]", err)
+	}
+	defer revoked.Close()
+	node, cleanup = newFakeECANode(t, revoked)
+	defer cleanup()
+
+	path := filepath.Join(node.conf.getRawsPath(), "eca.crl")
+	if err := revoked.WriteCRL(path); err != nil {
+		t.Fatalf("Failed writing CRL [%s]", err)
+	}
+	viper.Set("peer.pki.eca.crl.file", path)
+	defer viper.Set("peer.pki.eca.crl.file", "")
+
+	if err := node.retrieveECACertsChain("user"); !errors.Is(err, utils.ErrECACertRevoked) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrECACertRevoked, err)
+	}
+}
+
+func TestCloseDuringEnrollment(t *testing.T) {
+	eca, err := testutil.NewFakeECA(testutil.FakeECAOptions{Delay: time.Minute})
+	if err != nil {
+		t.Fatalf("Failed starting fake ECA [%s]", err)
+	}
+	defer eca.Close()
+	node, cleanup := newFakeECANode(t, eca)
+	defer cleanup()
+	node.conf.ecaEnrollmentTimeout = time.Minute
+	node.conf.ecaCloseTimeout = 10 * time.Second
+
+	errs := make(chan error, 1)
+	go func() {
+		_, _, _, _, err := node.getEnrollmentCertificateFromECA(context.Background(), "user", "pw")
+		errs <- err
+	}()
+
+	// Let the enrollment reach the stalled ECA
+	time.Sleep(200 * time.Millisecond)
+
+	start := time.Now()
+	if err := node.close(); err != nil {
+		t.Fatalf("Failed closing the node [%s]", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Closing must abort the in-flight enrollment, took [%s]", elapsed)
+	}
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, utils.ErrNodeClosed) {
+			t.Fatalf("Expected [%s], got [%v]", utils.ErrNodeClosed, err)
+		}
+	default:
+		t.Fatal("The in-flight enrollment must be over once the node is closed")
+	}
+
+	// Later enrollments fail without calling the ECA
+	if _, _, _, _, err := node.getEnrollmentCertificateFromECA(context.Background(), "user", "pw"); !errors.Is(err, utils.ErrNodeClosed) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrNodeClosed, err)
+	}
+}
+
+func TestEnroll(t *testing.T) {
+	eca, err := testutil.NewFakeECA(testutil.FakeECAOptions{})
+	if err != nil {
+		t.Fatalf("Failed starting fake ECA [%s]", err)
+	}
+	defer eca.Close()
+	addr := viper.GetString("peer.pki.eca.paddr")
+	viper.Set("peer.pki.eca.paddr", eca.Addr())
+	defer viper.Set("peer.pki.eca.paddr", addr)
+
+	res, err := Enroll(context.Background(), Config{Name: "tool", Type: NodeClient}, "user", "pw")
+	if err != nil {
+		t.Fatalf("Failed enrolling [%s]", err)
+	}
+	cert, err := primitives.DERToX509Certificate(res.Cert)
+	if err != nil {
+		t.Fatalf("Failed parsing enrollment certificate [%s]", err)
+	}
+	if err := primitives.CheckCertPKAgainstSK(cert, res.Key); err != nil {
+		t.Fatalf("The enrollment certificate must match the enrollment key [%s]", err)
+	}
+	if _, ok := res.ChainKey.(*ecdsa.PublicKey); !ok {
+		t.Fatalf("Expected a public chain key, got [%T]", res.ChainKey)
+	}
+
+	wrong, err := testutil.NewFakeECA(testutil.FakeECAOptions{AuthFailure: true})
+	if err != nil {
+		t.Fatalf("Failed starting fake ECA [%s]", err)
+	}
+	defer wrong.Close()
+	viper.Set("peer.pki.eca.paddr", wrong.Addr())
+	if _, err := Enroll(context.Background(), Config{Name: "tool", Type: NodeClient}, "user", "pw"); !errors.Is(err, utils.ErrEnrollmentAuthFailed) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrEnrollmentAuthFailed, err)
+	}
+}
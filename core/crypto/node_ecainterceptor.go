@@ -0,0 +1,150 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"sync"
+
+	membersrvc "github.com/hyperledger/fabric/membersrvc/protos"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// ecaNodeIDHeader is the metadata key naming the calling node to the ECA
+const ecaNodeIDHeader = "fabric-node-id"
+
+// ECAUnaryInvoker sends a call to the ECA
+type ECAUnaryInvoker func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error
+
+// ECAUnaryInterceptor intercepts the calls to the ECA, for instance to add an
+// auth token to the metadata of ctx or to log them. It sends the call by
+// invoking invoker. The vendored gRPC has no interceptors: the signature is the
+// one of grpc.UnaryClientInterceptor in later versions.
+type ECAUnaryInterceptor func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker ECAUnaryInvoker, opts ...grpc.CallOption) error
+
+var (
+	ecaUnaryInterceptors      []ECAUnaryInterceptor
+	ecaUnaryInterceptorsMutex sync.RWMutex
+)
+
+// SetECAUnaryInterceptors sets the interceptors of the calls to the ECA. The first
+// one is the outermost. They take effect on the nodes initialized afterwards.
+func SetECAUnaryInterceptors(interceptors ...ECAUnaryInterceptor) {
+	ecaUnaryInterceptorsMutex.Lock()
+	defer ecaUnaryInterceptorsMutex.Unlock()
+
+	ecaUnaryInterceptors = interceptors
+}
+
+func getECAUnaryInterceptors() []ECAUnaryInterceptor {
+	ecaUnaryInterceptorsMutex.RLock()
+	defer ecaUnaryInterceptorsMutex.RUnlock()
+
+	return ecaUnaryInterceptors
+}
+
+// nodeIDInterceptor adds the name of the node to the metadata of the ECA calls,
+// keeping the metadata set by the other interceptors
+func (node *nodeImpl) nodeIDInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker ECAUnaryInvoker, opts ...grpc.CallOption) error {
+	md, ok := metadata.FromContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	md[ecaNodeIDHeader] = []string{node.GetName()}
+
+	return invoker(metadata.NewContext(ctx, md), method, req, reply, cc, opts...)
+}
+
+// newECAPClient returns the ECA client of conn, going through interceptors if any
+func newECAPClient(conn *grpc.ClientConn, interceptors []ECAUnaryInterceptor) membersrvc.ECAPClient {
+	if len(interceptors) == 0 {
+		return membersrvc.NewECAPClient(conn)
+	}
+
+	return &interceptedECAPClient{cc: conn, interceptors: interceptors}
+}
+
+// interceptedECAPClient is the ECAP client generated by protoc, sending the calls through interceptors
+type interceptedECAPClient struct {
+	cc           *grpc.ClientConn
+	interceptors []ECAUnaryInterceptor
+}
+
+func (c *interceptedECAPClient) invoke(ctx context.Context, method string, in, out interface{}, opts ...grpc.CallOption) error {
+	invoker := ECAUnaryInvoker(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return grpc.Invoke(ctx, method, req, reply, cc, opts...)
+	})
+	for i := len(c.interceptors) - 1; i >= 0; i-- {
+		interceptor, next := c.interceptors[i], invoker
+		invoker = func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			return interceptor(ctx, method, req, reply, cc, next, opts...)
+		}
+	}
+
+	return invoker(ctx, method, in, out, c.cc, opts...)
+}
+
+func (c *interceptedECAPClient) ReadCACertificate(ctx context.Context, in *membersrvc.Empty, opts ...grpc.CallOption) (*membersrvc.Cert, error) {
+	out := new(membersrvc.Cert)
+	if err := c.invoke(ctx, "/protos.ECAP/ReadCACertificate", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *interceptedECAPClient) CreateCertificatePair(ctx context.Context, in *membersrvc.ECertCreateReq, opts ...grpc.CallOption) (*membersrvc.ECertCreateResp, error) {
+	out := new(membersrvc.ECertCreateResp)
+	if err := c.invoke(ctx, "/protos.ECAP/CreateCertificatePair", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *interceptedECAPClient) ReEnrollCertificatePair(ctx context.Context, in *membersrvc.ECertCreateReq, opts ...grpc.CallOption) (*membersrvc.ECertCreateResp, error) {
+	out := new(membersrvc.ECertCreateResp)
+	if err := c.invoke(ctx, "/protos.ECAP/ReEnrollCertificatePair", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *interceptedECAPClient) ReadCertificatePair(ctx context.Context, in *membersrvc.ECertReadReq, opts ...grpc.CallOption) (*membersrvc.CertPair, error) {
+	out := new(membersrvc.CertPair)
+	if err := c.invoke(ctx, "/protos.ECAP/ReadCertificatePair", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *interceptedECAPClient) ReadCertificateByHash(ctx context.Context, in *membersrvc.Hash, opts ...grpc.CallOption) (*membersrvc.Cert, error) {
+	out := new(membersrvc.Cert)
+	if err := c.invoke(ctx, "/protos.ECAP/ReadCertificateByHash", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *interceptedECAPClient) RevokeCertificatePair(ctx context.Context, in *membersrvc.ECertRevokeReq, opts ...grpc.CallOption) (*membersrvc.CAStatus, error) {
+	out := new(membersrvc.CAStatus)
+	if err := c.invoke(ctx, "/protos.ECAP/RevokeCertificatePair", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
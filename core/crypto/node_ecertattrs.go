@@ -0,0 +1,154 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	membersrvc "github.com/hyperledger/fabric/membersrvc/protos"
+	"golang.org/x/net/context"
+)
+
+// SetEnrollmentAttributes sets the extensions to request in the enrollment
+// certificates, as values by dotted object identifier, e.g. "1.2.3.4".
+// The ECA must support them, as declared by peer.pki.eca.attributes, otherwise
+// the enrollment fails before contacting the ECA: the membersrvc ECA ignores them.
+// The ECA decides whether to embed them. An enrollment certificate lacking
+// any of them, or carrying a different value, fails the enrollment.
+// Passing nil requests none.
+func (node *nodeImpl) SetEnrollmentAttributes(attrs map[string][]byte) error {
+	copied := make(map[string][]byte, len(attrs))
+	for oid, value := range attrs {
+		id, err := parseOID(oid)
+		if err != nil {
+			return fmt.Errorf("SetEnrollmentAttributes: %w", err)
+		}
+		if id.Equal(ECertSubjectRole) {
+			return fmt.Errorf("SetEnrollmentAttributes: [%s] is set by the ECA", oid)
+		}
+		copied[id.String()] = append([]byte(nil), value...)
+	}
+
+	node.enrollmentAttrsMutex.Lock()
+	defer node.enrollmentAttrsMutex.Unlock()
+
+	node.enrollmentAttrs = copied
+
+	return nil
+}
+
+// enrollmentAttributes returns the attributes of the enrollment requests,
+// sorted by object identifier so that the signed requests are reproducible
+func (node *nodeImpl) enrollmentAttributes() []*membersrvc.ECertAttribute {
+	node.enrollmentAttrsMutex.Lock()
+	defer node.enrollmentAttrsMutex.Unlock()
+
+	if len(node.enrollmentAttrs) == 0 {
+		return nil
+	}
+
+	attrs := make([]*membersrvc.ECertAttribute, 0, len(node.enrollmentAttrs))
+	for oid, value := range node.enrollmentAttrs {
+		attrs = append(attrs, &membersrvc.ECertAttribute{Oid: oid, Value: value})
+	}
+	sort.Slice(attrs, func(i, j int) bool { return attrs[i].Oid < attrs[j].Oid })
+
+	return attrs
+}
+
+// enrollmentAttrsKey is the context key of the attributes requested by a single enrollment
+type enrollmentAttrsKey struct{}
+
+// withEnrollmentAttributes returns a context requesting attrs in the enrollment
+// run with it, in place of the ones set by SetEnrollmentAttributes
+func withEnrollmentAttributes(ctx context.Context, attrs []*membersrvc.ECertAttribute) context.Context {
+	return context.WithValue(ctx, enrollmentAttrsKey{}, attrs)
+}
+
+// requestedEnrollmentAttributes returns the attributes to request in the enrollment run with ctx
+func (node *nodeImpl) requestedEnrollmentAttributes(ctx context.Context) []*membersrvc.ECertAttribute {
+	if attrs, ok := ctx.Value(enrollmentAttrsKey{}).([]*membersrvc.ECertAttribute); ok {
+		return attrs
+	}
+
+	return node.enrollmentAttributes()
+}
+
+// certEnrollmentAttributes returns the attributes cert carries, sorted by object identifier:
+// its extensions but the role set by the ECA and the standard X.509 ones
+func certEnrollmentAttributes(cert *x509.Certificate) []*membersrvc.ECertAttribute {
+	var attrs []*membersrvc.ECertAttribute
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(ECertSubjectRole) || isX509Extension(ext.Id) {
+			continue
+		}
+		attrs = append(attrs, &membersrvc.ECertAttribute{Oid: ext.Id.String(), Value: append([]byte(nil), ext.Value...)})
+	}
+	sort.Slice(attrs, func(i, j int) bool { return attrs[i].Oid < attrs[j].Oid })
+
+	return attrs
+}
+
+// isX509Extension tells whether id is under id-ce (2.5.29), the arc of the
+// standard certificate extensions such as the key usage
+func isX509Extension(id asn1.ObjectIdentifier) bool {
+	return len(id) > 3 && id[0] == 2 && id[1] == 5 && id[2] == 29
+}
+
+// checkEnrollmentAttributes checks that cert carries the requested attributes
+func checkEnrollmentAttributes(cert *x509.Certificate, attrs []*membersrvc.ECertAttribute) error {
+	for _, attr := range attrs {
+		found := false
+		for _, ext := range cert.Extensions {
+			if ext.Id.String() == attr.Oid && bytes.Equal(ext.Value, attr.Value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("checkEnrollmentAttributes: %w: [%s]", utils.ErrMissingEnrollmentAttribute, attr.Oid)
+		}
+	}
+
+	return nil
+}
+
+func parseOID(oid string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(oid, ".")
+	if len(parts) < 2 {
+		return nil, errors.New("Invalid object identifier [" + oid + "].")
+	}
+
+	id := make(asn1.ObjectIdentifier, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, errors.New("Invalid object identifier [" + oid + "].")
+		}
+		id[i] = n
+	}
+
+	return id, nil
+}
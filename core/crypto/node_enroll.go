@@ -0,0 +1,103 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+)
+
+// Config selects the settings of a standalone enrollment. The other settings
+// are read from the peer configuration, as for the nodes.
+type Config struct {
+
+	// Name identifies the enrollment in the logs
+	Name string
+
+	// Type is the type of the enrolled node. Validators get the private chain key,
+	// the others the public one.
+	Type NodeType
+}
+
+// EnrollResult holds the data issued by an enrollment
+type EnrollResult struct {
+
+	// Key is the enrollment signing key
+	Key interface{}
+
+	// Cert is the DER encoded enrollment certificate
+	Cert []byte
+
+	// Chain holds the DER encoded certificates between Cert and the ECA certificate, if any
+	Chain [][]byte
+
+	// ChainKey is the enrollment chain key of the confidentiality protocol
+	ChainKey interface{}
+}
+
+// Enroll runs the enrollment protocol for id and pw with the configured ECA,
+// for the tools that only need the enrollment data and not a whole node.
+// The ECA certificate is verified as by the nodes. Nothing is stored.
+func Enroll(ctx context.Context, conf Config, id, pw string) (*EnrollResult, error) {
+	node := newNodeImpl()
+	node.eType = conf.Type
+	node.ks = &keyStore{node: node}
+	node.certStore = NewMemCertStore()
+
+	if err := node.initConfiguration(conf.Name); err != nil {
+		return nil, fmt.Errorf("Enroll: %w", err)
+	}
+	defer node.closeECAConn()
+
+	if err := node.initRootsCertPool(); err != nil {
+		return nil, fmt.Errorf("Enroll: %w", err)
+	}
+	if err := node.retrieveECACertsChain(id); err != nil {
+		return nil, fmt.Errorf("Enroll: %w", err)
+	}
+	if err := node.loadECACertsChain(ctx); err != nil {
+		return nil, fmt.Errorf("Enroll: %w", err)
+	}
+
+	res, err := node.enroll(ctx, id, pw)
+	if err != nil {
+		return nil, fmt.Errorf("Enroll: %w", err)
+	}
+
+	return res, nil
+}
+
+// enroll runs the enrollment protocol for id and pw and checks the issued data
+func (node *nodeImpl) enroll(ctx context.Context, id, pw string) (*EnrollResult, error) {
+	key, enrollCertRaw, intermediates, enrollChainKey, err := node.getEnrollmentCertificateFromECA(ctx, id, pw)
+	if err != nil {
+		node.Errorf("Failed getting enrollment certificate [id=%s]: [%s]", node.logID(id), err)
+
+		return nil, fmt.Errorf("enroll: %w", err)
+	}
+	node.Debugf("Enrollment certificate [%s].", node.logCert(enrollCertRaw))
+
+	chainKey, err := node.validateEnrollmentData(key, enrollCertRaw, enrollChainKey)
+	if err != nil {
+		node.Errorf("Invalid enrollment data [id=%s]: [%s]", node.logID(id), err)
+
+		return nil, fmt.Errorf("enroll: %w", err)
+	}
+
+	return &EnrollResult{Key: key, Cert: enrollCertRaw, Chain: intermediates, ChainKey: chainKey}, nil
+}
@@ -0,0 +1,67 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+// EnrollmentStep is a step of the enrollment with the ECA
+type EnrollmentStep string
+
+const (
+	// EnrollmentStepGeneratingKey The enrollment keys are being generated
+	EnrollmentStepGeneratingKey EnrollmentStep = "generating key"
+
+	// EnrollmentStepRequestingCert The enrollment certificate is being requested to the ECA
+	EnrollmentStepRequestingCert EnrollmentStep = "requesting cert"
+
+	// EnrollmentStepVerifying The response of the ECA is being verified
+	EnrollmentStepVerifying EnrollmentStep = "verifying"
+
+	// EnrollmentStepStoring The enrollment data is being stored
+	EnrollmentStepStoring EnrollmentStep = "storing"
+)
+
+// EnrollmentEvent reports the progress of an enrollment
+type EnrollmentEvent struct {
+	EnrollID string
+	Step     EnrollmentStep
+}
+
+// SetEnrollmentEvents sets the channel the enrollment steps are sent to, for
+// front-ends to show the progress of the enrollment. Events are dropped when
+// the channel is full, so that a slow reader never stalls the enrollment.
+// Passing nil stops the events.
+func (node *nodeImpl) SetEnrollmentEvents(events chan<- EnrollmentEvent) {
+	node.enrollmentEventsMutex.Lock()
+	defer node.enrollmentEventsMutex.Unlock()
+
+	node.enrollmentEvents = events
+}
+
+func (node *nodeImpl) sendEnrollmentEvent(enrollID string, step EnrollmentStep) {
+	node.enrollmentEventsMutex.Lock()
+	events := node.enrollmentEvents
+	node.enrollmentEventsMutex.Unlock()
+
+	if events == nil {
+		return
+	}
+
+	select {
+	case events <- EnrollmentEvent{EnrollID: enrollID, Step: step}:
+	default:
+		node.Debugf("Enrollment event [%s] dropped, channel full.", step)
+	}
+}
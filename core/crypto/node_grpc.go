@@ -17,10 +17,19 @@ limitations under the License.
 package crypto
 
 import (
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/golang/protobuf/proto"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 
@@ -73,3 +82,225 @@ func (node *nodeImpl) getClientConn(address string, serverName string) (*grpc.Cl
 	node.Debug("TLS disabled...")
 	return comm.NewClientConnectionWithAddress(address, false, false, nil)
 }
+
+// unixScheme prefixes the ECA addresses naming a unix domain socket
+const unixScheme = "unix://"
+
+var (
+	ecaDialOptions      []grpc.DialOption
+	ecaDialOptionsMutex sync.RWMutex
+)
+
+// SetECADialOptions sets additional options used when dialing the ECA,
+// for instance a dialer going through a proxy. They are applied after the default
+// ones and take effect on the nodes initialized afterwards.
+func SetECADialOptions(opts ...grpc.DialOption) {
+	ecaDialOptionsMutex.Lock()
+	defer ecaDialOptionsMutex.Unlock()
+
+	ecaDialOptions = opts
+}
+
+func getECADialOptions() []grpc.DialOption {
+	ecaDialOptionsMutex.RLock()
+	defer ecaDialOptionsMutex.RUnlock()
+
+	return ecaDialOptions
+}
+
+// ecaConnPool holds the ECA connections shared by the nodes of this process,
+// by pool key (see getECAConnKey)
+var ecaConnPool = struct {
+	sync.Mutex
+	conns map[string]*pooledECAConn
+}{conns: make(map[string]*pooledECAConn)}
+
+type pooledECAConn struct {
+	conn *grpc.ClientConn
+	refs int
+
+	// ready is closed once the dial completed, err tells whether it failed
+	ready chan struct{}
+	err   error
+}
+
+// AcquireECAConn returns a connection to the ECA at addr, shared with the
+// other callers that acquired the same key. The key must identify addr and
+// every setting of opts affecting the connection security, since opts are used
+// only when no connection for key is open yet. Each call must be paired with
+// ReleaseECAConn.
+func AcquireECAConn(key, addr string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	ecaConnPool.Lock()
+	pooled, ok := ecaConnPool.conns[key]
+	if ok {
+		pooled.refs++
+		ecaConnPool.Unlock()
+
+		// Wait for the dial started by another caller
+		<-pooled.ready
+		if pooled.err != nil {
+			return nil, pooled.err
+		}
+
+		return pooled.conn, nil
+	}
+	pooled = &pooledECAConn{refs: 1, ready: make(chan struct{})}
+	ecaConnPool.conns[key] = pooled
+	ecaConnPool.Unlock()
+
+	// Dial without holding the pool, a blocking dial must not stall the other ECAs
+	conn, err := grpc.Dial(addr, opts...)
+	if err != nil {
+		err = fmt.Errorf("ECA at [%s] not reachable: [%s]", addr, err)
+
+		ecaConnPool.Lock()
+		delete(ecaConnPool.conns, key)
+		ecaConnPool.Unlock()
+	}
+	pooled.conn, pooled.err = conn, err
+	close(pooled.ready)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// ReleaseECAConn releases a connection obtained with AcquireECAConn.
+// The connection is closed once released by all the callers.
+func ReleaseECAConn(key string) error {
+	ecaConnPool.Lock()
+	defer ecaConnPool.Unlock()
+
+	pooled, ok := ecaConnPool.conns[key]
+	if !ok {
+		return errors.New("No connection to the ECA to release.")
+	}
+
+	pooled.refs--
+	if pooled.refs > 0 {
+		return nil
+	}
+	delete(ecaConnPool.conns, key)
+
+	return pooled.conn.Close()
+}
+
+// getECAConnKey returns the key of the connection to the ECA at address in
+// ecaConnPool, a digest of address and of the settings used by getECADialOpts.
+// Nodes with different TLS or dial settings never share a connection.
+func (node *nodeImpl) getECAConnKey(address string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "addr=%s\x00server=%s\x00tls=%t\x00", address, node.conf.getECATLSServerName(address), node.conf.isTLSEnabled())
+
+	if rootCert := node.conf.getECATLSRootCert(); rootCert != "" {
+		pem, err := ioutil.ReadFile(rootCert)
+		if err != nil {
+			return "", fmt.Errorf("getECAConnKey: %w", err)
+		}
+		fmt.Fprintf(h, "root=%x\x00", sha256.Sum256(pem))
+	} else if node.conf.isTLSEnabled() && node.tlsCertPool != nil {
+		for _, subject := range node.tlsCertPool.Subjects() {
+			fmt.Fprintf(h, "pool=%x\x00", subject)
+		}
+	}
+
+	fmt.Fprintf(h, "pin=%s\x00keepalive=%s\x00timeout=%s\x00agent=%s\x00max=%d\x00",
+		node.conf.getECACertPin(), node.conf.getECAKeepalive().time, node.conf.getECADialTimeout(),
+		node.conf.getClientUserAgent(), node.conf.getECAMaxRecvSize())
+
+	// Dial options can not be compared, the nodes share a connection only if
+	// they were given the same options by the same call to SetECADialOptions
+	if custom := node.conf.getECADialOptions(); len(custom) > 0 {
+		fmt.Fprintf(h, "opts=%p/%d\x00", &custom[0], len(custom))
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// getECADialOpts returns the options used to dial the ECA at address
+func (node *nodeImpl) getECADialOpts(address string) ([]grpc.DialOption, error) {
+	serverName := node.conf.getECATLSServerName(address)
+
+	var opts []grpc.DialOption
+	if rootCert := node.conf.getECATLSRootCert(); rootCert != "" {
+		node.Debugf("Dial to ECA addr:[%s], with serverName:[%s] and root certificate:[%s]...", address, serverName, rootCert)
+
+		creds, err := credentials.NewClientTLSFromFile(rootCert, serverName)
+		if err != nil {
+			node.Errorf("Failed loading ECA TLS root certificate [%s]: [%s]", rootCert, err)
+
+			return nil, err
+		}
+		opts = append(opts, grpc.WithTransportCredentials(creds))
+	} else if node.conf.isTLSEnabled() {
+		node.Debugf("Dial to ECA addr:[%s], with serverName:[%s]...", address, serverName)
+
+		config := tls.Config{
+			InsecureSkipVerify: false,
+			RootCAs:            node.tlsCertPool,
+			ServerName:         serverName,
+		}
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(&config)))
+	} else {
+		node.Warningf("No TLS configuration for the ECA at [%s]. Enrollment requests will be sent in clear!", address)
+
+		opts = append(opts, grpc.WithInsecure())
+	}
+
+	// Send TCP keepalives, so that a broken connection is detected by the OS
+	keepalive := node.conf.getECAKeepalive()
+	network, target := "tcp", ""
+	if strings.HasPrefix(address, unixScheme) {
+		network, target = "unix", strings.TrimPrefix(address, unixScheme)
+	}
+	opts = append(opts, grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+		if target != "" {
+			addr = target
+		}
+		dialer := net.Dialer{Timeout: timeout, KeepAlive: keepalive.time}
+		return dialer.Dial(network, addr)
+	}))
+
+	// Block until connected, so that an unreachable ECA is reported here and not by the first call
+	timeout := node.conf.getECADialTimeout()
+	opts = append(opts, grpc.WithBlock(), grpc.WithTimeout(timeout))
+
+	// Tell the ECA which software version calls, to the ECA audit logs
+	opts = append(opts, grpc.WithUserAgent(node.conf.getClientUserAgent()))
+
+	// Bound the size of the ECA responses that are decoded
+	opts = append(opts, grpc.WithCodec(&recvLimitCodec{max: node.conf.getECAMaxRecvSize()}))
+
+	// Custom options come last, so that they can replace the defaults
+	opts = append(opts, node.conf.getECADialOptions()...)
+
+	return opts, nil
+}
+
+// recvLimitCodec is the protobuf codec of gRPC, refusing to decode the messages
+// larger than max. This version of gRPC has no option limiting the size of the
+// received messages, and its transport has read the whole message into memory
+// before the codec sees it: the limit bounds only the decoding work and what is
+// kept, not the memory used to receive an oversized response.
+type recvLimitCodec struct {
+	max int
+}
+
+func (c *recvLimitCodec) Marshal(v interface{}) ([]byte, error) {
+	return proto.Marshal(v.(proto.Message))
+}
+
+func (c *recvLimitCodec) Unmarshal(data []byte, v interface{}) error {
+	if len(data) > c.max {
+		return fmt.Errorf("Response of [%d] bytes exceeds the limit of [%d] bytes", len(data), c.max)
+	}
+
+	return proto.Unmarshal(data, v.(proto.Message))
+}
+
+// String returns the name of the protobuf codec, so that the content type is unchanged
+func (c *recvLimitCodec) String() string {
+	return "proto"
+}
@@ -19,9 +19,13 @@ package crypto
 import (
 	"crypto/ecdsa"
 	"crypto/x509"
+	"sync"
+	"time"
 
 	"github.com/hyperledger/fabric/core/crypto/primitives"
 	"github.com/hyperledger/fabric/core/crypto/utils"
+	membersrvc "github.com/hyperledger/fabric/membersrvc/protos"
+	"golang.org/x/net/context"
 )
 
 // Public Struct
@@ -39,29 +43,140 @@ type nodeImpl struct {
 	// keyStore
 	ks *keyStore
 
+	// Certificate store
+	certStore CertStore
+
+	// Trusted root certificates
+	rootCerts []*x509.Certificate
+
 	// Certs Pool
-	rootsCertPool *x509.CertPool
-	tlsCertPool   *x509.CertPool
-	ecaCertPool   *x509.CertPool
-	tcaCertPool   *x509.CertPool
+	// rootsCertPool, rootCerts, ecaCertPool, ecaCert, ecaIntermediates and ecertIntermediates
+	// are replaced, never modified, under certPoolsMutex
+	rootsCertPool  *x509.CertPool
+	tlsCertPool    *x509.CertPool
+	ecaCertPool    *x509.CertPool
+	tcaCertPool    *x509.CertPool
+	certPoolsMutex sync.RWMutex
 
 	// 48-bytes identifier
 	id []byte
 
-	// Enrollment Certificate and private key
-	enrollID       string
-	enrollCert     *x509.Certificate
-	enrollPrivKey  *ecdsa.PrivateKey
-	enrollCertHash []byte
+	// Enrollment Certificate and private key.
+	// They are set, and replaced by a re-enrollment or an import, under enrollDataMutex.
+	// Re-enrollments are serialized by reEnrollMutex
+	enrollID        string
+	enrollCert      *x509.Certificate
+	enrollPrivKey   *ecdsa.PrivateKey
+	enrollCertHash  []byte
+	enrollDataMutex sync.RWMutex
+	reEnrollMutex   sync.Mutex
 
 	// Enrollment Chain
 	enrollChainKey interface{}
 
 	// TLS
-	tlsCert *x509.Certificate
+	tlsCert      *x509.Certificate
+	tlsCertMutex sync.RWMutex
 
 	// Crypto SPI
 	eciesSPI primitives.AsymmetricCipherSPI
+
+	// Logger, the crypto logger if nil
+	logger      Logger
+	loggerMutex sync.RWMutex
+
+	// Time source, time.Now if nil
+	clock func() time.Time
+
+	// ECA client, lazily initialized and shared by all the ECA calls
+	ecaClientFactory ecaClientFactory
+	ecaClient        membersrvc.ECAPClient
+	ecaClose         closeFunc
+	ecaKeepaliveStop chan struct{}
+	ecaConnMutex     sync.Mutex
+
+	// Address of the last ECA replica successfully dialed, tried first
+	ecaAddr string
+
+	// Time of the last successful call to the ECA, under ecaConnMutex
+	ecaContactTime time.Time
+
+	// ECA certificate, issuer of the enrollment certificate
+	ecaCert *x509.Certificate
+
+	// Certificates between the ECA certificate and an offline root, served with the ECA certificate
+	ecaIntermediates []*x509.Certificate
+
+	// Certificates between the ECA certificate and the root, if any
+	ecertIntermediates []*x509.Certificate
+
+	// Certificate pairs read from the ECA, by identity
+	certCache     *certCache
+	certCacheOnce sync.Once
+
+	// Raw ECA certificate as returned by the ECA, cached until verification fails
+	// or the cache TTL elapses
+	ecaCACert        []byte
+	ecaCACertFetched time.Time
+	ecaCACertMutex   sync.Mutex
+
+	// Enrollment certificate revocation check
+	revocationMutex         sync.Mutex
+	revocationCheckStop     chan struct{}
+	reEnrollmentRequired    bool
+	onEnrollmentCertRevoked func(err error)
+
+	// Enrollment certificate renewal
+	renewalMutex  sync.Mutex
+	renewalCancel context.CancelFunc
+	renewalGen    uint64
+
+	// Enrollment notification
+	onEnrolled      func(cert *x509.Certificate)
+	onEnrolledMutex sync.Mutex
+
+	// Extensions requested in the enrollment certificates, by dotted object identifier
+	enrollmentAttrs      map[string][]byte
+	enrollmentAttrsMutex sync.Mutex
+
+	// Certificate transparency log submission, disabled if nil
+	ctSubmitter      func(cert []byte) error
+	ctSubmitterMutex sync.Mutex
+
+	// Enrollment progress, nil if nobody listens
+	enrollmentEvents      chan<- EnrollmentEvent
+	enrollmentEventsMutex sync.Mutex
+
+	// Enrollment tracing, disabled if nil
+	tracer      Tracer
+	tracerMutex sync.RWMutex
+
+	// In-flight enrollments, aborted and waited for by close
+	enrollCtx      context.Context
+	enrollCancel   context.CancelFunc
+	enrollClosed   bool
+	enrollInFlight sync.WaitGroup
+	enrollMutex    sync.Mutex
+
+	// Background goroutines, waited for by close
+	background sync.WaitGroup
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// newNodeImpl returns a node trusting no root until initRootsCertPool loads them
+func newNodeImpl() *nodeImpl {
+	return &nodeImpl{rootsCertPool: x509.NewCertPool()}
+}
+
+// now returns the current time. Tests can override the clock.
+func (node *nodeImpl) now() time.Time {
+	if node.clock != nil {
+		return node.clock()
+	}
+
+	return time.Now()
 }
 
 type registerFunc func(eType NodeType, name string, pwd []byte, enrollID, enrollPWD string) error
@@ -132,7 +247,7 @@ func (node *nodeImpl) register(eType NodeType, name string, pwd []byte, enrollID
 	}
 
 	node.setRegistered()
-	node.Debugf("Registration of node [%s] with name [%s] completed", eType, name)
+	node.Debugf("Registration of node [%d] with name [%s] completed", eType, name)
 
 	return nil
 }
@@ -198,16 +313,52 @@ func (node *nodeImpl) nodeInit(eType NodeType, name string, pwd []byte) error {
 		return err
 	}
 
+	// Start the enrollment certificate revocation check
+	node.startRevocationCheck()
+
 	return nil
 }
 
+// close releases the resources held by the node: it stops the background
+// goroutines and waits for them, closes the ECA connection, flushes the
+// cert store and closes the keystore. Calling it again has no effect.
 func (node *nodeImpl) close() error {
-	// Close keystore
-	var err error
+	node.closeOnce.Do(func() {
+		// Abort the enrollments before closing the connection they use
+		node.abortEnrollments()
 
-	if node.ks != nil {
-		err = node.ks.close()
-	}
+		// Stop revocation check and certificate renewal
+		node.stopRevocationCheck()
+		node.stopCertRenewal()
+
+		// Close ECA connection
+		node.closeECAConn()
+
+		// Nothing must use the keystore once closed
+		node.background.Wait()
+
+		if flusher, ok := node.certStore.(certStoreFlusher); ok {
+			if err := flusher.Flush(); err != nil {
+				node.Errorf("Failed flushing cert store [%s].", err.Error())
+
+				node.closeErr = err
+			}
+		}
+
+		// Close keystore
+		if node.ks != nil {
+			if err := node.ks.close(); err != nil {
+				node.closeErr = err
+			}
+		}
+	})
+
+	return node.closeErr
+}
 
-	return err
+// Close releases the resources held by the node, as close does. The nodes
+// obtained with InitPeer or InitValidator are closed with ClosePeer or
+// CloseValidator instead, which also forget them.
+func (node *nodeImpl) Close() error {
+	return node.close()
 }
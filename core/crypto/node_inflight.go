@@ -0,0 +1,92 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	"golang.org/x/net/context"
+)
+
+// beginEnrollment registers an in-flight enrollment. The returned context is
+// canceled when ctx is done or the node is closed, done must be called once
+// the enrollment is over.
+func (node *nodeImpl) beginEnrollment(ctx context.Context) (context.Context, func(), error) {
+	node.enrollMutex.Lock()
+	defer node.enrollMutex.Unlock()
+
+	if node.enrollClosed {
+		return nil, nil, utils.ErrNodeClosed
+	}
+	if node.enrollCtx == nil {
+		node.enrollCtx, node.enrollCancel = context.WithCancel(context.Background())
+	}
+	nodeCtx := node.enrollCtx
+
+	ctx, cancel := context.WithCancel(ctx)
+	node.enrollInFlight.Add(1)
+	go func() {
+		select {
+		case <-nodeCtx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		cancel()
+		node.enrollInFlight.Done()
+	}, nil
+}
+
+// isClosing returns whether close aborted the in-flight enrollments
+func (node *nodeImpl) isClosing() bool {
+	node.enrollMutex.Lock()
+	defer node.enrollMutex.Unlock()
+
+	return node.enrollClosed
+}
+
+// abortEnrollments cancels the in-flight enrollments and waits for them to
+// return, for at most conf.getECACloseTimeout(). Later enrollments fail with
+// utils.ErrNodeClosed.
+func (node *nodeImpl) abortEnrollments() {
+	node.enrollMutex.Lock()
+	node.enrollClosed = true
+	if node.enrollCancel != nil {
+		node.enrollCancel()
+	}
+	node.enrollMutex.Unlock()
+
+	timeout := 5 * time.Second
+	if node.conf != nil {
+		timeout = node.conf.getECACloseTimeout()
+	}
+
+	aborted := make(chan struct{})
+	go func() {
+		node.enrollInFlight.Wait()
+		close(aborted)
+	}()
+
+	select {
+	case <-aborted:
+	case <-time.After(timeout):
+		node.Warningf("In-flight enrollments still running after [%s]. Closing anyway.", timeout)
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto"
+	"sync"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+)
+
+// KeySealer protects the enrollment key at rest. Seal returns the bytes written
+// to the keystore in place of the key, Unseal gets the key back from them.
+//
+// The node derives the transaction keys from the enrollment key, so Unseal must
+// return the *ecdsa.PrivateKey passed to Seal: keys never leaving a KMS cannot be
+// used. To seal with AWS KMS or GCP Cloud KMS, encrypt the PKCS#8 encoding of the
+// key with a data key (envelope encryption) and store the ciphertext together with
+// the data key wrapped by the KMS, for instance with GenerateDataKey in AWS or
+// Encrypt on a CryptoKey in GCP. Unseal asks the KMS to unwrap the data key and
+// decrypts the key with it. Access to the KMS is then what protects the key,
+// the keystore passphrase is not used.
+type KeySealer interface {
+
+	// Seal returns the sealed form of priv
+	Seal(priv crypto.Signer) ([]byte, error)
+
+	// Unseal returns the key sealed by Seal
+	Unseal(sealed []byte) (crypto.Signer, error)
+}
+
+var (
+	keySealer      KeySealer
+	keySealerMutex sync.RWMutex
+)
+
+// SetKeySealer sets the sealer of the enrollment keys of the nodes initialized
+// afterwards. If nil, the key is stored as PKCS#8 PEM, encrypted with the
+// keystore passphrase.
+func SetKeySealer(sealer KeySealer) {
+	keySealerMutex.Lock()
+	defer keySealerMutex.Unlock()
+
+	keySealer = sealer
+}
+
+func getKeySealer() KeySealer {
+	keySealerMutex.RLock()
+	defer keySealerMutex.RUnlock()
+
+	return keySealer
+}
+
+// getEnrollmentKeySealer returns the sealer set by SetKeySealer, or
+// the default one encrypting the key with passphrase
+func (node *nodeImpl) getEnrollmentKeySealer(passphrase []byte) KeySealer {
+	if sealer := node.conf.getKeySealer(); sealer != nil {
+		return sealer
+	}
+
+	return &passphraseKeySealer{passphrase}
+}
+
+// passphraseKeySealer is the default sealer. It passes the key through as
+// PKCS#8 PEM, encrypted with the passphrase if not empty.
+type passphraseKeySealer struct {
+	passphrase []byte
+}
+
+func (sealer *passphraseKeySealer) Seal(priv crypto.Signer) ([]byte, error) {
+	return primitives.PrivateKeyToPKCS8PEM(priv, sealer.passphrase)
+}
+
+func (sealer *passphraseKeySealer) Unseal(sealed []byte) (crypto.Signer, error) {
+	key, err := primitives.PEMtoPrivateKey(sealed, sealer.passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, utils.ErrInvalidKey
+	}
+
+	return signer, nil
+}
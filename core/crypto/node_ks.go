@@ -59,6 +59,14 @@ func (node *nodeImpl) initKeyStore(pwd []byte) error {
 	}
 	node.ks = &ks
 
+	if node.certStore == nil {
+		if factory := getCertStoreFactory(); factory != nil {
+			node.certStore = factory()
+		} else {
+			node.certStore = &fileCertStore{node}
+		}
+	}
+
 	/*
 		// Add default certs
 		for key, value := range defaultCerts {
@@ -124,7 +132,7 @@ func (ks *keyStore) storePrivateKey(alias string, privateKey interface{}) error
 		return err
 	}
 
-	err = ioutil.WriteFile(ks.node.conf.getPathForAlias(alias), rawKey, 0700)
+	err = utils.WriteFileAtomic(ks.node.conf.getPathForAlias(alias), rawKey, 0700)
 	if err != nil {
 		ks.node.Errorf("Failed storing private key [%s]: [%s]", alias, err)
 		return err
@@ -140,7 +148,7 @@ func (ks *keyStore) storePrivateKeyInClear(alias string, privateKey interface{})
 		return err
 	}
 
-	err = ioutil.WriteFile(ks.node.conf.getPathForAlias(alias), rawKey, 0700)
+	err = utils.WriteFileAtomic(ks.node.conf.getPathForAlias(alias), rawKey, 0700)
 	if err != nil {
 		ks.node.Errorf("Failed storing private key [%s]: [%s]", alias, err)
 		return err
@@ -181,7 +189,7 @@ func (ks *keyStore) storePublicKey(alias string, publicKey interface{}) error {
 		return err
 	}
 
-	err = ioutil.WriteFile(ks.node.conf.getPathForAlias(alias), rawKey, 0700)
+	err = utils.WriteFileAtomic(ks.node.conf.getPathForAlias(alias), rawKey, 0700)
 	if err != nil {
 		ks.node.Errorf("Failed storing private key [%s]: [%s]", alias, err)
 		return err
@@ -218,7 +226,7 @@ func (ks *keyStore) storeKey(alias string, key []byte) error {
 		return err
 	}
 
-	err = ioutil.WriteFile(ks.node.conf.getPathForAlias(alias), pem, 0700)
+	err = utils.WriteFileAtomic(ks.node.conf.getPathForAlias(alias), pem, 0700)
 	if err != nil {
 		ks.node.Errorf("Failed storing key [%s]: [%s]", alias, err)
 		return err
@@ -249,7 +257,7 @@ func (ks *keyStore) loadKey(alias string) ([]byte, error) {
 }
 
 func (ks *keyStore) storeCert(alias string, der []byte) error {
-	err := ioutil.WriteFile(ks.node.conf.getPathForAlias(alias), primitives.DERCertToPEM(der), 0700)
+	err := utils.WriteFileAtomic(ks.node.conf.getPathForAlias(alias), primitives.DERCertToPEM(der), ks.node.conf.getCertFilePerm())
 	if err != nil {
 		ks.node.Errorf("Failed storing certificate [%s]: [%s]", alias, err)
 		return err
@@ -16,38 +16,264 @@ limitations under the License.
 
 package crypto
 
-func (node *nodeImpl) prependPrefix(args []interface{}) []interface{} {
-	return append([]interface{}{node.conf.logPrefix}, args...)
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	"github.com/op/go-logging"
+)
+
+// Logger receives the log messages of the nodes, with the fields attached to them
+// if any. It lets the applications embedding this package route its logs through
+// their own logging library.
+type Logger interface {
+	Debug(msg string, fields map[string]interface{})
+	Info(msg string, fields map[string]interface{})
+	Warning(msg string, fields map[string]interface{})
+	Error(msg string, fields map[string]interface{})
+}
+
+// LevelLogger is a Logger telling which levels it records. The nodes skip
+// formatting the messages of the other levels.
+type LevelLogger interface {
+	Logger
+	IsEnabledFor(level logging.Level) bool
+}
+
+// goLogger logs to the crypto logger, the fields appended to the message
+type goLogger struct{}
+
+func (goLogger) IsEnabledFor(level logging.Level) bool {
+	return log.IsEnabledFor(level)
+}
+
+func (goLogger) Debug(msg string, fields map[string]interface{}) {
+	log.Debugf("%s%s", msg, formatLogFields(fields))
+}
+
+func (goLogger) Info(msg string, fields map[string]interface{}) {
+	log.Infof("%s%s", msg, formatLogFields(fields))
+}
+
+func (goLogger) Warning(msg string, fields map[string]interface{}) {
+	log.Warningf("%s%s", msg, formatLogFields(fields))
+}
+
+func (goLogger) Error(msg string, fields map[string]interface{}) {
+	log.Errorf("%s%s", msg, formatLogFields(fields))
+}
+
+// SetLogger sets the Logger of the node. Passing nil restores the crypto logger.
+func (node *nodeImpl) SetLogger(logger Logger) {
+	node.loggerMutex.Lock()
+	defer node.loggerMutex.Unlock()
+
+	node.logger = logger
+}
+
+func (node *nodeImpl) getLogger() Logger {
+	node.loggerMutex.RLock()
+	defer node.loggerMutex.RUnlock()
+
+	if node.logger == nil {
+		return goLogger{}
+	}
+
+	return node.logger
+}
+
+// loggerFor returns the Logger of the node if it records level, nil otherwise
+func (node *nodeImpl) loggerFor(level logging.Level) Logger {
+	logger := node.getLogger()
+	if leveled, ok := logger.(LevelLogger); ok && !leveled.IsEnabledFor(level) {
+		return nil
+	}
+
+	return logger
+}
+
+// sprint formats args as the crypto logger does, separated by spaces
+func (node *nodeImpl) sprint(args []interface{}) string {
+	return strings.TrimSuffix(fmt.Sprintln(append([]interface{}{node.conf.logPrefix}, args...)...), "\n")
 }
 
 func (node *nodeImpl) Infof(format string, args ...interface{}) {
-	log.Infof(node.conf.logPrefix+format, args...)
+	if logger := node.loggerFor(logging.INFO); logger != nil {
+		logger.Info(node.conf.logPrefix+fmt.Sprintf(format, args...), nil)
+	}
 }
 
 func (node *nodeImpl) Info(args ...interface{}) {
-	log.Info(node.prependPrefix(args)...)
+	if logger := node.loggerFor(logging.INFO); logger != nil {
+		logger.Info(node.sprint(args), nil)
+	}
 }
 
 func (node *nodeImpl) Debugf(format string, args ...interface{}) {
-	log.Debugf(node.conf.logPrefix+format, args...)
+	if logger := node.loggerFor(logging.DEBUG); logger != nil {
+		logger.Debug(node.conf.logPrefix+fmt.Sprintf(format, args...), nil)
+	}
 }
 
 func (node *nodeImpl) Debug(args ...interface{}) {
-	log.Debug(node.prependPrefix(args)...)
+	if logger := node.loggerFor(logging.DEBUG); logger != nil {
+		logger.Debug(node.sprint(args), nil)
+	}
 }
 
 func (node *nodeImpl) Errorf(format string, args ...interface{}) {
-	log.Errorf(node.conf.logPrefix+format, args...)
+	if logger := node.loggerFor(logging.ERROR); logger != nil {
+		logger.Error(node.conf.logPrefix+fmt.Sprintf(format, args...), nil)
+	}
 }
 
 func (node *nodeImpl) Error(args ...interface{}) {
-	log.Error(node.prependPrefix(args)...)
+	if logger := node.loggerFor(logging.ERROR); logger != nil {
+		logger.Error(node.sprint(args), nil)
+	}
 }
 
 func (node *nodeImpl) Warningf(format string, args ...interface{}) {
-	log.Warningf(node.conf.logPrefix+format, args...)
+	if logger := node.loggerFor(logging.WARNING); logger != nil {
+		logger.Warning(node.conf.logPrefix+fmt.Sprintf(format, args...), nil)
+	}
 }
 
 func (node *nodeImpl) Warning(args ...interface{}) {
-	log.Warning(node.prependPrefix(args)...)
+	if logger := node.loggerFor(logging.WARNING); logger != nil {
+		logger.Warning(node.sprint(args), nil)
+	}
+}
+
+// logID returns the identity id to log. If security.redactsensitivelogs is set,
+// the id is replaced by a prefix of its SHA-256 hash, still correlating the
+// messages of an identity.
+func (node *nodeImpl) logID(id string) string {
+	if !node.conf.getRedactSensitiveLogs() {
+		return id
+	}
+	hash := sha256.Sum256([]byte(id))
+
+	return "sha256:" + hex.EncodeToString(hash[:6])
+}
+
+// logCert returns the raw certificate to log, or a placeholder if
+// security.redactsensitivelogs is set
+func (node *nodeImpl) logCert(raw []byte) string {
+	if node.conf.getRedactSensitiveLogs() {
+		return "<redacted>"
+	}
+
+	return fmt.Sprintf("% x", raw)
+}
+
+// logEntry attaches key/value fields to the log messages of a node,
+// so that they can be parsed by log aggregators.
+type logEntry struct {
+	node   *nodeImpl
+	fields map[string]interface{}
+}
+
+// WithField returns a log entry carrying the passed field
+func (node *nodeImpl) WithField(key string, value interface{}) *logEntry {
+	return (&logEntry{node: node}).WithField(key, value)
+}
+
+// ecaLog returns a log entry for the ECA related messages
+func (node *nodeImpl) ecaLog() *logEntry {
+	return node.WithField("component", "eca").WithField("eca_addr", strings.Join(node.conf.getECAPAddrs(), ","))
+}
+
+// WithField returns a copy of the entry carrying also the passed field
+func (entry *logEntry) WithField(key string, value interface{}) *logEntry {
+	fields := make(map[string]interface{}, len(entry.fields)+1)
+	for k, v := range entry.fields {
+		fields[k] = v
+	}
+	fields[key] = value
+
+	return &logEntry{node: entry.node, fields: fields}
+}
+
+// WithError returns a copy of the entry carrying also the err field
+func (entry *logEntry) WithError(err error) *logEntry {
+	return entry.WithField("err", utils.ErrToString(err))
+}
+
+func (entry *logEntry) String() string {
+	return formatLogFields(entry.fields)
+}
+
+// formatLogFields formats fields as space separated key=value pairs, sorted by key
+func formatLogFields(fields map[string]interface{}) string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	for _, k := range keys {
+		value := fmt.Sprint(fields[k])
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(&buf, " %s=%s", k, value)
+	}
+
+	return buf.String()
+}
+
+func (entry *logEntry) Infof(format string, args ...interface{}) {
+	if logger := entry.node.loggerFor(logging.INFO); logger != nil {
+		logger.Info(entry.node.conf.logPrefix+fmt.Sprintf(format, args...), entry.fields)
+	}
+}
+
+func (entry *logEntry) Info(args ...interface{}) {
+	if logger := entry.node.loggerFor(logging.INFO); logger != nil {
+		logger.Info(entry.node.conf.logPrefix+fmt.Sprint(args...), entry.fields)
+	}
+}
+
+func (entry *logEntry) Debugf(format string, args ...interface{}) {
+	if logger := entry.node.loggerFor(logging.DEBUG); logger != nil {
+		logger.Debug(entry.node.conf.logPrefix+fmt.Sprintf(format, args...), entry.fields)
+	}
+}
+
+func (entry *logEntry) Debug(args ...interface{}) {
+	if logger := entry.node.loggerFor(logging.DEBUG); logger != nil {
+		logger.Debug(entry.node.conf.logPrefix+fmt.Sprint(args...), entry.fields)
+	}
+}
+
+func (entry *logEntry) Errorf(format string, args ...interface{}) {
+	if logger := entry.node.loggerFor(logging.ERROR); logger != nil {
+		logger.Error(entry.node.conf.logPrefix+fmt.Sprintf(format, args...), entry.fields)
+	}
+}
+
+func (entry *logEntry) Error(args ...interface{}) {
+	if logger := entry.node.loggerFor(logging.ERROR); logger != nil {
+		logger.Error(entry.node.conf.logPrefix+fmt.Sprint(args...), entry.fields)
+	}
+}
+
+func (entry *logEntry) Warningf(format string, args ...interface{}) {
+	if logger := entry.node.loggerFor(logging.WARNING); logger != nil {
+		logger.Warning(entry.node.conf.logPrefix+fmt.Sprintf(format, args...), entry.fields)
+	}
+}
+
+func (entry *logEntry) Warning(args ...interface{}) {
+	if logger := entry.node.loggerFor(logging.WARNING); logger != nil {
+		logger.Warning(entry.node.conf.logPrefix+fmt.Sprint(args...), entry.fields)
+	}
 }
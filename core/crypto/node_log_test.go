@@ -0,0 +1,175 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/op/go-logging"
+)
+
+func TestLogEntryFields(t *testing.T) {
+	node := &nodeImpl{conf: &configuration{}}
+
+	entry := node.WithField("component", "eca").WithField("user_id", "alice")
+	if s := entry.String(); s != " component=eca user_id=alice" {
+		t.Fatalf("Invalid fields [%s]", s)
+	}
+
+	// Values with spaces are quoted and parents are not modified
+	withErr := entry.WithError(errors.New("connection refused"))
+	if s := withErr.String(); s != ` component=eca err="connection refused" user_id=alice` {
+		t.Fatalf("Invalid fields [%s]", s)
+	}
+	if s := entry.String(); s != " component=eca user_id=alice" {
+		t.Fatalf("Parent entry must not be modified [%s]", s)
+	}
+}
+
+type loggedMessage struct {
+	level  string
+	msg    string
+	fields map[string]interface{}
+}
+
+// recordingLogger keeps the messages it receives
+type recordingLogger struct {
+	messages []loggedMessage
+}
+
+func (l *recordingLogger) Debug(msg string, fields map[string]interface{}) {
+	l.messages = append(l.messages, loggedMessage{"debug", msg, fields})
+}
+
+func (l *recordingLogger) Info(msg string, fields map[string]interface{}) {
+	l.messages = append(l.messages, loggedMessage{"info", msg, fields})
+}
+
+func (l *recordingLogger) Warning(msg string, fields map[string]interface{}) {
+	l.messages = append(l.messages, loggedMessage{"warning", msg, fields})
+}
+
+func (l *recordingLogger) Error(msg string, fields map[string]interface{}) {
+	l.messages = append(l.messages, loggedMessage{"error", msg, fields})
+}
+
+func TestSetLogger(t *testing.T) {
+	node := &nodeImpl{conf: &configuration{logPrefix: "[client.test] "}}
+	logger := &recordingLogger{}
+	node.SetLogger(logger)
+
+	node.Debugf("Loading [%s]...", "key")
+	node.Info("Enrolled", "user")
+	node.ecaLog().WithField("user_id", "alice").WithError(errors.New("ECA down")).Error("Failed enrolling.")
+
+	if len(logger.messages) != 3 {
+		t.Fatalf("Expected 3 messages, got [%d]", len(logger.messages))
+	}
+	if m := logger.messages[0]; m.level != "debug" || m.msg != "[client.test] Loading [key]..." || m.fields != nil {
+		t.Fatalf("Unexpected message [%+v]", m)
+	}
+	if m := logger.messages[1]; m.level != "info" || m.msg != "[client.test]  Enrolled user" {
+		t.Fatalf("Unexpected message [%+v]", m)
+	}
+	m := logger.messages[2]
+	if m.level != "error" || m.msg != "[client.test] Failed enrolling." {
+		t.Fatalf("Unexpected message [%+v]", m)
+	}
+	if m.fields["component"] != "eca" || m.fields["user_id"] != "alice" || m.fields["err"] != "ECA down" {
+		t.Fatalf("Unexpected fields [%v]", m.fields)
+	}
+
+	// The crypto logger is used again
+	node.SetLogger(nil)
+	node.Debug("Not recorded")
+	if len(logger.messages) != 3 {
+		t.Fatal("Messages must not be sent to the removed logger")
+	}
+}
+
+// warningLogger records only the warnings and the errors
+type warningLogger struct {
+	recordingLogger
+}
+
+func (l *warningLogger) IsEnabledFor(level logging.Level) bool {
+	return level <= logging.WARNING
+}
+
+// countingStringer counts how many times it is formatted
+type countingStringer struct {
+	calls int
+}
+
+func (s *countingStringer) String() string {
+	s.calls++
+
+	return "formatted"
+}
+
+func TestLogLevelSkipsFormatting(t *testing.T) {
+	node := &nodeImpl{conf: &configuration{}}
+	logger := &warningLogger{}
+	node.SetLogger(logger)
+
+	arg := &countingStringer{}
+	node.Debugf("Loading [%s]...", arg)
+	node.Info(arg)
+	node.ecaLog().WithField("user_id", arg).Debugf("Enrolling [%s]...", arg)
+	if arg.calls != 0 || len(logger.messages) != 0 {
+		t.Fatalf("Messages of disabled levels must not be formatted, formatted [%d] times", arg.calls)
+	}
+
+	node.Warningf("Failed loading [%s]", arg)
+	if arg.calls != 1 || len(logger.messages) != 1 {
+		t.Fatalf("Messages of enabled levels must be logged, formatted [%d] times", arg.calls)
+	}
+}
+
+func TestRedactSensitiveLogs(t *testing.T) {
+	node := &nodeImpl{conf: &configuration{logPrefix: "[peer.test] "}}
+	logger := &recordingLogger{}
+	node.SetLogger(logger)
+
+	if id := node.logID("alice"); id != "alice" {
+		t.Fatalf("Ids must be logged as is by default, got [%s]", id)
+	}
+	if raw := node.logCert([]byte{0xca, 0xfe}); raw != "ca fe" {
+		t.Fatalf("Certificates must be logged as is by default, got [%s]", raw)
+	}
+
+	node.conf.redactLogs = true
+	id := node.logID("alice")
+	if strings.Contains(id, "alice") || !strings.HasPrefix(id, "sha256:") {
+		t.Fatalf("The id must be replaced by a hash prefix, got [%s]", id)
+	}
+	if node.logID("alice") != id || node.logID("bob") == id {
+		t.Fatal("The redacted ids must still tell the identities apart")
+	}
+	if raw := node.logCert([]byte{0xca, 0xfe}); strings.Contains(raw, "ca fe") {
+		t.Fatalf("Certificates must not be logged, got [%s]", raw)
+	}
+
+	node.ecaLog().WithField("user_id", node.logID("alice")).Debug("Retrieving ECA certificate...")
+	for _, m := range logger.messages {
+		if strings.Contains(m.msg, "alice") || m.fields["user_id"] == "alice" {
+			t.Fatalf("The id leaked in [%+v]", m)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"sync"
+	"time"
+)
+
+// Metrics receives observations of the interactions with the ECA.
+// Dial failures are reported apart from call failures, so that
+// a CA outage can be told apart from rejected requests.
+type Metrics interface {
+	// ObserveEnrollment is invoked at the end of each enrollment
+	ObserveEnrollment(duration time.Duration, err error)
+
+	// ObserveECACall is invoked after each call to the ECA
+	ObserveECACall(method string, duration time.Duration, err error)
+
+	// ObserveECADialFailure is invoked when the ECA cannot be dialed
+	ObserveECADialFailure(err error)
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) ObserveEnrollment(duration time.Duration, err error) {}
+
+func (noopMetrics) ObserveECACall(method string, duration time.Duration, err error) {}
+
+func (noopMetrics) ObserveECADialFailure(err error) {}
+
+var (
+	metrics      Metrics = noopMetrics{}
+	metricsMutex sync.RWMutex
+)
+
+// SetMetrics sets the Metrics used by all the nodes. Passing nil restores the no-op default.
+func SetMetrics(m Metrics) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	if m == nil {
+		m = noopMetrics{}
+	}
+	metrics = m
+}
+
+func getMetrics() Metrics {
+	metricsMutex.RLock()
+	defer metricsMutex.RUnlock()
+
+	return metrics
+}
@@ -0,0 +1,87 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"os"
+	"sync"
+	"syscall"
+)
+
+var (
+	pathLocks      = make(map[string]*pathLock)
+	pathLocksMutex sync.Mutex
+)
+
+// pathLock is the lock of a path within the process, dropped from pathLocks
+// once no caller holds or waits for it
+type pathLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lockPath locks path, so that the nodes sharing a keystore don't interleave
+// their reads and writes of the same file. The nodes of the process are
+// serialized in memory, the processes with flock on path.lock, kept next to
+// path. If the lock file cannot be used, path is locked within the process only.
+// It returns the function releasing the lock.
+func lockPath(path string) (unlock func()) {
+	pathLocksMutex.Lock()
+	lock, ok := pathLocks[path]
+	if !ok {
+		lock = &pathLock{}
+		pathLocks[path] = lock
+	}
+	lock.refs++
+	pathLocksMutex.Unlock()
+
+	lock.Lock()
+	file := lockFile(path + ".lock")
+
+	return func() {
+		if file != nil {
+			syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+			file.Close()
+		}
+		lock.Unlock()
+
+		pathLocksMutex.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(pathLocks, path)
+		}
+		pathLocksMutex.Unlock()
+	}
+}
+
+// lockFile opens and flocks the lock file at path, nil if it fails
+func lockFile(path string) *os.File {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		log.Warningf("Failed opening lock file [%s]: [%s]. Locking within this process only.", path, err)
+
+		return nil
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		log.Warningf("Failed locking [%s]: [%s]. Locking within this process only.", path, err)
+		file.Close()
+
+		return nil
+	}
+
+	return file
+}
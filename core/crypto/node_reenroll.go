@@ -0,0 +1,193 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"os"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	membersrvc "github.com/hyperledger/fabric/membersrvc/protos"
+	"golang.org/x/net/context"
+)
+
+// reEnroll requests a new enrollment certificate for a fresh key and replaces
+// the stored enrollment key and certificate with them. The ECA, that issues the
+// enrollment certificates of an identity only once, authenticates the request with
+// the current enrollment key instead of the password. The old materials are kept
+// until the new certificate has been verified and stored, so that a failure in the
+// middle of the rotation leaves the node enrolled as before.
+func (node *nodeImpl) reEnroll(ctx context.Context, id string) error {
+	node.reEnrollMutex.Lock()
+	defer node.reEnrollMutex.Unlock()
+
+	if id != node.enrollID {
+		node.Errorf("Cannot re-enroll [%s]: node enrolled as [%s].", node.logID(id), node.logID(node.enrollID))
+
+		return fmt.Errorf("Node enrolled as [%s], not [%s].", node.enrollID, id)
+	}
+
+	ecertKey := node.getEnrollmentKey()
+	if ecertKey == nil {
+		return fmt.Errorf("reEnroll: %w", utils.ErrNotInitialized)
+	}
+
+	node.Debugf("Re-enrolling [%s]...", node.logID(id))
+
+	// The enrollment protocol sets the intermediates used to verify the new certificate
+	oldIntermediates := node.getECertIntermediates()
+
+	key, certRaw, intermediates, _, err := node.getReEnrollmentCertificateFromECA(ctx, id, ecertKey)
+	if err != nil {
+		node.setECertIntermediates(oldIntermediates)
+		node.Errorf("Failed getting new enrollment certificate [id=%s]: [%s]", node.logID(id), err)
+
+		return err
+	}
+
+	if err := node.swapEnrollmentData(key, certRaw, intermediates); err != nil {
+		node.setECertIntermediates(oldIntermediates)
+		node.Errorf("Failed storing new enrollment data [id=%s]: [%s]", node.logID(id), err)
+
+		return err
+	}
+
+	node.revocationMutex.Lock()
+	node.reEnrollmentRequired = false
+	node.revocationMutex.Unlock()
+
+	node.notifyEnrolled(certRaw)
+
+	node.Debugf("Re-enrolling [%s]...done! New enrollCertHash [% x].", node.logID(id), primitives.Hash(certRaw))
+
+	return nil
+}
+
+// RotateEnrollmentKey re-enrolls the node with a fresh key, keeping its identity: the
+// enrollment id is the one the node is enrolled with, and the attributes requested are
+// the ones the current enrollment certificate carries, whatever SetEnrollmentAttributes set,
+// if the ECA supports attributes.
+// The request is authenticated by the current enrollment key, no password is needed.
+// As for a renewal, the stored key and certificate are replaced only once the new certificate
+// has been verified, and kept if the rotation fails.
+func (node *nodeImpl) RotateEnrollmentKey(ctx context.Context) error {
+	enrollCert := node.getEnrollmentCertificate()
+	if enrollCert == nil || node.enrollID == "" {
+		return fmt.Errorf("RotateEnrollmentKey: %w", utils.ErrNotInitialized)
+	}
+
+	node.Infof("Rotating enrollment key of [%s]...", node.logID(node.enrollID))
+
+	// There is nothing to keep if the ECA sets the extensions itself
+	var attrs []*membersrvc.ECertAttribute
+	if node.conf.getECAAttributes() {
+		attrs = certEnrollmentAttributes(enrollCert)
+	}
+	if err := node.reEnroll(withEnrollmentAttributes(ctx, attrs), node.enrollID); err != nil {
+		node.Errorf("Failed rotating enrollment key [%s].", err.Error())
+
+		return fmt.Errorf("RotateEnrollmentKey: %w", err)
+	}
+
+	node.Infof("Rotating enrollment key of [%s]...done!", node.logID(node.enrollID))
+
+	return nil
+}
+
+// swapEnrollmentData replaces the stored enrollment key, certificate and
+// intermediate certificates, restoring the old ones if any step fails.
+func (node *nodeImpl) swapEnrollmentData(key interface{}, certRaw []byte, intermediates [][]byte) error {
+	priv, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		node.Error("The enrollment key is held by a PKCS#11 token and cannot be stored.")
+
+		return utils.ErrPKCS11NotAvailable
+	}
+
+	cert, err := primitives.DERToX509Certificate(certRaw)
+	if err != nil {
+		node.Errorf("Failed parsing new enrollment certificate [%s].", err.Error())
+
+		return err
+	}
+
+	keyPEM, err := node.getEnrollmentKeySealer(node.getEnrollmentKeyPassphrase()).Seal(priv)
+	if err != nil {
+		node.Errorf("Failed sealing enrollment key [%s].", err.Error())
+
+		return err
+	}
+
+	keyPath := node.conf.getPathForAlias(node.conf.getEnrollmentKeyFilename())
+	certPath := node.conf.getPathForAlias(node.conf.getEnrollmentCertFilename())
+
+	// Stage the new materials next to the old ones
+	if err := utils.WriteFileAtomic(keyPath+".new", keyPEM, 0600); err != nil {
+		return err
+	}
+	defer os.Remove(keyPath + ".new")
+	if err := node.ks.storeCert(node.conf.getEnrollmentCertFilename()+".new", certRaw); err != nil {
+		return err
+	}
+	defer os.Remove(certPath + ".new")
+
+	// Back up the old materials, then move the new ones in place
+	var restore []func()
+	rollback := func() {
+		for i := len(restore) - 1; i >= 0; i-- {
+			restore[i]()
+		}
+	}
+	for _, path := range []string{keyPath, certPath} {
+		if err := os.Rename(path, path+".old"); err != nil {
+			rollback()
+			return err
+		}
+		p := path
+		restore = append(restore, func() { os.Rename(p+".old", p) })
+
+		if err := os.Rename(path+".new", path); err != nil {
+			rollback()
+			return err
+		}
+	}
+
+	if len(intermediates) != 0 {
+		name := node.conf.getECertIntermediatesFilename()
+		old, getErr := node.certStore.Get(name)
+
+		if err := node.certStore.Put(name, node.encodeCerts(intermediates...)); err != nil {
+			if getErr == nil {
+				node.certStore.Put(name, old)
+			}
+			rollback()
+			return err
+		}
+	}
+
+	os.Remove(keyPath + ".old")
+	os.Remove(certPath + ".old")
+
+	node.enrollDataMutex.Lock()
+	node.enrollPrivKey = priv
+	node.setEnrollmentCertificate(cert, certRaw)
+	node.enrollDataMutex.Unlock()
+
+	return nil
+}
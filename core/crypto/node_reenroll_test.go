@@ -0,0 +1,336 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/testutil"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	membersrvc "github.com/hyperledger/fabric/membersrvc/protos"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// failingCertStore fails every Put
+type failingCertStore struct {
+	CertStore
+}
+
+func (store *failingCertStore) Put(name string, pem []byte) error {
+	return errors.New("Cert store failure")
+}
+
+func newTestReEnrollNode(t *testing.T) (*nodeImpl, []byte, func()) {
+	node, cleanup := newTestECANode(t, &fakeECAPClient{createErrs: []error{errors.New("Identity already enrolled.")}})
+	node.ks = &keyStore{node: node}
+	node.certStore = &fileCertStore{node}
+	node.conf.securityLevel = 256
+	node.conf.hashAlgorithm = "SHA3"
+	node.conf.ecaEnrollmentTimeout = time.Second
+	node.enrollID = "user"
+
+	certRaw, priv, err := primitives.NewSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Failed generating certificate [%s]", err)
+	}
+	if err := node.storeEnrollmentKey(priv, nil); err != nil {
+		t.Fatalf("Failed storing enrollment key [%s]", err)
+	}
+	if err := node.ks.storeCert(node.conf.getEnrollmentCertFilename(), certRaw); err != nil {
+		t.Fatalf("Failed storing enrollment certificate [%s]", err)
+	}
+	node.enrollPrivKey = priv.(*ecdsa.PrivateKey)
+	if node.enrollCert, err = primitives.DERToX509Certificate(certRaw); err != nil {
+		t.Fatalf("Failed parsing certificate [%s]", err)
+	}
+
+	return node, certRaw, cleanup
+}
+
+func readEnrollmentCert(t *testing.T, node *nodeImpl) []byte {
+	raw, err := ioutil.ReadFile(node.conf.getPathForAlias(node.conf.getEnrollmentCertFilename()))
+	if err != nil {
+		t.Fatalf("Failed reading enrollment certificate [%s]", err)
+	}
+	return raw
+}
+
+func TestReEnrollFailureKeepsOldMaterials(t *testing.T) {
+	node, oldCert, cleanup := newTestReEnrollNode(t)
+	defer cleanup()
+
+	if err := node.reEnroll(context.Background(), "other"); err == nil {
+		t.Fatal("Re-enrolling a different identity must fail")
+	}
+
+	if err := node.reEnroll(context.Background(), "user"); err == nil {
+		t.Fatal("Re-enrollment must fail when the ECA rejects the request")
+	}
+	if !bytes.Equal(readEnrollmentCert(t, node), primitives.DERCertToPEM(oldCert)) {
+		t.Fatal("Enrollment certificate changed by a failed re-enrollment")
+	}
+
+	// A failure while swapping restores the old materials
+	certRaw, priv, err := primitives.NewSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Failed generating certificate [%s]", err)
+	}
+	node.certStore = &failingCertStore{node.certStore}
+	if err := node.swapEnrollmentData(priv, certRaw, [][]byte{certRaw}); err == nil {
+		t.Fatal("Swapping must fail when the intermediates cannot be stored")
+	}
+	if !bytes.Equal(readEnrollmentCert(t, node), primitives.DERCertToPEM(oldCert)) {
+		t.Fatal("Enrollment certificate not restored after a failed swap")
+	}
+	if _, err := node.loadEnrollmentKeyWithPassphrase(nil); err != nil {
+		t.Fatalf("Enrollment key not restored after a failed swap [%s]", err)
+	}
+}
+
+func TestRotateEnrollmentKey(t *testing.T) {
+	eca, err := testutil.NewFakeECA(testutil.FakeECAOptions{})
+	if err != nil {
+		t.Fatalf("Failed starting fake ECA [%s]", err)
+	}
+	defer eca.Close()
+	node, cleanup := newFakeECANode(t, eca)
+	defer cleanup()
+	node.ks = &keyStore{node: node}
+
+	if err := node.RotateEnrollmentKey(context.Background()); !errors.Is(err, utils.ErrNotInitialized) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrNotInitialized, err)
+	}
+
+	if err := node.retrieveECACertsChain("user"); err != nil {
+		t.Fatalf("Failed retrieving ECA certificates chain [%s]", err)
+	}
+	if err := node.loadECACertsChain(context.Background()); err != nil {
+		t.Fatalf("Failed loading ECA certificates chain [%s]", err)
+	}
+	if err := node.SetEnrollmentAttributes(map[string][]byte{"1.2.3.4": []byte("role")}); err != nil {
+		t.Fatalf("Failed setting enrollment attributes [%s]", err)
+	}
+	if _, err := node.retrieveEnrollmentData(context.Background(), "user", "pw"); err != nil {
+		t.Fatalf("Failed enrolling [%s]", err)
+	}
+	if err := node.loadEnrollmentID(); err != nil {
+		t.Fatalf("Failed loading enrollment id [%s]", err)
+	}
+	if err := node.loadEnrollmentKey(); err != nil {
+		t.Fatalf("Failed loading enrollment key [%s]", err)
+	}
+	if err := node.loadEnrollmentCertificate(); err != nil {
+		t.Fatalf("Failed loading enrollment certificate [%s]", err)
+	}
+	oldKey := node.enrollPrivKey
+
+	// The attributes come from the current certificate, not from the node settings
+	if err := node.SetEnrollmentAttributes(nil); err != nil {
+		t.Fatalf("Failed clearing enrollment attributes [%s]", err)
+	}
+	if err := node.RotateEnrollmentKey(context.Background()); err != nil {
+		t.Fatalf("Failed rotating enrollment key [%s]", err)
+	}
+
+	if node.enrollPrivKey.D.Cmp(oldKey.D) == 0 {
+		t.Fatal("The enrollment key must be replaced")
+	}
+	if node.enrollCert.Subject.CommonName != "user" {
+		t.Fatalf("Expected an enrollment certificate for [user], got [%s]", node.enrollCert.Subject.CommonName)
+	}
+	attrs := certEnrollmentAttributes(node.enrollCert)
+	if len(attrs) != 1 || attrs[0].Oid != "1.2.3.4" || string(attrs[0].Value) != "role" {
+		t.Fatalf("The attributes of the enrollment certificate must be kept, got %v", attrs)
+	}
+	if !bytes.Equal(readEnrollmentCert(t, node), primitives.DERCertToPEM(node.enrollCert.Raw)) {
+		t.Fatal("The stored enrollment certificate must be the rotated one")
+	}
+	loaded, err := node.loadEnrollmentKeyWithPassphrase(nil)
+	if err != nil {
+		t.Fatalf("Failed loading rotated enrollment key [%s]", err)
+	}
+	if loaded.D.Cmp(node.enrollPrivKey.D) != 0 {
+		t.Fatal("The stored enrollment key must be the rotated one")
+	}
+}
+
+func TestSwapEnrollmentData(t *testing.T) {
+	node, _, cleanup := newTestReEnrollNode(t)
+	defer cleanup()
+
+	certRaw, priv, err := primitives.NewSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Failed generating certificate [%s]", err)
+	}
+	if err := node.swapEnrollmentData(priv, certRaw, nil); err != nil {
+		t.Fatalf("Failed swapping enrollment data [%s]", err)
+	}
+
+	if !bytes.Equal(readEnrollmentCert(t, node), primitives.DERCertToPEM(certRaw)) {
+		t.Fatal("Enrollment certificate not swapped")
+	}
+	loaded, err := node.loadEnrollmentKeyWithPassphrase(nil)
+	if err != nil {
+		t.Fatalf("Failed loading enrollment key [%s]", err)
+	}
+	if loaded.D.Cmp(priv.(*ecdsa.PrivateKey).D) != 0 {
+		t.Fatal("Enrollment key not swapped")
+	}
+	if !bytes.Equal(node.enrollCert.Raw, certRaw) || node.enrollPrivKey != priv {
+		t.Fatal("In-memory enrollment data not swapped")
+	}
+
+	for _, alias := range []string{node.conf.getEnrollmentKeyFilename(), node.conf.getEnrollmentCertFilename()} {
+		for _, suffix := range []string{".old", ".new"} {
+			if _, err := os.Stat(node.conf.getPathForAlias(alias) + suffix); !os.IsNotExist(err) {
+				t.Fatalf("Leftover file [%s%s]", alias, suffix)
+			}
+		}
+	}
+}
+
+func TestRenewIfNeeded(t *testing.T) {
+	node, certRaw, cleanup := newTestReEnrollNode(t)
+	defer cleanup()
+
+	// The certificate expires in one hour
+	node.conf.renewalThreshold = time.Minute
+	if attempted, _ := node.renewIfNeeded(context.Background()); attempted {
+		t.Fatal("Renewal must not be attempted before the threshold")
+	}
+
+	node.conf.renewalThreshold = 2 * time.Hour
+	if attempted, err := node.renewIfNeeded(context.Background()); !attempted || err == nil {
+		t.Fatal("Renewal must fail when the ECA rejects the request")
+	}
+	if !bytes.Equal(readEnrollmentCert(t, node), primitives.DERCertToPEM(certRaw)) {
+		t.Fatal("Enrollment certificate changed by a failed renewal")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if attempted, _ := node.renewIfNeeded(ctx); attempted {
+		t.Fatal("Renewal must not be attempted once the context is cancelled")
+	}
+}
+
+func TestStartCertRenewal(t *testing.T) {
+	node, _, cleanup := newTestReEnrollNode(t)
+	defer cleanup()
+	node.conf.renewalCheckInterval = time.Millisecond
+
+	if err := node.StartCertRenewal(context.Background()); err != nil {
+		t.Fatalf("Failed starting certificate renewal [%s]", err)
+	}
+	if err := node.StartCertRenewal(context.Background()); err == nil {
+		t.Fatal("Starting the certificate renewal twice must fail")
+	}
+
+	node.stopCertRenewal()
+	if err := node.StartCertRenewal(context.Background()); err != nil {
+		t.Fatalf("Failed restarting certificate renewal [%s]", err)
+	}
+	node.stopCertRenewal()
+}
+
+func TestStartCertRenewalStopsOnPermanentError(t *testing.T) {
+	node, _, cleanup := newTestReEnrollNode(t)
+	defer cleanup()
+	client := &fakeECAPClient{createErrs: []error{grpc.Errorf(codes.Unimplemented, "unknown method ReEnrollCertificatePair")}}
+	node.ecaClientFactory = func(addr string) (membersrvc.ECAPClient, closeFunc, error) {
+		return client, func() error { return nil }, nil
+	}
+	node.conf.renewalThreshold = 2 * time.Hour
+	node.conf.renewalCheckInterval = time.Millisecond
+
+	if err := node.StartCertRenewal(context.Background()); err != nil {
+		t.Fatalf("Failed starting certificate renewal [%s]", err)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		node.background.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		node.stopCertRenewal()
+		t.Fatal("The renewal must stop once the ECA does not support re-enrollment")
+	}
+
+	if client.createCalls != 1 {
+		t.Fatalf("The renewal must not be retried, got [%d] calls", client.createCalls)
+	}
+	// The renewal can be started again, once the ECA is upgraded
+	if err := node.StartCertRenewal(context.Background()); err != nil {
+		t.Fatalf("Failed restarting certificate renewal [%s]", err)
+	}
+	node.stopCertRenewal()
+}
+
+// flushingCertStore counts the flushes
+type flushingCertStore struct {
+	CertStore
+	flushes int
+}
+
+func (store *flushingCertStore) Flush() error {
+	store.flushes++
+
+	return nil
+}
+
+func TestNodeClose(t *testing.T) {
+	node, _, cleanup := newTestReEnrollNode(t)
+	defer cleanup()
+	node.conf.renewalCheckInterval = time.Millisecond
+	store := &flushingCertStore{CertStore: node.certStore}
+	node.certStore = store
+	// No keystore database has been opened
+	node.ks = nil
+
+	if err := node.StartCertRenewal(context.Background()); err != nil {
+		t.Fatalf("Failed starting certificate renewal [%s]", err)
+	}
+
+	if err := node.Close(); err != nil {
+		t.Fatalf("Failed closing node [%s]", err)
+	}
+	if node.renewalCancel != nil {
+		t.Fatal("Certificate renewal not stopped")
+	}
+	if store.flushes != 1 {
+		t.Fatalf("The cert store must be flushed once, got [%d]", store.flushes)
+	}
+
+	// Closing again does nothing
+	if err := node.close(); err != nil {
+		t.Fatalf("Failed closing node twice [%s]", err)
+	}
+	if store.flushes != 1 {
+		t.Fatalf("The cert store must be flushed once, got [%d]", store.flushes)
+	}
+}
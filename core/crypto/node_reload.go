@@ -0,0 +1,69 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/net/context"
+)
+
+// ECAChainReloader is implemented by the peers, clients and validators of this
+// package. Their ECA certificates chain can be reloaded without a restart.
+type ECAChainReloader interface {
+
+	// ReloadECAChain loads again the ECA certificates chain from the cert store
+	ReloadECAChain(ctx context.Context) error
+}
+
+// ReloadECAChainOnSignal reloads the ECA certificates chain of node each time the
+// process receives one of sigs, SIGHUP if none is passed. Failed reloads are logged
+// and the previous chain is kept. The returned function stops the reloads.
+func ReloadECAChainOnSignal(node ECAChainReloader, sigs ...os.Signal) (stop func()) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGHUP}
+	}
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+
+	// Stopping also aborts a reload in progress
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-ch:
+				log.Infof("Received [%s]. Reloading ECA certificates chain...", sig)
+
+				if err := node.ReloadECAChain(ctx); err != nil {
+					log.Errorf("Failed reloading ECA certificates chain [%s].", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(ch)
+		cancel()
+		close(done)
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"golang.org/x/net/context"
+)
+
+func TestReloadECAChain(t *testing.T) {
+	node, cleanup := newTestCertStoreNode(t, 0644)
+	defer cleanup()
+	node.certStore = NewMemCertStore()
+	name := node.conf.getECACertsChainFilename()
+
+	for i := 0; i < 2; i++ {
+		certRaw, _, err := primitives.NewSelfSignedCert()
+		if err != nil {
+			t.Fatalf("Failed generating certificate [%s]", err)
+		}
+		if err := node.certStore.Put(name, primitives.DERCertToPEM(certRaw)); err != nil {
+			t.Fatalf("Failed storing ECA certificates chain [%s]", err)
+		}
+
+		if err := node.ReloadECAChain(context.Background()); err != nil {
+			t.Fatalf("Failed reloading ECA certificates chain [%s]", err)
+		}
+		cert, _ := primitives.DERToX509Certificate(certRaw)
+		if !node.getECACert().Equal(cert) {
+			t.Fatal("The reloaded ECA certificate must replace the previous one")
+		}
+		if _, err := primitives.CheckCertAgainRoot(cert, node.getECACertPool()); err != nil {
+			t.Fatalf("The reloaded ECA certificate must be trusted [%s]", err)
+		}
+	}
+
+	// A broken chain leaves the previous one in use
+	cert, pool := node.getECACert(), node.getECACertPool()
+	if err := node.certStore.Put(name, []byte("not a certificate")); err != nil {
+		t.Fatalf("Failed storing ECA certificates chain [%s]", err)
+	}
+	if err := node.ReloadECAChain(context.Background()); err == nil {
+		t.Fatal("Reloading a broken ECA certificates chain must fail")
+	}
+	if node.getECACert() != cert || node.getECACertPool() != pool {
+		t.Fatal("The previous ECA certificates chain must be kept")
+	}
+}
+
+type countingReloader chan struct{}
+
+func (r countingReloader) ReloadECAChain(ctx context.Context) error {
+	r <- struct{}{}
+
+	return nil
+}
+
+func TestReloadECAChainOnSignal(t *testing.T) {
+	reloads := make(countingReloader, 1)
+	stop := ReloadECAChainOnSignal(reloads, syscall.SIGUSR1)
+	defer stop()
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatalf("Failed sending signal [%s]", err)
+	}
+
+	select {
+	case <-reloads:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The ECA certificates chain must be reloaded on signal")
+	}
+}
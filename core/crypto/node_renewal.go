@@ -0,0 +1,136 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"errors"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	"golang.org/x/net/context"
+)
+
+// StartCertRenewal starts checking the enrollment certificate expiry in background.
+// When the certificate expires within conf.getRenewalThreshold(), the node re-enrolls.
+// The check stops when ctx is done or the node is closed.
+func (node *nodeImpl) StartCertRenewal(ctx context.Context) error {
+	node.renewalMutex.Lock()
+	defer node.renewalMutex.Unlock()
+
+	if node.renewalCancel != nil {
+		return errors.New("Certificate renewal already started.")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	node.renewalCancel = cancel
+	node.renewalGen++
+	gen := node.renewalGen
+
+	interval := node.conf.getRenewalCheckInterval()
+	node.Debugf("Checking enrollment certificate expiry every [%s].", interval)
+
+	node.background.Add(1)
+	go func() {
+		defer node.background.Done()
+		defer node.clearCertRenewal(gen, cancel)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if _, err := node.renewIfNeeded(ctx); isPermanentRenewalError(err) {
+				node.Errorf("Certificate renewal stopped, re-enrolling again will fail in the same way [%s].", err)
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				node.Debug("Certificate renewal stopped.")
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return nil
+}
+
+// clearCertRenewal releases the renewal gen, once stopped, so that it can be started again.
+// A renewal started since then is left untouched.
+func (node *nodeImpl) clearCertRenewal(gen uint64, cancel context.CancelFunc) {
+	node.renewalMutex.Lock()
+	defer node.renewalMutex.Unlock()
+
+	cancel()
+	if node.renewalGen == gen {
+		node.renewalCancel = nil
+	}
+}
+
+func (node *nodeImpl) stopCertRenewal() {
+	node.renewalMutex.Lock()
+	defer node.renewalMutex.Unlock()
+
+	if node.renewalCancel != nil {
+		node.renewalCancel()
+		node.renewalCancel = nil
+	}
+}
+
+// renewIfNeeded re-enrolls if the enrollment certificate expires within the
+// renewal threshold. It returns whether a renewal was attempted, and its outcome.
+func (node *nodeImpl) renewIfNeeded(ctx context.Context) (bool, error) {
+	enrollCert := node.getEnrollmentCertificate()
+	if ctx.Err() != nil || enrollCert == nil {
+		return false, nil
+	}
+
+	notAfter := enrollCert.NotAfter
+	if notAfter.Sub(node.now()) > node.conf.getRenewalThreshold() {
+		return false, nil
+	}
+
+	node.Infof("Enrollment certificate expires at [%s]. Renewing...", notAfter)
+
+	if err := node.reEnroll(ctx, node.enrollID); err != nil {
+		node.Errorf("Failed renewing the enrollment certificate [%s].", err)
+
+		return true, err
+	}
+
+	node.Infof("Enrollment certificate renewed. Expires at [%s].", node.getEnrollmentCertificate().NotAfter)
+
+	return true, nil
+}
+
+// isPermanentRenewalError returns true if err signals that renewing again
+// cannot succeed, no matter how many times it is retried.
+func isPermanentRenewalError(err error) bool {
+	for _, permanent := range []error{
+		utils.ErrReEnrollmentNotSupported,
+		utils.ErrEnrollmentAuthFailed,
+		utils.ErrInvalidEnrollmentRequest,
+		utils.ErrEnrollmentAttributesNotSupported,
+		utils.ErrNotInitialized,
+	} {
+		if errors.Is(err, permanent) {
+			return true
+		}
+	}
+
+	return false
+}
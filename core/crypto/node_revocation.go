@@ -0,0 +1,197 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	"golang.org/x/crypto/ocsp"
+)
+
+// SetEnrollmentCertRevokedHandler sets the function invoked when the periodic
+// revocation check finds the enrollment certificate revoked.
+func (node *nodeImpl) SetEnrollmentCertRevokedHandler(handler func(err error)) {
+	node.revocationMutex.Lock()
+	defer node.revocationMutex.Unlock()
+
+	node.onEnrollmentCertRevoked = handler
+}
+
+func (node *nodeImpl) isReEnrollmentRequired() bool {
+	node.revocationMutex.Lock()
+	defer node.revocationMutex.Unlock()
+
+	return node.reEnrollmentRequired
+}
+
+// checkECertRevocation asks the OCSP responders listed in cert whether it has been revoked.
+// It returns utils.ErrEnrollmentCertRevoked if so.
+func (node *nodeImpl) checkECertRevocation(cert *x509.Certificate) error {
+	if len(cert.OCSPServer) == 0 {
+		node.Debug("No OCSP responder in the enrollment certificate. Skipping revocation check.")
+
+		return nil
+	}
+	ecaCert := node.getECACert()
+	if ecaCert == nil {
+		return errors.New("ECA certificate not loaded. Cannot check the enrollment certificate revocation.")
+	}
+
+	req, err := ocsp.CreateRequest(cert, ecaCert, nil)
+	if err != nil {
+		node.Errorf("Failed creating OCSP request [%s].", err.Error())
+
+		return err
+	}
+
+	client := &http.Client{Timeout: node.conf.getOCSPTimeout()}
+
+	var lastErr error
+	for _, server := range cert.OCSPServer {
+		resp, err := node.queryOCSPResponder(client, server, req, cert, ecaCert)
+		if err != nil {
+			node.Warningf("Failed querying OCSP responder [%s]: [%s]", server, err)
+			lastErr = err
+			continue
+		}
+
+		switch resp.Status {
+		case ocsp.Good:
+			node.Debugf("Enrollment certificate not revoked according to [%s].", server)
+
+			return nil
+		case ocsp.Revoked:
+			node.Errorf("Enrollment certificate revoked at [%s] according to [%s].", resp.RevokedAt, server)
+
+			return utils.ErrEnrollmentCertRevoked
+		default:
+			lastErr = fmt.Errorf("OCSP responder [%s] doesn't know the enrollment certificate", server)
+		}
+	}
+
+	return lastErr
+}
+
+// queryOCSPResponder posts req to server and returns the status of cert in the response.
+// The response must be signed by issuer, or by a responder it delegated, and be current.
+func (node *nodeImpl) queryOCSPResponder(client *http.Client, server string, req []byte, cert, issuer *x509.Certificate) (*ocsp.Response, error) {
+	httpResp, err := client.Post(server, "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected HTTP status [%s]", httpResp.Status)
+	}
+
+	der, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := ocsp.ParseResponseForCert(der, cert, issuer)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Certificate != nil && !resp.Certificate.Equal(issuer) && !hasExtKeyUsage(resp.Certificate, x509.ExtKeyUsageOCSPSigning) {
+		return nil, errors.New("OCSP responder certificate not authorized for OCSP signing.")
+	}
+
+	// Refuse the stale or not yet valid statuses, a replayed response must not hide a revocation
+	now := node.now()
+	if resp.ThisUpdate.After(now) {
+		return nil, fmt.Errorf("OCSP response not valid before [%s].", resp.ThisUpdate)
+	}
+	if !resp.NextUpdate.IsZero() && resp.NextUpdate.Before(now) {
+		return nil, fmt.Errorf("OCSP response expired at [%s].", resp.NextUpdate)
+	}
+
+	return resp, nil
+}
+
+// hasExtKeyUsage tells whether cert carries the extended key usage
+func hasExtKeyUsage(cert *x509.Certificate, usage x509.ExtKeyUsage) bool {
+	for _, u := range cert.ExtKeyUsage {
+		if u == usage {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (node *nodeImpl) startRevocationCheck() {
+	interval := node.conf.getRevocationCheckInterval()
+	if interval <= 0 {
+		return
+	}
+
+	node.Debugf("Checking enrollment certificate revocation every [%s].", interval)
+
+	stop := make(chan struct{})
+	node.revocationCheckStop = stop
+
+	node.background.Add(1)
+	go func() {
+		defer node.background.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				node.runRevocationCheck()
+			}
+		}
+	}()
+}
+
+func (node *nodeImpl) stopRevocationCheck() {
+	if node.revocationCheckStop != nil {
+		close(node.revocationCheckStop)
+		node.revocationCheckStop = nil
+	}
+}
+
+func (node *nodeImpl) runRevocationCheck() {
+	err := node.checkECertRevocation(node.getEnrollmentCertificate())
+	if err != utils.ErrEnrollmentCertRevoked {
+		return
+	}
+
+	node.invalidateCertificate(node.enrollID)
+
+	node.revocationMutex.Lock()
+	node.reEnrollmentRequired = true
+	handler := node.onEnrollmentCertRevoked
+	node.revocationMutex.Unlock()
+
+	if handler != nil {
+		handler(err)
+	}
+}
@@ -0,0 +1,164 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	"golang.org/x/crypto/ocsp"
+)
+
+// newTestOCSPResponder serves the response returned by respond for the OCSP requests
+func newTestOCSPResponder(t *testing.T, respond func(req *ocsp.Request) []byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("Failed reading OCSP request [%s]", err)
+			return
+		}
+		req, err := ocsp.ParseRequest(raw)
+		if err != nil {
+			t.Errorf("Failed parsing OCSP request [%s]", err)
+			return
+		}
+		w.Write(respond(req))
+	}))
+}
+
+func TestCheckECertRevocation(t *testing.T) {
+	node, cleanup := newTestCertStoreNode(t, 0644)
+	defer cleanup()
+
+	eca, ecaKey := newTestCert(t, "eca", true, nil, nil)
+	node.ecaCert = eca
+	cert, _ := newTestCert(t, "peer", false, eca, ecaKey)
+
+	now := time.Now().Truncate(time.Second)
+	template := ocsp.Response{Status: ocsp.Good, ThisUpdate: now, NextUpdate: now.Add(time.Hour)}
+	signer := ecaKey
+	responder := newTestOCSPResponder(t, func(req *ocsp.Request) []byte {
+		if req.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+			t.Errorf("Unexpected serial number in OCSP request [%s]", req.SerialNumber)
+		}
+		template.SerialNumber = req.SerialNumber
+		der, err := ocsp.CreateResponse(eca, eca, template, signer)
+		if err != nil {
+			t.Errorf("Failed creating OCSP response [%s]", err)
+		}
+		return der
+	})
+	defer responder.Close()
+
+	// Nothing to check without responder
+	if err := node.checkECertRevocation(cert); err != nil {
+		t.Fatalf("Certificates without OCSP responder must not be checked [%s]", err)
+	}
+
+	cert.OCSPServer = []string{responder.URL}
+	if err := node.checkECertRevocation(cert); err != nil {
+		t.Fatalf("Failed checking a good certificate [%s]", err)
+	}
+
+	template.Status, template.RevokedAt = ocsp.Revoked, now
+	if err := node.checkECertRevocation(cert); !errors.Is(err, utils.ErrEnrollmentCertRevoked) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrEnrollmentCertRevoked, err)
+	}
+
+	template.Status = ocsp.Unknown
+	if err := node.checkECertRevocation(cert); err == nil {
+		t.Fatal("An unknown certificate status must be reported")
+	}
+
+	// A replayed response must not hide a revocation
+	template.Status = ocsp.Good
+	node.clock = func() time.Time { return now.Add(2 * time.Hour) }
+	if err := node.checkECertRevocation(cert); err == nil {
+		t.Fatal("An expired OCSP response must be rejected")
+	}
+	node.clock = func() time.Time { return now.Add(-time.Hour) }
+	if err := node.checkECertRevocation(cert); err == nil {
+		t.Fatal("A not yet valid OCSP response must be rejected")
+	}
+	node.clock = nil
+
+	// Responses signed by someone else are rejected
+	_, otherKey := newTestCert(t, "other", true, nil, nil)
+	signer = otherKey
+	if err := node.checkECertRevocation(cert); err == nil {
+		t.Fatal("An OCSP response with an invalid signature must be rejected")
+	}
+}
+
+func TestCheckECertRevocationDelegatedResponder(t *testing.T) {
+	node, cleanup := newTestCertStoreNode(t, 0644)
+	defer cleanup()
+
+	eca, ecaKey := newTestCert(t, "eca", true, nil, nil)
+	node.ecaCert = eca
+	cert, _ := newTestCert(t, "peer", false, eca, ecaKey)
+
+	// The delegated responder must be authorized for OCSP signing
+	delegate, delegateKey := newTestCert(t, "ocsp", false, eca, ecaKey)
+	var responderCert *x509.Certificate
+	var responderKey *ecdsa.PrivateKey
+	responder := newTestOCSPResponder(t, func(req *ocsp.Request) []byte {
+		now := time.Now()
+		template := ocsp.Response{Status: ocsp.Good, SerialNumber: req.SerialNumber, ThisUpdate: now, NextUpdate: now.Add(time.Hour), Certificate: responderCert}
+		der, err := ocsp.CreateResponse(eca, responderCert, template, responderKey)
+		if err != nil {
+			t.Errorf("Failed creating OCSP response [%s]", err)
+		}
+		return der
+	})
+	defer responder.Close()
+	cert.OCSPServer = []string{responder.URL}
+
+	responderCert, responderKey = delegate, delegateKey
+	if err := node.checkECertRevocation(cert); err == nil {
+		t.Fatal("A responder not authorized for OCSP signing must be rejected")
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "ocsp"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, eca, &delegateKey.PublicKey, ecaKey)
+	if err != nil {
+		t.Fatalf("Failed creating certificate [%s]", err)
+	}
+	if responderCert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatalf("Failed parsing certificate [%s]", err)
+	}
+	if err := node.checkECertRevocation(cert); err != nil {
+		t.Fatalf("Failed checking with a delegated responder [%s]", err)
+	}
+}
@@ -0,0 +1,78 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	"golang.org/x/net/context"
+)
+
+// enrollFromSecretsFile enrolls with the enrollment id and password read from the
+// file at path, the id on the first line and the password on the second. Unlike
+// arguments, the file doesn't leak the password in logs, process lists or shell
+// history. It must not be accessible by group or others.
+// The read buffer is zeroed once the enrollment is done and the password is never logged.
+func (node *nodeImpl) enrollFromSecretsFile(ctx context.Context, path string) error {
+	id, pw, err := node.readEnrollmentSecrets(path)
+	if err != nil {
+		return fmt.Errorf("enrollFromSecretsFile: %w", err)
+	}
+	defer utils.Zero(pw)
+
+	if _, err := node.retrieveEnrollmentData(ctx, id, string(pw)); err != nil {
+		return fmt.Errorf("enrollFromSecretsFile: %w", err)
+	}
+
+	return nil
+}
+
+// readEnrollmentSecrets returns the enrollment id and password stored at path.
+// The caller must zero the password once done with it.
+func (node *nodeImpl) readEnrollmentSecrets(path string) (string, []byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("readEnrollmentSecrets: %w", err)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		node.Errorf("Secrets file [%s] has mode [%s].", path, info.Mode().Perm())
+
+		return "", nil, fmt.Errorf("readEnrollmentSecrets: %w: [%s]", utils.ErrInsecureSecretsFile, path)
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("readEnrollmentSecrets: %w", err)
+	}
+	defer utils.Zero(raw)
+
+	lines := bytes.SplitN(raw, []byte("\n"), 3)
+	if len(lines) < 2 {
+		return "", nil, fmt.Errorf("readEnrollmentSecrets: %w: [%s]", utils.ErrInvalidSecretsFile, path)
+	}
+	id := bytes.TrimSpace(lines[0])
+	pw := bytes.TrimRight(lines[1], "\r")
+	if len(id) == 0 || len(pw) == 0 {
+		return "", nil, fmt.Errorf("readEnrollmentSecrets: %w: [%s]", utils.ErrInvalidSecretsFile, path)
+	}
+
+	return string(id), utils.Clone(pw), nil
+}
@@ -0,0 +1,90 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+func TestReadEnrollmentSecrets(t *testing.T) {
+	node, cleanup := newTestCertStoreNode(t, 0644)
+	defer cleanup()
+	path := filepath.Join(node.conf.getRawsPath(), "secrets")
+
+	if err := ioutil.WriteFile(path, []byte("alice\r\ns3cret pw\r\n"), 0600); err != nil {
+		t.Fatalf("Failed writing secrets file [%s]", err)
+	}
+	id, pw, err := node.readEnrollmentSecrets(path)
+	if err != nil {
+		t.Fatalf("Failed reading secrets file [%s]", err)
+	}
+	if id != "alice" || string(pw) != "s3cret pw" {
+		t.Fatalf("Unexpected credentials [%s] [%s]", id, pw)
+	}
+
+	for _, raw := range []string{"", "alice", "alice\n", "\ns3cret"} {
+		if err := ioutil.WriteFile(path, []byte(raw), 0600); err != nil {
+			t.Fatalf("Failed writing secrets file [%s]", err)
+		}
+		if _, _, err := node.readEnrollmentSecrets(path); !errors.Is(err, utils.ErrInvalidSecretsFile) {
+			t.Fatalf("[%q]: Expected [%s], got [%v]", raw, utils.ErrInvalidSecretsFile, err)
+		}
+	}
+
+	// Readable by others
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatalf("Failed changing mode [%s]", err)
+	}
+	if _, _, err := node.readEnrollmentSecrets(path); !errors.Is(err, utils.ErrInsecureSecretsFile) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrInsecureSecretsFile, err)
+	}
+}
+
+func TestEnrollFromSecretsFile(t *testing.T) {
+	client := &fakeECAPClient{createErrs: []error{grpc.Errorf(codes.Unauthenticated, "Identity or token does not match.")}}
+	node, cleanup := newTestECANode(t, client)
+	defer cleanup()
+	node.conf.securityLevel = 256
+	node.conf.hashAlgorithm = "SHA3"
+	node.conf.ecaEnrollmentTimeout = time.Second
+	node.ks = &keyStore{node: node}
+
+	path := filepath.Join(node.conf.getRawsPath(), "secrets")
+	if err := node.enrollFromSecretsFile(context.Background(), path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Expected a missing file error, got [%v]", err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("alice\nwrong\n"), 0600); err != nil {
+		t.Fatalf("Failed writing secrets file [%s]", err)
+	}
+	if err := node.enrollFromSecretsFile(context.Background(), path); !errors.Is(err, utils.ErrEnrollmentAuthFailed) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrEnrollmentAuthFailed, err)
+	}
+	if client.createCalls != 1 {
+		t.Fatalf("Expected one enrollment request, got [%d]", client.createCalls)
+	}
+}
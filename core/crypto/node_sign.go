@@ -17,9 +17,12 @@ limitations under the License.
 package crypto
 
 import (
+	"crypto/ecdsa"
+	"fmt"
 	"math/big"
 
 	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
 )
 
 func (node *nodeImpl) sign(signKey interface{}, msg []byte) ([]byte, error) {
@@ -27,11 +30,11 @@ func (node *nodeImpl) sign(signKey interface{}, msg []byte) ([]byte, error) {
 }
 
 func (node *nodeImpl) signWithEnrollmentKey(msg []byte) ([]byte, error) {
-	return primitives.ECDSASign(node.enrollPrivKey, msg)
+	return primitives.ECDSASign(node.getEnrollmentKey(), msg)
 }
 
 func (node *nodeImpl) ecdsaSignWithEnrollmentKey(msg []byte) (*big.Int, *big.Int, error) {
-	return primitives.ECDSASignDirect(node.enrollPrivKey, msg)
+	return primitives.ECDSASignDirect(node.getEnrollmentKey(), msg)
 }
 
 func (node *nodeImpl) verify(verKey interface{}, msg, signature []byte) (bool, error) {
@@ -39,5 +42,32 @@ func (node *nodeImpl) verify(verKey interface{}, msg, signature []byte) (bool, e
 }
 
 func (node *nodeImpl) verifyWithEnrollmentCert(msg, signature []byte) (bool, error) {
-	return primitives.ECDSAVerify(node.enrollCert.PublicKey, msg, signature)
+	return primitives.ECDSAVerify(node.getEnrollmentCertificate().PublicKey, msg, signature)
+}
+
+// VerifyWithECert verifies the ECDSA signature r, s of payload made with the enrollment key.
+// r and s are text encoded, as in the signatures of the requests sent to the ECA and the TCA.
+// A signature not verifying fails with utils.ErrInvalidSignature.
+func (node *nodeImpl) VerifyWithECert(payload, r, s []byte) error {
+	cert := node.getEnrollmentCertificate()
+	if cert == nil {
+		return fmt.Errorf("VerifyWithECert: %w", utils.ErrNotInitialized)
+	}
+	pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("VerifyWithECert: %w: not an ECDSA enrollment key", utils.ErrInvalidKey)
+	}
+
+	R, S := new(big.Int), new(big.Int)
+	if err := R.UnmarshalText(r); err != nil {
+		return fmt.Errorf("VerifyWithECert: %w: %v", utils.ErrInvalidSignature, err)
+	}
+	if err := S.UnmarshalText(s); err != nil {
+		return fmt.Errorf("VerifyWithECert: %w: %v", utils.ErrInvalidSignature, err)
+	}
+	if !ecdsa.Verify(pub, primitives.Hash(payload), R, S) {
+		return fmt.Errorf("VerifyWithECert: %w", utils.ErrInvalidSignature)
+	}
+
+	return nil
 }
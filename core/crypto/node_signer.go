@@ -0,0 +1,127 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	membersrvc "github.com/hyperledger/fabric/membersrvc/protos"
+)
+
+// PKCS11SignerFactory creates an enrollment signing key on a PKCS#11 token and
+// returns a signer that never exposes the private key. No PKCS#11 binding is
+// part of this tree: builds linking one must set it for security.pkcs11.enabled
+// to be usable.
+var PKCS11SignerFactory func(curve elliptic.Curve) (crypto.Signer, error)
+
+// newEnrollmentSigner creates the signing key used to enroll, in software or on a PKCS#11 token
+func (node *nodeImpl) newEnrollmentSigner(curve elliptic.Curve) (crypto.Signer, error) {
+	if !node.conf.getPKCS11Enabled() {
+		return primitives.NewECDSAKeyForCurve(curve)
+	}
+
+	if PKCS11SignerFactory == nil {
+		node.Error("PKCS#11 enabled but no PKCS#11 signer available.")
+
+		return nil, utils.ErrPKCS11NotAvailable
+	}
+
+	node.Debug("Generating enrollment signing key on the PKCS#11 token...")
+
+	return PKCS11SignerFactory(curve)
+}
+
+// checkCurveStrength rejects the curves weaker than conf.getMinSecurityLevel()
+func (node *nodeImpl) checkCurveStrength(curve elliptic.Curve) error {
+	params := curve.Params()
+	node.Debugf("Generating keys on curve [%s] of [%d] bits.", params.Name, params.BitSize)
+
+	if min := node.conf.getMinSecurityLevel(); params.BitSize < min {
+		node.Errorf("Curve [%s] weaker than the minimum security level [%d].", params.Name, min)
+
+		return fmt.Errorf("%w: curve [%s] has [%d] bits, at least [%d] required", utils.ErrKeyTooWeak, params.Name, params.BitSize, min)
+	}
+
+	return nil
+}
+
+// signEnrollmentRequest signs the digest of an enrollment request with the ECDSA key signer,
+// in software or on a PKCS#11 token
+func (node *nodeImpl) signEnrollmentRequest(signer crypto.Signer, digest []byte) (*membersrvc.Signature, error) {
+	r, s, err := signECDSA(rand.Reader, signer, digest)
+	if err != nil {
+		return nil, err
+	}
+	R, err := r.MarshalText()
+	if err != nil {
+		return nil, err
+	}
+	S, err := s.MarshalText()
+	if err != nil {
+		return nil, err
+	}
+
+	return &membersrvc.Signature{Type: membersrvc.CryptoType_ECDSA, R: R, S: S}, nil
+}
+
+// signECDSA signs digest with signer and returns the r and s of the ECDSA signature
+func signECDSA(rnd io.Reader, signer crypto.Signer, digest []byte) (*big.Int, *big.Int, error) {
+	raw, err := signer.Sign(rnd, digest, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sig := new(primitives.ECDSASignature)
+	if _, err := asn1.Unmarshal(raw, sig); err != nil {
+		return nil, nil, err
+	}
+	if sig.R == nil || sig.S == nil {
+		return nil, nil, errors.New("Invalid ECDSA signature.")
+	}
+
+	return sig.R, sig.S, nil
+}
+
+// isSoftwareKey returns true if the private key material of key is available in memory
+func isSoftwareKey(key interface{}) bool {
+	switch key.(type) {
+	case *ecdsa.PrivateKey:
+		return true
+	}
+
+	return false
+}
+
+// checkPublicKeyMatchesSigner checks that pub is the public key of signer
+func checkPublicKeyMatchesSigner(pub interface{}, signer crypto.Signer) error {
+	if !reflect.DeepEqual(pub, signer.Public()) {
+		return errors.New("Signer does not match public key")
+	}
+
+	return nil
+}
@@ -0,0 +1,172 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"io"
+	"math/big"
+	"testing"
+	"testing/cryptotest"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	membersrvc "github.com/hyperledger/fabric/membersrvc/protos"
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// tokenSigner mimics a PKCS#11 signer, the private key never leaves it
+type tokenSigner struct {
+	key *ecdsa.PrivateKey
+}
+
+func (s *tokenSigner) Public() crypto.PublicKey {
+	return &s.key.PublicKey
+}
+
+func (s *tokenSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return s.key.Sign(rand, digest, opts)
+}
+
+func TestEnrollmentSignerPKCS11(t *testing.T) {
+	node, cleanup := newTestCertStoreNode(t, 0644)
+	defer cleanup()
+
+	viper.Set("security.pkcs11.enabled", true)
+	defer viper.Set("security.pkcs11.enabled", false)
+
+	if _, err := node.newEnrollmentSigner(elliptic.P256()); err != utils.ErrPKCS11NotAvailable {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrPKCS11NotAvailable, err)
+	}
+
+	PKCS11SignerFactory = func(curve elliptic.Curve) (crypto.Signer, error) {
+		key, err := primitives.NewECDSAKeyForCurve(curve)
+		if err != nil {
+			return nil, err
+		}
+		return &tokenSigner{key}, nil
+	}
+	defer func() { PKCS11SignerFactory = nil }()
+
+	signer, err := node.newEnrollmentSigner(elliptic.P256())
+	if err != nil {
+		t.Fatalf("Failed creating PKCS#11 signer [%s]", err)
+	}
+	if isSoftwareKey(signer) {
+		t.Fatal("PKCS#11 signer must not expose the private key")
+	}
+
+	digest := primitives.Hash([]byte("enrollment request"))
+	r, s, err := signECDSA(rand.Reader, signer, digest)
+	if err != nil {
+		t.Fatalf("Failed signing [%s]", err)
+	}
+	if !ecdsa.Verify(signer.Public().(*ecdsa.PublicKey), digest, r, s) {
+		t.Fatal("Invalid signature")
+	}
+
+	if err := node.storeEnrollmentKey(signer, nil); !errors.Is(err, utils.ErrPKCS11NotAvailable) {
+		t.Fatalf("Storing a token key must fail, got [%v]", err)
+	}
+}
+
+func TestSignEnrollmentRequest(t *testing.T) {
+	node, cleanup := newTestCertStoreNode(t, 0644)
+	defer cleanup()
+
+	signer, err := node.newEnrollmentSigner(elliptic.P256())
+	if err != nil {
+		t.Fatalf("Failed creating signer [%s]", err)
+	}
+	if !isSoftwareKey(signer) {
+		t.Fatal("The signing key must be a software key")
+	}
+
+	digest := primitives.Hash([]byte("enrollment request"))
+	sig, err := node.signEnrollmentRequest(signer, digest)
+	if err != nil {
+		t.Fatalf("Failed signing [%s]", err)
+	}
+	if sig.Type != membersrvc.CryptoType_ECDSA {
+		t.Fatalf("Unexpected signature type [%s]", sig.Type)
+	}
+
+	r, s := new(big.Int), new(big.Int)
+	r.UnmarshalText(sig.R)
+	s.UnmarshalText(sig.S)
+	if !ecdsa.Verify(signer.Public().(*ecdsa.PublicKey), digest, r, s) {
+		t.Fatal("Invalid signature")
+	}
+}
+
+func TestEnrollmentKeysReproducible(t *testing.T) {
+	node, cleanup := newTestCertStoreNode(t, 0644)
+	defer cleanup()
+
+	newKey := func(seed uint64) *ecdsa.PrivateKey {
+		// Go ignores custom readers when generating keys, crypto/rand is seeded instead
+		cryptotest.SetGlobalRandom(t, seed)
+
+		signer, err := node.newEnrollmentSigner(elliptic.P256())
+		if err != nil {
+			t.Fatalf("Failed creating signer [%s]", err)
+		}
+
+		return signer.(*ecdsa.PrivateKey)
+	}
+
+	if !newKey(1).Equal(newKey(1)) {
+		t.Fatal("Keys generated from the same seed must be equal")
+	}
+	if newKey(1).Equal(newKey(2)) {
+		t.Fatal("Keys generated from different seeds must differ")
+	}
+}
+
+func TestCurveStrengthPolicy(t *testing.T) {
+	client := &fakeECAPClient{createErrs: []error{grpc.Errorf(codes.Unauthenticated, "Identity or token does not match.")}}
+	node, cleanup := newTestECANode(t, client)
+	defer cleanup()
+	node.conf.securityLevel = 256
+	node.conf.hashAlgorithm = "SHA3"
+	node.conf.ecaEnrollmentTimeout = time.Second
+
+	node.conf.minSecurityLevel = 256
+	if err := node.checkCurveStrength(elliptic.P256()); err != nil {
+		t.Fatalf("P-256 must satisfy a minimum security level of 256 [%s]", err)
+	}
+	if err := node.checkCurveStrength(elliptic.P224()); !errors.Is(err, utils.ErrKeyTooWeak) {
+		t.Fatalf("P-224 must be rejected with [%s], got [%v]", utils.ErrKeyTooWeak, err)
+	}
+
+	// No key is generated nor request sent on a weak curve
+	node.conf.minSecurityLevel = 384
+	if _, _, _, _, err := node.getEnrollmentCertificateFromECA(context.Background(), "user", "pw"); !errors.Is(err, utils.ErrKeyTooWeak) {
+		t.Fatalf("Expected [%s], got [%v]", utils.ErrKeyTooWeak, err)
+	}
+	if client.createCalls != 0 {
+		t.Fatalf("Expected no call to the ECA, got [%d]", client.createCalls)
+	}
+}
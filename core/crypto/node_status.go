@@ -0,0 +1,99 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto/x509"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+)
+
+// CertStatus describes a certificate held by the node
+type CertStatus struct {
+	// Fingerprint is the hash of the certificate as colon-separated hex
+	Fingerprint string
+	NotBefore   time.Time
+	NotAfter    time.Time
+}
+
+// CryptoStatus is a snapshot of the crypto state of a node, for diagnostics.
+// It marshals to JSON and holds no secret. EnrollID is redacted as in the logs
+// if security.redactsensitivelogs is set.
+type CryptoStatus struct {
+	Name     string
+	Type     string
+	Enrolled bool
+	EnrollID string
+
+	// ECAAddress is the ECA replica last dialed, empty if none was
+	ECAAddress string
+
+	// Certificates, nil if not loaded
+	EnrollmentCert *CertStatus
+	ECACert        *CertStatus
+	TLSCert        *CertStatus
+
+	TrustedRoots int
+
+	// LastECAContact is the time of the last successful call to the ECA, zero if none
+	LastECAContact time.Time
+}
+
+// CryptoStatus returns a snapshot of the crypto state of the node
+func (node *nodeImpl) CryptoStatus() CryptoStatus {
+	node.ecaConnMutex.Lock()
+	ecaAddr := node.ecaAddr
+	lastContact := node.ecaContactTime
+	node.ecaConnMutex.Unlock()
+
+	enrollCert := node.getEnrollmentCertificate()
+
+	return CryptoStatus{
+		Name:           node.GetName(),
+		Type:           eTypeToString(node.eType),
+		Enrolled:       enrollCert != nil,
+		EnrollID:       node.logID(node.getEnrollmentID()),
+		ECAAddress:     ecaAddr,
+		EnrollmentCert: newCertStatus(enrollCert),
+		ECACert:        newCertStatus(node.getECACert()),
+		TLSCert:        newCertStatus(node.getTLSCert()),
+		TrustedRoots:   node.TrustedRootsCount(),
+		LastECAContact: lastContact,
+	}
+}
+
+func newCertStatus(cert *x509.Certificate) *CertStatus {
+	if cert == nil {
+		return nil
+	}
+
+	return &CertStatus{
+		Fingerprint: utils.EncodeFingerprint(primitives.Hash(cert.Raw)),
+		NotBefore:   cert.NotBefore,
+		NotAfter:    cert.NotAfter,
+	}
+}
+
+// markECAContact records a successful call to the ECA
+func (node *nodeImpl) markECAContact() {
+	node.ecaConnMutex.Lock()
+	defer node.ecaConnMutex.Unlock()
+
+	node.ecaContactTime = node.now()
+}
@@ -38,7 +38,7 @@ func (node *nodeImpl) retrieveTCACertsChain(userID string) error {
 
 		return err
 	}
-	node.Debugf("TCA certificate [% x]", tcaCertRaw)
+	node.Debugf("TCA certificate [%s]", node.logCert(tcaCertRaw))
 
 	// TODO: Test TCA cert againt root CA
 	_, err = primitives.DERToX509Certificate(tcaCertRaw)
@@ -49,7 +49,7 @@ func (node *nodeImpl) retrieveTCACertsChain(userID string) error {
 	}
 
 	// Store TCA cert
-	node.Debugf("Storing TCA certificate for [%s]...", userID)
+	node.Debugf("Storing TCA certificate for [%s]...", node.logID(userID))
 
 	if err := node.ks.storeCert(node.conf.getTCACertsChainFilename(), tcaCertRaw); err != nil {
 		node.Errorf("Failed storing tca certificate [%s].", err.Error())
@@ -87,6 +87,8 @@ func (node *nodeImpl) getTCAClient() (*grpc.ClientConn, membersrvc.TCAPClient, e
 	conn, err := node.getClientConn(node.conf.getTCAPAddr(), node.conf.getTCAServerName())
 	if err != nil {
 		node.Errorf("Failed getting client connection: [%s]", err)
+
+		return nil, nil, err
 	}
 
 	client := membersrvc.NewTCAPClient(conn)
@@ -99,6 +101,9 @@ func (node *nodeImpl) getTCAClient() (*grpc.ClientConn, membersrvc.TCAPClient, e
 func (node *nodeImpl) callTCAReadCACertificate(ctx context.Context, opts ...grpc.CallOption) (*membersrvc.Cert, error) {
 	// Get a TCA Client
 	sock, tcaP, err := node.getTCAClient()
+	if err != nil {
+		return nil, err
+	}
 	defer sock.Close()
 
 	// Issue the request
@@ -21,42 +21,124 @@ import (
 
 	"crypto/ecdsa"
 	"crypto/rand"
+	"crypto/tls"
 	"crypto/x509"
 	"errors"
+	"fmt"
 	"google/protobuf"
 	"time"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
 	"github.com/hyperledger/fabric/core/util"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 )
 
+// retrieveTLSCACertsChain fetches the TLSCA certificate and stores it as the
+// TLSCA certificates chain, unless already stored.
+func (node *nodeImpl) retrieveTLSCACertsChain(userID string) error {
+	if _, err := node.certStore.Get(node.conf.getTLSCACertsChainFilename()); err == nil {
+		return nil
+	}
+
+	node.Debugf("Retrieving TLSCA certificate for [%s]...", node.logID(userID))
+
+	response, err := node.callTLSCAReadCACertificate(context.Background())
+	if err != nil {
+		node.Errorf("Failed requesting TLSCA certificate [%s].", err.Error())
+
+		return err
+	}
+
+	tlscaCert, err := primitives.DERToX509Certificate(response.Cert)
+	if err != nil {
+		node.Errorf("Failed parsing TLSCA certificate [%s].", err.Error())
+
+		return err
+	}
+	if err := node.verifyTLSCACertificate(tlscaCert); err != nil {
+		node.Errorf("Failed verifying TLSCA certificate [%s].", err.Error())
+
+		return err
+	}
+
+	node.Debugf("Storing TLSCA certificate for [%s]...", node.logID(userID))
+
+	if err := node.certStore.Put(node.conf.getTLSCACertsChainFilename(), primitives.DERCertToPEM(tlscaCert.Raw)); err != nil {
+		node.Errorf("Failed storing TLSCA certificate [%s].", err.Error())
+
+		return err
+	}
+
+	return nil
+}
+
+// verifyTLSCACertificate checks the TLSCA certificate against the trusted roots, if any
+func (node *nodeImpl) verifyTLSCACertificate(x509TLSCACert *x509.Certificate) error {
+	roots, err := node.verificationRoots()
+	if err != nil {
+		return fmt.Errorf("verifyTLSCACertificate: %w", err)
+	}
+	if roots == nil {
+		node.Warning("No trusted root certificates configured. Accepting TLSCA certificate without verification.")
+
+		return nil
+	}
+
+	if _, err := primitives.CheckCertAgainRoot(x509TLSCACert, roots); err != nil {
+		return fmt.Errorf("TLSCA certificate [%s] does not chain to a trusted root: [%s]", x509TLSCACert.Subject.CommonName, err)
+	}
+
+	return nil
+}
+
+// getTLSCACertPool returns the pool of the stored TLSCA certificates chain
+func (node *nodeImpl) getTLSCACertPool() (*x509.CertPool, error) {
+	pem, err := node.certStore.Get(node.conf.getTLSCACertsChainFilename())
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("Failed appending TLSCA certificates chain.")
+	}
+
+	return pool, nil
+}
+
 func (node *nodeImpl) retrieveTLSCertificate(id, affiliation string) error {
 	if !node.ks.certMissing(node.conf.getTLSCertFilename()) {
 		return nil
 	}
 
+	if err := node.retrieveTLSCACertsChain(id); err != nil {
+		node.Errorf("Failed retrieving TLSCA certificates chain [id=%s] %s", node.logID(id), err)
+
+		return err
+	}
+
 	key, tlsCertRaw, err := node.getTLSCertificateFromTLSCA(id, affiliation)
 	if err != nil {
-		node.Errorf("Failed getting tls certificate [id=%s] %s", id, err)
+		node.Errorf("Failed getting tls certificate [id=%s] %s", node.logID(id), err)
 
 		return err
 	}
-	node.Debugf("TLS Cert [% x]", tlsCertRaw)
+	node.Debugf("TLS Cert [%s]", node.logCert(tlsCertRaw))
 
-	node.Debugf("Storing TLS key and certificate for user [%s]...", id)
+	node.Debugf("Storing TLS key and certificate for user [%s]...", node.logID(id))
 
 	// Store tls key.
 	if err := node.ks.storePrivateKeyInClear(node.conf.getTLSKeyFilename(), key); err != nil {
-		node.Errorf("Failed storing tls key [id=%s]: %s", id, err)
+		node.Errorf("Failed storing tls key [id=%s]: %s", node.logID(id), err)
 		return err
 	}
 
 	// Store tls cert
 	if err := node.ks.storeCert(node.conf.getTLSCertFilename(), tlsCertRaw); err != nil {
-		node.Errorf("Failed storing tls certificate [id=%s]: %s", id, err)
+		node.Errorf("Failed storing tls certificate [id=%s]: %s", node.logID(id), err)
 		return err
 	}
 
@@ -65,13 +147,13 @@ func (node *nodeImpl) retrieveTLSCertificate(id, affiliation string) error {
 
 func (node *nodeImpl) deleteTLSCertificate(id, affiliation string) error {
 	if err := node.ks.deletePrivateKeyInClear(node.conf.getTLSKeyFilename()); err != nil {
-		node.Errorf("Failed deleting tls key [id=%s]: %s", id, err)
+		node.Errorf("Failed deleting tls key [id=%s]: %s", node.logID(id), err)
 		return err
 	}
 
 	// Store tls cert
 	if err := node.ks.deleteCert(node.conf.getTLSCertFilename()); err != nil {
-		node.Errorf("Failed deleting tls certificate [id=%s]: %s", id, err)
+		node.Errorf("Failed deleting tls certificate [id=%s]: %s", node.logID(id), err)
 		return err
 	}
 
@@ -87,11 +169,43 @@ func (node *nodeImpl) loadTLSCertificate() error {
 
 		return err
 	}
+	node.tlsCertMutex.Lock()
 	node.tlsCert = cert
+	node.tlsCertMutex.Unlock()
 
 	return nil
 }
 
+// getTLSCert returns the TLS certificate, nil if not loaded
+func (node *nodeImpl) getTLSCert() *x509.Certificate {
+	node.tlsCertMutex.RLock()
+	defer node.tlsCertMutex.RUnlock()
+
+	return node.tlsCert
+}
+
+// GetTLSCertificate returns the TLS certificate issued by the TLSCA and its key,
+// to be used by the node to serve TLS connections.
+func (node *nodeImpl) GetTLSCertificate() (*tls.Certificate, error) {
+	cert := node.getTLSCert()
+	if cert == nil {
+		return nil, errors.New("No TLS certificate loaded.")
+	}
+
+	key, err := node.ks.loadPrivateKey(node.conf.getTLSKeyFilename())
+	if err != nil {
+		node.Errorf("Failed loading tls key [%s].", err.Error())
+
+		return nil, err
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{cert.Raw},
+		PrivateKey:  key,
+		Leaf:        cert,
+	}, nil
+}
+
 func (node *nodeImpl) loadTLSCACertsChain() error {
 	if node.conf.isTLSEnabled() {
 		node.Debug("Loading TLSCA certificates chain...")
@@ -116,12 +230,32 @@ func (node *nodeImpl) loadTLSCACertsChain() error {
 		node.Debug("TLS is disabled!!!")
 	}
 
+	// The TLSCA certificate retrieved at registration, if any, issued the peers TLS certificates
+	pem, err := node.certStore.Get(node.conf.getTLSCACertsChainFilename())
+	if errors.Is(err, utils.ErrCertNotFound) {
+		return nil
+	}
+	if err != nil {
+		node.Errorf("Failed loading TLSCA certificates chain [%s].", err.Error())
+
+		return err
+	}
+	if !node.tlsCertPool.AppendCertsFromPEM(pem) {
+		node.Error("Failed appending TLSCA certificates chain.")
+
+		return errors.New("Failed appending TLSCA certificates chain.")
+	}
+
 	return nil
 }
 
 func (node *nodeImpl) getTLSCertificateFromTLSCA(id, affiliation string) (interface{}, []byte, error) {
 	node.Debug("getTLSCertificate...")
 
+	if err := node.checkCurveStrength(primitives.GetDefaultCurve()); err != nil {
+		return nil, nil, err
+	}
+
 	priv, err := primitives.NewECDSAKey()
 
 	if err != nil {
@@ -133,9 +267,14 @@ func (node *nodeImpl) getTLSCertificateFromTLSCA(id, affiliation string) (interf
 	uuid := util.GenerateUUID()
 
 	// Prepare the request
-	pubraw, _ := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	pubraw, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		node.Errorf("Failed marshalling public key: %s", err)
+
+		return nil, nil, err
+	}
 	now := time.Now()
-	timestamp := google_protobuf.Timestamp{Seconds: int64(now.Second()), Nanos: int32(now.Nanosecond())}
+	timestamp := google_protobuf.Timestamp{Seconds: now.Unix(), Nanos: int32(now.Nanosecond())}
 
 	req := &membersrvc.TLSCertCreateReq{
 		Ts: &timestamp,
@@ -144,13 +283,30 @@ func (node *nodeImpl) getTLSCertificateFromTLSCA(id, affiliation string) (interf
 			Type: membersrvc.CryptoType_ECDSA,
 			Key:  pubraw,
 		}, Sig: nil}
-	rawreq, _ := proto.Marshal(req)
+	rawreq, err := proto.Marshal(req)
+	if err != nil {
+		node.Errorf("Failed marshalling tls certificate request: %s", err)
+
+		return nil, nil, err
+	}
 	r, s, err := ecdsa.Sign(rand.Reader, priv, primitives.Hash(rawreq))
 	if err != nil {
-		panic(err)
+		node.Errorf("Failed signing tls certificate request: %s", err)
+
+		return nil, nil, err
+	}
+	R, err := r.MarshalText()
+	if err != nil {
+		node.Errorf("Failed marshalling signature: %s", err)
+
+		return nil, nil, err
+	}
+	S, err := s.MarshalText()
+	if err != nil {
+		node.Errorf("Failed marshalling signature: %s", err)
+
+		return nil, nil, err
 	}
-	R, _ := r.MarshalText()
-	S, _ := s.MarshalText()
 	req.Sig = &membersrvc.Signature{Type: membersrvc.CryptoType_ECDSA, R: R, S: S}
 
 	pbCert, err := node.callTLSCACreateCertificate(context.Background(), req)
@@ -163,20 +319,44 @@ func (node *nodeImpl) getTLSCertificateFromTLSCA(id, affiliation string) (interf
 	node.Debug("Verifing tls certificate...")
 
 	tlsCert, err := primitives.DERToX509Certificate(pbCert.Cert.Cert)
-	certPK := tlsCert.PublicKey.(*ecdsa.PublicKey)
-	primitives.VerifySignCapability(priv, certPK)
+	if err != nil {
+		node.Errorf("Failed parsing tls certificate: %s", err)
+
+		return nil, nil, err
+	}
+	if err := node.verifyTLSCertificate(tlsCert, priv); err != nil {
+		node.Errorf("Failed verifying tls certificate: %s", err)
+
+		return nil, nil, err
+	}
 
 	node.Debug("Verifing tls certificate...done!")
 
 	return priv, pbCert.Cert.Cert, nil
 }
 
+// verifyTLSCertificate checks that the TLS certificate is for priv and, if the
+// TLSCA certificates chain is stored, issued by the TLSCA
+func (node *nodeImpl) verifyTLSCertificate(cert *x509.Certificate, priv *ecdsa.PrivateKey) error {
+	pool, err := node.getTLSCACertPool()
+	if errors.Is(err, utils.ErrCertNotFound) {
+		return primitives.CheckCertPKAgainstSK(cert, priv)
+	}
+	if err != nil {
+		return err
+	}
+
+	return primitives.CheckCertAgainstSKAndRoot(cert, priv, pool)
+}
+
 func (node *nodeImpl) getTLSCAClient() (*grpc.ClientConn, membersrvc.TLSCAPClient, error) {
 	node.Debug("Getting TLSCA client...")
 
 	conn, err := node.getClientConn(node.conf.getTLSCAPAddr(), node.conf.getTLSCAServerName())
 	if err != nil {
 		node.Errorf("Failed getting client connection: [%s]", err)
+
+		return nil, nil, err
 	}
 
 	client := membersrvc.NewTLSCAPClient(conn)
@@ -204,3 +384,22 @@ func (node *nodeImpl) callTLSCACreateCertificate(ctx context.Context, in *member
 
 	return resp, nil
 }
+
+func (node *nodeImpl) callTLSCAReadCACertificate(ctx context.Context, opts ...grpc.CallOption) (*membersrvc.Cert, error) {
+	conn, tlscaP, err := node.getTLSCAClient()
+	if err != nil {
+		node.Errorf("Failed dialing in: %s", err)
+
+		return nil, err
+	}
+	defer conn.Close()
+
+	cert, err := tlscaP.ReadCACertificate(ctx, &membersrvc.Empty{}, opts...)
+	if err != nil {
+		node.Errorf("Failed requesting tlsca read certificate [%s].", err.Error())
+
+		return nil, err
+	}
+
+	return cert, nil
+}
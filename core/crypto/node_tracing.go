@@ -0,0 +1,88 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"encoding/hex"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// Tracer starts the spans covering the enrollment and the calls to the ECA.
+// It is small enough to be backed by an OpenTelemetry tracer without this
+// package depending on it.
+type Tracer interface {
+	// Start starts a span named name, child of the span carried by ctx if any,
+	// and returns a context carrying the new span
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer
+type Span interface {
+	// SetAttribute records an attribute of the span
+	SetAttribute(key, value string)
+
+	// End ends the span, err is the outcome of the traced operation
+	End(err error)
+}
+
+const (
+	// Span attribute carrying the gRPC status code of the traced operation
+	spanAttrGRPCStatus = "rpc.grpc.status_code"
+
+	// Span attribute carrying the hash of the certificate returned by the ECA
+	spanAttrCertHash = "cert.hash"
+)
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key, value string) {}
+
+func (noopSpan) End(err error) {}
+
+// SetTracer sets the Tracer of the enrollment spans. Passing nil disables tracing.
+func (node *nodeImpl) SetTracer(tracer Tracer) {
+	node.tracerMutex.Lock()
+	defer node.tracerMutex.Unlock()
+
+	node.tracer = tracer
+}
+
+// startSpan starts a span named name if a Tracer is set, a no-op span otherwise
+func (node *nodeImpl) startSpan(ctx context.Context, name string) (context.Context, Span) {
+	node.tracerMutex.RLock()
+	tracer := node.tracer
+	node.tracerMutex.RUnlock()
+
+	if tracer == nil {
+		return ctx, noopSpan{}
+	}
+
+	return tracer.Start(ctx, name)
+}
+
+// endSpan records the gRPC status of err and the hash of cert, if any, then ends span
+func endSpan(span Span, err error, cert []byte) {
+	span.SetAttribute(spanAttrGRPCStatus, grpc.Code(ecaRootError(err)).String())
+	if len(cert) != 0 {
+		span.SetAttribute(spanAttrCertHash, hex.EncodeToString(primitives.Hash(cert)))
+	}
+
+	span.End(err)
+}
@@ -39,7 +39,7 @@ type peerImpl struct {
 
 // GetID returns this peer's identifier
 func (peer *peerImpl) GetID() []byte {
-	return utils.Clone(peer.id)
+	return utils.Clone(peer.getNodeID())
 }
 
 // GetEnrollmentID returns this peer's enrollment id
@@ -82,7 +82,7 @@ func (peer *peerImpl) TransactionPreValidation(tx *obc.Transaction) (*obc.Transa
 		if _, err = primitives.CheckCertAgainRoot(x509Cert, peer.tcaCertPool); err != nil {
 			peer.Warningf("Failed verifing certificate against TCA cert pool [%s].", err.Error())
 			// 3. Check against ECA certPool, if this check also fails then return an error
-			if _, err = primitives.CheckCertAgainRoot(x509Cert, peer.ecaCertPool); err != nil {
+			if _, err = primitives.CheckCertAgainRoot(x509Cert, peer.getECACertPool()); err != nil {
 				peer.Warningf("Failed verifing certificate against ECA cert pool [%s].", err.Error())
 
 				return tx, fmt.Errorf("Certificate has not been signed by a trusted authority. [%s]", err)
@@ -188,7 +188,7 @@ func (peer *peerImpl) GetTransactionBinding(tx *obc.Transaction) ([]byte, error)
 func (peer *peerImpl) register(eType NodeType, name string, pwd []byte, enrollID, enrollPWD string, regFunc registerFunc) error {
 
 	if err := peer.nodeImpl.register(eType, name, pwd, enrollID, enrollPWD, regFunc); err != nil {
-		peer.Errorf("Failed registering peer [%s]: [%s]", enrollID, err)
+		peer.Errorf("Failed registering peer [%s]: [%s]", peer.logID(enrollID), err)
 		return err
 	}
 
@@ -18,6 +18,7 @@ package primitives
 
 import (
 	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"encoding/asn1"
 	"math/big"
@@ -30,7 +31,14 @@ type ECDSASignature struct {
 
 // NewECDSAKey generates a new ECDSA Key
 func NewECDSAKey() (*ecdsa.PrivateKey, error) {
-	return ecdsa.GenerateKey(GetDefaultCurve(), rand.Reader)
+	return NewECDSAKeyForCurve(GetDefaultCurve())
+}
+
+// NewECDSAKeyForCurve generates a new ECDSA Key on the passed curve.
+// Go ignores custom randomness sources for key generation, tests wanting
+// reproducible keys seed crypto/rand with testing/cryptotest.SetGlobalRandom.
+func NewECDSAKeyForCurve(curve elliptic.Curve) (*ecdsa.PrivateKey, error) {
+	return ecdsa.GenerateKey(curve, rand.Reader)
 }
 
 // ECDSASignDirect signs
@@ -18,6 +18,7 @@ package primitives
 
 import (
 	"crypto/elliptic"
+	"fmt"
 )
 
 var (
@@ -28,3 +29,15 @@ var (
 func GetDefaultCurve() elliptic.Curve {
 	return defaultCurve
 }
+
+// GetCurveForSecurityLevel returns the elliptic curve matching the passed security level
+func GetCurveForSecurityLevel(level int) (elliptic.Curve, error) {
+	switch level {
+	case 256:
+		return elliptic.P256(), nil
+	case 384:
+		return elliptic.P384(), nil
+	}
+
+	return nil, fmt.Errorf("Security level not supported [%d]", level)
+}
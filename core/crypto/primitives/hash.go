@@ -18,7 +18,12 @@ package primitives
 
 import (
 	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
 	"hash"
+
+	"golang.org/x/crypto/sha3"
 )
 
 var (
@@ -36,6 +41,23 @@ func GetHashAlgorithm() string {
 	return defaultHashAlgorithm
 }
 
+// GetHashForSecurityLevel returns the hash function of the passed algorithm,
+// SHA2 or SHA3, whose output length matches the security level
+func GetHashForSecurityLevel(algorithm string, level int) (func() hash.Hash, error) {
+	switch {
+	case algorithm == "SHA2" && level == 256:
+		return sha256.New, nil
+	case algorithm == "SHA2" && level == 384:
+		return sha512.New384, nil
+	case algorithm == "SHA3" && level == 256:
+		return sha3.New256, nil
+	case algorithm == "SHA3" && level == 384:
+		return sha3.New384, nil
+	}
+
+	return nil, fmt.Errorf("Hash algorithm [%s] not supported at security level [%d]", algorithm, level)
+}
+
 // NewHash returns a new hash function
 func NewHash() hash.Hash {
 	return GetDefaultHash()()
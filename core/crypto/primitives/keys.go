@@ -90,6 +90,36 @@ func PrivateKeyToEncryptedPEM(privateKey interface{}, pwd []byte) ([]byte, error
 	}
 }
 
+// PrivateKeyToPKCS8PEM converts a private key to a PKCS#8 PEM, encrypted with pwd if not empty
+func PrivateKeyToPKCS8PEM(privateKey interface{}, pwd []byte) ([]byte, error) {
+	switch privateKey.(type) {
+	case *ecdsa.PrivateKey, *rsa.PrivateKey:
+	default:
+		return nil, utils.ErrInvalidKey
+	}
+
+	raw, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pwd) == 0 {
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: raw}), nil
+	}
+
+	block, err := x509.EncryptPEMBlock(
+		rand.Reader,
+		"PRIVATE KEY",
+		raw,
+		pwd,
+		x509.PEMCipherAES256)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(block), nil
+}
+
 // DERToPrivateKey unmarshals a der to private key
 func DERToPrivateKey(der []byte) (key interface{}, err error) {
 	//fmt.Printf("DER [%s]\n", EncodeBase64(der))
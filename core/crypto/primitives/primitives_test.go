@@ -8,7 +8,10 @@ import (
 	"math/big"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/hyperledger/fabric/core/crypto/utils"
 )
 
 type TestParameters struct {
@@ -130,6 +133,48 @@ func TestECDSA(t *testing.T) {
 	}
 }
 
+func TestECDSAKeyForCurve(t *testing.T) {
+	for _, level := range []int{256, 384} {
+		curve, err := GetCurveForSecurityLevel(level)
+		if err != nil {
+			t.Fatalf("Failed getting curve for security level [%d]: [%s]", level, err)
+		}
+
+		key, err := NewECDSAKeyForCurve(curve)
+		if err != nil {
+			t.Fatalf("Failed generating ECDSA key [%s]", err)
+		}
+		if key.Curve != curve {
+			t.Fatalf("Key generated on the wrong curve [%s], expected [%s]", key.Curve.Params().Name, curve.Params().Name)
+		}
+	}
+
+	if _, err := GetCurveForSecurityLevel(1024); err == nil {
+		t.Fatal("Getting a curve for an unsupported security level should fail")
+	}
+}
+
+func TestGetHashForSecurityLevel(t *testing.T) {
+	for _, algorithm := range []string{"SHA2", "SHA3"} {
+		for _, level := range []int{256, 384} {
+			newHash, err := GetHashForSecurityLevel(algorithm, level)
+			if err != nil {
+				t.Fatalf("Failed getting hash for [%s] at security level [%d]: [%s]", algorithm, level, err)
+			}
+			if size := newHash().Size() * 8; size != level {
+				t.Fatalf("Hash for [%s] at security level [%d] has size [%d]", algorithm, level, size)
+			}
+		}
+	}
+
+	if _, err := GetHashForSecurityLevel("SHA3", 1024); err == nil {
+		t.Fatal("Getting a hash for an unsupported security level should fail")
+	}
+	if _, err := GetHashForSecurityLevel("MD5", 256); err == nil {
+		t.Fatal("Getting a hash for an unsupported algorithm should fail")
+	}
+}
+
 func TestECDSAKeys(t *testing.T) {
 	key, err := NewECDSAKey()
 	if err != nil {
@@ -394,6 +439,15 @@ func TestX509(t *testing.T) {
 		t.Fatalf("Failed checking cert vk against sk [%s]", err)
 	}
 
+	// Test PEMtoCertificates
+	certs, err := PEMtoCertificates(append(pem, pem...))
+	if err != nil {
+		t.Fatalf("Failed converting PEM bundle to (x509) [%s]", err)
+	}
+	if len(certs) != 2 || !reflect.DeepEqual(certs[1].Raw, der) {
+		t.Fatalf("Invalid certificates from PEM bundle [%d]", len(certs))
+	}
+
 	// Test DERToX509Certificate
 	if certFromPEM, err = DERToX509Certificate(der); err != nil {
 		t.Fatalf("Failed converting DER to (x509) [%s]", err)
@@ -419,3 +473,61 @@ func TestX509(t *testing.T) {
 		t.Fatalf("Checking cert vk against sk shoud failed. Invalid VK [%s]", err)
 	}
 }
+
+func TestX509Whitespace(t *testing.T) {
+	der, _, err := NewSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Failed genereting self signed cert")
+	}
+	pem := string(DERCertToPEM(der))
+
+	// PEM with CRLF line endings, indentation and surrounding whitespace
+	for _, raw := range []string{
+		strings.Replace(pem, "\n", "\r\n", -1),
+		"\n\t " + strings.Replace(pem, "\n", "\n    ", -1) + " \r\n",
+	} {
+		derFromPEM, err := PEMtoDER([]byte(raw))
+		if err != nil {
+			t.Fatalf("Failed converting PEM to (DER) [%s]", err)
+		}
+		if !reflect.DeepEqual(der, derFromPEM) {
+			t.Fatalf("Invalid der from PEM [%x][%x]", der, derFromPEM)
+		}
+		if _, err := PEMtoCertificate([]byte(raw)); err != nil {
+			t.Fatalf("Failed converting PEM to (x509) [%s]", err)
+		}
+		if _, _, err := PEMtoCertificateAndDER([]byte(raw)); err != nil {
+			t.Fatalf("Failed converting PEM to (x509, DER) [%s]", err)
+		}
+		if certs, err := PEMtoCertificates([]byte(raw + raw)); err != nil || len(certs) != 2 {
+			t.Fatalf("Failed converting PEM bundle to (x509) [%v]", err)
+		}
+
+		// Round trip
+		if !reflect.DeepEqual(DERCertToPEM(derFromPEM), []byte(pem)) {
+			t.Fatal("PEM round trip changed the certificate")
+		}
+	}
+
+	// DER with surrounding whitespace
+	cert, err := DERToX509Certificate(append(append([]byte("\r\n "), der...), "\r\n"...))
+	if err != nil {
+		t.Fatalf("Failed converting DER to (x509) [%s]", err)
+	}
+	if !reflect.DeepEqual(cert.Raw, der) {
+		t.Fatalf("Invalid x509 from DER [%x][%x]", der, cert.Raw)
+	}
+	if _, err := DERToX509Certificate(append(der, "junk"...)); err == nil {
+		t.Fatal("Converting DER to (x509) should fail on trailing data")
+	}
+
+	// Base64 with line breaks
+	encoded := utils.EncodeBase64(der)
+	decoded, err := utils.DecodeBase64(" " + encoded[:40] + "\r\n" + encoded[40:] + "\n")
+	if err != nil {
+		t.Fatalf("Failed decoding base64 [%s]", err)
+	}
+	if !reflect.DeepEqual(der, decoded) {
+		t.Fatal("Base64 round trip changed the certificate")
+	}
+}
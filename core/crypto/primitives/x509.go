@@ -17,6 +17,7 @@ limitations under the License.
 package primitives
 
 import (
+	"bytes"
 	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/rsa"
@@ -48,14 +49,39 @@ var (
 	TCertAttributesHeaders = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 9}
 )
 
-// DERToX509Certificate converts der to x509
+// DERToX509Certificate converts der to x509.
+// Whitespace around the certificate is ignored.
 func DERToX509Certificate(asn1Data []byte) (*x509.Certificate, error) {
-	return x509.ParseCertificate(asn1Data)
+	cert, err := x509.ParseCertificate(asn1Data)
+	if err == nil {
+		return cert, nil
+	}
+
+	// A DER certificate never starts with whitespace. Its length is encoded,
+	// so whatever follows can be checked without guessing where it ends
+	var raw asn1.RawValue
+	rest, uerr := asn1.Unmarshal(bytes.TrimLeft(asn1Data, " \t\r\n"), &raw)
+	if uerr != nil || len(bytes.TrimSpace(rest)) != 0 {
+		return nil, err
+	}
+
+	return x509.ParseCertificate(raw.FullBytes)
+}
+
+// normalizePEM drops the CRLF line endings and the whitespace around each line,
+// found in certificates coming from configuration files or other tools
+func normalizePEM(raw []byte) []byte {
+	lines := bytes.Split(raw, []byte("\n"))
+	for i, line := range lines {
+		lines[i] = bytes.TrimSpace(line)
+	}
+
+	return bytes.Join(lines, []byte("\n"))
 }
 
 // PEMtoCertificate converts pem to x509
 func PEMtoCertificate(raw []byte) (*x509.Certificate, error) {
-	block, _ := pem.Decode(raw)
+	block, _ := pem.Decode(normalizePEM(raw))
 	if block == nil {
 		return nil, errors.New("No PEM block available")
 	}
@@ -72,9 +98,31 @@ func PEMtoCertificate(raw []byte) (*x509.Certificate, error) {
 	return cert, nil
 }
 
+// PEMtoCertificates converts a bundle of PEM certificates to x509
+func PEMtoCertificates(raw []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for block, rest := pem.Decode(normalizePEM(raw)); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" || len(block.Headers) != 0 {
+			return nil, errors.New("Not a valid CERTIFICATE PEM block")
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, errors.New("No PEM block available")
+	}
+
+	return certs, nil
+}
+
 // PEMtoDER converts pem to der
 func PEMtoDER(raw []byte) ([]byte, error) {
-	block, _ := pem.Decode(raw)
+	block, _ := pem.Decode(normalizePEM(raw))
 	if block == nil {
 		return nil, errors.New("No PEM block available")
 	}
@@ -88,7 +136,7 @@ func PEMtoDER(raw []byte) ([]byte, error) {
 
 // PEMtoCertificateAndDER converts pem to x509 and der
 func PEMtoCertificateAndDER(raw []byte) (*x509.Certificate, []byte, error) {
-	block, _ := pem.Decode(raw)
+	block, _ := pem.Decode(normalizePEM(raw))
 	if block == nil {
 		return nil, nil, errors.New("No PEM block available")
 	}
@@ -0,0 +1,430 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package testutil provides helpers to test the nodes of the crypto package
+// against an in-process membership service.
+package testutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/primitives/ecies"
+	membersrvc "github.com/hyperledger/fabric/membersrvc/protos"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// eCertSubjectRole is the object identifier of the role in the enrollment certificates
+var eCertSubjectRole = asn1.ObjectIdentifier{2, 1, 3, 4, 5, 6, 7}
+
+// FakeECAOptions sets how the fake ECA misbehaves. The zero value enrolls anyone.
+type FakeECAOptions struct {
+
+	// AuthFailure rejects every enrollment, as for a wrong password
+	AuthFailure bool
+
+	// Delay is waited before answering each call, to simulate a stalled ECA
+	Delay time.Duration
+
+	// Revoked lists the ECA certificate in the CRL written by WriteCRL
+	Revoked bool
+}
+
+// FakeECA is an ECA serving the public gRPC interface of membersrvc on a unix
+// socket. It runs the enrollment protocol for any identity and password and
+// issues the enrollment certificates with its self-signed certificate. As the
+// membersrvc ECA, it enrolls an identity only once, later certificates are
+// issued by the re-enrollment protocol.
+type FakeECA struct {
+	opts   FakeECAOptions
+	dir    string
+	server *grpc.Server
+
+	key    *ecdsa.PrivateKey
+	cert   []byte
+	obcPub []byte
+
+	// Enrollment challenges, encryption keys and signing enrollment certificates, by identity
+	mutex      sync.Mutex
+	challenges map[string][]byte
+	encKeys    map[string][]byte
+	ecerts     map[string]*x509.Certificate
+}
+
+// NewFakeECA starts a fake ECA. Point peer.pki.eca.paddr at its Addr and Close it when done.
+func NewFakeECA(opts FakeECAOptions) (*FakeECA, error) {
+	eca := &FakeECA{opts: opts, challenges: make(map[string][]byte), encKeys: make(map[string][]byte), ecerts: make(map[string]*x509.Certificate)}
+
+	var err error
+	if eca.key, err = primitives.NewECDSAKey(); err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fake-eca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	if eca.cert, err = x509.CreateCertificate(rand.Reader, template, template, &eca.key.PublicKey, eca.key); err != nil {
+		return nil, err
+	}
+
+	// The chain key of the non-validators
+	chainKey, err := primitives.NewECDSAKey()
+	if err != nil {
+		return nil, err
+	}
+	raw, err := x509.MarshalPKIXPublicKey(&chainKey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	eca.obcPub = pem.EncodeToMemory(&pem.Block{Type: "ECDSA PUBLIC KEY", Bytes: raw})
+
+	if eca.dir, err = ioutil.TempDir("", "fake-eca"); err != nil {
+		return nil, err
+	}
+	lis, err := net.Listen("unix", filepath.Join(eca.dir, "eca.sock"))
+	if err != nil {
+		os.RemoveAll(eca.dir)
+
+		return nil, err
+	}
+	eca.server = grpc.NewServer()
+	membersrvc.RegisterECAPServer(eca.server, eca)
+	go eca.server.Serve(lis)
+
+	return eca, nil
+}
+
+// Addr returns the address to dial the fake ECA at
+func (eca *FakeECA) Addr() string {
+	return "unix://" + filepath.Join(eca.dir, "eca.sock")
+}
+
+// Cert returns the DER encoded certificate of the fake ECA
+func (eca *FakeECA) Cert() []byte {
+	return eca.cert
+}
+
+// WriteCRL writes at path a CRL signed by the fake ECA. It lists the ECA
+// certificate if the fake ECA is Revoked, nothing otherwise.
+func (eca *FakeECA) WriteCRL(path string) error {
+	cert, err := x509.ParseCertificate(eca.cert)
+	if err != nil {
+		return err
+	}
+
+	var revoked []pkix.RevokedCertificate
+	if eca.opts.Revoked {
+		revoked = append(revoked, pkix.RevokedCertificate{SerialNumber: cert.SerialNumber, RevocationTime: time.Now()})
+	}
+	crl, err := cert.CreateCRL(rand.Reader, eca.key, revoked, time.Now(), time.Now().Add(time.Hour))
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, crl, 0644)
+}
+
+// Close stops the fake ECA
+func (eca *FakeECA) Close() {
+	eca.server.Stop()
+	os.RemoveAll(eca.dir)
+}
+
+func (eca *FakeECA) wait(ctx context.Context) error {
+	if eca.opts.Delay <= 0 {
+		return nil
+	}
+
+	select {
+	case <-time.After(eca.opts.Delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ReadCACertificate returns the certificate of the fake ECA
+func (eca *FakeECA) ReadCACertificate(ctx context.Context, in *membersrvc.Empty) (*membersrvc.Cert, error) {
+	if err := eca.wait(ctx); err != nil {
+		return nil, err
+	}
+
+	return &membersrvc.Cert{Cert: eca.cert}, nil
+}
+
+// CreateCertificatePair runs the two steps of the enrollment protocol: it first
+// sends an encrypted challenge, then issues the certificates if the signed
+// request carries the decrypted challenge.
+func (eca *FakeECA) CreateCertificatePair(ctx context.Context, in *membersrvc.ECertCreateReq) (*membersrvc.ECertCreateResp, error) {
+	if err := eca.wait(ctx); err != nil {
+		return nil, err
+	}
+	if eca.opts.AuthFailure {
+		return nil, grpc.Errorf(codes.Unauthenticated, "Identity or token does not match.")
+	}
+
+	id := in.Id.Id
+	if eca.ecert(id) != nil {
+		return nil, grpc.Errorf(codes.AlreadyExists, "Identity already enrolled.")
+	}
+
+	return eca.createCertificatePair(id, in)
+}
+
+// ReEnrollCertificatePair runs the enrollment protocol, as CreateCertificatePair, for an identity
+// already enrolled. The requests are authenticated with the current enrollment certificate.
+func (eca *FakeECA) ReEnrollCertificatePair(ctx context.Context, in *membersrvc.ECertCreateReq) (*membersrvc.ECertCreateResp, error) {
+	if err := eca.wait(ctx); err != nil {
+		return nil, err
+	}
+
+	id := in.Id.Id
+	ecert := eca.ecert(id)
+	if ecert == nil {
+		return nil, grpc.Errorf(codes.FailedPrecondition, "Identity not enrolled.")
+	}
+
+	ecertSig, sig := in.EcertSig, in.Sig
+	in.EcertSig, in.Sig = nil, nil
+	raw, err := proto.Marshal(in)
+	in.EcertSig, in.Sig = ecertSig, sig
+	if err != nil {
+		return nil, err
+	}
+	if ecertSig == nil || verifySignature(ecert.PublicKey, raw, ecertSig) != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "Enrollment certificate signature verification failed.")
+	}
+
+	return eca.createCertificatePair(id, in)
+}
+
+// createCertificatePair runs the two steps of the enrollment protocol for id
+func (eca *FakeECA) createCertificatePair(id string, in *membersrvc.ECertCreateReq) (*membersrvc.ECertCreateResp, error) {
+	ekey, err := x509.ParsePKIXPublicKey(in.Enc.Key)
+	if err != nil {
+		return nil, err
+	}
+	encKey, ok := ekey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("Unsupported (encryption) key type.")
+	}
+
+	if in.Sig == nil {
+		return eca.challenge(id, in.Enc.Key, encKey)
+	}
+
+	eca.mutex.Lock()
+	challenge, prev := eca.challenges[id], eca.encKeys[id]
+	delete(eca.challenges, id)
+	delete(eca.encKeys, id)
+	eca.mutex.Unlock()
+
+	if challenge == nil || subtle.ConstantTimeCompare(in.Tok.Tok, challenge) != 1 {
+		return nil, grpc.Errorf(codes.Unauthenticated, "Identity or token does not match.")
+	}
+	if subtle.ConstantTimeCompare(in.Enc.Key, prev) != 1 {
+		return nil, errors.New("Encryption keys do not match.")
+	}
+
+	skey, err := verifyRequest(in)
+	if err != nil {
+		return nil, err
+	}
+
+	sraw, err := eca.issue(id, skey, x509.KeyUsageDigitalSignature, in.Attrs)
+	if err != nil {
+		return nil, err
+	}
+	eraw, err := eca.issue(id, encKey, x509.KeyUsageDataEncipherment, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	ecert, err := x509.ParseCertificate(sraw)
+	if err != nil {
+		return nil, err
+	}
+	eca.mutex.Lock()
+	eca.ecerts[id] = ecert
+	eca.mutex.Unlock()
+
+	return &membersrvc.ECertCreateResp{Certs: &membersrvc.CertPair{Sign: sraw, Enc: eraw}, Chain: &membersrvc.Token{}, Pkchain: eca.obcPub, Nonce: in.Nonce}, nil
+}
+
+// ecert returns the signing enrollment certificate last issued to id, nil if id is not enrolled
+func (eca *FakeECA) ecert(id string) *x509.Certificate {
+	eca.mutex.Lock()
+	defer eca.mutex.Unlock()
+
+	return eca.ecerts[id]
+}
+
+// ReadCertificatePair is not implemented
+func (eca *FakeECA) ReadCertificatePair(ctx context.Context, in *membersrvc.ECertReadReq) (*membersrvc.CertPair, error) {
+	return nil, grpc.Errorf(codes.Unimplemented, "Not implemented by the fake ECA.")
+}
+
+// ReadCertificateByHash is not implemented
+func (eca *FakeECA) ReadCertificateByHash(ctx context.Context, in *membersrvc.Hash) (*membersrvc.Cert, error) {
+	return nil, grpc.Errorf(codes.Unimplemented, "Not implemented by the fake ECA.")
+}
+
+// RevokeCertificatePair is not implemented
+func (eca *FakeECA) RevokeCertificatePair(ctx context.Context, in *membersrvc.ECertRevokeReq) (*membersrvc.CAStatus, error) {
+	return nil, grpc.Errorf(codes.Unimplemented, "Not implemented by the fake ECA.")
+}
+
+func (eca *FakeECA) challenge(id string, raw []byte, encKey *ecdsa.PublicKey) (*membersrvc.ECertCreateResp, error) {
+	challenge, err := primitives.GetRandomBytes(12)
+	if err != nil {
+		return nil, err
+	}
+
+	spi := ecies.NewSPI()
+	eciesKey, err := spi.NewPublicKey(nil, encKey)
+	if err != nil {
+		return nil, err
+	}
+	cipher, err := spi.NewAsymmetricCipherFromPublicKey(eciesKey)
+	if err != nil {
+		return nil, err
+	}
+	out, err := cipher.Process(challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	eca.mutex.Lock()
+	eca.challenges[id] = challenge
+	eca.encKeys[id] = raw
+	eca.mutex.Unlock()
+
+	return &membersrvc.ECertCreateResp{Tok: &membersrvc.Token{Tok: out}}, nil
+}
+
+// verifyRequest checks the signature of in, as the membersrvc ECA does, and returns the signing key
+func verifyRequest(in *membersrvc.ECertCreateReq) (interface{}, error) {
+	sig := in.Sig
+	in.Sig = nil
+	defer func() { in.Sig = sig }()
+
+	skey, err := x509.ParsePKIXPublicKey(in.Sign.Key)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := proto.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(skey, raw, sig); err != nil {
+		return nil, err
+	}
+
+	return skey, nil
+}
+
+// verifySignature checks the signature sig of the marshalled request raw by pub
+func verifySignature(pub interface{}, raw []byte, sig *membersrvc.Signature) error {
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		newHash, err := primitives.GetHashForSecurityLevel(primitives.GetHashAlgorithm(), pub.Curve.Params().BitSize)
+		if err != nil {
+			return err
+		}
+		hash := newHash()
+		hash.Write(raw)
+
+		r, s := big.NewInt(0), big.NewInt(0)
+		r.UnmarshalText(sig.R)
+		s.UnmarshalText(sig.S)
+		if !ecdsa.Verify(pub, hash.Sum(nil), r, s) {
+			return errors.New("Signature verification failed.")
+		}
+	default:
+		return errors.New("Unsupported (signing) key type.")
+	}
+
+	return nil
+}
+
+// issue creates an enrollment certificate for id, with the requested attributes as extensions
+func (eca *FakeECA) issue(id string, pub interface{}, usage x509.KeyUsage, attrs []*membersrvc.ECertAttribute) ([]byte, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	exts := []pkix.Extension{{Id: eCertSubjectRole, Critical: true, Value: []byte(strconv.Itoa(int(membersrvc.Role_CLIENT)))}}
+	for _, attr := range attrs {
+		oid, err := parseOID(attr.Oid)
+		if err != nil {
+			return nil, err
+		}
+		exts = append(exts, pkix.Extension{Id: oid, Value: attr.Value})
+	}
+
+	ca, err := x509.ParseCertificate(eca.cert)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:    serial,
+		Subject:         pkix.Name{CommonName: id},
+		NotBefore:       time.Now().Add(-time.Minute),
+		NotAfter:        time.Now().Add(time.Hour),
+		KeyUsage:        usage,
+		ExtraExtensions: exts,
+	}
+
+	return x509.CreateCertificate(rand.Reader, template, ca, pub, eca.key)
+}
+
+func parseOID(oid string) (asn1.ObjectIdentifier, error) {
+	var id asn1.ObjectIdentifier
+	for _, part := range strings.Split(oid, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, errors.New("Invalid object identifier [" + oid + "].")
+		}
+		id = append(id, n)
+	}
+
+	return id, nil
+}
@@ -58,6 +58,9 @@ var (
 	// ErrNotImplemented Not implemented
 	ErrNotImplemented = errors.New("Not implemented.")
 
+	// ErrNotSupported Not supported
+	ErrNotSupported = errors.New("Not supported.")
+
 	// ErrKeyStoreAlreadyInitialized Keystore already Initilized
 	ErrKeyStoreAlreadyInitialized = errors.New("Keystore already Initilized.")
 
@@ -84,6 +87,93 @@ var (
 
 	// ErrInvalidProtocolVersion Invalid protocol version
 	ErrInvalidProtocolVersion = errors.New("Invalid protocol version")
+
+	// ErrEnrollmentAuthFailed The ECA rejected the enrollment credentials
+	ErrEnrollmentAuthFailed = errors.New("Enrollment failed. The enrollment id or password is not valid.")
+
+	// ErrAlreadyEnrolled The ECA has already issued the enrollment certificates of the identity
+	ErrAlreadyEnrolled = errors.New("The identity is already enrolled.")
+
+	// ErrInvalidEnrollmentRequest The ECA rejected the enrollment request as malformed
+	ErrInvalidEnrollmentRequest = errors.New("Invalid enrollment request.")
+
+	// ErrNotECAServer The ECA address points at a gRPC server other than the ECA
+	ErrNotECAServer = errors.New("Not an ECA server.")
+
+	// ErrECAUnavailable The ECA cannot be reached
+	ErrECAUnavailable = errors.New("The ECA is not available.")
+
+	// ErrPKCS11NotAvailable PKCS#11 is enabled but not supported by this build
+	ErrPKCS11NotAvailable = errors.New("PKCS#11 support not available.")
+
+	// ErrNoTrustedRoots Trusted root certificates are configured but none is loaded
+	ErrNoTrustedRoots = errors.New("No trusted root certificate loaded.")
+
+	// ErrInvalidECACert The ECA certificate cannot be parsed
+	ErrInvalidECACert = errors.New("Invalid ECA certificate.")
+
+	// ErrECACertPinMismatch The ECA certificate does not match the configured pin
+	ErrECACertPinMismatch = errors.New("The ECA certificate does not match the configured pin.")
+
+	// ErrECACertNotAllowed The fingerprint of the ECA certificate is not in the configured allowlist
+	ErrECACertNotAllowed = errors.New("The ECA certificate is not in the allowlist.")
+
+	// ErrECACertRevoked The ECA certificate is listed in the configured CRL
+	ErrECACertRevoked = errors.New("The ECA certificate has been revoked.")
+
+	// ErrEnrollmentCertRevoked The enrollment certificate has been revoked
+	ErrEnrollmentCertRevoked = errors.New("The enrollment certificate has been revoked. Re-enrollment required.")
+
+	// ErrCertNotFound No certificate is stored under the requested name
+	ErrCertNotFound = errors.New("Certificate not found.")
+
+	// ErrInvalidCert The certificate cannot be parsed or does not chain to a trusted root
+	ErrInvalidCert = errors.New("Invalid certificate.")
+
+	// ErrEmptyCertChain A stored certificates chain is empty, likely after an incomplete write
+	ErrEmptyCertChain = errors.New("Certificates chain empty, probably incompletely written. Remove it and enroll again.")
+
+	// ErrWrongPassphrase The stored key cannot be decrypted with the passed passphrase
+	ErrWrongPassphrase = errors.New("Wrong passphrase.")
+
+	// ErrKeyTooWeak The key parameters are weaker than the configured minimum security level
+	ErrKeyTooWeak = errors.New("Key parameters weaker than the minimum security level.")
+
+	// ErrClockSkew The ECA rejected the request timestamp, the clocks of the node and the ECA differ too much
+	ErrClockSkew = errors.New("Request timestamp rejected by the ECA. Check the clocks of this node and the ECA.")
+
+	// ErrInsecureSecretsFile The secrets file can be read by users other than its owner
+	ErrInsecureSecretsFile = errors.New("Secrets file accessible by group or others. Restrict it to 0600.")
+
+	// ErrInvalidSecretsFile The secrets file does not hold an enrollment id and password
+	ErrInvalidSecretsFile = errors.New("Invalid secrets file. Expected the enrollment id and password on two lines.")
+
+	// ErrMissingEnrollmentAttribute The enrollment certificate lacks a requested attribute
+	ErrMissingEnrollmentAttribute = errors.New("Requested attribute missing in the enrollment certificate.")
+
+	// ErrEnrollmentAttributesNotSupported The ECA is not known to embed the requested attributes
+	ErrEnrollmentAttributesNotSupported = errors.New("The ECA does not support enrollment attributes. Set peer.pki.eca.attributes if it does.")
+
+	// ErrCertLifetimeTooLong The enrollment certificate is valid for longer than allowed
+	ErrCertLifetimeTooLong = errors.New("Enrollment certificate validity period exceeds the maximum lifetime.")
+
+	// ErrCertStoreDir The cert store directory cannot be created
+	ErrCertStoreDir = errors.New("Failed creating the cert store directory.")
+
+	// ErrNodeClosed The node has been closed
+	ErrNodeClosed = errors.New("The node has been closed.")
+
+	// ErrCTSubmissionFailed The enrollment certificate could not be submitted to the certificate transparency log
+	ErrCTSubmissionFailed = errors.New("Failed submitting the enrollment certificate to the certificate transparency log.")
+
+	// ErrSelfSignedCert The enrollment certificate is self-signed instead of being issued by the ECA
+	ErrSelfSignedCert = errors.New("Self-signed enrollment certificate.")
+
+	// ErrCertIOTimeout Reading a stored certificate took longer than security.certiotimeout
+	ErrCertIOTimeout = errors.New("Timed out reading the stored certificate.")
+
+	// ErrReEnrollmentNotSupported The ECA does not serve the re-enrollment of enrolled identities
+	ErrReEnrollmentNotSupported = errors.New("The ECA does not support re-enrollment.")
 )
 
 // ErrToString converts and error to a string. If the error is nil, it returns the string "<clean>"
@@ -18,9 +18,12 @@ package utils
 
 import (
 	"encoding/base64"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 // DirMissingOrEmpty checks is a directory is missin or empty
@@ -88,9 +91,51 @@ func FilePathMissing(path string) (bool, error) {
 	return false, nil
 }
 
-// DecodeBase64 decodes from Base64
+// WriteFileAtomic writes data to the file at path like ioutil.WriteFile, but through a
+// temporary file renamed into place. If the process dies or the power fails, the file
+// holds either its previous or its new content, never a truncated one.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	// No-op once renamed
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	// Persist the rename
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// DecodeBase64 decodes from Base64. Whitespace, including line breaks, is ignored
 func DecodeBase64(in string) ([]byte, error) {
-	return base64.StdEncoding.DecodeString(in)
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(in), ""))
 }
 
 // EncodeBase64 encodes to Base64
@@ -98,6 +143,16 @@ func EncodeBase64(in []byte) string {
 	return base64.StdEncoding.EncodeToString(in)
 }
 
+// EncodeFingerprint encodes to colon-separated uppercase hex, e.g. "AB:CD:EF"
+func EncodeFingerprint(in []byte) string {
+	parts := make([]string, len(in))
+	for i, b := range in {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+
+	return strings.Join(parts, ":")
+}
+
 // IntArrayEquals checks if the arrays of ints are the same
 func IntArrayEquals(a []int, b []int) bool {
 	if len(a) != len(b) {
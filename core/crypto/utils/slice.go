@@ -23,3 +23,10 @@ func Clone(src []byte) []byte {
 
 	return clone
 }
+
+// Zero overwrites the passed slice with zeros
+func Zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
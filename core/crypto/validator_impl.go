@@ -130,7 +130,7 @@ func (validator *validatorImpl) Verify(vkID, signature, message []byte) error {
 func (validator *validatorImpl) register(id string, pwd []byte, enrollID, enrollPWD string, regFunc registerFunc) error {
 	// Register node
 	if err := validator.peerImpl.register(NodeValidator, id, pwd, enrollID, enrollPWD, nil); err != nil {
-		validator.Errorf("Failed registering [%s]: [%s]", enrollID, err)
+		validator.Errorf("Failed registering [%s]: [%s]", validator.logID(enrollID), err)
 		return err
 	}
 
@@ -455,7 +455,7 @@ func (ca *CA) readCertificateByKeyUsage(id string, usage x509.KeyUsage) ([]byte,
 	defer mutex.RUnlock()
 
 	var raw []byte
-	err := ca.db.QueryRow("SELECT cert FROM Certificates WHERE id=? AND usage=?", id, usage).Scan(&raw)
+	err := ca.db.QueryRow("SELECT cert FROM Certificates WHERE id=? AND usage=? ORDER BY timestamp DESC", id, usage).Scan(&raw)
 
 	if err != nil {
 		Trace.Printf("readCertificateByKeyUsage() Error: %v", err)
@@ -17,8 +17,11 @@ limitations under the License.
 package ca
 
 import (
+	"bytes"
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/x509"
 	"errors"
 	"google/protobuf"
@@ -31,6 +34,8 @@ import (
 	"github.com/hyperledger/fabric/core/crypto/primitives/ecies"
 	pb "github.com/hyperledger/fabric/membersrvc/protos"
 	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 )
 
 type User struct {
@@ -158,6 +163,94 @@ func enrollUser(user *User) error {
 	return nil
 }
 
+//helper function re-enrolling an enrolled user with new keys
+func reEnrollUser(user *User) (*pb.CertPair, error) {
+
+	ecap := &ECAP{eca}
+
+	signPriv, err := primitives.NewECDSAKey()
+	if err != nil {
+		return nil, err
+	}
+	signPub, err := x509.MarshalPKIXPublicKey(&signPriv.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	encPriv, err := primitives.NewECDSAKey()
+	if err != nil {
+		return nil, err
+	}
+	encPub, err := x509.MarshalPKIXPublicKey(&encPriv.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &pb.ECertCreateReq{
+		Ts:   &google_protobuf.Timestamp{Seconds: time.Now().Unix(), Nanos: 0},
+		Id:   &pb.Identity{Id: user.enrollID},
+		Sign: &pb.PublicKey{Type: pb.CryptoType_ECDSA, Key: signPub},
+		Enc:  &pb.PublicKey{Type: pb.CryptoType_ECDSA, Key: encPub},
+		Sig:  nil}
+
+	sign := func(key *ecdsa.PrivateKey) (*pb.Signature, error) {
+		hash := primitives.NewHash()
+		raw, _ := proto.Marshal(req)
+		hash.Write(raw)
+
+		r, s, err := ecdsa.Sign(rand.Reader, key, hash.Sum(nil))
+		if err != nil {
+			return nil, err
+		}
+		R, _ := r.MarshalText()
+		S, _ := s.MarshalText()
+		return &pb.Signature{Type: pb.CryptoType_ECDSA, R: R, S: S}, nil
+	}
+
+	//Phase 1 of the protocol, authenticated by the current enrollment key
+	if req.EcertSig, err = sign(user.enrollPrivKey); err != nil {
+		return nil, err
+	}
+	resp, err := ecap.ReEnrollCertificatePair(context.Background(), req)
+	if err != nil {
+		return nil, err
+	}
+
+	//Phase 2 of the protocol
+	spi := ecies.NewSPI()
+	eciesKey, err := spi.NewPrivateKey(nil, encPriv)
+	if err != nil {
+		return nil, err
+	}
+
+	ecies, err := spi.NewAsymmetricCipherFromPublicKey(eciesKey)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := ecies.Process(resp.Tok.Tok)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Tok = &pb.Token{Tok: out}
+	req.EcertSig = nil
+	if req.EcertSig, err = sign(user.enrollPrivKey); err != nil {
+		return nil, err
+	}
+	if req.Sig, err = sign(signPriv); err != nil {
+		return nil, err
+	}
+
+	resp, err = ecap.ReEnrollCertificatePair(context.Background(), req)
+	if err != nil {
+		return nil, err
+	}
+
+	user.enrollPrivKey = signPriv
+	return resp.Certs, nil
+}
+
 func registerUser(registrar User, user *User) error {
 
 	ecaa := &ECAA{eca}
@@ -261,6 +354,17 @@ func TestCreateCertificatePairTestUser(t *testing.T) {
 	}
 }
 
+//enroll testUser again with its password - should get error
+func TestCreateCertificatePairAlreadyEnrolled(t *testing.T) {
+
+	// a copy, to keep the enrollment key of testUser
+	user := testUser
+	err := enrollUser(&user)
+	if grpc.Code(err) != codes.AlreadyExists {
+		t.Fatalf("Expected an already enrolled error, got [%v]", err)
+	}
+}
+
 //register testUser again - should get error
 func TestRegisterDuplicateUser(t *testing.T) {
 
@@ -333,6 +437,78 @@ func TestCreateCertificatePairClient(t *testing.T) {
 	}
 }
 
+//RSA signing keys are not supported
+func TestCreateCertificatePairRSA(t *testing.T) {
+	user := User{enrollID: "testRSAUser", role: 1, affiliation: "institution_a"}
+	if err := registerUser(testAdmin, &user); err != nil {
+		t.Fatalf("Failed to register testRSAUser: [%s]", err.Error())
+	}
+
+	ecap := &ECAP{eca}
+
+	signPriv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signPub, err := x509.MarshalPKIXPublicKey(&signPriv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encPriv, err := primitives.NewECDSAKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encPub, err := x509.MarshalPKIXPublicKey(&encPriv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := &pb.ECertCreateReq{
+		Ts:   &google_protobuf.Timestamp{Seconds: time.Now().Unix(), Nanos: 0},
+		Id:   &pb.Identity{Id: user.enrollID},
+		Tok:  &pb.Token{Tok: user.enrollPwd},
+		Sign: &pb.PublicKey{Type: pb.CryptoType_RSA, Key: signPub},
+		Enc:  &pb.PublicKey{Type: pb.CryptoType_ECDSA, Key: encPub},
+		Sig:  nil}
+
+	resp, err := ecap.CreateCertificatePair(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spi := ecies.NewSPI()
+	eciesKey, err := spi.NewPrivateKey(nil, encPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecies, err := spi.NewAsymmetricCipherFromPublicKey(eciesKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ecies.Process(resp.Tok.Tok)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Tok.Tok = out
+	req.Sig = nil
+
+	hash := primitives.NewHash()
+	raw, _ := proto.Marshal(req)
+	hash.Write(raw)
+
+	sig, err := rsa.SignPKCS1v15(rand.Reader, signPriv, crypto.SHA256, hash.Sum(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Sig = &pb.Signature{Type: pb.CryptoType_RSA, R: sig}
+	if _, err := ecap.CreateCertificatePair(context.Background(), req); err == nil {
+		t.Fatal("RSA signing keys must be rejected")
+	}
+}
+
 //testClient1 should be able to register testClient2 since testClient1's
 //delegateRoles field contains the value "client"
 func TestRegisterUserClientAsRegistrar(t *testing.T) {
@@ -386,6 +562,63 @@ func TestReadCertificatePair(t *testing.T) {
 	}
 }
 
+func TestReEnrollCertificatePair(t *testing.T) {
+
+	ecap := &ECAP{eca}
+
+	certs, err := reEnrollUser(&testUser)
+	if err != nil {
+		t.Fatalf("Failed to re-enroll testUser: [%s]", err.Error())
+	}
+
+	pair, err := ecap.ReadCertificatePair(context.Background(), &pb.ECertReadReq{Id: &pb.Identity{Id: testUser.enrollID}})
+	if err != nil {
+		t.Fatalf("Failed to read certificate pair: [%s]", err.Error())
+	}
+	if !bytes.Equal(pair.Sign, certs.Sign) || !bytes.Equal(pair.Enc, certs.Enc) {
+		t.Fatal("The re-enrolled certificate pair should be the one returned")
+	}
+
+	//the enrollment password can no longer be used
+	user := testUser
+	if err := enrollUser(&user); err == nil {
+		t.Fatal("Enrolling testUser again should have failed")
+	}
+}
+
+func TestReEnrollCertificatePairBadSignature(t *testing.T) {
+
+	ecap := &ECAP{eca}
+
+	key, err := primitives.NewECDSAKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+
+	req := &pb.ECertCreateReq{
+		Ts:   &google_protobuf.Timestamp{Seconds: time.Now().Unix(), Nanos: 0},
+		Id:   &pb.Identity{Id: testUser.enrollID},
+		Sign: &pb.PublicKey{Type: pb.CryptoType_ECDSA, Key: pub},
+		Enc:  &pb.PublicKey{Type: pb.CryptoType_ECDSA, Key: pub}}
+
+	hash := primitives.NewHash()
+	raw, _ := proto.Marshal(req)
+	hash.Write(raw)
+	r, s, err := ecdsa.Sign(rand.Reader, key, hash.Sum(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	R, _ := r.MarshalText()
+	S, _ := s.MarshalText()
+	req.EcertSig = &pb.Signature{Type: pb.CryptoType_ECDSA, R: R, S: S}
+
+	_, err = ecap.ReEnrollCertificatePair(context.Background(), req)
+	if grpc.Code(err) != codes.Unauthenticated {
+		t.Fatalf("Expected an Unauthenticated error for a request not signed by the enrollment key, got [%v]", err)
+	}
+}
+
 func TestReadCertificatePairBadIdentity(t *testing.T) {
 	ecap := &ECAP{eca}
 
@@ -517,26 +750,30 @@ func TestCreateCertificatePairBadIdentity(t *testing.T) {
 		Sig:  nil}
 
 	_, err := ecap.CreateCertificatePair(context.Background(), req)
-	if err.Error() != "Identity lookup error: sql: no rows in result set" {
+	if grpc.Code(err) != codes.Unauthenticated || grpc.ErrorDesc(err) != "Identity lookup error: sql: no rows in result set" {
 		t.Log(err.Error())
 		t.Fatal("The expected error of 'Identity lookup error: sql: no rows in result set' was not returned for bad identity")
 	}
 }
 
 func TestCreateCertificatePairBadToken(t *testing.T) {
+	user := User{enrollID: "testBadTokenUser", role: 1, affiliation: "institution_a"}
+	if err := registerUser(testAdmin, &user); err != nil {
+		t.Fatalf("Failed to register testBadTokenUser: [%s]", err.Error())
+	}
 
 	ecap := &ECAP{eca}
 
 	req := &pb.ECertCreateReq{
 		Ts:   &google_protobuf.Timestamp{Seconds: time.Now().Unix(), Nanos: 0},
-		Id:   &pb.Identity{Id: testUser.enrollID},
+		Id:   &pb.Identity{Id: user.enrollID},
 		Tok:  &pb.Token{Tok: []byte("badPassword")},
 		Sign: &pb.PublicKey{Type: pb.CryptoType_ECDSA, Key: []byte{0}},
 		Enc:  &pb.PublicKey{Type: pb.CryptoType_ECDSA, Key: []byte{0}},
 		Sig:  nil}
 
 	_, err := ecap.CreateCertificatePair(context.Background(), req)
-	if err.Error() != "Identity or token does not match." {
+	if grpc.Code(err) != codes.Unauthenticated {
 		t.Fatal("Expected error was not returned for bad password")
 	}
 }
@@ -569,3 +806,4 @@ func TestPublishCRL(t *testing.T) {
 		t.Fatalf("Expected error was not returned: [%s]", err.Error())
 	}
 }
+
@@ -36,6 +36,8 @@ import (
 	pb "github.com/hyperledger/fabric/membersrvc/protos"
 	"github.com/spf13/viper"
 	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 )
 
 // ECAP serves the public GRPC interface of the ECA.
@@ -109,13 +111,17 @@ func (ecap *ECAP) CreateCertificatePair(ctx context.Context, in *pb.ECertCreateR
 	err := ecap.eca.readUser(id).Scan(&role, &tok, &state, &prev, &enrollID)
 
 	if err != nil {
-		errMsg := "Identity lookup error: " + err.Error()
-		Trace.Println(errMsg)
-		return nil, errors.New(errMsg)
+		Trace.Println("Identity lookup error: " + err.Error())
+		return nil, grpc.Errorf(codes.Unauthenticated, "Identity lookup error: %s", err)
+	}
+	if state != 0 && state != 1 {
+		// the password has been consumed by the enrollment
+		Trace.Printf("identity already enrolled: id=%s\n", id)
+		return nil, grpc.Errorf(codes.AlreadyExists, "Identity already enrolled.")
 	}
 	if !bytes.Equal(tok, in.Tok.Tok) {
 		Trace.Printf("id or token mismatch: id=%s\n", id)
-		return nil, errors.New("Identity or token does not match.")
+		return nil, grpc.Errorf(codes.Unauthenticated, "Identity or token does not match.")
 	}
 
 	ekey, err := x509.ParsePKIXPublicKey(in.Enc.Key)
@@ -123,7 +129,6 @@ func (ecap *ECAP) CreateCertificatePair(ctx context.Context, in *pb.ECertCreateR
 		return nil, err
 	}
 
-	fetchResult := pb.FetchAttrsResult{Status: pb.FetchAttrsResult_SUCCESS, Msg: ""}
 	switch {
 	case state == 0:
 		// initial request, create encryption challenge
@@ -138,18 +143,7 @@ func (ecap *ECAP) CreateCertificatePair(ctx context.Context, in *pb.ECertCreateR
 			return nil, err
 		}
 
-		spi := ecies.NewSPI()
-		eciesKey, err := spi.NewPublicKey(nil, ekey.(*ecdsa.PublicKey))
-		if err != nil {
-			return nil, err
-		}
-
-		ecies, err := spi.NewAsymmetricCipherFromPublicKey(eciesKey)
-		if err != nil {
-			return nil, err
-		}
-
-		out, err := ecies.Process(tok)
+		out, err := encryptChallenge(ekey.(*ecdsa.PublicKey), tok)
 
 		return &pb.ECertCreateResp{Certs: nil, Chain: nil, Pkchain: nil, Tok: &pb.Token{Tok: out}}, err
 
@@ -162,80 +156,236 @@ func (ecap *ECAP) CreateCertificatePair(ctx context.Context, in *pb.ECertCreateR
 		// validate request signature
 		sig := in.Sig
 		in.Sig = nil
-
-		r, s := big.NewInt(0), big.NewInt(0)
-		r.UnmarshalText(sig.R)
-		s.UnmarshalText(sig.S)
-
-		if in.Sign.Type != pb.CryptoType_ECDSA {
-			return nil, errors.New("Unsupported (signing) key type.")
+		if sig == nil {
+			return nil, errors.New("Missing request signature.")
 		}
+
 		skey, err := x509.ParsePKIXPublicKey(in.Sign.Key)
 		if err != nil {
 			return nil, err
 		}
 
-		hash := primitives.NewHash()
 		raw, _ := proto.Marshal(in)
-		hash.Write(raw)
-		if ecdsa.Verify(skey.(*ecdsa.PublicKey), hash.Sum(nil), r, s) == false {
-			return nil, errors.New("Signature verification failed.")
+		if err := verifyRequestSignature(skey, in.Sign.Type, raw, sig); err != nil {
+			return nil, err
 		}
 
-		// create new certificate pair
-		ts := time.Now().Add(-1 * time.Minute).UnixNano()
+		return ecap.createCertificatePair(id, enrollID, role, skey, ekey, in.Nonce)
+	}
+
+	return nil, errors.New("Invalid (=expired) certificate creation token provided.")
+}
+
+// ReEnrollCertificatePair requests the creation of a new enrollment certificate pair, for new keys,
+// by an identity already enrolled. The ECA does not keep the enrollment password: instead the request
+// is signed, in EcertSig, with the key of the current enrollment certificate. As for CreateCertificatePair,
+// the first request gets a challenge encrypted for the new encryption key, and the second one,
+// carrying the challenge and signed with the new signing key, gets the new certificate pair.
+// The previous certificates are kept, to verify what has been signed with them, but are no longer
+// returned by ReadCertificatePair.
+//
+func (ecap *ECAP) ReEnrollCertificatePair(ctx context.Context, in *pb.ECertCreateReq) (*pb.ECertCreateResp, error) {
+	Trace.Println("gRPC ECAP:ReEnrollCertificatePair")
+
+	var tok, prev []byte
+	var role, state int
+	var enrollID string
+
+	if in.Id == nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Missing identity.")
+	}
+
+	id := in.Id.Id
+	err := ecap.eca.readUser(id).Scan(&role, &tok, &state, &prev, &enrollID)
+	if err != nil {
+		Trace.Println("Identity lookup error: " + err.Error())
+		return nil, grpc.Errorf(codes.Unauthenticated, "Identity lookup error: %s", err)
+	}
+	if state != 2 && state != 3 {
+		return nil, grpc.Errorf(codes.FailedPrecondition, "Identity not enrolled.")
+	}
+	if in.Enc == nil || in.Sign == nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Missing public keys.")
+	}
+
+	// authenticate the request with the current enrollment certificate
+	ecertSig, sig := in.EcertSig, in.Sig
+	in.EcertSig, in.Sig = nil, nil
+	if ecertSig == nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "Missing enrollment certificate signature.")
+	}
+
+	ecert, err := ecap.eca.readCertificateByKeyUsage(id, x509.KeyUsageDigitalSignature)
+	if err != nil {
+		return nil, grpc.Errorf(codes.FailedPrecondition, "No enrollment certificate for the given identity was found.")
+	}
+	x509ECert, err := x509.ParseCertificate(ecert)
+	if err != nil {
+		Error.Println(err)
+		return nil, err
+	}
+
+	raw, _ := proto.Marshal(in)
+	if err := verifyRequestSignature(x509ECert.PublicKey, ecertSig.Type, raw, ecertSig); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "%s", err)
+	}
+
+	ekey, err := x509.ParsePKIXPublicKey(in.Enc.Key)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "%s", err)
+	}
+	if _, ok := ekey.(*ecdsa.PublicKey); !ok {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Unsupported (encryption) key type.")
+	}
 
-		spec := NewDefaultCertificateSpecWithCommonName(id, enrollID, skey.(*ecdsa.PublicKey), x509.KeyUsageDigitalSignature, pkix.Extension{Id: ECertSubjectRole, Critical: true, Value: []byte(strconv.Itoa(ecap.eca.readRole(id)))})
-		sraw, err := ecap.eca.createCertificateFromSpec(spec, ts, nil, true)
+	if in.Tok == nil || len(in.Tok.Tok) == 0 {
+		// initial request, create encryption challenge
+		tok = []byte(randomString(12))
+
+		mutex.Lock()
+		_, err = ecap.eca.db.Exec("UPDATE Users SET token=?, state=?, key=? WHERE id=?", tok, 3, in.Enc.Key, id)
+		mutex.Unlock()
 		if err != nil {
 			Error.Println(err)
 			return nil, err
 		}
 
-		_ = ioutil.WriteFile("/tmp/ecert_"+id, sraw, 0644)
+		out, err := encryptChallenge(ekey.(*ecdsa.PublicKey), tok)
+
+		return &pb.ECertCreateResp{Certs: nil, Chain: nil, Pkchain: nil, Tok: &pb.Token{Tok: out}}, err
+	}
+
+	if state != 3 || subtle.ConstantTimeCompare(in.Tok.Tok, tok) != 1 {
+		return nil, grpc.Errorf(codes.Unauthenticated, "Invalid re-enrollment challenge.")
+	}
+
+	// ensure that the same encryption key is signed that has been used for the challenge
+	if subtle.ConstantTimeCompare(in.Enc.Key, prev) != 1 {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Encryption keys do not match.")
+	}
 
-		spec = NewDefaultCertificateSpecWithCommonName(id, enrollID, ekey.(*ecdsa.PublicKey), x509.KeyUsageDataEncipherment, pkix.Extension{Id: ECertSubjectRole, Critical: true, Value: []byte(strconv.Itoa(ecap.eca.readRole(id)))})
-		eraw, err := ecap.eca.createCertificateFromSpec(spec, ts, nil, true)
+	// validate request signature by the new signing key
+	if sig == nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Missing request signature.")
+	}
+
+	skey, err := x509.ParsePKIXPublicKey(in.Sign.Key)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "%s", err)
+	}
+
+	in.EcertSig = ecertSig
+	raw, _ = proto.Marshal(in)
+	in.EcertSig = nil
+	if err := verifyRequestSignature(skey, in.Sign.Type, raw, sig); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "%s", err)
+	}
+
+	return ecap.createCertificatePair(id, enrollID, role, skey, ekey, in.Nonce)
+}
+
+// encryptChallenge encrypts the enrollment challenge tok for the encryption key ekey
+func encryptChallenge(ekey *ecdsa.PublicKey, tok []byte) ([]byte, error) {
+	spi := ecies.NewSPI()
+	eciesKey, err := spi.NewPublicKey(nil, ekey)
+	if err != nil {
+		return nil, err
+	}
+
+	ecies, err := spi.NewAsymmetricCipherFromPublicKey(eciesKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return ecies.Process(tok)
+}
+
+// verifyRequestSignature verifies the signature sig over the marshalled request raw by the key pub
+// of type keyType.
+func verifyRequestSignature(pub interface{}, keyType pb.CryptoType, raw []byte, sig *pb.Signature) error {
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		if keyType != pb.CryptoType_ECDSA {
+			return errors.New("Unsupported (signing) key type.")
+		}
+
+		r, s := big.NewInt(0), big.NewInt(0)
+		r.UnmarshalText(sig.R)
+		s.UnmarshalText(sig.S)
+
+		// The request hash matches the curve of the signing key
+		newHash, err := primitives.GetHashForSecurityLevel(primitives.GetHashAlgorithm(), pub.Curve.Params().BitSize)
 		if err != nil {
-			mutex.Lock()
-			ecap.eca.db.Exec("DELETE FROM Certificates Where id=?", id)
-			mutex.Unlock()
-			Error.Println(err)
-			return nil, err
+			return err
+		}
+		hash := newHash()
+		hash.Write(raw)
+		if ecdsa.Verify(pub, hash.Sum(nil), r, s) == false {
+			return errors.New("Signature verification failed.")
 		}
+	default:
+		return errors.New("Unsupported (signing) key type.")
+	}
+
+	return nil
+}
 
+// createCertificatePair issues the enrollment certificate pair of id for the signing key skey
+// and the encryption key ekey, and marks id as enrolled.
+func (ecap *ECAP) createCertificatePair(id, enrollID string, role int, skey, ekey interface{}, nonce []byte) (*pb.ECertCreateResp, error) {
+	fetchResult := pb.FetchAttrsResult{Status: pb.FetchAttrsResult_SUCCESS, Msg: ""}
+
+	// create new certificate pair
+	ts := time.Now().Add(-1 * time.Minute).UnixNano()
+
+	spec := NewDefaultCertificateSpecWithCommonName(id, enrollID, skey, x509.KeyUsageDigitalSignature, pkix.Extension{Id: ECertSubjectRole, Critical: true, Value: []byte(strconv.Itoa(ecap.eca.readRole(id)))})
+	sraw, err := ecap.eca.createCertificateFromSpec(spec, ts, nil, true)
+	if err != nil {
+		Error.Println(err)
+		return nil, err
+	}
+
+	_ = ioutil.WriteFile("/tmp/ecert_"+id, sraw, 0644)
+
+	spec = NewDefaultCertificateSpecWithCommonName(id, enrollID, ekey.(*ecdsa.PublicKey), x509.KeyUsageDataEncipherment, pkix.Extension{Id: ECertSubjectRole, Critical: true, Value: []byte(strconv.Itoa(ecap.eca.readRole(id)))})
+	eraw, err := ecap.eca.createCertificateFromSpec(spec, ts, nil, true)
+	if err != nil {
 		mutex.Lock()
-		_, err = ecap.eca.db.Exec("UPDATE Users SET state=? WHERE id=?", 2, id)
+		ecap.eca.db.Exec("DELETE FROM Certificates Where id=? AND timestamp=?", id, ts)
 		mutex.Unlock()
-		if err != nil {
-			mutex.Lock()
-			ecap.eca.db.Exec("DELETE FROM Certificates Where id=?", id)
-			mutex.Unlock()
-			Error.Println(err)
-			return nil, err
-		}
+		Error.Println(err)
+		return nil, err
+	}
+
+	mutex.Lock()
+	_, err = ecap.eca.db.Exec("UPDATE Users SET state=? WHERE id=?", 2, id)
+	mutex.Unlock()
+	if err != nil {
+		mutex.Lock()
+		ecap.eca.db.Exec("DELETE FROM Certificates Where id=? AND timestamp=?", id, ts)
+		mutex.Unlock()
+		Error.Println(err)
+		return nil, err
+	}
+
+	var obcECKey []byte
+	if role == int(pb.Role_VALIDATOR) {
+		obcECKey = ecap.eca.obcPriv
+	} else {
+		obcECKey = ecap.eca.obcPub
+	}
+	if role == int(pb.Role_CLIENT) {
+		//Only client have to fetch attributes.
+		if viper.GetBool("aca.enabled") {
+			err = ecap.fetchAttributes(&pb.Cert{Cert: sraw})
+			if err != nil {
+				fetchResult = pb.FetchAttrsResult{Status: pb.FetchAttrsResult_FAILURE, Msg: err.Error()}
 
-		var obcECKey []byte
-		if role == int(pb.Role_VALIDATOR) {
-			obcECKey = ecap.eca.obcPriv
-		} else {
-			obcECKey = ecap.eca.obcPub
-		}
-		if role == int(pb.Role_CLIENT) {
-			//Only client have to fetch attributes.
-			if viper.GetBool("aca.enabled") {
-				err = ecap.fetchAttributes(&pb.Cert{Cert: sraw})
-				if err != nil {
-					fetchResult = pb.FetchAttrsResult{Status: pb.FetchAttrsResult_FAILURE, Msg: err.Error()}
-
-				}
 			}
 		}
-
-		return &pb.ECertCreateResp{Certs: &pb.CertPair{Sign: sraw, Enc: eraw}, Chain: &pb.Token{Tok: ecap.eca.obcKey}, Pkchain: obcECKey, Tok: nil, FetchResult: &fetchResult}, nil
 	}
 
-	return nil, errors.New("Invalid (=expired) certificate creation token provided.")
+	return &pb.ECertCreateResp{Certs: &pb.CertPair{Sign: sraw, Enc: eraw}, Chain: &pb.Token{Tok: ecap.eca.obcKey}, Pkchain: obcECKey, Tok: nil, FetchResult: &fetchResult, Nonce: nonce}, nil
 }
 
 // ReadCertificatePair reads an enrollment certificate pair from the ECA.
@@ -243,25 +393,16 @@ func (ecap *ECAP) CreateCertificatePair(ctx context.Context, in *pb.ECertCreateR
 func (ecap *ECAP) ReadCertificatePair(ctx context.Context, in *pb.ECertReadReq) (*pb.CertPair, error) {
 	Trace.Println("gRPC ECAP:ReadCertificate")
 
-	rows, err := ecap.eca.readCertificates(in.Id.Id)
-	defer rows.Close()
-
-	hasResults := false
-	var certs [][]byte
-	if err == nil {
-		for rows.Next() {
-			hasResults = true
-			var raw []byte
-			err = rows.Scan(&raw)
-			certs = append(certs, raw)
-		}
-		err = rows.Err()
+	// a re-enrolled identity has several pairs, the latest one is current
+	sign, err := ecap.eca.readCertificateByKeyUsage(in.Id.Id, x509.KeyUsageDigitalSignature)
+	if err != nil {
+		return nil, errors.New("No certificates for the given identity were found.")
 	}
-
-	if !hasResults {
+	enc, err := ecap.eca.readCertificateByKeyUsage(in.Id.Id, x509.KeyUsageDataEncipherment)
+	if err != nil {
 		return nil, errors.New("No certificates for the given identity were found.")
 	}
-	return &pb.CertPair{Sign: certs[0], Enc: certs[1]}, err
+	return &pb.CertPair{Sign: sign, Enc: enc}, nil
 }
 
 // ReadCertificateByHash reads a single enrollment certificate by hash from the ECA.
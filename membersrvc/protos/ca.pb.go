@@ -23,6 +23,7 @@ It has these top-level messages:
 	User
 	UserSet
 	ECertCreateReq
+	ECertAttribute
 	ECertCreateResp
 	ECertReadReq
 	ECertRevokeReq
@@ -426,12 +427,15 @@ func (m *UserSet) GetUsers() []*User {
 // Certificate requests.
 //
 type ECertCreateReq struct {
-	Ts   *google_protobuf.Timestamp `protobuf:"bytes,1,opt,name=ts" json:"ts,omitempty"`
-	Id   *Identity                  `protobuf:"bytes,2,opt,name=id" json:"id,omitempty"`
-	Tok  *Token                     `protobuf:"bytes,3,opt,name=tok" json:"tok,omitempty"`
-	Sign *PublicKey                 `protobuf:"bytes,4,opt,name=sign" json:"sign,omitempty"`
-	Enc  *PublicKey                 `protobuf:"bytes,5,opt,name=enc" json:"enc,omitempty"`
-	Sig  *Signature                 `protobuf:"bytes,6,opt,name=sig" json:"sig,omitempty"`
+	Ts       *google_protobuf.Timestamp `protobuf:"bytes,1,opt,name=ts" json:"ts,omitempty"`
+	Id       *Identity                  `protobuf:"bytes,2,opt,name=id" json:"id,omitempty"`
+	Tok      *Token                     `protobuf:"bytes,3,opt,name=tok" json:"tok,omitempty"`
+	Sign     *PublicKey                 `protobuf:"bytes,4,opt,name=sign" json:"sign,omitempty"`
+	Enc      *PublicKey                 `protobuf:"bytes,5,opt,name=enc" json:"enc,omitempty"`
+	Sig      *Signature                 `protobuf:"bytes,6,opt,name=sig" json:"sig,omitempty"`
+	Nonce    []byte                     `protobuf:"bytes,7,opt,name=nonce,proto3" json:"nonce,omitempty"`
+	Attrs    []*ECertAttribute          `protobuf:"bytes,8,rep,name=attrs" json:"attrs,omitempty"`
+	EcertSig *Signature                 `protobuf:"bytes,9,opt,name=ecertSig" json:"ecertSig,omitempty"`
 }
 
 func (m *ECertCreateReq) Reset()         { *m = ECertCreateReq{} }
@@ -480,12 +484,37 @@ func (m *ECertCreateReq) GetSig() *Signature {
 	return nil
 }
 
+func (m *ECertCreateReq) GetAttrs() []*ECertAttribute {
+	if m != nil {
+		return m.Attrs
+	}
+	return nil
+}
+
+func (m *ECertCreateReq) GetEcertSig() *Signature {
+	if m != nil {
+		return m.EcertSig
+	}
+	return nil
+}
+
+type ECertAttribute struct {
+	Oid   string `protobuf:"bytes,1,opt,name=oid" json:"oid,omitempty"`
+	Value []byte `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+}
+
+func (m *ECertAttribute) Reset()         { *m = ECertAttribute{} }
+func (m *ECertAttribute) String() string { return proto.CompactTextString(m) }
+func (*ECertAttribute) ProtoMessage()    {}
+
 type ECertCreateResp struct {
-	Certs       *CertPair         `protobuf:"bytes,1,opt,name=certs" json:"certs,omitempty"`
-	Chain       *Token            `protobuf:"bytes,2,opt,name=chain" json:"chain,omitempty"`
-	Pkchain     []byte            `protobuf:"bytes,5,opt,name=pkchain,proto3" json:"pkchain,omitempty"`
-	Tok         *Token            `protobuf:"bytes,3,opt,name=tok" json:"tok,omitempty"`
-	FetchResult *FetchAttrsResult `protobuf:"bytes,4,opt,name=fetchResult" json:"fetchResult,omitempty"`
+	Certs         *CertPair         `protobuf:"bytes,1,opt,name=certs" json:"certs,omitempty"`
+	Chain         *Token            `protobuf:"bytes,2,opt,name=chain" json:"chain,omitempty"`
+	Pkchain       []byte            `protobuf:"bytes,5,opt,name=pkchain,proto3" json:"pkchain,omitempty"`
+	Tok           *Token            `protobuf:"bytes,3,opt,name=tok" json:"tok,omitempty"`
+	FetchResult   *FetchAttrsResult `protobuf:"bytes,4,opt,name=fetchResult" json:"fetchResult,omitempty"`
+	Nonce         []byte            `protobuf:"bytes,6,opt,name=nonce,proto3" json:"nonce,omitempty"`
+	Intermediates [][]byte          `protobuf:"bytes,7,rep,name=intermediates,proto3" json:"intermediates,omitempty"`
 }
 
 func (m *ECertCreateResp) Reset()         { *m = ECertCreateResp{} }
@@ -1204,6 +1233,7 @@ var _ grpc.ClientConn
 type ECAPClient interface {
 	ReadCACertificate(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Cert, error)
 	CreateCertificatePair(ctx context.Context, in *ECertCreateReq, opts ...grpc.CallOption) (*ECertCreateResp, error)
+	ReEnrollCertificatePair(ctx context.Context, in *ECertCreateReq, opts ...grpc.CallOption) (*ECertCreateResp, error)
 	ReadCertificatePair(ctx context.Context, in *ECertReadReq, opts ...grpc.CallOption) (*CertPair, error)
 	ReadCertificateByHash(ctx context.Context, in *Hash, opts ...grpc.CallOption) (*Cert, error)
 	RevokeCertificatePair(ctx context.Context, in *ECertRevokeReq, opts ...grpc.CallOption) (*CAStatus, error)
@@ -1235,6 +1265,15 @@ func (c *eCAPClient) CreateCertificatePair(ctx context.Context, in *ECertCreateR
 	return out, nil
 }
 
+func (c *eCAPClient) ReEnrollCertificatePair(ctx context.Context, in *ECertCreateReq, opts ...grpc.CallOption) (*ECertCreateResp, error) {
+	out := new(ECertCreateResp)
+	err := grpc.Invoke(ctx, "/protos.ECAP/ReEnrollCertificatePair", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *eCAPClient) ReadCertificatePair(ctx context.Context, in *ECertReadReq, opts ...grpc.CallOption) (*CertPair, error) {
 	out := new(CertPair)
 	err := grpc.Invoke(ctx, "/protos.ECAP/ReadCertificatePair", in, out, c.cc, opts...)
@@ -1267,6 +1306,7 @@ func (c *eCAPClient) RevokeCertificatePair(ctx context.Context, in *ECertRevokeR
 type ECAPServer interface {
 	ReadCACertificate(context.Context, *Empty) (*Cert, error)
 	CreateCertificatePair(context.Context, *ECertCreateReq) (*ECertCreateResp, error)
+	ReEnrollCertificatePair(context.Context, *ECertCreateReq) (*ECertCreateResp, error)
 	ReadCertificatePair(context.Context, *ECertReadReq) (*CertPair, error)
 	ReadCertificateByHash(context.Context, *Hash) (*Cert, error)
 	RevokeCertificatePair(context.Context, *ECertRevokeReq) (*CAStatus, error)
@@ -1300,6 +1340,18 @@ func _ECAP_CreateCertificatePair_Handler(srv interface{}, ctx context.Context, d
 	return out, nil
 }
 
+func _ECAP_ReEnrollCertificatePair_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(ECertCreateReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ECAPServer).ReEnrollCertificatePair(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func _ECAP_ReadCertificatePair_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
 	in := new(ECertReadReq)
 	if err := dec(in); err != nil {
@@ -1348,6 +1400,10 @@ var _ECAP_serviceDesc = grpc.ServiceDesc{
 			MethodName: "CreateCertificatePair",
 			Handler:    _ECAP_CreateCertificatePair_Handler,
 		},
+		{
+			MethodName: "ReEnrollCertificatePair",
+			Handler:    _ECAP_ReEnrollCertificatePair_Handler,
+		},
 		{
 			MethodName: "ReadCertificatePair",
 			Handler:    _ECAP_ReadCertificatePair_Handler,